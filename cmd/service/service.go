@@ -5,6 +5,8 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // user timezones must work even if the image has no zoneinfo
 
 	"gitlab.com/avolkov/wood_post/pkg/log"
 
@@ -28,7 +30,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		log.Info(ctx)
 		err := services.TelegramBot.Run(ctx)
 		if err != nil {
@@ -44,4 +48,11 @@ func main() {
 	<-sigs
 	log.Info("Shutting down...")
 	cancel()
+
+	// let the bot finish updates it already took
+	select {
+	case <-done:
+	case <-time.After(cfg.ShutdownTimeout):
+		log.Warn("shutdown timeout, queued updates are dropped")
+	}
 }
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,7 +16,29 @@ type Config struct {
 	DBUser           string
 	DBPassword       string
 	DBName           string
-	BinanceAPIURL    string //FIXME
+	BinanceAPIURL    string // base URL, e.g. https://api.binance.com
+	KrakenAPIURL     string
+	CoinbaseAPIURL   string
+	CoinGeckoAPIURL  string
+	PriceSources     string // comma separated fallback chain, e.g. "binance,kraken,coinbase,coingecko"
+	PriceCacheTTL    time.Duration
+	PriceCacheStale  time.Duration // how long expired quotes may still be served
+
+	PriceCollectorInterval time.Duration // how often price snapshots are saved to DB
+
+	SessionStore string        // "memory" or "postgres", postgres keeps wizards across restarts
+	SessionTTL   time.Duration // inactive sessions are deleted after this period
+
+	CallbackSecret string // signs inline button data, a random one is generated at startup when empty
+
+	UpdateWorkers   int           // updates of different users are handled in parallel by this many workers
+	UpdateQueueSize int           // updates waiting per worker, polling pauses when a queue is full
+	ShutdownTimeout time.Duration // how long queued updates may take to finish on shutdown
+
+	UpdatesMode   string // "polling" or "webhook"
+	WebhookURL    string // public https URL Telegram posts updates to, its path is served
+	WebhookListen string // address of the webhook server, e.g. ":8080"
+	WebhookSecret string // Telegram sends it in every webhook request, required for webhook mode
 }
 
 func Load() *Config {
@@ -27,7 +51,49 @@ func Load() *Config {
 		DBUser:           os.Getenv("DB_USER"),
 		DBPassword:       os.Getenv("DB_PASS"),
 		DBName:           os.Getenv("DB_NAME"),
-		BinanceAPIURL:    os.Getenv("BINANCE_API_URL"), //FIXME
+		BinanceAPIURL:    os.Getenv("BINANCE_API_URL"),
+		KrakenAPIURL:     os.Getenv("KRAKEN_API_URL"),
+		CoinbaseAPIURL:   os.Getenv("COINBASE_API_URL"),
+		CoinGeckoAPIURL:  os.Getenv("COINGECKO_API_URL"),
+		PriceSources:     os.Getenv("PRICE_SOURCES"),
+		PriceCacheTTL:    durationEnv("PRICE_CACHE_TTL", time.Minute),
+		PriceCacheStale:  durationEnv("PRICE_CACHE_STALE", 10*time.Minute),
+
+		PriceCollectorInterval: durationEnv("PRICE_COLLECTOR_INTERVAL", time.Hour),
+
+		SessionStore: os.Getenv("SESSION_STORE"),
+		SessionTTL:   durationEnv("SESSION_TTL", 5*time.Minute),
+
+		CallbackSecret: os.Getenv("CALLBACK_SECRET"),
+
+		UpdateWorkers:   intEnv("UPDATE_WORKERS", 16),
+		UpdateQueueSize: intEnv("UPDATE_QUEUE_SIZE", 32),
+		ShutdownTimeout: durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		UpdatesMode:   os.Getenv("UPDATES_MODE"),
+		WebhookURL:    os.Getenv("WEBHOOK_URL"),
+		WebhookListen: os.Getenv("WEBHOOK_LISTEN"),
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+	}
+
+	if cfg.SessionStore == "" {
+		cfg.SessionStore = "memory"
+	}
+
+	if cfg.PriceSources == "" {
+		cfg.PriceSources = "binance,kraken,coinbase,coingecko"
+	}
+
+	if cfg.UpdatesMode == "" {
+		cfg.UpdatesMode = "polling"
+	}
+
+	if cfg.WebhookListen == "" {
+		cfg.WebhookListen = ":8080" // port published in docker-compose
+	}
+
+	if cfg.UpdatesMode == "webhook" && (cfg.WebhookURL == "" || cfg.WebhookSecret == "") {
+		log.Fatal("WEBHOOK_URL and WEBHOOK_SECRET are required in webhook mode")
 	}
 
 	if cfg.TelegramBotToken == "" {
@@ -35,3 +101,33 @@ func Load() *Config {
 	}
 	return cfg
 }
+
+// reads duration like "30s" or "5m", falls back to def when empty or invalid
+func durationEnv(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %s", name, raw, def)
+		return def
+	}
+	return d
+}
+
+// reads positive integer, falls back to def when empty or invalid
+func intEnv(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		log.Printf("invalid %s=%q, using default %d", name, raw, def)
+		return def
+	}
+	return n
+}
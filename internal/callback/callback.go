@@ -0,0 +1,166 @@
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is written into every payload, buttons of other versions are stale.
+// bump it when actions or their arguments change meaning
+const Version = "1"
+
+// MaxLength is the Telegram limit for callback data
+const MaxLength = 64
+
+const (
+	sep    = "|"
+	sigLen = 6 // bytes of HMAC kept in the payload
+)
+
+var (
+	ErrTooLong   = errors.New("callback data is longer than 64 bytes")
+	ErrStale     = errors.New("callback data of unknown version")
+	ErrTampered  = errors.New("callback data signature mismatch")
+	ErrMalformed = errors.New("malformed callback data")
+	ErrNoSecret  = errors.New("callback secret is empty")
+)
+
+// Data is a decoded button press: action name and its arguments
+type Data struct {
+	Action string
+	Args   []string
+}
+
+func New(action string, args ...string) Data {
+	return Data{Action: action, Args: args}
+}
+
+// Arg returns i-th argument, empty when there is none
+func (d Data) Arg(i int) string {
+	if i < 0 || i >= len(d.Args) {
+		return ""
+	}
+	return d.Args[i]
+}
+
+func (d Data) Int64(i int) (int64, error) {
+	v, err := strconv.ParseInt(d.Arg(i), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s argument %d: %v", ErrMalformed, d.Action, i, err)
+	}
+	return v, nil
+}
+
+// Codec turns Data into payloads like "1|tx_edit_pick|42|q0Xz3bUe",
+// the last field is a truncated HMAC of the rest
+type Codec struct {
+	key []byte
+}
+
+// NewCodec needs a secret, unsigned buttons would let anyone craft callbacks
+func NewCodec(secret string) (*Codec, error) {
+	if secret == "" {
+		return nil, ErrNoSecret
+	}
+	return &Codec{key: []byte(secret)}, nil
+}
+
+// RandomSecret is used when no secret is configured,
+// buttons sent before a restart stop working with it
+func RandomSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generate callback secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+func (c *Codec) Encode(d Data) (string, error) {
+	if d.Action == "" || strings.ContainsAny(d.Action, sep+"%") {
+		return "", fmt.Errorf("%w: invalid action %q", ErrMalformed, d.Action)
+	}
+
+	fields := make([]string, 0, len(d.Args)+3)
+	fields = append(fields, Version, d.Action)
+	for _, arg := range d.Args {
+		fields = append(fields, escape(arg))
+	}
+
+	payload := strings.Join(fields, sep)
+	payload += sep + c.sign(payload)
+
+	if len(payload) > MaxLength {
+		return "", fmt.Errorf("%w: %s is %d bytes", ErrTooLong, d.Action, len(payload))
+	}
+	return payload, nil
+}
+
+func (c *Codec) Decode(payload string) (Data, error) {
+	if len(payload) > MaxLength {
+		return Data{}, ErrTooLong
+	}
+	if !strings.HasPrefix(payload, Version+sep) {
+		return Data{}, ErrStale
+	}
+
+	i := strings.LastIndex(payload, sep)
+	signed, sig := payload[:i], payload[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(c.sign(signed))) {
+		return Data{}, ErrTampered
+	}
+	payload = signed
+
+	fields := strings.Split(payload, sep)[1:]
+	if len(fields) == 0 || fields[0] == "" {
+		return Data{}, ErrMalformed
+	}
+
+	d := Data{Action: fields[0]}
+	for _, f := range fields[1:] {
+		arg, err := unescape(f)
+		if err != nil {
+			return Data{}, err
+		}
+		d.Args = append(d.Args, arg)
+	}
+	return d, nil
+}
+
+func (c *Codec) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:sigLen])
+}
+
+// only the separator and the escape char itself are escaped, so payloads stay short
+var escaper = strings.NewReplacer("%", "%25", sep, "%7C")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(s[i:], "%25"):
+			b.WriteByte('%')
+		case strings.HasPrefix(s[i:], "%7C"):
+			b.WriteString(sep)
+		default:
+			return "", fmt.Errorf("%w: bad escape in %q", ErrMalformed, s)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
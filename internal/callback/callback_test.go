@@ -0,0 +1,146 @@
+package callback
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestCodec(t *testing.T) *Codec {
+	t.Helper()
+	c, err := NewCodec("secret")
+	if err != nil {
+		t.Fatalf("new codec: %v", err)
+	}
+	return c
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	c := newTestCodec(t)
+
+	tests := []struct {
+		name string
+		data Data
+	}{
+		{name: "no args", data: New("menu")},
+		{name: "args", data: New("tx_edit_pick", "42", "amount")},
+		{name: "empty arg", data: New("tx_date", "")},
+		{name: "separator and escape char", data: New("tag", "a|b", "100%", "%7C")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := c.Encode(tt.data)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if !strings.HasPrefix(payload, Version+sep+tt.data.Action) {
+				t.Errorf("got payload %q, want it to start with version and action", payload)
+			}
+
+			got, err := c.Decode(payload)
+			if err != nil {
+				t.Fatalf("decode %q: %v", payload, err)
+			}
+			if !reflect.DeepEqual(got, tt.data) {
+				t.Errorf("got %+v, want %+v", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestCodecEscapesSeparators(t *testing.T) {
+	c := newTestCodec(t)
+
+	payload, err := c.Encode(New("tag", "a|b"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if strings.Count(payload, sep) != 3 {
+		t.Errorf("got payload %q, want the separator in the argument escaped", payload)
+	}
+
+	if _, err := c.Encode(New("a|b")); !errors.Is(err, ErrMalformed) {
+		t.Errorf("action with a separator: got %v, want ErrMalformed", err)
+	}
+}
+
+func TestCodecRejects(t *testing.T) {
+	c := newTestCodec(t)
+
+	payload, err := c.Encode(New("tx_delete", "42"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	other, err := NewCodec("other secret")
+	if err != nil {
+		t.Fatalf("new codec: %v", err)
+	}
+	foreign, err := other.Encode(New("tx_delete", "42"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		wantErr error
+	}{
+		{name: "changed argument", payload: strings.Replace(payload, "42", "43", 1), wantErr: ErrTampered},
+		{name: "signed with another secret", payload: foreign, wantErr: ErrTampered},
+		{name: "no signature", payload: Version + sep + "tx_delete" + sep + "42", wantErr: ErrTampered},
+		{name: "old version", payload: "0" + payload[len(Version):], wantErr: ErrStale},
+		{name: "no version", payload: "tx_delete", wantErr: ErrStale},
+		{name: "too long", payload: payload + strings.Repeat("x", MaxLength), wantErr: ErrTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Decode(tt.payload); !errors.Is(err, tt.wantErr) {
+				t.Errorf("decode %q: got %v, want %v", tt.payload, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodecLengthLimit(t *testing.T) {
+	c := newTestCodec(t)
+
+	// version, action, separators and the signature take 13 bytes
+	fits := strings.Repeat("x", MaxLength-len("1|a||")-8)
+	payload, err := c.Encode(New("a", fits))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if len(payload) != MaxLength {
+		t.Errorf("got %d bytes, want %d", len(payload), MaxLength)
+	}
+
+	if _, err := c.Encode(New("a", fits+"x")); !errors.Is(err, ErrTooLong) {
+		t.Errorf("got %v, want ErrTooLong", err)
+	}
+
+	// escaping counts towards the limit
+	if _, err := c.Encode(New("a", fits[1:]+"|")); !errors.Is(err, ErrTooLong) {
+		t.Errorf("escaped argument: got %v, want ErrTooLong", err)
+	}
+}
+
+func TestNewCodecRequiresSecret(t *testing.T) {
+	if _, err := NewCodec(""); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("got %v, want ErrNoSecret", err)
+	}
+
+	a, err := RandomSecret()
+	if err != nil {
+		t.Fatalf("random secret: %v", err)
+	}
+	b, err := RandomSecret()
+	if err != nil {
+		t.Fatalf("random secret: %v", err)
+	}
+	if a == "" || a == b {
+		t.Errorf("got secrets %q and %q, want two different ones", a, b)
+	}
+}
@@ -0,0 +1,285 @@
+package costbasis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// Method defines which lots are matched against a sale
+type Method string
+
+const (
+	FIFO    Method = "fifo"    // oldest lots are sold first
+	LIFO    Method = "lifo"    // newest lots are sold first
+	Average Method = "average" // every sold unit costs the weighted average of holdings
+)
+
+var Methods = []Method{FIFO, LIFO, Average}
+
+func ParseMethod(raw string) (Method, error) {
+	m := Method(strings.ToLower(strings.TrimSpace(raw)))
+	for _, known := range Methods {
+		if m == known {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown cost basis method: %s", raw)
+}
+
+func (m Method) Title() string {
+	switch m {
+	case Average:
+		return "Weighted average"
+	default:
+		return strings.ToUpper(string(m))
+	}
+}
+
+// Lot is an open (not yet sold) part of an acquisition
+type Lot struct {
+	TxID     int64
+	Acquired time.Time
+	Amount   decimal.Decimal // remaining amount
+	CostUSD  decimal.Decimal // cost basis of the remaining amount
+}
+
+// Disposal is a part of a sale matched against a single lot
+type Disposal struct {
+	SaleTxID  int64
+	LotTxID   int64 // 0 when more was sold than acquired
+	Asset     string
+	Acquired  time.Time
+	Disposed  time.Time
+	Amount    decimal.Decimal
+	CostBasis decimal.Decimal
+	Proceeds  decimal.Decimal
+	Gain      decimal.Decimal
+}
+
+// Position is the result of replaying all transactions of one asset
+type Position struct {
+	Asset       string
+	Amount      decimal.Decimal // amount still held
+	CostBasis   decimal.Decimal // cost basis of the amount still held
+	RealizedPnL decimal.Decimal
+	IncomeUSD   decimal.Decimal // fair value of rewards and airdrops when received, part of the cost basis
+	OpenLots    []Lot
+	Disposals   []Disposal
+}
+
+func (p *Position) AverageCost() decimal.Decimal {
+	if !p.Amount.IsPositive() {
+		return decimal.Zero
+	}
+	return p.CostBasis.Div(p.Amount)
+}
+
+func (p *Position) UnrealizedPnL(currentPrice decimal.Decimal) decimal.Decimal {
+	return p.Amount.Mul(currentPrice).Sub(p.CostBasis)
+}
+
+// Replay goes through transactions in date order and matches sales against lots
+// with the given method. Positions are returned sorted by asset.
+// Dates typed in the bot have no time of day, so on equal dates acquisitions go before
+// disposals and transactions of the same kind go in id order, whatever the order of txs.
+func Replay(method Method, txs []t.Transaction) ([]*Position, error) {
+	if _, err := ParseMethod(string(method)); err != nil {
+		return nil, err
+	}
+
+	ordered := make([]t.Transaction, len(txs))
+	copy(ordered, txs)
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if !a.TransactionDate.Equal(b.TransactionDate) {
+			return a.TransactionDate.Before(b.TransactionDate)
+		}
+		if a.Sign() != b.Sign() {
+			return a.Sign() > b.Sign()
+		}
+		return a.ID < b.ID
+	})
+
+	// transfers between own portfolios have both legs, the asset and its lots stay with the user.
+	// when only one leg is replayed, e.g. for a single portfolio, it moves lots in or out
+	legs := make(map[int64]int)
+	for _, tx := range ordered {
+		if tx.Type == "transfer" {
+			legs[tx.TransferID]++
+		}
+	}
+
+	positions := make(map[string]*Position)
+	for _, tx := range ordered {
+		// fiat flows, nothing to match
+		if tx.Sign() == 0 {
+			continue
+		}
+
+		pos, ok := positions[tx.Asset]
+		if !ok {
+			pos = &Position{Asset: tx.Asset}
+			positions[tx.Asset] = pos
+		}
+
+		switch {
+		case tx.Type == "transfer" && legs[tx.TransferID] > 1:
+			continue
+		case tx.Type == "transfer" && tx.Direction == t.TransferOut:
+			pos.withdraw(method, tx)
+		case tx.Sign() > 0:
+			pos.acquire(tx)
+		default:
+			pos.dispose(method, tx)
+		}
+	}
+
+	result := make([]*Position, 0, len(positions))
+	for _, pos := range positions {
+		result = append(result, pos)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Asset < result[j].Asset
+	})
+
+	return result, nil
+}
+
+func (p *Position) acquire(tx t.Transaction) {
+	p.OpenLots = append(p.OpenLots, Lot{
+		TxID:     tx.ID,
+		Acquired: tx.TransactionDate,
+		Amount:   tx.AssetAmount,
+		CostUSD:  tx.NetUSD(),
+	})
+	p.Amount = p.Amount.Add(tx.AssetAmount)
+	p.CostBasis = p.CostBasis.Add(tx.NetUSD())
+
+	if t.IsIncome(tx.Type) {
+		p.IncomeUSD = p.IncomeUSD.Add(tx.USDAmount)
+	}
+}
+
+func (p *Position) dispose(method Method, tx t.Transaction) {
+	// proceeds are split between matched lots in proportion to the amount taken from each
+	proceedsOf := func(amount decimal.Decimal) decimal.Decimal {
+		if amount.Equal(tx.AssetAmount) {
+			return tx.NetUSD()
+		}
+		return tx.NetUSD().Mul(amount).Div(tx.AssetAmount)
+	}
+
+	remaining := p.consume(method, tx.AssetAmount, func(lot Lot, amount, cost decimal.Decimal) {
+		p.addDisposal(tx, lot.TxID, lot.Acquired, amount, cost, proceedsOf(amount))
+	})
+
+	// sold more than was bought, the rest has no known cost
+	if remaining.IsPositive() {
+		p.addDisposal(tx, 0, tx.TransactionDate, remaining, decimal.Zero, proceedsOf(remaining))
+	}
+}
+
+// Cost returns the cost basis of amount taken from open lots with the given method and the part
+// of amount that is not held, the position itself is left as is
+func (p *Position) Cost(method Method, amount decimal.Decimal) (cost, missing decimal.Decimal) {
+	c := *p
+	c.OpenLots = append([]Lot(nil), p.OpenLots...)
+
+	cost = decimal.Zero
+	missing = c.consume(method, amount, func(_ Lot, _, lotCost decimal.Decimal) {
+		cost = cost.Add(lotCost)
+	})
+	return cost, missing
+}
+
+// withdraw takes lots out of the position without realizing anything,
+// used for transfers to wallets that are not tracked
+func (p *Position) withdraw(method Method, tx t.Transaction) {
+	p.consume(method, tx.AssetAmount, func(Lot, decimal.Decimal, decimal.Decimal) {})
+}
+
+// consume matches amount against open lots with the given method, calls matched for every
+// part of a lot taken and returns the amount that could not be matched
+func (p *Position) consume(
+	method Method,
+	amount decimal.Decimal,
+	matched func(lot Lot, amount, cost decimal.Decimal),
+) decimal.Decimal {
+	remaining := amount
+
+	// with average cost every open lot is priced at the pool average before matching,
+	// lots themselves are still consumed oldest first to keep acquisition dates
+	if method == Average && p.Amount.IsPositive() {
+		for i := range p.OpenLots {
+			p.OpenLots[i].CostUSD = p.CostBasis.Mul(p.OpenLots[i].Amount).Div(p.Amount)
+		}
+	}
+
+	for remaining.IsPositive() && len(p.OpenLots) > 0 {
+		idx := 0
+		if method == LIFO {
+			idx = len(p.OpenLots) - 1
+		}
+		lot := &p.OpenLots[idx]
+
+		taken := decimal.Min(remaining, lot.Amount)
+		cost := lot.CostUSD
+		if taken.LessThan(lot.Amount) {
+			cost = lot.CostUSD.Mul(taken).Div(lot.Amount)
+		}
+
+		matched(*lot, taken, cost)
+
+		lot.Amount = lot.Amount.Sub(taken)
+		lot.CostUSD = lot.CostUSD.Sub(cost)
+		p.Amount = p.Amount.Sub(taken)
+		p.CostBasis = p.CostBasis.Sub(cost)
+		remaining = remaining.Sub(taken)
+
+		if !lot.Amount.IsPositive() {
+			p.OpenLots = append(p.OpenLots[:idx], p.OpenLots[idx+1:]...)
+		}
+	}
+
+	// drop rounding leftovers of divisions
+	if len(p.OpenLots) == 0 {
+		p.Amount = decimal.Zero
+		p.CostBasis = decimal.Zero
+	}
+
+	return remaining
+}
+
+func (p *Position) addDisposal(tx t.Transaction, lotTxID int64, acquired time.Time, amount, cost, proceeds decimal.Decimal) {
+	gain := proceeds.Sub(cost)
+	p.Disposals = append(p.Disposals, Disposal{
+		SaleTxID:  tx.ID,
+		LotTxID:   lotTxID,
+		Asset:     tx.Asset,
+		Acquired:  acquired,
+		Disposed:  tx.TransactionDate,
+		Amount:    amount,
+		CostBasis: cost,
+		Proceeds:  proceeds,
+		Gain:      gain,
+	})
+	p.RealizedPnL = p.RealizedPnL.Add(gain)
+}
+
+const (
+	ShortTerm = "short"
+	LongTerm  = "long"
+)
+
+// Term classifies holding period, assets held for more than a year are long-term
+func (d Disposal) Term() string {
+	if d.Disposed.After(d.Acquired.AddDate(1, 0, 0)) {
+		return LongTerm
+	}
+	return ShortTerm
+}
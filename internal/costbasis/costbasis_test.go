@@ -0,0 +1,324 @@
+package costbasis
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+func day(d int) time.Time {
+	return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func tx(id int64, date time.Time, txType, amount, usd string) types.Transaction {
+	return types.Transaction{
+		ID:              id,
+		Type:            txType,
+		Asset:           "BTC",
+		AssetAmount:     decimal.RequireFromString(amount),
+		USDAmount:       decimal.RequireFromString(usd),
+		TransactionDate: date,
+	}
+}
+
+func withFee(tx types.Transaction, feeUSD string) types.Transaction {
+	tx.FeeUSD = decimal.RequireFromString(feeUSD)
+	return tx
+}
+
+func transfer(id int64, date time.Time, direction string, transferID int64, amount, usd string) types.Transaction {
+	leg := tx(id, date, "transfer", amount, usd)
+	leg.Direction = direction
+	leg.TransferID = transferID
+	return leg
+}
+
+func equal(got decimal.Decimal, want string) bool {
+	return got.Equal(decimal.RequireFromString(want))
+}
+
+func TestReplay(t *testing.T) {
+	buys := []types.Transaction{
+		tx(1, day(1), "buy", "1", "100"),
+		tx(2, day(2), "buy", "1", "200"),
+	}
+	sale := tx(3, day(3), "sell", "1", "300")
+
+	tests := []struct {
+		name          string
+		method        Method
+		txs           []types.Transaction
+		wantAmount    string
+		wantCost      string
+		wantRealized  string
+		wantIncome    string
+		wantDisposals int
+		wantLots      []int64 // tx ids of open lots
+	}{
+		{
+			name:          "fifo sells the oldest lot",
+			method:        FIFO,
+			txs:           append(buys, sale),
+			wantAmount:    "1",
+			wantCost:      "200",
+			wantRealized:  "200",
+			wantDisposals: 1,
+			wantLots:      []int64{2},
+		},
+		{
+			name:          "lifo sells the newest lot",
+			method:        LIFO,
+			txs:           append(buys, sale),
+			wantAmount:    "1",
+			wantCost:      "100",
+			wantRealized:  "100",
+			wantDisposals: 1,
+			wantLots:      []int64{1},
+		},
+		{
+			name:          "average sells at the pool average",
+			method:        Average,
+			txs:           append(buys, sale),
+			wantAmount:    "1",
+			wantCost:      "150",
+			wantRealized:  "150",
+			wantDisposals: 1,
+			wantLots:      []int64{2},
+		},
+		{
+			name:          "sale spans two lots",
+			method:        FIFO,
+			txs:           append(buys, tx(3, day(3), "sell", "1.5", "600")),
+			wantAmount:    "0.5",
+			wantCost:      "100",
+			wantRealized:  "400",
+			wantDisposals: 2,
+			wantLots:      []int64{2},
+		},
+		{
+			name:          "partial lot",
+			method:        FIFO,
+			txs:           []types.Transaction{tx(1, day(1), "buy", "2", "200"), tx(2, day(2), "sell", "0.5", "100")},
+			wantAmount:    "1.5",
+			wantCost:      "150",
+			wantRealized:  "50",
+			wantDisposals: 1,
+			wantLots:      []int64{1},
+		},
+		{
+			name:          "oversell has no cost for the rest",
+			method:        FIFO,
+			txs:           []types.Transaction{tx(1, day(1), "buy", "1", "100"), tx(2, day(2), "sell", "2", "400")},
+			wantAmount:    "0",
+			wantCost:      "0",
+			wantRealized:  "300",
+			wantDisposals: 2,
+		},
+		{
+			name:   "fees add to cost and reduce proceeds",
+			method: FIFO,
+			txs: []types.Transaction{
+				withFee(tx(1, day(1), "buy", "1", "100"), "1"),
+				withFee(tx(2, day(2), "sell", "1", "200"), "2"),
+			},
+			wantAmount:    "0",
+			wantCost:      "0",
+			wantRealized:  "97",
+			wantDisposals: 1,
+		},
+		{
+			name:   "transfer to an external wallet takes lots without a gain",
+			method: FIFO,
+			txs: append(buys,
+				transfer(3, day(3), types.TransferOut, 3, "1", "100"),
+			),
+			wantAmount: "1",
+			wantCost:   "200",
+			wantLots:   []int64{2},
+		},
+		{
+			name:   "transfer between own portfolios keeps lots",
+			method: FIFO,
+			txs: append(buys,
+				transfer(3, day(3), types.TransferOut, 3, "1", "100"),
+				transfer(4, day(3), types.TransferIn, 3, "1", "100"),
+			),
+			wantAmount: "2",
+			wantCost:   "300",
+			wantLots:   []int64{1, 2},
+		},
+		{
+			name:   "incoming leg alone is a lot at the moved cost",
+			method: FIFO,
+			txs: []types.Transaction{
+				transfer(4, day(3), types.TransferIn, 3, "1", "100"),
+			},
+			wantAmount: "1",
+			wantCost:   "100",
+			wantLots:   []int64{4},
+		},
+		{
+			name:   "income at fair value, airdrop at zero cost",
+			method: FIFO,
+			txs: []types.Transaction{
+				tx(1, day(1), "income", "1", "50"),
+				tx(2, day(2), "airdrop", "1", "0"),
+				tx(3, day(3), "sell", "2", "200"),
+			},
+			wantAmount:    "0",
+			wantCost:      "0",
+			wantRealized:  "150",
+			wantIncome:    "50",
+			wantDisposals: 2,
+		},
+		{
+			name:   "same date acquisitions go first whatever the input order",
+			method: FIFO,
+			txs: []types.Transaction{
+				tx(3, day(1), "sell", "1", "300"),
+				tx(2, day(1), "buy", "1", "200"),
+				tx(1, day(1), "buy", "1", "100"),
+			},
+			wantAmount:    "1",
+			wantCost:      "200",
+			wantRealized:  "200",
+			wantDisposals: 1,
+			wantLots:      []int64{2},
+		},
+		{
+			name:   "fiat flows are skipped",
+			method: FIFO,
+			txs: []types.Transaction{
+				tx(1, day(1), "deposit", "100", "100"),
+				tx(2, day(2), "withdrawal", "100", "100"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions, err := Replay(tt.method, tt.txs)
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			if tt.wantAmount == "" {
+				if len(positions) != 0 {
+					t.Errorf("got %d positions, want none", len(positions))
+				}
+				return
+			}
+			if len(positions) != 1 {
+				t.Fatalf("got %d positions, want 1", len(positions))
+			}
+
+			pos := positions[0]
+			if tt.wantRealized == "" {
+				tt.wantRealized = "0"
+			}
+			if tt.wantIncome == "" {
+				tt.wantIncome = "0"
+			}
+			if !equal(pos.Amount, tt.wantAmount) || !equal(pos.CostBasis, tt.wantCost) ||
+				!equal(pos.RealizedPnL, tt.wantRealized) || !equal(pos.IncomeUSD, tt.wantIncome) {
+				t.Errorf("got amount %s, cost %s, realized %s, income %s, want %s, %s, %s, %s",
+					pos.Amount, pos.CostBasis, pos.RealizedPnL, pos.IncomeUSD,
+					tt.wantAmount, tt.wantCost, tt.wantRealized, tt.wantIncome)
+			}
+			if len(pos.Disposals) != tt.wantDisposals {
+				t.Errorf("got %d disposals, want %d", len(pos.Disposals), tt.wantDisposals)
+			}
+
+			var lots []int64
+			for _, lot := range pos.OpenLots {
+				lots = append(lots, lot.TxID)
+			}
+			if len(lots) != len(tt.wantLots) {
+				t.Fatalf("got open lots %v, want %v", lots, tt.wantLots)
+			}
+			for i := range lots {
+				if lots[i] != tt.wantLots[i] {
+					t.Errorf("got open lots %v, want %v", lots, tt.wantLots)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestReplayOversellDisposal(t *testing.T) {
+	positions, err := Replay(FIFO, []types.Transaction{
+		tx(1, day(1), "buy", "1", "100"),
+		tx(2, day(2), "sell", "2", "400"),
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	unmatched := positions[0].Disposals[1]
+	if unmatched.LotTxID != 0 || !equal(unmatched.Amount, "1") || !equal(unmatched.CostBasis, "0") ||
+		!equal(unmatched.Proceeds, "200") || !unmatched.Acquired.Equal(day(2)) {
+		t.Errorf("got unmatched disposal %+v, want 1 sold for 200 without a lot", unmatched)
+	}
+}
+
+func TestReplayUnknownMethod(t *testing.T) {
+	if _, err := Replay("hifo", nil); err == nil {
+		t.Error("got no error for an unknown method")
+	}
+}
+
+func TestPositionCost(t *testing.T) {
+	// cost of the rest is what it was bought for, not lowered by the sale proceeds
+	positions, err := Replay(FIFO, []types.Transaction{
+		tx(1, day(1), "buy", "2", "20000"),
+		tx(2, day(2), "sell", "1", "30000"),
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	pos := positions[0]
+
+	tests := []struct {
+		amount      string
+		wantCost    string
+		wantMissing string
+	}{
+		{amount: "0.5", wantCost: "5000", wantMissing: "0"},
+		{amount: "1", wantCost: "10000", wantMissing: "0"},
+		{amount: "1.5", wantCost: "10000", wantMissing: "0.5"},
+	}
+
+	for _, tt := range tests {
+		cost, missing := pos.Cost(FIFO, decimal.RequireFromString(tt.amount))
+		if !equal(cost, tt.wantCost) || !equal(missing, tt.wantMissing) {
+			t.Errorf("cost of %s: got %s, missing %s, want %s, %s", tt.amount, cost, missing, tt.wantCost, tt.wantMissing)
+		}
+	}
+
+	if !equal(pos.Amount, "1") || len(pos.OpenLots) != 1 || !equal(pos.OpenLots[0].Amount, "1") {
+		t.Errorf("position changed by Cost: %+v", pos)
+	}
+}
+
+func TestDisposalTerm(t *testing.T) {
+	acquired := time.Date(2023, 3, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		disposed time.Time
+		want     string
+	}{
+		{disposed: acquired, want: ShortTerm},
+		{disposed: acquired.AddDate(0, 11, 28), want: ShortTerm},
+		{disposed: acquired.AddDate(1, 0, 0), want: ShortTerm},
+		{disposed: acquired.AddDate(1, 0, 1), want: LongTerm},
+	}
+
+	for _, tt := range tests {
+		d := Disposal{Acquired: acquired, Disposed: tt.disposed}
+		if got := d.Term(); got != tt.want {
+			t.Errorf("held until %s: got %s, want %s", tt.disposed.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
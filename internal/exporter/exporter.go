@@ -0,0 +1,380 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/internal/importer"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// Format of the exported file
+type Format string
+
+const (
+	CSV  Format = "csv"  // flat table, readable by spreadsheets and by the generic importer
+	JSON Format = "json" // versioned document which can be restored
+)
+
+func ParseFormat(raw string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(raw))); f {
+	case CSV, JSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown export format: %s", raw)
+}
+
+// Version of the JSON document, bump it on incompatible changes and keep reading the old ones
+const Version = 1
+
+// MaxRows limits documents accepted by Read
+const MaxRows = 50000
+
+var (
+	ErrNotExport          = errors.New("file is not an export")
+	ErrUnsupportedVersion = errors.New("export version is not supported")
+	ErrTooManyRows        = fmt.Errorf("export has more than %d transactions", MaxRows)
+)
+
+// Document is the JSON export, transactions reference portfolios by name
+type Document struct {
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	Portfolios   []Portfolio   `json:"portfolios"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+type Portfolio struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	IsDefault   bool      `json:"is_default"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Transaction struct {
+	ID          int64           `json:"id"`
+	Portfolio   string          `json:"portfolio"`
+	Type        string          `json:"type"`
+	Asset       string          `json:"asset"`
+	Amount      decimal.Decimal `json:"amount"`
+	Price       decimal.Decimal `json:"price"` // USD price
+	Currency    string          `json:"quote_currency"`
+	QuotePrice  decimal.Decimal `json:"quote_price"` // price in Currency
+	AmountUSD   decimal.Decimal `json:"amount_usd"`
+	Fee         decimal.Decimal `json:"fee"`
+	FeeCurrency string          `json:"fee_currency"`
+	FeeUSD      decimal.Decimal `json:"fee_usd"`
+	Date        time.Time       `json:"date"`
+	Note        string          `json:"note,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	TransferID  int64           `json:"transfer_id,omitempty"` // id shared by both legs of a transfer
+	Direction   string          `json:"direction,omitempty"`   // "in" or "out" for transfers
+	ImportHash  string          `json:"import_hash,omitempty"`
+}
+
+func fromTransaction(tx t.Transaction) Transaction {
+	return Transaction{
+		ID:          tx.ID,
+		Portfolio:   tx.PortfolioName,
+		Type:        tx.Type,
+		Asset:       tx.Asset,
+		Amount:      tx.AssetAmount,
+		Price:       tx.AssetPrice,
+		Currency:    tx.QuoteCurrency,
+		QuotePrice:  tx.QuotePrice,
+		AmountUSD:   tx.USDAmount,
+		Fee:         tx.FeeAmount,
+		FeeCurrency: tx.FeeCurrency,
+		FeeUSD:      tx.FeeUSD,
+		Date:        tx.TransactionDate.UTC(),
+		Note:        tx.Note,
+		Tags:        tx.Tags,
+		TransferID:  tx.TransferID,
+		Direction:   tx.Direction,
+		ImportHash:  tx.ImportHash,
+	}
+}
+
+func (tx Transaction) toTransaction() t.Transaction {
+	return t.Transaction{
+		ID:              tx.ID,
+		PortfolioName:   tx.Portfolio,
+		Type:            tx.Type,
+		Asset:           tx.Asset,
+		AssetAmount:     tx.Amount,
+		AssetPrice:      tx.Price,
+		QuoteCurrency:   tx.Currency,
+		QuotePrice:      tx.QuotePrice,
+		USDAmount:       tx.AmountUSD,
+		FeeAmount:       tx.Fee,
+		FeeCurrency:     tx.FeeCurrency,
+		FeeUSD:          tx.FeeUSD,
+		TransactionDate: tx.Date,
+		Note:            tx.Note,
+		Tags:            tx.Tags,
+		TransferID:      tx.TransferID,
+		Direction:       tx.Direction,
+		ImportHash:      tx.ImportHash,
+	}
+}
+
+// Writer streams transactions into a file, Close must be called to finish it
+type Writer interface {
+	Write(tx t.Transaction) error
+	Close() error
+}
+
+// NewWriter starts the file, portfolios go to the JSON header and are ignored by CSV
+func NewWriter(format Format, w io.Writer, portfolios []t.Portfolio, exportedAt time.Time) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w)
+	case JSON:
+		return newJSONWriter(w, portfolios, exportedAt)
+	}
+	return nil, fmt.Errorf("unknown export format: %s", format)
+}
+
+// first columns are the ones of the generic import format, price is in the currency column
+var csvHeader = []string{
+	"date", "type", "asset", "amount", "price", "currency", "fee", "fee_currency", "note", "tags",
+	"portfolio", "price_usd", "amount_usd", "fee_usd", "transfer_id", "direction",
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(csvHeader); err != nil {
+		return nil, fmt.Errorf("write csv header: %w", err)
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) Write(tx t.Transaction) error {
+	transferID := ""
+	if tx.TransferID != 0 {
+		transferID = strconv.FormatInt(tx.TransferID, 10)
+	}
+
+	return cw.w.Write([]string{
+		tx.TransactionDate.UTC().Format(time.RFC3339),
+		tx.Type,
+		tx.Asset,
+		tx.AssetAmount.String(),
+		tx.QuotePrice.String(),
+		tx.QuoteCurrency,
+		tx.FeeAmount.String(),
+		tx.FeeCurrency,
+		tx.Note,
+		strings.Join(tx.Tags, ";"),
+		tx.PortfolioName,
+		tx.AssetPrice.String(),
+		tx.USDAmount.String(),
+		tx.FeeUSD.String(),
+		transferID,
+		tx.Direction,
+	})
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// writes the document by hand around the transactions array so rows never pile up in memory
+type jsonWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func newJSONWriter(w io.Writer, portfolios []t.Portfolio, exportedAt time.Time) (*jsonWriter, error) {
+	header := Document{
+		Version:    Version,
+		ExportedAt: exportedAt.UTC(),
+		Portfolios: make([]Portfolio, 0, len(portfolios)),
+	}
+	for _, p := range portfolios {
+		header.Portfolios = append(header.Portfolios, Portfolio{
+			Name:        p.Name,
+			Description: p.Description,
+			IsDefault:   p.IsDefault,
+			CreatedAt:   p.CreatedAt.UTC(),
+		})
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("marshal export header: %w", err)
+	}
+
+	// header is a complete object with "transactions":null at the end, it's reopened as an array
+	head, ok := strings.CutSuffix(string(data), `"transactions":null}`)
+	if !ok {
+		return nil, errors.New("unexpected export header layout")
+	}
+
+	jw := &jsonWriter{w: bufio.NewWriter(w)}
+	if _, err := jw.w.WriteString(head + `"transactions":[`); err != nil {
+		return nil, fmt.Errorf("write export header: %w", err)
+	}
+	return jw, nil
+}
+
+func (jw *jsonWriter) Write(tx t.Transaction) error {
+	data, err := json.Marshal(fromTransaction(tx))
+	if err != nil {
+		return fmt.Errorf("marshal transaction %d: %w", tx.ID, err)
+	}
+
+	sep := ",\n"
+	if jw.count == 0 {
+		sep = "\n"
+	}
+	jw.count++
+
+	if _, err := jw.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = jw.w.Write(data)
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	if _, err := jw.w.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return jw.w.Flush()
+}
+
+// Read decodes a JSON export and checks it can be restored. rows exported without an import hash
+// get one from their content, so restoring the same file twice doesn't duplicate them. the store
+// computes the same hash for its rows without one, keep both in sync
+func Read(r io.Reader) (*Document, error) {
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotExport, err)
+	}
+
+	switch {
+	case doc.Version == 0:
+		return nil, ErrNotExport
+	case doc.Version > Version:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, doc.Version)
+	case len(doc.Transactions) > MaxRows:
+		return nil, ErrTooManyRows
+	}
+
+	for _, p := range doc.Portfolios {
+		if strings.TrimSpace(p.Name) == "" {
+			return nil, errors.New("portfolio without a name")
+		}
+		if t.PrettyPortfolioName(p.Name) == "" {
+			return nil, fmt.Errorf("portfolio %q has no latin letters or digits", p.Name)
+		}
+	}
+
+	seen := make(map[string]int)
+	for i := range doc.Transactions {
+		tx := &doc.Transactions[i]
+		if err := validate(*tx); err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", tx.ID, err)
+		}
+		// exports made before currencies were recorded are in USD
+		if tx.Currency == "" {
+			tx.Currency = t.FiatUSD
+			tx.QuotePrice = tx.Price
+		}
+		if tx.ImportHash == "" {
+			tx.ImportHash = importer.Hash(t.TempTransactionData{
+				Asset:           tx.Asset,
+				Type:            tx.Type,
+				AssetAmount:     tx.Amount,
+				QuoteCurrency:   tx.Currency,
+				QuotePrice:      tx.QuotePrice,
+				FeeAmount:       tx.Fee,
+				FeeCurrency:     tx.FeeCurrency,
+				TransactionDate: tx.Date,
+			}, seen)
+		}
+	}
+
+	return &doc, nil
+}
+
+var knownTypes = map[string]bool{
+	"buy": true, "sell": true, "transfer": true, "income": true,
+	"airdrop": true, "gift": true, "deposit": true, "withdrawal": true,
+}
+
+// largest amount at the largest price, the decoder accepts any number so every value is checked
+var maxUSDValue = t.MaxAssetAmount.Mul(t.MaxAssetPrice)
+
+func validate(tx Transaction) error {
+	switch {
+	case strings.TrimSpace(tx.Portfolio) == "":
+		return errors.New("portfolio is missing")
+	case t.PrettyPortfolioName(tx.Portfolio) == "":
+		return fmt.Errorf("portfolio %q has no latin letters or digits", tx.Portfolio)
+	case !knownTypes[tx.Type]:
+		return fmt.Errorf("unknown type %q", tx.Type)
+	case tx.Asset == "":
+		return errors.New("asset is missing")
+	case !tx.Amount.IsPositive():
+		return errors.New("amount must be greater than 0")
+	case tx.Amount.LessThan(t.MinTransactionNumber):
+		return fmt.Errorf("amount is smaller than %s", t.MinTransactionNumber)
+	case tx.Amount.GreaterThan(t.MaxAssetAmount):
+		return fmt.Errorf("amount is larger than %s", t.MaxAssetAmount)
+	case tx.Price.IsNegative(), tx.QuotePrice.IsNegative():
+		return errors.New("price can't be negative")
+	case tx.Price.GreaterThan(t.MaxAssetPrice), tx.QuotePrice.GreaterThan(t.MaxAssetPrice):
+		return fmt.Errorf("price is larger than %s", t.MaxAssetPrice)
+	case tx.Fee.IsNegative():
+		return errors.New("fee can't be negative")
+	case tx.Fee.GreaterThan(t.MaxAssetPrice):
+		return fmt.Errorf("fee is larger than %s", t.MaxAssetPrice)
+	case tx.AmountUSD.IsNegative(), tx.FeeUSD.IsNegative():
+		return errors.New("USD values can't be negative")
+	case tx.AmountUSD.GreaterThan(maxUSDValue), tx.FeeUSD.GreaterThan(maxUSDValue):
+		return fmt.Errorf("USD values are larger than %s", maxUSDValue)
+	case tx.Date.IsZero():
+		return errors.New("date is missing")
+	case tx.Type == "transfer" && (tx.TransferID == 0 || (tx.Direction != t.TransferIn && tx.Direction != t.TransferOut)):
+		return errors.New("transfer leg without transfer id or direction")
+	}
+	return nil
+}
+
+// PortfolioList converts portfolios for the store
+func (d *Document) PortfolioList() []t.Portfolio {
+	portfolios := make([]t.Portfolio, 0, len(d.Portfolios))
+	for _, p := range d.Portfolios {
+		portfolios = append(portfolios, t.Portfolio{
+			Name:        p.Name,
+			Description: p.Description,
+			IsDefault:   p.IsDefault,
+			CreatedAt:   p.CreatedAt,
+		})
+	}
+	return portfolios
+}
+
+// TransactionList converts transactions for the store
+func (d *Document) TransactionList() []t.Transaction {
+	txs := make([]t.Transaction, 0, len(d.Transactions))
+	for _, tx := range d.Transactions {
+		txs = append(txs, tx.toTransaction())
+	}
+	return txs
+}
@@ -0,0 +1,176 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/internal/importer"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+var (
+	exportedAt = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	testPortfolios = []types.Portfolio{
+		{ID: 1, Name: "Main", IsDefault: true, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Name: "Cold", Description: "hardware wallet", CreatedAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	testTransactions = []types.Transaction{
+		{
+			ID: 10, PortfolioName: "Main", Type: "buy", Asset: "BTC",
+			AssetAmount: decimal.RequireFromString("0.5"), AssetPrice: decimal.NewFromInt(66000),
+			QuoteCurrency: "EUR", QuotePrice: decimal.NewFromInt(60000), USDAmount: decimal.NewFromInt(33000),
+			FeeAmount: decimal.NewFromInt(5), FeeCurrency: "EUR", FeeUSD: decimal.RequireFromString("5.5"),
+			TransactionDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			Note:            "first, \"dip\"", Tags: []string{"dca", "long"}, ImportHash: "abc",
+		},
+		{
+			ID: 11, PortfolioName: "Main", Type: "transfer", Asset: "BTC",
+			AssetAmount: decimal.RequireFromString("0.2"), AssetPrice: decimal.NewFromInt(66000),
+			QuoteCurrency: "USD", QuotePrice: decimal.NewFromInt(66000), USDAmount: decimal.NewFromInt(13200),
+			FeeCurrency: "USD", TransactionDate: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+			TransferID: 11, Direction: types.TransferOut,
+		},
+		{
+			ID: 12, PortfolioName: "Cold", Type: "transfer", Asset: "BTC",
+			AssetAmount: decimal.RequireFromString("0.2"), AssetPrice: decimal.NewFromInt(66000),
+			QuoteCurrency: "USD", QuotePrice: decimal.NewFromInt(66000), USDAmount: decimal.NewFromInt(13200),
+			FeeCurrency: "USD", TransactionDate: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+			TransferID: 11, Direction: types.TransferIn,
+		},
+	}
+)
+
+func export(t *testing.T, format Format) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, testPortfolios, exportedAt)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	for _, tx := range testTransactions {
+		if err := w.Write(tx); err != nil {
+			t.Fatalf("write transaction %d: %v", tx.ID, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	doc, err := Read(bytes.NewReader(export(t, JSON)))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if doc.Version != Version || !doc.ExportedAt.Equal(exportedAt) {
+		t.Errorf("got version %d exported at %s", doc.Version, doc.ExportedAt)
+	}
+
+	portfolios := doc.PortfolioList()
+	if len(portfolios) != len(testPortfolios) {
+		t.Fatalf("got %d portfolios, want %d", len(portfolios), len(testPortfolios))
+	}
+	for i, p := range portfolios {
+		want := testPortfolios[i]
+		want.ID = 0 // ids are not exported
+		if fmt.Sprintf("%+v", p) != fmt.Sprintf("%+v", want) {
+			t.Errorf("portfolio %d: got %+v, want %+v", i, p, want)
+		}
+	}
+
+	txs := doc.TransactionList()
+	if len(txs) != len(testTransactions) {
+		t.Fatalf("got %d transactions, want %d", len(txs), len(testTransactions))
+	}
+	for i, tx := range txs {
+		want := testTransactions[i]
+		// rows exported without a hash get one on restore
+		if want.ImportHash == "" {
+			if tx.ImportHash == "" {
+				t.Errorf("transaction %d: no import hash", tx.ID)
+			}
+			want.ImportHash = tx.ImportHash
+		}
+		if fmt.Sprintf("%+v", tx) != fmt.Sprintf("%+v", want) {
+			t.Errorf("transaction %d:\n got %+v\nwant %+v", want.ID, tx, want)
+		}
+	}
+}
+
+func TestCSVMatchesGenericImport(t *testing.T) {
+	data := export(t, CSV)
+
+	// columns of the generic import format go first and in its order
+	header, _, _ := strings.Cut(string(data), "\n")
+	if !strings.HasPrefix(header, "date,type,asset,amount,price,currency,fee,fee_currency,note,tags,") {
+		t.Errorf("got header %q, want the generic import columns first", header)
+	}
+
+	res, err := importer.Parse(bytes.NewReader(data), importer.Generic)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	// transfers can't be imported, the buy comes back as it was written
+	if len(res.Rows) != 1 || len(res.Errors) != 2 {
+		t.Fatalf("got %d rows, errors %+v, want the buy and both transfer legs rejected", len(res.Rows), res.Errors)
+	}
+
+	got, want := res.Rows[0].Tx, testTransactions[0]
+	if got.Type != want.Type || got.Asset != want.Asset || !got.AssetAmount.Equal(want.AssetAmount) ||
+		got.QuoteCurrency != want.QuoteCurrency || !got.QuotePrice.Equal(want.QuotePrice) ||
+		!got.FeeAmount.Equal(want.FeeAmount) || got.FeeCurrency != want.FeeCurrency ||
+		got.Note != want.Note || strings.Join(got.Tags, ";") != strings.Join(want.Tags, ";") ||
+		!got.TransactionDate.Equal(want.TransactionDate) {
+		t.Errorf("got %+v, want it to match %+v", got, want)
+	}
+}
+
+func TestReadRejectsInvalidRows(t *testing.T) {
+	const row = `{"id":1,"portfolio":"Main","type":"buy","asset":"BTC","amount":"1","price":"60000",` +
+		`"quote_currency":"USD","quote_price":"60000","amount_usd":"60000","fee":"0","fee_usd":"0","date":"2024-03-01T00:00:00Z"}`
+
+	tests := []struct {
+		name    string
+		replace [2]string
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "unknown type", replace: [2]string{`"buy"`, `"stake"`}, wantErr: "unknown type"},
+		{name: "negative amount", replace: [2]string{`"amount":"1"`, `"amount":"-1"`}, wantErr: "amount must be greater than 0"},
+		{name: "dust amount", replace: [2]string{`"amount":"1"`, `"amount":"0.000000001"`}, wantErr: "amount is smaller"},
+		{name: "huge amount", replace: [2]string{`"amount":"1"`, `"amount":"1e12"`}, wantErr: "amount is larger"},
+		{name: "negative price", replace: [2]string{`"price":"60000"`, `"price":"-1"`}, wantErr: "price can't be negative"},
+		{name: "huge quote price", replace: [2]string{`"quote_price":"60000"`, `"quote_price":"1e20"`}, wantErr: "price is larger"},
+		{name: "negative fee", replace: [2]string{`"fee":"0"`, `"fee":"-1"`}, wantErr: "fee can't be negative"},
+		{name: "huge USD amount", replace: [2]string{`"amount_usd":"60000"`, `"amount_usd":"1e30"`}, wantErr: "USD values are larger"},
+		{name: "unbounded exponent", replace: [2]string{`"amount_usd":"60000"`, `"amount_usd":"1e2000000000"`}, wantErr: ErrNotExport.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := row
+			if tt.replace[0] != "" {
+				tx = strings.Replace(row, tt.replace[0], tt.replace[1], 1)
+			}
+			doc := `{"version":1,"portfolios":[{"name":"Main"}],"transactions":[` + tx + `]}`
+
+			_, err := Read(strings.NewReader(doc))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	// Back is the action of the generic back button, it's added to every
+	// prompt that has a previous state
+	Back = "fsm_back"
+	// Upload is the action of a file sent by the user, its argument is the file reference
+	Upload = "fsm_upload"
+)
+
+var (
+	ErrUnknownFlow  = errors.New("unknown flow")
+	ErrUnknownState = errors.New("unknown state")
+	ErrNotAccepted  = errors.New("input is not accepted in this state")
+)
+
+// ValidationError is shown to the user, the flow stays in the same state
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func Invalid(message string) error {
+	return &ValidationError{Message: message}
+}
+
+// Button runs Action with Arg when pressed, encoding them is up to the caller
+type Button struct {
+	Text   string
+	Action string
+	Arg    string
+}
+
+// Prompt is what a state shows to the user, rendering is up to the caller
+type Prompt struct {
+	Text     string
+	Markdown bool
+	Buttons  [][]Button
+	TTL      time.Duration // how long the message stays, 0 means caller's default
+}
+
+// Event is what the user sent: typed text, pressed button or a file
+type Event struct {
+	Text   string
+	Action string
+	Arg    string
+}
+
+func Text(text string) Event {
+	return Event{Text: text}
+}
+
+func Callback(action, arg string) Event {
+	return Event{Action: action, Arg: arg}
+}
+
+func File(ref string) Event {
+	return Event{Action: Upload, Arg: ref}
+}
+
+// Input is one kind of event a state accepts
+type Input[D any] struct {
+	// button action, its argument is passed to Handle.
+	// empty action accepts typed text
+	Action string
+	// states Handle may return, checked when the flow is built and on every transition
+	Next []string
+	// validates the value, updates data and returns the next state.
+	// returning the current state shows its prompt again
+	Handle func(ctx context.Context, data D, value string) (string, error)
+}
+
+type State[D any] struct {
+	Name   string
+	Prompt func(ctx context.Context, data D) (Prompt, error)
+	// state without inputs is final: its prompt is shown and the flow ends
+	Inputs []Input[D]
+}
+
+func (s State[D]) final() bool {
+	return len(s.Inputs) == 0
+}
+
+func (s State[D]) match(ev Event) (Input[D], string, bool) {
+	for _, in := range s.Inputs {
+		if in.Action == ev.Action {
+			if ev.Action == "" {
+				return in, ev.Text, true
+			}
+			return in, ev.Arg, true
+		}
+	}
+	return Input[D]{}, "", false
+}
+
+type Flow[D any] struct {
+	Name   string
+	start  string
+	states map[string]State[D]
+}
+
+// NewFlow builds a flow starting at the first state. it panics on duplicate
+// states and transitions to unknown ones, flows are declared at startup
+func NewFlow[D any](name string, states ...State[D]) *Flow[D] {
+	if len(states) == 0 {
+		panic(fmt.Sprintf("fsm: flow %s has no states", name))
+	}
+
+	f := &Flow[D]{
+		Name:   name,
+		start:  states[0].Name,
+		states: make(map[string]State[D], len(states)),
+	}
+	for _, st := range states {
+		if _, exists := f.states[st.Name]; exists {
+			panic(fmt.Sprintf("fsm: flow %s: duplicate state %s", name, st.Name))
+		}
+		f.states[st.Name] = st
+	}
+
+	for _, st := range states {
+		for _, in := range st.Inputs {
+			for _, next := range in.Next {
+				if _, exists := f.states[next]; !exists {
+					panic(fmt.Sprintf("fsm: flow %s: state %s leads to unknown state %s", name, st.Name, next))
+				}
+			}
+		}
+	}
+
+	return f
+}
+
+// Position of a user in a flow, the caller keeps it between events
+type Position struct {
+	Flow    string
+	State   string
+	History []string // visited states for Back, oldest first
+}
+
+func (p Position) Active() bool {
+	return p.Flow != ""
+}
+
+// Reply is the result of an event
+type Reply struct {
+	Prompt Prompt
+	Error  string // validation message, the prompt is the same state again
+	Done   bool   // flow reached a final state and position is reset
+}
+
+type Machine[D any] struct {
+	flows map[string]*Flow[D]
+}
+
+func NewMachine[D any](flows ...*Flow[D]) *Machine[D] {
+	m := &Machine[D]{flows: make(map[string]*Flow[D], len(flows))}
+	for _, f := range flows {
+		m.flows[f.Name] = f
+	}
+	return m
+}
+
+// Start resets position to the first state of the flow
+func (m *Machine[D]) Start(ctx context.Context, pos *Position, flow string, data D) (Reply, error) {
+	f, ok := m.flows[flow]
+	if !ok {
+		return Reply{}, fmt.Errorf("%w: %s", ErrUnknownFlow, flow)
+	}
+	return m.StartAt(ctx, pos, flow, f.start, data)
+}
+
+// StartAt resets position to the given state, for entries that skip the first steps
+func (m *Machine[D]) StartAt(ctx context.Context, pos *Position, flow, state string, data D) (Reply, error) {
+	f, ok := m.flows[flow]
+	if !ok {
+		return Reply{}, fmt.Errorf("%w: %s", ErrUnknownFlow, flow)
+	}
+	if _, ok := f.states[state]; !ok {
+		return Reply{}, fmt.Errorf("%w: %s.%s", ErrUnknownState, flow, state)
+	}
+
+	*pos = Position{Flow: flow, State: state}
+	return m.enter(ctx, f, pos, data)
+}
+
+// Accepts reports whether the current state handles the event,
+// other events are left to the caller
+func (m *Machine[D]) Accepts(pos Position, ev Event) bool {
+	st, ok := m.current(pos)
+	if !ok {
+		return false
+	}
+	if ev.Action == Back {
+		return len(pos.History) > 0
+	}
+	_, _, ok = st.match(ev)
+	return ok
+}
+
+// Handle moves the flow by one event
+func (m *Machine[D]) Handle(ctx context.Context, pos *Position, data D, ev Event) (Reply, error) {
+	st, ok := m.current(*pos)
+	if !ok {
+		return Reply{}, fmt.Errorf("%w: %s.%s", ErrUnknownState, pos.Flow, pos.State)
+	}
+	f := m.flows[pos.Flow]
+
+	if ev.Action == Back {
+		if len(pos.History) == 0 {
+			return Reply{}, ErrNotAccepted
+		}
+		pos.State = pos.History[len(pos.History)-1]
+		pos.History = pos.History[:len(pos.History)-1]
+		return m.enter(ctx, f, pos, data)
+	}
+
+	in, value, ok := st.match(ev)
+	if !ok {
+		return Reply{}, ErrNotAccepted
+	}
+
+	next, err := in.Handle(ctx, data, value)
+	if err != nil {
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			return Reply{}, fmt.Errorf("%s.%s: %w", pos.Flow, pos.State, err)
+		}
+		reply, err := m.enter(ctx, f, pos, data)
+		reply.Error = verr.Message
+		return reply, err
+	}
+
+	if next != pos.State {
+		if !slices.Contains(in.Next, next) {
+			return Reply{}, fmt.Errorf("%w: %s.%s -> %s is not declared", ErrUnknownState, pos.Flow, pos.State, next)
+		}
+		// going back to a visited state unwinds the history, so Back never loops
+		if i := slices.Index(pos.History, next); i >= 0 {
+			pos.History = pos.History[:i]
+		} else {
+			pos.History = append(pos.History, pos.State)
+		}
+		pos.State = next
+	}
+
+	return m.enter(ctx, f, pos, data)
+}
+
+func (m *Machine[D]) current(pos Position) (State[D], bool) {
+	f, ok := m.flows[pos.Flow]
+	if !ok {
+		return State[D]{}, false
+	}
+	st, ok := f.states[pos.State]
+	return st, ok
+}
+
+// renders prompt of the current state, final state resets the position
+func (m *Machine[D]) enter(ctx context.Context, f *Flow[D], pos *Position, data D) (Reply, error) {
+	st := f.states[pos.State]
+
+	prompt, err := st.Prompt(ctx, data)
+	if err != nil {
+		return Reply{}, fmt.Errorf("%s.%s prompt: %w", f.Name, st.Name, err)
+	}
+
+	if st.final() {
+		*pos = Position{}
+		return Reply{Prompt: prompt, Done: true}, nil
+	}
+
+	if len(pos.History) > 0 {
+		prompt.Buttons = append(prompt.Buttons, []Button{{Text: "⬅️ Back", Action: Back}})
+	}
+	return Reply{Prompt: prompt}, nil
+}
@@ -0,0 +1,339 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+var errStoreDown = errors.New("store is down")
+
+type order struct {
+	Asset   string
+	Amount  int
+	Receipt string
+}
+
+// asset -> amount -> confirm -> placed | cancelled, confirm can go back to asset
+func orderFlow() *Flow[*order] {
+	prompt := func(text string) func(context.Context, *order) (Prompt, error) {
+		return func(context.Context, *order) (Prompt, error) {
+			return Prompt{Text: text}, nil
+		}
+	}
+
+	return NewFlow("order",
+		State[*order]{
+			Name:   "asset",
+			Prompt: prompt("Choose asset"),
+			Inputs: []Input[*order]{
+				{
+					Action: "asset",
+					Next:   []string{"amount"},
+					Handle: func(_ context.Context, o *order, value string) (string, error) {
+						o.Asset = value
+						return "amount", nil
+					},
+				},
+				{
+					// leads to a state it didn't declare
+					Action: "skip",
+					Next:   []string{"amount"},
+					Handle: func(context.Context, *order, string) (string, error) {
+						return "confirm", nil
+					},
+				},
+			},
+		},
+		State[*order]{
+			Name: "amount",
+			Prompt: func(_ context.Context, o *order) (Prompt, error) {
+				return Prompt{Text: "Amount of " + o.Asset}, nil
+			},
+			Inputs: []Input[*order]{{
+				Next: []string{"confirm"},
+				Handle: func(_ context.Context, o *order, value string) (string, error) {
+					amount, err := strconv.Atoi(value)
+					switch {
+					case err != nil:
+						return "", Invalid("Amount must be a number.")
+					case amount < 0:
+						return "", errStoreDown
+					case amount == 0:
+						// asked again without an error
+						return "amount", nil
+					}
+					o.Amount = amount
+					return "confirm", nil
+				},
+			}},
+		},
+		State[*order]{
+			Name:   "confirm",
+			Prompt: prompt("Place the order?"),
+			Inputs: []Input[*order]{
+				{
+					Action: "yes",
+					Next:   []string{"placed"},
+					Handle: func(context.Context, *order, string) (string, error) { return "placed", nil },
+				},
+				{
+					Action: "no",
+					Next:   []string{"cancelled"},
+					Handle: func(context.Context, *order, string) (string, error) { return "cancelled", nil },
+				},
+				{
+					Action: "change",
+					Next:   []string{"asset"},
+					Handle: func(context.Context, *order, string) (string, error) { return "asset", nil },
+				},
+				{
+					Action: Upload,
+					Next:   []string{"placed"},
+					Handle: func(_ context.Context, o *order, value string) (string, error) {
+						o.Receipt = value
+						return "placed", nil
+					},
+				},
+			},
+		},
+		State[*order]{Name: "placed", Prompt: prompt("Order placed")},
+		State[*order]{Name: "cancelled", Prompt: prompt("Order cancelled")},
+	)
+}
+
+func hasBack(p Prompt) bool {
+	for _, row := range p.Buttons {
+		for _, b := range row {
+			if b.Action == Back {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestMachineHandle(t *testing.T) {
+	tests := []struct {
+		name        string
+		events      []Event // all but the last one must succeed
+		wantErr     error
+		wantState   string // empty when the flow ended
+		wantHistory []string
+		wantPrompt  string
+		wantError   string
+		wantBack    bool
+		wantDone    bool
+	}{
+		{
+			name:        "button moves to declared state",
+			events:      []Event{Callback("asset", "BTC")},
+			wantState:   "amount",
+			wantHistory: []string{"asset"},
+			wantPrompt:  "Amount of BTC",
+			wantBack:    true,
+		},
+		{
+			name:        "typed text moves to declared state",
+			events:      []Event{Callback("asset", "BTC"), Text("2")},
+			wantState:   "confirm",
+			wantHistory: []string{"asset", "amount"},
+			wantPrompt:  "Place the order?",
+			wantBack:    true,
+		},
+		{
+			name:        "undeclared next state",
+			events:      []Event{Callback("skip", "")},
+			wantErr:     ErrUnknownState,
+			wantState:   "asset",
+			wantHistory: nil,
+		},
+		{
+			name:        "validation error shows the same prompt again",
+			events:      []Event{Callback("asset", "BTC"), Text("two")},
+			wantState:   "amount",
+			wantHistory: []string{"asset"},
+			wantPrompt:  "Amount of BTC",
+			wantError:   "Amount must be a number.",
+			wantBack:    true,
+		},
+		{
+			name:        "returning current state doesn't grow history",
+			events:      []Event{Callback("asset", "BTC"), Text("0")},
+			wantState:   "amount",
+			wantHistory: []string{"asset"},
+			wantPrompt:  "Amount of BTC",
+			wantBack:    true,
+		},
+		{
+			name:        "handler error is returned",
+			events:      []Event{Callback("asset", "BTC"), Text("-1")},
+			wantErr:     errStoreDown,
+			wantState:   "amount",
+			wantHistory: []string{"asset"},
+		},
+		{
+			name:      "event the state doesn't accept",
+			events:    []Event{Text("BTC")},
+			wantErr:   ErrNotAccepted,
+			wantState: "asset",
+		},
+		{
+			name:        "back returns to previous state",
+			events:      []Event{Callback("asset", "BTC"), Text("2"), Callback(Back, "")},
+			wantState:   "amount",
+			wantHistory: []string{"asset"},
+			wantPrompt:  "Amount of BTC",
+			wantBack:    true,
+		},
+		{
+			name:       "back to first state has no back button",
+			events:     []Event{Callback("asset", "BTC"), Callback(Back, "")},
+			wantState:  "asset",
+			wantPrompt: "Choose asset",
+		},
+		{
+			name:      "back without history",
+			events:    []Event{Callback(Back, "")},
+			wantErr:   ErrNotAccepted,
+			wantState: "asset",
+		},
+		{
+			name:       "going to a visited state unwinds history",
+			events:     []Event{Callback("asset", "BTC"), Text("2"), Callback("change", "")},
+			wantState:  "asset",
+			wantPrompt: "Choose asset",
+		},
+		{
+			name:       "file is handled by the upload input",
+			events:     []Event{Callback("asset", "BTC"), Text("2"), File("receipt.pdf")},
+			wantPrompt: "Order placed",
+			wantDone:   true,
+		},
+		{
+			name:       "final state ends the flow",
+			events:     []Event{Callback("asset", "BTC"), Text("2"), Callback("yes", "")},
+			wantPrompt: "Order placed",
+			wantDone:   true,
+		},
+		{
+			name:       "other final state ends the flow too",
+			events:     []Event{Callback("asset", "BTC"), Text("2"), Callback("no", "")},
+			wantPrompt: "Order cancelled",
+			wantDone:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewMachine(orderFlow())
+			data := &order{}
+
+			var pos Position
+			if _, err := m.Start(ctx, &pos, "order", data); err != nil {
+				t.Fatalf("start: %v", err)
+			}
+
+			var reply Reply
+			var err error
+			for i, ev := range tt.events {
+				reply, err = m.Handle(ctx, &pos, data, ev)
+				if i < len(tt.events)-1 && err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if pos.State != tt.wantState || !slices.Equal(pos.History, tt.wantHistory) {
+				t.Errorf("got position %s %v, want %s %v", pos.State, pos.History, tt.wantState, tt.wantHistory)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if reply.Prompt.Text != tt.wantPrompt {
+				t.Errorf("got prompt %q, want %q", reply.Prompt.Text, tt.wantPrompt)
+			}
+			if reply.Error != tt.wantError {
+				t.Errorf("got validation error %q, want %q", reply.Error, tt.wantError)
+			}
+			if hasBack(reply.Prompt) != tt.wantBack {
+				t.Errorf("got back button %v, want %v", hasBack(reply.Prompt), tt.wantBack)
+			}
+			if reply.Done != tt.wantDone || pos.Active() == tt.wantDone {
+				t.Errorf("got done %v, active %v, want done %v", reply.Done, pos.Active(), tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestMachineAccepts(t *testing.T) {
+	m := NewMachine(orderFlow())
+	pos := Position{Flow: "order", State: "amount", History: []string{"asset"}}
+
+	tests := []struct {
+		name string
+		pos  Position
+		ev   Event
+		want bool
+	}{
+		{name: "text in text state", pos: pos, ev: Text("2"), want: true},
+		{name: "unknown button", pos: pos, ev: Callback("asset", "ETH"), want: false},
+		{name: "file in text state", pos: pos, ev: File("receipt.pdf"), want: false},
+		{name: "file in upload state", pos: Position{Flow: "order", State: "confirm"}, ev: File("receipt.pdf"), want: true},
+		{name: "back with history", pos: pos, ev: Callback(Back, ""), want: true},
+		{name: "back without history", pos: Position{Flow: "order", State: "asset"}, ev: Callback(Back, ""), want: false},
+		{name: "no active flow", pos: Position{}, ev: Text("2"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Accepts(tt.pos, tt.ev); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMachineStart(t *testing.T) {
+	ctx := context.Background()
+	m := NewMachine(orderFlow())
+	data := &order{Asset: "ETH"}
+
+	pos := Position{Flow: "order", State: "confirm", History: []string{"asset", "amount"}}
+	reply, err := m.StartAt(ctx, &pos, "order", "amount", data)
+	if err != nil {
+		t.Fatalf("start at: %v", err)
+	}
+	if pos.State != "amount" || len(pos.History) != 0 || hasBack(reply.Prompt) {
+		t.Errorf("got position %+v, back %v, want fresh amount state", pos, hasBack(reply.Prompt))
+	}
+
+	if _, err := m.Start(ctx, &pos, "refund", data); !errors.Is(err, ErrUnknownFlow) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownFlow)
+	}
+	if _, err := m.StartAt(ctx, &pos, "order", "shipped", data); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownState)
+	}
+}
+
+func TestNewFlowPanicsOnUnknownNextState(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewFlow didn't panic")
+		}
+	}()
+
+	NewFlow("broken", State[*order]{
+		Name: "start",
+		Inputs: []Input[*order]{{
+			Next:   []string{"nowhere"},
+			Handle: func(context.Context, *order, string) (string, error) { return "nowhere", nil },
+		}},
+	})
+}
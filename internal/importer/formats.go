@@ -0,0 +1,286 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// fees charged in the quote currency, USD quotes keep the plain USD ticker
+func quoteFeeCurrency(quote string) string {
+	if t.IsUSD(quote) {
+		return t.FeeCurrencyUSD
+	}
+	return quote
+}
+
+// numbers come with thousand separators and currency signs in some exports
+func parseNumber(raw string) (decimal.Decimal, error) {
+	cleaned := strings.NewReplacer(",", "", "$", "", " ", "").Replace(strings.TrimSpace(raw))
+	if cleaned == "" {
+		return decimal.Zero, nil
+	}
+	v, err := decimal.NewFromString(cleaned)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("wrong number %q", raw)
+	}
+	return v, nil
+}
+
+var amountWithTickerRe = regexp.MustCompile(`^([0-9.,]+)\s*([A-Za-z0-9]*)$`)
+
+// splits values like "0.00100000BTC" into amount and ticker
+func parseAmountWithTicker(raw string) (decimal.Decimal, string, error) {
+	m := amountWithTickerRe.FindStringSubmatch(strings.TrimSpace(raw))
+	if m == nil {
+		return decimal.Zero, "", fmt.Errorf("wrong amount %q", raw)
+	}
+	v, err := parseNumber(m[1])
+	if err != nil {
+		return decimal.Zero, "", err
+	}
+	return v, strings.ToUpper(m[2]), nil
+}
+
+// fractional seconds are accepted by all layouts while parsing
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseDate(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range dateLayouts {
+		if d, err := time.Parse(layout, raw); err == nil {
+			return d.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("wrong date %q", raw)
+}
+
+func parseSide(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "buy":
+		return "buy", nil
+	case "sell":
+		return "sell", nil
+	}
+	return "", fmt.Errorf("unknown side %q", raw)
+}
+
+// Date(UTC),Pair,Side,Price,Executed,Amount,Fee
+// executed and fee carry their ticker, e.g. "0.00100000BTC", "0.0001BNB"
+func parseBinance(r record) (t.TempTransactionData, error) {
+	var tx t.TempTransactionData
+	var err error
+
+	if tx.Type, err = parseSide(r.get("side")); err != nil {
+		return tx, err
+	}
+	if tx.TransactionDate, err = parseDate(r.get("date(utc)")); err != nil {
+		return tx, err
+	}
+	if tx.AssetAmount, tx.Asset, err = parseAmountWithTicker(r.get("executed")); err != nil {
+		return tx, err
+	}
+
+	pair := strings.ToUpper(r.get("pair"))
+	quote, ok := strings.CutPrefix(pair, tx.Asset)
+	if tx.Asset == "" || !ok || quote == "" {
+		return tx, fmt.Errorf("can't split pair %q", pair)
+	}
+	tx.QuoteCurrency = quote
+
+	if tx.QuotePrice, err = parseNumber(r.get("price")); err != nil {
+		return tx, err
+	}
+	if tx.FeeAmount, tx.FeeCurrency, err = parseAmountWithTicker(r.get("fee")); err != nil {
+		return tx, err
+	}
+
+	return tx, nil
+}
+
+var coinbaseTypes = map[string]string{
+	"buy":                 "buy",
+	"advanced trade buy":  "buy",
+	"sell":                "sell",
+	"advanced trade sell": "sell",
+	"rewards income":      "income",
+	"staking income":      "income",
+	"learning reward":     "income",
+	"inflation reward":    "income",
+	"coinbase earn":       "income",
+}
+
+// Timestamp,Transaction Type,Asset,Quantity Transacted,Spot Price Currency,Spot Price at Transaction,
+// Subtotal,Total (inclusive of fees and/or spread),Fees and/or Spread,Notes
+func parseCoinbase(r record) (t.TempTransactionData, error) {
+	var tx t.TempTransactionData
+	var err error
+
+	rawType := r.get("transaction type")
+	txType, ok := coinbaseTypes[strings.ToLower(rawType)]
+	if !ok {
+		return tx, fmt.Errorf("transaction type %q is not supported", rawType)
+	}
+	tx.Type = txType
+
+	if tx.TransactionDate, err = parseDate(r.get("timestamp")); err != nil {
+		return tx, err
+	}
+
+	tx.Asset = strings.ToUpper(r.get("asset"))
+
+	// sells are negative in newer exports
+	if tx.AssetAmount, err = parseNumber(strings.TrimPrefix(r.get("quantity transacted"), "-")); err != nil {
+		return tx, err
+	}
+
+	tx.QuoteCurrency = strings.ToUpper(r.get("spot price currency"))
+	if tx.QuotePrice, err = parseNumber(r.get("spot price at transaction")); err != nil {
+		return tx, err
+	}
+
+	if tx.Type != "income" {
+		if tx.FeeAmount, err = parseNumber(r.get("fees and/or spread")); err != nil {
+			return tx, err
+		}
+		tx.FeeCurrency = quoteFeeCurrency(tx.QuoteCurrency)
+	}
+
+	tx.Note = r.get("notes")
+
+	return tx, nil
+}
+
+// "txid","ordertxid","pair","time","type","ordertype","price","cost","fee","vol","margin","misc","ledgers"
+// price and fee are in the quote currency, vol is the traded amount
+func parseKraken(r record) (t.TempTransactionData, error) {
+	var tx t.TempTransactionData
+	var err error
+
+	if tx.Type, err = parseSide(r.get("type")); err != nil {
+		return tx, err
+	}
+	if tx.TransactionDate, err = parseDate(r.get("time")); err != nil {
+		return tx, err
+	}
+
+	base, quote, ok := splitKrakenPair(r.get("pair"))
+	if !ok {
+		return tx, fmt.Errorf("can't split pair %q", r.get("pair"))
+	}
+	tx.Asset = base
+	tx.QuoteCurrency = quote
+
+	if tx.AssetAmount, err = parseNumber(r.get("vol")); err != nil {
+		return tx, err
+	}
+	if tx.QuotePrice, err = parseNumber(r.get("price")); err != nil {
+		return tx, err
+	}
+	if tx.FeeAmount, err = parseNumber(r.get("fee")); err != nil {
+		return tx, err
+	}
+	tx.FeeCurrency = quoteFeeCurrency(quote)
+
+	return tx, nil
+}
+
+// kraken prefixes legacy assets with X (crypto) and Z (fiat) and has own tickers for a few
+func krakenAsset(code string) string {
+	if len(code) == 4 && (code[0] == 'X' || code[0] == 'Z') {
+		code = code[1:]
+	}
+	switch code {
+	case "XBT":
+		return "BTC"
+	case "XDG":
+		return "DOGE"
+	}
+	return code
+}
+
+// splits pairs like "XXBTZUSD", "SOLEUR" or "XBT/USD"
+func splitKrakenPair(raw string) (string, string, bool) {
+	pair := strings.ToUpper(strings.TrimSpace(raw))
+
+	if base, quote, ok := strings.Cut(pair, "/"); ok {
+		return krakenAsset(base), krakenAsset(quote), true
+	}
+	if len(pair) == 8 && pair[0] == 'X' && (pair[4] == 'Z' || pair[4] == 'X') {
+		return krakenAsset(pair[:4]), krakenAsset(pair[4:]), true
+	}
+	for _, quote := range []string{"USDT", "USDC", "USD", "EUR", "GBP"} {
+		if base, ok := strings.CutSuffix(pair, quote); ok && base != "" {
+			return krakenAsset(base), quote, true
+		}
+	}
+	return "", "", false
+}
+
+// transfers need two portfolios, so they can't come from a file
+var genericTypes = []string{"buy", "sell", "income", "airdrop", "gift", "deposit", "withdrawal"}
+
+var genericTagRe = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+// date,type,asset,amount,price and optional currency (of the price, USD by default),
+// fee,fee_currency,note,tags (separated by ";")
+func parseGeneric(r record) (t.TempTransactionData, error) {
+	var tx t.TempTransactionData
+	var err error
+
+	tx.Type = strings.ToLower(r.get("type"))
+	known := false
+	for _, txType := range genericTypes {
+		known = known || tx.Type == txType
+	}
+	if !known {
+		return tx, fmt.Errorf("transaction type %q is not supported", r.get("type"))
+	}
+
+	if tx.TransactionDate, err = parseDate(r.get("date")); err != nil {
+		return tx, err
+	}
+
+	tx.Asset = strings.ToUpper(r.get("asset"))
+	if tx.Asset == "" && t.IsFiatFlow(tx.Type) {
+		tx.Asset = t.FiatUSD
+	}
+
+	if tx.AssetAmount, err = parseNumber(r.get("amount")); err != nil {
+		return tx, err
+	}
+	if tx.QuotePrice, err = parseNumber(r.get("price")); err != nil {
+		return tx, err
+	}
+	tx.QuoteCurrency = strings.ToUpper(r.get("currency"))
+	if tx.QuoteCurrency == "" {
+		tx.QuoteCurrency = t.FiatUSD
+	}
+	if tx.FeeAmount, err = parseNumber(r.get("fee")); err != nil {
+		return tx, err
+	}
+	tx.FeeCurrency = strings.ToUpper(r.get("fee_currency"))
+	tx.Note = r.get("note")
+
+	for _, tag := range strings.FieldsFunc(r.get("tags"), func(r rune) bool { return r == ';' || r == ' ' }) {
+		tag = strings.TrimPrefix(tag, "#")
+		if !genericTagRe.MatchString(tag) {
+			return tx, errors.New("wrong tag " + strconv.Quote(tag))
+		}
+		tx.Tags = append(tx.Tags, tag)
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,334 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// Format is a layout of a CSV file exported by an exchange or written by hand
+type Format string
+
+const (
+	Binance  Format = "binance"  // spot trade history
+	Coinbase Format = "coinbase" // transaction history report
+	Kraken   Format = "kraken"   // trades export
+	Generic  Format = "generic"  // date,type,asset,amount,price[,currency,fee,fee_currency,note,tags]
+)
+
+// detection goes in this order, generic is the loosest so it's the last one
+var Formats = []Format{Binance, Coinbase, Kraken, Generic}
+
+func ParseFormat(raw string) (Format, error) {
+	f := Format(strings.ToLower(strings.TrimSpace(raw)))
+	for _, known := range Formats {
+		if f == known {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown import format: %s", raw)
+}
+
+func (f Format) Title() string {
+	switch f {
+	case Binance:
+		return "Binance trade history"
+	case Coinbase:
+		return "Coinbase transaction history"
+	case Kraken:
+		return "Kraken trades"
+	default:
+		return "Generic"
+	}
+}
+
+const (
+	MaxRows          = 10000
+	maxPreambleLines = 10 // coinbase puts a few lines of text before the header
+	maxNoteLength    = 500
+)
+
+var (
+	ErrUnknownFormat = errors.New("could not detect file format")
+	ErrNoHeader      = errors.New("header of the chosen format not found")
+	ErrTooManyRows   = fmt.Errorf("file has more than %d rows", MaxRows)
+)
+
+// Row is a valid transaction parsed from the file
+type Row struct {
+	Line int
+	Tx   t.TempTransactionData
+}
+
+// RowError is a line that could not be imported
+type RowError struct {
+	Line int
+	Err  string
+}
+
+type Result struct {
+	Format Format
+	Rows   []Row
+	Errors []RowError
+}
+
+// one data line with header lookup
+type record struct {
+	cols   map[string]int
+	values []string
+}
+
+func (r record) get(name string) string {
+	i, ok := r.cols[name]
+	if !ok || i >= len(r.values) {
+		return ""
+	}
+	return strings.TrimSpace(r.values[i])
+}
+
+type spec struct {
+	required []string // header columns identifying the format, lower case
+	parse    func(r record) (t.TempTransactionData, error)
+}
+
+var specs = map[Format]spec{
+	Binance:  {required: []string{"date(utc)", "pair", "side", "price", "executed", "amount", "fee"}, parse: parseBinance},
+	Coinbase: {required: []string{"timestamp", "transaction type", "asset", "quantity transacted", "spot price currency", "spot price at transaction"}, parse: parseCoinbase},
+	Kraken:   {required: []string{"txid", "pair", "time", "type", "price", "cost", "fee", "vol"}, parse: parseKraken},
+	Generic:  {required: []string{"date", "type", "asset", "amount", "price"}, parse: parseGeneric},
+}
+
+// Parse reads the whole file, empty format means it's detected from the header.
+// invalid lines are collected in Result.Errors and don't stop parsing
+func Parse(r io.Reader, format Format) (*Result, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var (
+		res  = &Result{Format: format}
+		cols map[string]int
+		seen = make(map[string]int)
+	)
+
+	for n := 0; ; n++ {
+		values, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		if cols == nil {
+			if n >= maxPreambleLines {
+				break
+			}
+			cols = headerColumns(values)
+			if res.Format == "" {
+				res.Format = detect(cols)
+			}
+			if res.Format == "" || !hasColumns(cols, specs[res.Format].required) {
+				cols = nil
+			}
+			continue
+		}
+
+		if isBlank(values) {
+			continue
+		}
+		if len(res.Rows)+len(res.Errors) >= MaxRows {
+			return nil, ErrTooManyRows
+		}
+
+		tx, err := specs[res.Format].parse(record{cols: cols, values: values})
+		if err == nil {
+			err = finish(&tx)
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, RowError{Line: line, Err: err.Error()})
+			continue
+		}
+
+		tx.ImportHash = Hash(tx, seen)
+		res.Rows = append(res.Rows, Row{Line: line, Tx: tx})
+	}
+
+	if cols == nil {
+		if format == "" {
+			return nil, ErrUnknownFormat
+		}
+		return nil, ErrNoHeader
+	}
+
+	return res, nil
+}
+
+func headerColumns(values []string) map[string]int {
+	cols := make(map[string]int, len(values))
+	for i, v := range values {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(v, "\ufeff")))
+		if _, ok := cols[name]; !ok {
+			cols[name] = i
+		}
+	}
+	return cols
+}
+
+func hasColumns(cols map[string]int, required []string) bool {
+	for _, name := range required {
+		if _, ok := cols[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func detect(cols map[string]int) Format {
+	for _, f := range Formats {
+		if hasColumns(cols, specs[f].required) {
+			return f
+		}
+	}
+	return ""
+}
+
+func isBlank(values []string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+var assetRe = regexp.MustCompile(`^[A-Z0-9]{2,10}$`)
+
+// validates the parsed row the same way the wizard does and fills USD values of rows priced in USD,
+// other rows are converted by ConvertQuotes
+func finish(tx *t.TempTransactionData) error {
+	if t.IsFiatFlow(tx.Type) {
+		tx.QuoteCurrency = t.FiatUSD
+		tx.QuotePrice = decimal.NewFromInt(1)
+	}
+
+	switch {
+	case !assetRe.MatchString(tx.Asset):
+		return fmt.Errorf("wrong asset %q", tx.Asset)
+	case !assetRe.MatchString(tx.Quote()):
+		return fmt.Errorf("wrong currency %q", tx.QuoteCurrency)
+	case !tx.AssetAmount.IsPositive():
+		return errors.New("amount must be greater than 0")
+	case tx.AssetAmount.LessThan(t.MinTransactionNumber):
+		return fmt.Errorf("amount is smaller than %s", t.MinTransactionNumber)
+	case tx.AssetAmount.GreaterThan(t.MaxAssetAmount):
+		return fmt.Errorf("amount is larger than %s", t.MaxAssetAmount)
+	case tx.QuotePrice.IsNegative(), tx.QuotePrice.IsZero() && tx.Type != "gift":
+		return errors.New("price must be greater than 0")
+	case tx.QuotePrice.GreaterThan(t.MaxAssetPrice):
+		return fmt.Errorf("price is larger than %s", t.MaxAssetPrice)
+	case tx.FeeAmount.IsNegative():
+		return errors.New("fee can't be negative")
+	case tx.FeeAmount.GreaterThan(t.MaxAssetPrice):
+		return fmt.Errorf("fee is larger than %s", t.MaxAssetPrice)
+	case tx.TransactionDate.IsZero():
+		return errors.New("date is missing")
+	case tx.TransactionDate.After(time.Now().AddDate(0, 0, 1)):
+		return errors.New("date is in the future")
+	case utf8.RuneCountInString(tx.Note) > maxNoteLength:
+		return fmt.Errorf("note is longer than %d characters", maxNoteLength)
+	}
+
+	if tx.FeeCurrency == "" || tx.FeeAmount.IsZero() {
+		tx.FeeCurrency = t.FeeCurrencyUSD
+	}
+
+	// fees in a third currency (e.g. BNB) are valued by ConvertQuotes
+	if t.IsUSD(tx.QuoteCurrency) {
+		tx.ApplyQuoteRate(decimal.NewFromInt(1))
+	}
+
+	return nil
+}
+
+// ConvertQuotes fills USD values of rows priced in other currencies and of fees paid in a third
+// currency, rate returns the USD value of one unit of the currency on the day. rows without a rate
+// are moved to the errors
+func ConvertQuotes(res *Result, rate func(currency string, date time.Time) (decimal.Decimal, error)) {
+	rows := res.Rows[:0]
+	failed := false
+	for _, row := range res.Rows {
+		if err := convertRow(&row.Tx, rate); err != nil {
+			res.Errors = append(res.Errors, RowError{Line: row.Line, Err: err.Error()})
+			failed = true
+			continue
+		}
+		rows = append(rows, row)
+	}
+	res.Rows = rows
+
+	if failed {
+		sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+	}
+}
+
+func convertRow(tx *t.TempTransactionData, rate func(currency string, date time.Time) (decimal.Decimal, error)) error {
+	if !t.IsUSD(tx.QuoteCurrency) {
+		r, err := rate(tx.QuoteCurrency, tx.TransactionDate)
+		if err != nil {
+			return fmt.Errorf("no %s rate for %s", tx.QuoteCurrency, tx.TransactionDate.Format("2006-01-02"))
+		}
+		tx.ApplyQuoteRate(r)
+		// a price in BTC can be far above the limit once valued in USD
+		if tx.AssetPrice.GreaterThan(t.MaxAssetPrice) {
+			return fmt.Errorf("USD price %s is larger than %s", tx.AssetPrice, t.MaxAssetPrice)
+		}
+	}
+
+	// fees in a third currency (e.g. BNB) are valued with the rate of that currency
+	if _, ok := tx.FeeToUSD(); !ok {
+		r, err := rate(tx.FeeCurrency, tx.TransactionDate)
+		if err != nil {
+			return fmt.Errorf("no %s rate for %s to value the fee", tx.FeeCurrency, tx.TransactionDate.Format("2006-01-02"))
+		}
+		tx.FeeUSD = tx.FeeAmount.Mul(r)
+	}
+
+	return nil
+}
+
+// Hash identifies the transaction regardless of the file it came from, identical rows
+// (e.g. partial fills) are told apart by their position among the equal ones counted in seen
+func Hash(tx t.TempTransactionData, seen map[string]int) string {
+	key := strings.Join([]string{
+		tx.TransactionDate.UTC().Format(time.RFC3339),
+		tx.Type,
+		tx.Asset,
+		tx.AssetAmount.String(),
+		tx.QuotePrice.String(),
+		tx.FeeAmount.String(),
+		tx.FeeCurrency,
+	}, "|")
+	// rows priced in USD keep the hashes they had before currencies were recorded
+	if !t.IsUSD(tx.QuoteCurrency) {
+		key += "|" + tx.QuoteCurrency
+	}
+
+	seen[key]++
+	sum := sha256.Sum256([]byte(key + "#" + strconv.Itoa(seen[key])))
+	return hex.EncodeToString(sum[:])
+}
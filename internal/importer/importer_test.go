@@ -0,0 +1,89 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+func TestConvertQuotesValuesThirdCurrencyFees(t *testing.T) {
+	const csv = "date,type,asset,amount,price,currency,fee,fee_currency\n" +
+		"2024-03-01,buy,BTC,0.5,60000,USDT,0.01,BNB\n" + // fee in a third currency
+		"2024-03-02,buy,BTC,0.5,55000,EUR,1,EUR\n" + // fee in the quote currency
+		"2024-03-03,buy,ETH,1,3000,USD,0.02,DOGE\n" // fee currency without a rate
+
+	res, err := Parse(strings.NewReader(csv), Generic)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	rates := map[string]decimal.Decimal{
+		"BNB": decimal.NewFromInt(400),
+		"EUR": decimal.RequireFromString("1.1"),
+	}
+	ConvertQuotes(res, func(currency string, _ time.Time) (decimal.Decimal, error) {
+		if r, ok := rates[currency]; ok {
+			return r, nil
+		}
+		return decimal.Zero, errors.New("no rate")
+	})
+
+	if len(res.Rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(res.Rows))
+	}
+	if got := res.Rows[0].Tx.FeeUSD.String(); got != "4" {
+		t.Errorf("BNB fee: got %s USD, want 4", got)
+	}
+	if got := res.Rows[1].Tx.FeeUSD.String(); got != "1.1" {
+		t.Errorf("EUR fee: got %s USD, want 1.1", got)
+	}
+
+	if len(res.Errors) != 1 || res.Errors[0].Line != 4 || !strings.Contains(res.Errors[0].Err, "DOGE") {
+		t.Errorf("got errors %+v, want DOGE fee error on line 4", res.Errors)
+	}
+}
+
+func TestParseRejectsOutOfRangeRows(t *testing.T) {
+	const csv = "date,type,asset,amount,price,fee\n" +
+		"2024-03-01,buy,BTC,0.5,60000,1\n" +
+		"2024-03-01,buy,BTC,1e2000000000,60000,0\n" + // would take gigabytes
+		"2024-03-01,buy,BTC,1000000001,1,0\n" +
+		"2024-03-01,buy,BTC,1,10000001,0\n" +
+		"2024-03-01,buy,BTC,1,1,10000001\n" +
+		"2024-03-01,buy,BTC,0.000000001,1,0\n"
+
+	res, err := Parse(strings.NewReader(csv), Generic)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if len(res.Rows) != 1 {
+		t.Errorf("got %d rows, want 1", len(res.Rows))
+	}
+	var lines []int
+	for _, e := range res.Errors {
+		lines = append(lines, e.Line)
+	}
+	if fmt.Sprint(lines) != "[3 4 5 6 7]" {
+		t.Errorf("got errors on lines %v, want 3 to 7: %+v", lines, res.Errors)
+	}
+}
+
+func TestConvertQuotesRejectsHugeUSDPrice(t *testing.T) {
+	res, err := Parse(strings.NewReader("date,type,asset,amount,price,currency\n2024-03-01,buy,ETH,1,9000000,BTC\n"), Generic)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	ConvertQuotes(res, func(string, time.Time) (decimal.Decimal, error) {
+		return decimal.NewFromInt(60000), nil
+	})
+
+	if len(res.Rows) != 0 || len(res.Errors) != 1 {
+		t.Errorf("got %d rows, errors %+v, want the row rejected", len(res.Rows), res.Errors)
+	}
+}
@@ -0,0 +1,262 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+const DefaultBinanceAPIURL = "https://api.binance.com"
+
+// Binance fetches spot prices from Binance public API
+type Binance struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewBinance(baseURL string, httpClient *http.Client) *Binance {
+	if baseURL == "" {
+		baseURL = DefaultBinanceAPIURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	return &Binance{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+func (b *Binance) Name() string {
+	return "binance"
+}
+
+// binanceInvalidSymbol is returned for the whole batch when any of the pairs is not listed
+const binanceInvalidSymbol = -1121
+
+type binanceAPIError struct {
+	Code int
+	Msg  string
+}
+
+func (e *binanceAPIError) Error() string {
+	return fmt.Sprintf("Binance API error (code %d): %s", e.Code, e.Msg)
+}
+
+// CurrentPrices makes a single request with the pairs array to get only the prices we need.
+// If some pair is not listed Binance rejects the whole batch, then pairs are requested one by one.
+func (b *Binance) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	if len(assets) == 0 {
+		return make(map[string]Quote), nil
+	}
+
+	quotes, err := b.fetch(ctx, assets, quote)
+
+	var apiErr *binanceAPIError
+	if err == nil || !errors.As(err, &apiErr) || apiErr.Code != binanceInvalidSymbol || len(assets) == 1 {
+		return quotes, err
+	}
+
+	log.Warn("Binance rejected pairs batch, requesting pairs one by one", "pairs_count", len(assets))
+
+	quotes = make(map[string]Quote)
+	for _, asset := range assets {
+		single, err := b.fetch(ctx, []string{asset}, quote)
+		if err != nil {
+			if errors.As(err, &apiErr) && apiErr.Code == binanceInvalidSymbol {
+				log.Warn("Pair is not listed on Binance", "pair", asset+quote)
+				continue
+			}
+			return nil, err
+		}
+		for a, q := range single {
+			quotes[a] = q
+		}
+	}
+
+	return quotes, nil
+}
+
+func (b *Binance) fetch(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	// pair -> asset, Binance answers with pair symbols only
+	pairs := make([]string, 0, len(assets))
+	pairToAsset := make(map[string]string, len(assets))
+	for _, asset := range assets {
+		pair := asset + quote
+		pairs = append(pairs, pair)
+		pairToAsset[pair] = asset
+	}
+
+	// Prepare the symbols array parameter for Binance API
+	// Format: ["BTCUSDT","ETHUSDT","BNBUSDT"]
+	symbolsJSON, err := json.Marshal(pairs)
+	if err != nil {
+		return nil, fmt.Errorf("marshal pairs to JSON: %w", err)
+	}
+
+	apiURL := b.baseURL + "/api/v3/ticker/price?symbols=" + url.QueryEscape(string(symbolsJSON))
+
+	log.Info("Making request to Binance API", "url", apiURL)
+
+	// Parse the response - should be an array of price data for our specific pairs
+	var priceResponses []t.BinancePriceResponse
+	status, body, err := getJSON(ctx, b.httpClient, apiURL, &priceResponses)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		// Try to parse as Binance error response
+		var binanceErr t.BinanceErrorResponse
+		if err := json.Unmarshal(body, &binanceErr); err == nil {
+			return nil, &binanceAPIError{Code: binanceErr.Code, Msg: binanceErr.Msg}
+		}
+		return nil, fmt.Errorf("API request failed with status %d: %s", status, string(body))
+	}
+
+	log.Info("Received price data from Binance", "received_symbols", len(priceResponses))
+
+	now := time.Now()
+	quotes := make(map[string]Quote)
+	for _, priceResp := range priceResponses {
+		asset, ok := pairToAsset[priceResp.Symbol]
+		if !ok {
+			continue
+		}
+
+		price, err := decimal.NewFromString(priceResp.Price)
+		if err != nil {
+			log.Warn("Failed to parse price", "symbol", priceResp.Symbol, "price", priceResp.Price, "error", err)
+			continue
+		}
+
+		quotes[asset] = Quote{
+			Asset:     asset,
+			Currency:  quote,
+			Price:     price,
+			Source:    b.Name(),
+			UpdatedAt: now,
+		}
+	}
+
+	return quotes, nil
+}
+
+// binanceKlinesLimit is max klines per request
+const binanceKlinesLimit = 1000
+
+// PriceAt returns close price of the daily kline for the date,
+// for the current day it is the last price so far
+func (b *Binance) PriceAt(ctx context.Context, asset, quote string, date time.Time) (Quote, error) {
+	day := dayStart(date)
+
+	quotes, err := b.klines(ctx, asset, quote, day, 1)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	// pair may be listed later than requested date, then the first kline is from another day
+	if len(quotes) == 0 || !quotes[0].UpdatedAt.Equal(day) {
+		return Quote{}, ErrNoPrice
+	}
+
+	return quotes[0], nil
+}
+
+// DailyPrices returns daily close prices within [from, to], days before listing are missing
+func (b *Binance) DailyPrices(ctx context.Context, asset, quote string, from, to time.Time) ([]Quote, error) {
+	start, end := dayStart(from), dayStart(to)
+
+	var series []Quote
+	for !start.After(end) {
+		quotes, err := b.klines(ctx, asset, quote, start, binanceKlinesLimit)
+		if err != nil {
+			return nil, err
+		}
+		if len(quotes) == 0 {
+			break
+		}
+
+		for _, q := range quotes {
+			if q.UpdatedAt.After(end) {
+				return series, nil
+			}
+			series = append(series, q)
+		}
+
+		start = quotes[len(quotes)-1].UpdatedAt.AddDate(0, 0, 1)
+	}
+
+	return series, nil
+}
+
+// klines requests daily candles starting from the day, UpdatedAt of every quote is the candle open time
+func (b *Binance) klines(ctx context.Context, asset, quote string, from time.Time, limit int) ([]Quote, error) {
+	params := url.Values{}
+	params.Set("symbol", asset+quote)
+	params.Set("interval", "1d")
+	params.Set("startTime", strconv.FormatInt(from.UnixMilli(), 10))
+	params.Set("limit", strconv.Itoa(limit))
+	apiURL := b.baseURL + "/api/v3/klines?" + params.Encode()
+
+	// [[openTime, "open", "high", "low", "close", "volume", closeTime, ...]]
+	var klines [][]any
+	status, body, err := getJSON(ctx, b.httpClient, apiURL, &klines)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		var binanceErr t.BinanceErrorResponse
+		if err := json.Unmarshal(body, &binanceErr); err == nil {
+			if binanceErr.Code == binanceInvalidSymbol {
+				return nil, ErrNoPrice
+			}
+			return nil, &binanceAPIError{Code: binanceErr.Code, Msg: binanceErr.Msg}
+		}
+		return nil, fmt.Errorf("API request failed with status %d: %s", status, string(body))
+	}
+
+	quotes := make([]Quote, 0, len(klines))
+	for _, k := range klines {
+		if len(k) < 5 {
+			return nil, fmt.Errorf("unexpected kline format: %v", k)
+		}
+
+		openTime, ok := k[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected kline open time: %v", k[0])
+		}
+
+		closeRaw, ok := k[4].(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected kline close price: %v", k[4])
+		}
+
+		price, err := decimal.NewFromString(closeRaw)
+		if err != nil {
+			return nil, fmt.Errorf("parse kline close price: %w", err)
+		}
+
+		quotes = append(quotes, Quote{
+			Asset:     asset,
+			Currency:  quote,
+			Price:     price,
+			Source:    b.Name(),
+			UpdatedAt: time.UnixMilli(int64(openTime)).UTC(),
+		})
+	}
+
+	return quotes, nil
+}
@@ -0,0 +1,145 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+func TestBinanceCurrentPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "batch",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v3/ticker/price" || r.URL.Query().Get("symbols") != `["BTCUSDT","ETHUSDT"]` {
+					http.Error(w, "unexpected request "+r.URL.String(), http.StatusTeapot)
+					return
+				}
+				fmt.Fprint(w, `[{"symbol":"BTCUSDT","price":"65000.50"},{"symbol":"ETHUSDT","price":"bad"},{"symbol":"XRPUSDT","price":"1"}]`)
+			},
+			want: map[string]string{"BTC": "65000.5"},
+		},
+		{
+			name: "unlisted pair splits the batch",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("symbols") == `["BTCUSDT"]` {
+					fmt.Fprint(w, `[{"symbol":"BTCUSDT","price":"65000"}]`)
+					return
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"code":-1121,"msg":"Invalid symbol."}`)
+			},
+			want: map[string]string{"BTC": "65000"},
+		},
+		{
+			name: "api error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"code":-1003,"msg":"Too many requests."}`)
+			},
+			wantErr: "code -1003",
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "bad gateway", http.StatusBadGateway)
+			},
+			wantErr: "status 502",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			quotes, err := NewBinance(srv.URL, srv.Client()).CurrentPrices(context.Background(), []string{"BTC", "ETH"}, "USDT")
+			checkQuotes(t, quotes, err, tt.want, tt.wantErr, "binance")
+		})
+	}
+}
+
+func TestBinancePriceAt(t *testing.T) {
+	day := time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		want    string
+		wantErr error
+	}{
+		{name: "close of the day", body: fmt.Sprintf(`[[%d,"1","4","0.5","3.25","100",0]]`, day.UnixMilli()), want: "3.25"},
+		{name: "listed later", body: fmt.Sprintf(`[[%d,"1","4","0.5","3","100",0]]`, day.AddDate(0, 0, 3).UnixMilli()), wantErr: ErrNoPrice},
+		{name: "no klines", body: `[]`, wantErr: ErrNoPrice},
+		{name: "unknown pair", status: http.StatusBadRequest, body: `{"code":-1121,"msg":"Invalid symbol."}`, wantErr: ErrNoPrice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				q := r.URL.Query()
+				if r.URL.Path != "/api/v3/klines" || q.Get("symbol") != "BTCUSDT" || q.Get("interval") != "1d" {
+					http.Error(w, "unexpected request "+r.URL.String(), http.StatusTeapot)
+					return
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			quote, err := NewBinance(srv.URL, srv.Client()).PriceAt(context.Background(), "BTC", "USDT", day.Add(15*time.Hour))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if quote.Price.String() != tt.want || !quote.UpdatedAt.Equal(day) {
+				t.Errorf("got %s at %s, want %s at %s", quote.Price, quote.UpdatedAt, tt.want, day)
+			}
+		})
+	}
+}
+
+// checkQuotes compares prices of quotes by asset, or the error when wantErr is set
+func checkQuotes(t *testing.T, quotes map[string]Quote, err error, want map[string]string, wantErr, source string) {
+	t.Helper()
+
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("got error %v, want %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(quotes) != len(want) {
+		t.Errorf("got %d quotes %v, want %v", len(quotes), quotes, want)
+	}
+	for asset, price := range want {
+		q, ok := quotes[asset]
+		if !ok || !q.Price.Equal(decimal.RequireFromString(price)) || q.Source != source || q.Asset != asset {
+			t.Errorf("%s: got %+v, want %s from %s", asset, q, price, source)
+		}
+	}
+}
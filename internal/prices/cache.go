@@ -0,0 +1,161 @@
+package prices
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+// upstream lookups run on their own context: a background revalidation has no caller,
+// and a coalesced one is shared by callers that may give up before it's done
+const fetchTimeout = 30 * time.Second
+
+// cacheCall is a single upstream lookup shared by all concurrent callers of the same pair
+type cacheCall struct {
+	done  chan struct{}
+	quote Quote
+	ok    bool
+	err   error
+}
+
+// quote with the time the cache got it. Quote.UpdatedAt is set by the source and may be
+// much older, e.g. stored quotes carry the start of their day, so freshness is not based on it
+type cacheEntry struct {
+	quote     Quote
+	fetchedAt time.Time
+}
+
+// Cache is a shared in-process price cache keyed by pair.
+// Fresh quotes are served from memory, concurrent lookups of the same pair are coalesced
+// into one upstream request, and expired quotes are served while they are revalidated
+// or when the upstream fails.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration // quote is fresh for ttl
+	maxStale time.Duration // expired quote may be served for maxStale more while refreshing
+
+	mu       sync.Mutex
+	entries  map[string]cacheEntry
+	inflight map[string]*cacheCall
+}
+
+func NewCache(provider Provider, ttl, maxStale time.Duration) *Cache {
+	return &Cache{
+		provider: provider,
+		ttl:      ttl,
+		maxStale: maxStale,
+		entries:  make(map[string]cacheEntry),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+func (c *Cache) Name() string {
+	return c.provider.Name()
+}
+
+func cacheKey(asset, quote string) string {
+	return asset + quote
+}
+
+func (c *Cache) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	quotes := make(map[string]Quote, len(assets))
+	now := time.Now()
+
+	var mine, revalidate []string
+	waiting := make(map[string]*cacheCall)
+
+	c.mu.Lock()
+	for _, asset := range assets {
+		key := cacheKey(asset, quote)
+		entry, cached := c.entries[key]
+		age := now.Sub(entry.fetchedAt)
+
+		switch {
+		case cached && age < c.ttl:
+			quotes[asset] = entry.quote
+
+		case cached && age < c.ttl+c.maxStale:
+			// serve stale quote right away and refresh it in background
+			quotes[asset] = entry.quote
+			if _, busy := c.inflight[key]; !busy {
+				c.inflight[key] = &cacheCall{done: make(chan struct{})}
+				revalidate = append(revalidate, asset)
+			}
+
+		default:
+			call, busy := c.inflight[key]
+			if !busy {
+				call = &cacheCall{done: make(chan struct{})}
+				c.inflight[key] = call
+				mine = append(mine, asset)
+			}
+			waiting[asset] = call
+		}
+	}
+	c.mu.Unlock()
+
+	if len(revalidate) > 0 {
+		go c.fetch(revalidate, quote)
+	}
+	// the leader waits for its lookup like everyone else, so leaving early doesn't fail the others
+	if len(mine) > 0 {
+		go c.fetch(mine, quote)
+	}
+
+	var fetchErr error
+
+	for asset, call := range waiting {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if call.ok {
+			quotes[asset] = call.quote
+		} else if call.err != nil && fetchErr == nil {
+			fetchErr = call.err
+		}
+	}
+
+	// nothing to show and upstream failed, report the failure
+	if len(quotes) == 0 && fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return quotes, nil
+}
+
+// fetch asks upstream for the assets this caller is leading and wakes up the waiters
+func (c *Cache) fetch(assets []string, quote string) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	fetched, err := c.provider.CurrentPrices(ctx, assets, quote)
+	if err != nil {
+		log.Warn("price cache upstream failed, serving stale quotes if any", "error", err)
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, asset := range assets {
+		key := cacheKey(asset, quote)
+		call := c.inflight[key]
+		delete(c.inflight, key)
+
+		if q, ok := fetched[asset]; ok {
+			c.entries[key] = cacheEntry{quote: q, fetchedAt: now}
+			call.quote, call.ok = q, true
+		} else if stale, ok := c.entries[key]; ok && err != nil {
+			call.quote, call.ok = stale.quote, true
+		} else {
+			call.err = err
+		}
+		close(call.done)
+	}
+}
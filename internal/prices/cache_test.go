@@ -0,0 +1,103 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+// provider with quotes dated like the stored source does: at the start of their day
+type startOfDayProvider struct {
+	*Fake
+}
+
+func (p startOfDayProvider) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	quotes, err := p.Fake.CurrentPrices(ctx, assets, quote)
+	for asset, q := range quotes {
+		q.UpdatedAt = dayStart(time.Now()).Add(-24 * time.Hour)
+		quotes[asset] = q
+	}
+	return quotes, err
+}
+
+func TestCacheFreshnessIgnoresQuoteTime(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake(map[string]decimal.Decimal{"BTC": decimal.NewFromInt(65000)})
+	cache := NewCache(startOfDayProvider{fake}, time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		quotes, err := cache.CurrentPrices(ctx, []string{"BTC"}, DefaultQuote)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if !quotes["BTC"].Price.Equal(decimal.NewFromInt(65000)) {
+			t.Fatalf("call %d: got %+v", i, quotes["BTC"])
+		}
+	}
+
+	if calls := fake.Calls(); calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+}
+
+// provider that holds every lookup until released, it gives up with its context
+type gatedProvider struct {
+	*Fake
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *gatedProvider) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	p.calls.Add(1)
+	select {
+	case p.started <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.Fake.CurrentPrices(ctx, assets, quote)
+}
+
+func TestCacheSharedFetchOutlivesLeader(t *testing.T) {
+	provider := &gatedProvider{
+		Fake:    NewFake(map[string]decimal.Decimal{"BTC": decimal.NewFromInt(65000)}),
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	cache := NewCache(provider, time.Minute, time.Minute)
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := cache.CurrentPrices(leaderCtx, []string{"BTC"}, DefaultQuote)
+		leaderErr <- err
+	}()
+
+	<-provider.started
+	cancel()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader got error %v, want %v", err, context.Canceled)
+	}
+
+	// the lookup the leader started goes on and serves the next caller
+	close(provider.release)
+	quotes, err := cache.CurrentPrices(context.Background(), []string{"BTC"}, DefaultQuote)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if !quotes["BTC"].Price.Equal(decimal.NewFromInt(65000)) {
+		t.Errorf("got %+v", quotes["BTC"])
+	}
+	if calls := provider.calls.Load(); calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,84 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+// Chain asks providers one by one, every next provider gets only the assets
+// that were not priced by the previous ones
+type Chain struct {
+	providers []Provider
+}
+
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+func (c *Chain) Name() string {
+	names := make([]string, 0, len(c.providers))
+	for _, p := range c.providers {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *Chain) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	quotes := make(map[string]Quote, len(assets))
+	remaining := assets
+
+	var errs []error
+	for _, p := range c.providers {
+		if len(remaining) == 0 {
+			break
+		}
+
+		found, err := p.CurrentPrices(ctx, remaining, quote)
+		if err != nil {
+			log.Warn("price source failed, trying next one", "source", p.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+
+		var missing []string
+		for _, asset := range remaining {
+			q, ok := found[asset]
+			if !ok {
+				missing = append(missing, asset)
+				continue
+			}
+			quotes[asset] = q
+		}
+
+		log.Info("price source done", "source", p.Name(), "priced", len(remaining)-len(missing), "missing", len(missing))
+		remaining = missing
+	}
+
+	// every source failed, nothing to show
+	if len(quotes) == 0 && len(errs) > 0 && len(errs) == len(c.providers) {
+		return nil, errors.Join(errs...)
+	}
+
+	return quotes, nil
+}
+
+// NewSource creates provider by its config name, empty baseURL means provider default
+func NewSource(name, baseURL string, httpClient *http.Client) (Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "binance":
+		return NewBinance(baseURL, httpClient), nil
+	case "kraken":
+		return NewKraken(baseURL, httpClient), nil
+	case "coinbase":
+		return NewCoinbase(baseURL, httpClient), nil
+	case "coingecko":
+		return NewCoinGecko(baseURL, httpClient), nil
+	default:
+		return nil, fmt.Errorf("unknown price source: %s", name)
+	}
+}
@@ -0,0 +1,123 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+// records assets every call was asked for
+type recordingProvider struct {
+	*Fake
+	asked [][]string
+}
+
+func (p *recordingProvider) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	p.asked = append(p.asked, assets)
+	return p.Fake.CurrentPrices(ctx, assets, quote)
+}
+
+func newRecording(source string, prices map[string]int64, err error) *recordingProvider {
+	f := NewFake(make(map[string]decimal.Decimal))
+	f.Source = source
+	f.Err = err
+	for asset, price := range prices {
+		f.Prices[asset] = decimal.NewFromInt(price)
+	}
+	return &recordingProvider{Fake: f}
+}
+
+func TestChainFallbackOrder(t *testing.T) {
+	first := newRecording("first", map[string]int64{"BTC": 1}, nil)
+	broken := newRecording("broken", nil, errors.New("timeout"))
+	second := newRecording("second", map[string]int64{"BTC": 2, "ETH": 3}, nil)
+	last := newRecording("last", map[string]int64{"ETH": 4}, nil)
+
+	chain := NewChain(first, broken, second, last)
+	if chain.Name() != "first,broken,second,last" {
+		t.Errorf("got name %q", chain.Name())
+	}
+
+	quotes, err := chain.CurrentPrices(context.Background(), []string{"BTC", "ETH", "FOO"}, DefaultQuote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if quotes["BTC"].Source != "first" || quotes["ETH"].Source != "second" || len(quotes) != 2 {
+		t.Errorf("got %+v, want BTC from first and ETH from second", quotes)
+	}
+
+	asked := map[string][][]string{
+		"first":  first.asked,
+		"broken": broken.asked,
+		"second": second.asked,
+		"last":   last.asked,
+	}
+	want := map[string][][]string{
+		"first":  {{"BTC", "ETH", "FOO"}},
+		"broken": {{"ETH", "FOO"}},
+		"second": {{"ETH", "FOO"}},
+		"last":   {{"FOO"}},
+	}
+	if !reflect.DeepEqual(asked, want) {
+		t.Errorf("got assets asked %v, want %v", asked, want)
+	}
+}
+
+func TestChainStopsWhenEverythingIsPriced(t *testing.T) {
+	first := newRecording("first", map[string]int64{"BTC": 1}, nil)
+	second := newRecording("second", map[string]int64{"BTC": 2}, nil)
+
+	if _, err := NewChain(first, second).CurrentPrices(context.Background(), []string{"BTC"}, DefaultQuote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.Calls() != 0 {
+		t.Errorf("second source called %d times, want 0", second.Calls())
+	}
+}
+
+func TestChainErrors(t *testing.T) {
+	errA, errB := errors.New("a is down"), errors.New("b is down")
+
+	tests := []struct {
+		name      string
+		providers []Provider
+		wantErr   []error
+		wantCount int
+	}{
+		{
+			name:      "every source failed",
+			providers: []Provider{newRecording("a", nil, errA), newRecording("b", nil, errB)},
+			wantErr:   []error{errA, errB},
+		},
+		{
+			name:      "one source failed, nothing found",
+			providers: []Provider{newRecording("a", nil, errA), newRecording("b", nil, nil)},
+		},
+		{
+			name:      "one source failed, the other priced",
+			providers: []Provider{newRecording("a", nil, errA), newRecording("b", map[string]int64{"BTC": 1}, nil)},
+			wantCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotes, err := NewChain(tt.providers...).CurrentPrices(context.Background(), []string{"BTC"}, DefaultQuote)
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("got %v, want it to wrap %v", err, want)
+				}
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if len(quotes) != tt.wantCount {
+				t.Errorf("got %d quotes, want %d", len(quotes), tt.wantCount)
+			}
+		})
+	}
+}
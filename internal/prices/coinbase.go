@@ -0,0 +1,86 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+const DefaultCoinbaseAPIURL = "https://api.coinbase.com"
+
+type coinbaseSpotResponse struct {
+	Data struct {
+		Amount   string `json:"amount"`
+		Base     string `json:"base"`
+		Currency string `json:"currency"`
+	} `json:"data"`
+}
+
+// Coinbase fetches spot prices from Coinbase public API, one request per pair
+type Coinbase struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewCoinbase(baseURL string, httpClient *http.Client) *Coinbase {
+	if baseURL == "" {
+		baseURL = DefaultCoinbaseAPIURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	return &Coinbase{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+func (c *Coinbase) Name() string {
+	return "coinbase"
+}
+
+func (c *Coinbase) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	quotes := make(map[string]Quote)
+
+	for _, asset := range assets {
+		apiURL := c.baseURL + "/v2/prices/" + url.PathEscape(asset+"-"+quote) + "/spot"
+
+		var resp coinbaseSpotResponse
+		status, body, err := getJSON(ctx, c.httpClient, apiURL, &resp)
+		if err != nil {
+			return nil, err
+		}
+
+		// unknown pairs are answered with 4xx
+		if status == http.StatusNotFound || status == http.StatusBadRequest {
+			log.Warn("Coinbase could not price asset", "asset", asset, "status", status)
+			continue
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("Coinbase API request failed with status %d: %s", status, string(body))
+		}
+
+		price, err := decimal.NewFromString(resp.Data.Amount)
+		if err != nil {
+			log.Warn("Failed to parse price", "asset", asset, "price", resp.Data.Amount, "error", err)
+			continue
+		}
+
+		quotes[asset] = Quote{
+			Asset:     asset,
+			Currency:  quote,
+			Price:     price,
+			Source:    c.Name(),
+			UpdatedAt: time.Now(),
+		}
+	}
+
+	return quotes, nil
+}
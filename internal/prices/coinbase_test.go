@@ -0,0 +1,57 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoinbaseCurrentPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "unknown pairs are skipped",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v2/prices/BTC-USD/spot":
+					fmt.Fprint(w, `{"data":{"amount":"65000.25","base":"BTC","currency":"USD"}}`)
+				case "/v2/prices/ETH-USD/spot":
+					http.Error(w, `{"errors":[{"id":"invalid_request"}]}`, http.StatusBadRequest)
+				default:
+					http.NotFound(w, r)
+				}
+			},
+			want: map[string]string{"BTC": "65000.25"},
+		},
+		{
+			name: "unparsable price is skipped",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"data":{"amount":""}}`)
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "rate limited",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+			},
+			wantErr: "status 429",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			quotes, err := NewCoinbase(srv.URL, srv.Client()).CurrentPrices(context.Background(), []string{"BTC", "ETH", "FOO"}, "USD")
+			checkQuotes(t, quotes, err, tt.want, tt.wantErr, "coinbase")
+		})
+	}
+}
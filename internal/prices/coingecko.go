@@ -0,0 +1,94 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+const DefaultCoinGeckoAPIURL = "https://api.coingecko.com"
+
+// CoinGecko does not list stablecoins as vs currency, they are priced against usd
+var coinGeckoQuoteAliases = map[string]string{
+	"USDT": "usd",
+	"USDC": "usd",
+}
+
+// CoinGecko fetches prices by ticker symbols from CoinGecko simple price API
+type CoinGecko struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewCoinGecko(baseURL string, httpClient *http.Client) *CoinGecko {
+	if baseURL == "" {
+		baseURL = DefaultCoinGeckoAPIURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	return &CoinGecko{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+func (c *CoinGecko) Name() string {
+	return "coingecko"
+}
+
+func (c *CoinGecko) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	quotes := make(map[string]Quote)
+	if len(assets) == 0 {
+		return quotes, nil
+	}
+
+	vsCurrency := strings.ToLower(quote)
+	if alias, ok := coinGeckoQuoteAliases[quote]; ok {
+		vsCurrency = alias
+	}
+
+	symbols := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		symbols = append(symbols, strings.ToLower(asset))
+	}
+
+	params := url.Values{}
+	params.Set("symbols", strings.Join(symbols, ","))
+	params.Set("vs_currencies", vsCurrency)
+	apiURL := c.baseURL + "/api/v3/simple/price?" + params.Encode()
+
+	// {"btc":{"usd":67187.34},"eth":{"usd":3500.1}}
+	var resp map[string]map[string]decimal.Decimal
+	status, body, err := getJSON(ctx, c.httpClient, apiURL, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("CoinGecko API request failed with status %d: %s", status, string(body))
+	}
+
+	now := time.Now()
+	for _, asset := range assets {
+		price, ok := resp[strings.ToLower(asset)][vsCurrency]
+		if !ok || !price.IsPositive() {
+			continue
+		}
+
+		quotes[asset] = Quote{
+			Asset:     asset,
+			Currency:  quote,
+			Price:     price,
+			Source:    c.Name(),
+			UpdatedAt: now,
+		}
+	}
+
+	return quotes, nil
+}
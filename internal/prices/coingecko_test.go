@@ -0,0 +1,55 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoinGeckoCurrentPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "stablecoin quote is usd",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				q := r.URL.Query()
+				if r.URL.Path != "/api/v3/simple/price" || q.Get("symbols") != "btc,eth,foo" || q.Get("vs_currencies") != "usd" {
+					http.Error(w, "unexpected request "+r.URL.String(), http.StatusTeapot)
+					return
+				}
+				fmt.Fprint(w, `{"btc":{"usd":67187.34},"eth":{"usd":0},"foo":{}}`)
+			},
+			want: map[string]string{"BTC": "67187.34"},
+		},
+		{
+			name: "rate limited",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"status":{"error_code":429}}`, http.StatusTooManyRequests)
+			},
+			wantErr: "status 429",
+		},
+		{
+			name: "broken body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"btc":{"usd":"1e2000000000"}}`)
+			},
+			wantErr: "unmarshal response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			quotes, err := NewCoinGecko(srv.URL, srv.Client()).CurrentPrices(context.Background(), []string{"BTC", "ETH", "FOO"}, "USDT")
+			checkQuotes(t, quotes, err, tt.want, tt.wantErr, "coingecko")
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// Converter values currencies in USD. fiat currencies are priced like assets,
+// EUR comes from the EURUSDT pair, BTC from BTCUSDT, dollar stablecoins are 1
+type Converter struct {
+	current Provider
+	history HistoricalProvider
+}
+
+func NewConverter(current Provider, history HistoricalProvider) *Converter {
+	return &Converter{current: current, history: history}
+}
+
+var one = decimal.NewFromInt(1)
+
+// Rate returns the current USD value of one unit of the currency
+func (c *Converter) Rate(ctx context.Context, currency string) (decimal.Decimal, error) {
+	currency = strings.ToUpper(currency)
+	if t.IsUSD(currency) {
+		return one, nil
+	}
+
+	quotes, err := c.current.CurrentPrices(ctx, []string{currency}, DefaultQuote)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("get %s rate: %w", currency, err)
+	}
+
+	q, ok := quotes[currency]
+	if !ok || !q.Price.IsPositive() {
+		return decimal.Zero, ErrNoPrice
+	}
+	return q.Price, nil
+}
+
+// RateAt returns the USD value of one unit of the currency on the given day
+func (c *Converter) RateAt(ctx context.Context, currency string, date time.Time) (decimal.Decimal, error) {
+	currency = strings.ToUpper(currency)
+	if t.IsUSD(currency) {
+		return one, nil
+	}
+
+	q, err := c.history.PriceAt(ctx, currency, DefaultQuote, date)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !q.Price.IsPositive() {
+		return decimal.Zero, ErrNoPrice
+	}
+	return q.Price, nil
+}
@@ -0,0 +1,94 @@
+package prices
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+// Fake is an in-memory Provider for tests and local runs without network
+type Fake struct {
+	Source string
+	Prices map[string]decimal.Decimal // asset -> price
+	Daily  map[string]decimal.Decimal // "BTC@2025-06-15" -> close price, Prices are used when missing
+	Err    error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func NewFake(prices map[string]decimal.Decimal) *Fake {
+	return &Fake{
+		Source: "fake",
+		Prices: prices,
+	}
+}
+
+func (f *Fake) Name() string {
+	return f.Source
+}
+
+func (f *Fake) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	now := time.Now()
+	quotes := make(map[string]Quote)
+	for _, asset := range assets {
+		price, ok := f.Prices[asset]
+		if !ok {
+			continue
+		}
+		quotes[asset] = Quote{
+			Asset:     asset,
+			Currency:  quote,
+			Price:     price,
+			Source:    f.Source,
+			UpdatedAt: now,
+		}
+	}
+
+	return quotes, nil
+}
+
+// Calls returns how many times CurrentPrices was called
+func (f *Fake) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls
+}
+
+func (f *Fake) PriceAt(ctx context.Context, asset, quote string, date time.Time) (Quote, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.Err != nil {
+		return Quote{}, f.Err
+	}
+
+	day := dayStart(date)
+	price, ok := f.Daily[asset+"@"+day.Format("2006-01-02")]
+	if !ok {
+		price, ok = f.Prices[asset]
+	}
+	if !ok {
+		return Quote{}, ErrNoPrice
+	}
+
+	return Quote{
+		Asset:     asset,
+		Currency:  quote,
+		Price:     price,
+		Source:    f.Source,
+		UpdatedAt: day,
+	}, nil
+}
@@ -0,0 +1,26 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNoPrice = errors.New("no price for the requested date")
+
+// HistoricalProvider returns daily close price of an asset on a given date
+type HistoricalProvider interface {
+	Name() string
+	PriceAt(ctx context.Context, asset, quote string, date time.Time) (Quote, error)
+}
+
+// SeriesProvider returns daily close prices of an asset for a date range
+type SeriesProvider interface {
+	DailyPrices(ctx context.Context, asset, quote string, from, to time.Time) ([]Quote, error)
+}
+
+// dayStart truncates date to the start of its UTC day, daily candles are UTC based
+func dayStart(date time.Time) time.Time {
+	y, m, d := date.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
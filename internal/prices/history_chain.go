@@ -0,0 +1,49 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+// HistoryChain asks historical providers one by one until one of them knows the price
+type HistoryChain struct {
+	providers []HistoricalProvider
+}
+
+func NewHistoryChain(providers ...HistoricalProvider) *HistoryChain {
+	return &HistoryChain{providers: providers}
+}
+
+func (c *HistoryChain) Name() string {
+	return "history_chain"
+}
+
+func (c *HistoryChain) PriceAt(ctx context.Context, asset, quote string, date time.Time) (Quote, error) {
+	lastErr := ErrNoPrice
+	for _, p := range c.providers {
+		q, err := p.PriceAt(ctx, asset, quote, date)
+		if err == nil {
+			return q, nil
+		}
+		if !errors.Is(err, ErrNoPrice) {
+			log.Warn("historical price source failed, trying next one", "source", p.Name(), "error", err)
+			lastErr = err
+		}
+	}
+	return Quote{}, lastErr
+}
+
+// DailyPrices uses the first provider that supports price series
+func (c *HistoryChain) DailyPrices(ctx context.Context, asset, quote string, from, to time.Time) ([]Quote, error) {
+	for _, p := range c.providers {
+		sp, ok := p.(SeriesProvider)
+		if !ok {
+			continue
+		}
+		return sp.DailyPrices(ctx, asset, quote, from, to)
+	}
+	return nil, ErrNoPrice
+}
@@ -0,0 +1,39 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// getJSON performs GET request and decodes 200 response into v.
+// Non-200 responses are returned with their status code and raw body for the caller to inspect.
+func getJSON(ctx context.Context, client *http.Client, apiURL string, v any) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create HTTP request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("execute HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, body, nil
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, body, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	return resp.StatusCode, body, nil
+}
@@ -0,0 +1,104 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+const DefaultKrakenAPIURL = "https://api.kraken.com"
+
+// kraken uses its own tickers for some assets
+var krakenAssetAliases = map[string]string{
+	"BTC":  "XBT",
+	"DOGE": "XDG",
+}
+
+type krakenTickerResponse struct {
+	Error  []string `json:"error"`
+	Result map[string]struct {
+		LastTrade []string `json:"c"` // [price, lot volume]
+	} `json:"result"`
+}
+
+// Kraken fetches spot prices from Kraken public API, one request per pair
+type Kraken struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewKraken(baseURL string, httpClient *http.Client) *Kraken {
+	if baseURL == "" {
+		baseURL = DefaultKrakenAPIURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	return &Kraken{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+func (k *Kraken) Name() string {
+	return "kraken"
+}
+
+func (k *Kraken) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	quotes := make(map[string]Quote)
+
+	for _, asset := range assets {
+		krakenAsset := asset
+		if alias, ok := krakenAssetAliases[asset]; ok {
+			krakenAsset = alias
+		}
+
+		apiURL := k.baseURL + "/0/public/Ticker?pair=" + url.QueryEscape(krakenAsset+quote)
+
+		var resp krakenTickerResponse
+		status, body, err := getJSON(ctx, k.httpClient, apiURL, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("Kraken API request failed with status %d: %s", status, string(body))
+		}
+
+		// unknown pairs come back as error with empty result, just skip them
+		if len(resp.Error) > 0 {
+			log.Warn("Kraken could not price asset", "asset", asset, "error", resp.Error)
+			continue
+		}
+
+		// result key is Kraken internal pair name, so take the only entry
+		for _, ticker := range resp.Result {
+			if len(ticker.LastTrade) == 0 {
+				break
+			}
+
+			price, err := decimal.NewFromString(ticker.LastTrade[0])
+			if err != nil {
+				log.Warn("Failed to parse price", "asset", asset, "price", ticker.LastTrade[0], "error", err)
+				break
+			}
+
+			quotes[asset] = Quote{
+				Asset:     asset,
+				Currency:  quote,
+				Price:     price,
+				Source:    k.Name(),
+				UpdatedAt: time.Now(),
+			}
+			break
+		}
+	}
+
+	return quotes, nil
+}
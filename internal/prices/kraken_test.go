@@ -0,0 +1,64 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKrakenCurrentPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "aliases and unknown pairs",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("pair") {
+				case "XBTUSD":
+					fmt.Fprint(w, `{"error":[],"result":{"XXBTZUSD":{"c":["65000.10","0.01"]}}}`)
+				case "XDGUSD":
+					fmt.Fprint(w, `{"error":[],"result":{"XDGUSD":{"c":["0.15","100"]}}}`)
+				default:
+					fmt.Fprint(w, `{"error":["EQuery:Unknown asset pair"],"result":{}}`)
+				}
+			},
+			want: map[string]string{"BTC": "65000.1", "DOGE": "0.15"},
+		},
+		{
+			name: "unparsable price is skipped",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"error":[],"result":{"PAIR":{"c":["n/a","0"]}}}`)
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			wantErr: "status 503",
+		},
+		{
+			name: "broken body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"result":`)
+			},
+			wantErr: "unmarshal response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			quotes, err := NewKraken(srv.URL, srv.Client()).CurrentPrices(context.Background(), []string{"BTC", "DOGE", "FOO"}, "USD")
+			checkQuotes(t, quotes, err, tt.want, tt.wantErr, "kraken")
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package prices
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+// DefaultQuote is the currency all report prices are quoted in
+const DefaultQuote = "USDT"
+
+// Quote is a single asset price returned by a provider
+type Quote struct {
+	Asset     string          // "BTC", "ETH"
+	Currency  string          // quote currency, e.g. "USDT"
+	Price     decimal.Decimal // price of 1 asset in Currency
+	Source    string          // provider name that priced the asset
+	UpdatedAt time.Time       // when the price was fetched
+}
+
+// Provider returns current prices for a set of assets in a quote currency.
+// Assets that the provider cannot price are simply missing from the result,
+// an error is returned only when the request itself failed.
+type Provider interface {
+	Name() string
+	CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error)
+}
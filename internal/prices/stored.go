@@ -0,0 +1,70 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+// StoredSourceName marks quotes that come from the local prices table
+const StoredSourceName = "history"
+
+// PriceStore is the part of the store that keeps collected price snapshots
+type PriceStore interface {
+	GetLatestPrices(ctx context.Context, assets []string, quote string) ([]t.PriceRecord, error)
+	GetPriceAt(ctx context.Context, asset, quote string, at time.Time) (t.PriceRecord, error)
+}
+
+// Stored prices assets from collected snapshots, it is the last resort
+// when exchanges are not reachable
+type Stored struct {
+	db PriceStore
+}
+
+func NewStored(db PriceStore) *Stored {
+	return &Stored{db: db}
+}
+
+func (s *Stored) Name() string {
+	return StoredSourceName
+}
+
+func (s *Stored) CurrentPrices(ctx context.Context, assets []string, quote string) (map[string]Quote, error) {
+	records, err := s.db.GetLatestPrices(ctx, assets, quote)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]Quote, len(records))
+	for _, r := range records {
+		quotes[r.Asset] = recordToQuote(r)
+	}
+
+	return quotes, nil
+}
+
+func (s *Stored) PriceAt(ctx context.Context, asset, quote string, date time.Time) (Quote, error) {
+	// snapshots are stored at the start of their day, older ones are carried forward
+	r, err := s.db.GetPriceAt(ctx, asset, quote, dayStart(date))
+	if err != nil {
+		if errors.Is(err, store.ErrPriceNotFound) {
+			return Quote{}, ErrNoPrice
+		}
+		return Quote{}, err
+	}
+
+	return recordToQuote(r), nil
+}
+
+func recordToQuote(r t.PriceRecord) Quote {
+	return Quote{
+		Asset:     r.Asset,
+		Currency:  r.Quote,
+		Price:     r.Price,
+		Source:    StoredSourceName,
+		UpdatedAt: r.Time,
+	}
+}
@@ -1,7 +1,13 @@
 package internal
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
 	"gitlab.com/avolkov/wood_post/config"
+	"gitlab.com/avolkov/wood_post/internal/prices"
 	"gitlab.com/avolkov/wood_post/internal/telegram_bot"
 	"gitlab.com/avolkov/wood_post/pkg/log"
 	"gitlab.com/avolkov/wood_post/store"
@@ -20,7 +26,18 @@ func New(cfg *config.Config) (*Services, error) {
 	}
 	log.Info("internal: db connection established")
 
-	tg, err := telegram_bot.New(cfg.TelegramBotToken, db, cfg) //FIXME
+	priceProvider, err := newPriceProvider(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// daily klines are taken from Binance, collected snapshots are used when Binance has no data
+	historyProvider := prices.NewHistoryChain(
+		prices.NewBinance(cfg.BinanceAPIURL, &http.Client{Timeout: 15 * time.Second}),
+		prices.NewStored(db),
+	)
+
+	tg, err := telegram_bot.New(cfg.TelegramBotToken, db, cfg, priceProvider, historyProvider) //FIXME
 	if err != nil {
 		return nil, err
 	}
@@ -30,3 +47,39 @@ func New(cfg *config.Config) (*Services, error) {
 		TelegramBot: tg,
 	}, nil
 }
+
+// builds price sources fallback chain in the order from config,
+// collected price history is always the last source
+func newPriceProvider(cfg *config.Config, db *store.Store) (prices.Provider, error) {
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+
+	baseURLs := map[string]string{
+		"binance":   cfg.BinanceAPIURL,
+		"kraken":    cfg.KrakenAPIURL,
+		"coinbase":  cfg.CoinbaseAPIURL,
+		"coingecko": cfg.CoinGeckoAPIURL,
+	}
+
+	var sources []prices.Provider
+	for _, name := range strings.Split(cfg.PriceSources, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		source, err := prices.NewSource(name, baseURLs[name], httpClient)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no price sources configured")
+	}
+	log.Infof("internal: price sources chain: %s", cfg.PriceSources)
+
+	sources = append(sources, prices.NewStored(db))
+
+	return prices.NewChain(sources...), nil
+}
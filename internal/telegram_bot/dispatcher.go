@@ -0,0 +1,114 @@
+package telegram_bot
+
+import (
+	"context"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+// dispatcher handles updates on a fixed set of workers. every user is bound to
+// one worker, so updates of a user are handled in order and their session is
+// touched by one goroutine at a time, while other users don't wait for them
+type dispatcher struct {
+	queues []chan tgbotapi.Update
+	handle func(ctx context.Context, update tgbotapi.Update) error
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex // held by dispatch while it sends, so stop doesn't close a queue under it
+	stopped bool
+}
+
+func newDispatcher(workers, queueSize int, handle func(context.Context, tgbotapi.Update) error) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	d := &dispatcher{
+		queues: make([]chan tgbotapi.Update, workers),
+		handle: handle,
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan tgbotapi.Update, queueSize)
+	}
+	return d
+}
+
+// start runs workers until stop. updates that are already queued are handled
+// even after ctx is cancelled, so a user doesn't lose a half-done action on shutdown
+func (d *dispatcher) start(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+
+	for i, queue := range d.queues {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for update := range queue {
+				if err := d.handle(ctx, update); err != nil {
+					log.Error("update handling error", "worker", i, "error", err)
+				}
+			}
+		}()
+	}
+}
+
+// dispatch queues the update for its user's worker. full queue blocks the caller,
+// so polling slows down instead of piling up updates. false when ctx is done first
+// or the dispatcher is stopped
+func (d *dispatcher) dispatch(ctx context.Context, update tgbotapi.Update) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.stopped {
+		log.Warn("update came after the dispatcher stopped", "update_id", update.UpdateID)
+		return false
+	}
+
+	queue := d.queues[d.shard(updateUserID(update))]
+
+	select {
+	case queue <- update:
+		return true
+	default:
+	}
+
+	log.Warn("update queue is full, waiting", "update_id", update.UpdateID)
+	select {
+	case queue <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stop closes the queues and waits until workers handle what's left in them.
+// dispatch calls blocked on a full queue must be released by their ctx first
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *dispatcher) shard(tgUserID int64) int {
+	return int(uint64(tgUserID) % uint64(len(d.queues)))
+}
+
+// telegram user of the update, 0 for updates the bot doesn't handle
+func updateUserID(update tgbotapi.Update) int64 {
+	switch {
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From.ID
+	case update.Message != nil && update.Message.From != nil:
+		return update.Message.From.ID
+	}
+	return 0
+}
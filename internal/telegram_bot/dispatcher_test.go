@@ -0,0 +1,191 @@
+package telegram_bot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func userUpdate(updateID int, tgUserID int64, seq int) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message: &tgbotapi.Message{
+			MessageID: seq,
+			From:      &tgbotapi.User{ID: tgUserID},
+		},
+	}
+}
+
+func TestDispatcherKeepsUserOrder(t *testing.T) {
+	const (
+		users   = 10
+		perUser = 50
+	)
+
+	var (
+		mu       sync.Mutex
+		handled  = make(map[int64][]int)
+		inFlight [users + 1]atomic.Int32
+	)
+
+	d := newDispatcher(4, 8, func(_ context.Context, update tgbotapi.Update) error {
+		user := update.Message.From.ID
+		if inFlight[user].Add(1) != 1 {
+			t.Errorf("user %d is handled by two workers at once", user)
+		}
+		defer inFlight[user].Add(-1)
+
+		// uneven handling time, so workers overtake each other
+		if update.UpdateID%7 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		mu.Lock()
+		handled[user] = append(handled[user], update.Message.MessageID)
+		mu.Unlock()
+		return nil
+	})
+	d.start(context.Background())
+
+	updateID := 0
+	for seq := 0; seq < perUser; seq++ {
+		for user := int64(1); user <= users; user++ {
+			updateID++
+			if !d.dispatch(context.Background(), userUpdate(updateID, user, seq)) {
+				t.Fatal("dispatch failed without ctx cancel")
+			}
+		}
+	}
+	d.stop()
+
+	for user := int64(1); user <= users; user++ {
+		seqs := handled[user]
+		if len(seqs) != perUser {
+			t.Errorf("user %d: handled %d updates, want %d", user, len(seqs), perUser)
+			continue
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("user %d: update %d handled at position %d", user, seq, i)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherBlocksOnFullQueue(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+
+	d := newDispatcher(1, 1, func(context.Context, tgbotapi.Update) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	d.start(context.Background())
+
+	ctx := context.Background()
+	d.dispatch(ctx, userUpdate(1, 1, 1))
+	<-started // the worker is busy with the first update
+	d.dispatch(ctx, userUpdate(2, 1, 2))
+
+	// the queue is full, the next dispatch waits for the worker
+	done := make(chan bool)
+	go func() {
+		done <- d.dispatch(ctx, userUpdate(3, 1, 3))
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("dispatch returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-done {
+		t.Error("dispatch failed after the queue got free")
+	}
+	d.stop()
+
+	if len(started) != 2 {
+		t.Errorf("handled %d more updates, want 2", len(started))
+	}
+}
+
+func TestDispatcherGivesUpOnCancelWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	d := newDispatcher(1, 1, func(context.Context, tgbotapi.Update) error {
+		<-release
+		return nil
+	})
+
+	// no workers yet, so the second update finds the queue full
+	ctx, cancel := context.WithCancel(context.Background())
+	if !d.dispatch(ctx, userUpdate(1, 1, 1)) {
+		t.Fatal("dispatch into an empty queue failed")
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- d.dispatch(ctx, userUpdate(2, 1, 2))
+	}()
+	cancel()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("dispatch queued the update into a full queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dispatch didn't return after ctx cancel")
+	}
+}
+
+func TestDispatcherDrainsQueueOnStop(t *testing.T) {
+	const total = 20
+
+	release := make(chan struct{})
+	var handled atomic.Int32
+
+	d := newDispatcher(2, total, func(ctx context.Context, update tgbotapi.Update) error {
+		<-release
+		if ctx.Err() != nil {
+			t.Errorf("update %d handled with cancelled ctx", update.UpdateID)
+		}
+		handled.Add(1)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.start(ctx)
+
+	for i := 1; i <= total; i++ {
+		d.dispatch(ctx, userUpdate(i, int64(i), i))
+	}
+
+	// shutdown: ctx is cancelled while updates are still queued
+	cancel()
+	close(release)
+	d.stop()
+
+	if got := handled.Load(); got != total {
+		t.Errorf("handled %d updates, want %d", got, total)
+	}
+}
+
+// a webhook request still in flight after shutdown gets false instead of a panic
+func TestDispatchAfterStop(t *testing.T) {
+	d := newDispatcher(1, 1, func(context.Context, tgbotapi.Update) error { return nil })
+	d.start(context.Background())
+	d.stop()
+
+	if d.dispatch(context.Background(), userUpdate(1, 1, 1)) {
+		t.Error("dispatch succeeded after stop")
+	}
+}
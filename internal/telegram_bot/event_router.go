@@ -2,11 +2,13 @@ package telegram_bot
 
 import (
 	"context"
-	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
 	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
 func (s *Service) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery, sv *UserSession, tgUserID int64) error {
@@ -18,93 +20,239 @@ func (s *Service) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery
 		return errors.Wrap(err, "failed to get user from DB")
 	}
 
-	log.Infof("user_id: %d, selected callback: %s", dbUserID, cb.Data)
+	data, err := s.callbacks.Decode(cb.Data)
+	if err != nil {
+		log.Warn("rejected callback", "user_id", dbUserID, "data", cb.Data, "error", err)
+		return s.rejectCallback(cb.Message.Chat.ID, tgUserID, cb.Message.MessageID)
+	}
+
+	log.Infof("user_id: %d, selected callback: %s %v", dbUserID, data.Action, data.Args)
+
+	if ev := fsm.Callback(data.Action, data.Arg(0)); s.flowAccepts(sv, ev) {
+		env := &flowEnv{TgUserID: tgUserID, DBUserID: dbUserID, Session: sv}
+		return s.handleFlowEvent(ctx, cb.Message.Chat.ID, env, ev)
+	}
 
-	switch {
-	case cb.Data == "create_portfolio":
+	switch data.Action {
+	case "create_portfolio":
 		return s.checkBeforeCreatePortfolio(ctx, cb.Message.Chat.ID, tgUserID, dbUserID)
 
-	case cb.Data == "who_am_i":
+	case "who_am_i":
 		return s.showServiceInfo(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-		// case cb.Data == "gf_portfolios":
+		// case "gf_portfolios":
 		// 	return s.gfPortfoliosMain(cb.Message.Chat.ID, tgUserID, r.BotMessageID)
 
-	case cb.Data == "gf_portfolios_main":
+	case "gf_portfolios_main":
 		return s.gfPortfoliosMain(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case cb.Data == "gf_portfolios_delete":
-		s.sessions.setTempField(tgUserID, "NextAction", "delete")
-		return s.ShowPortfolios(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, "delete")
+	case "gf_portfolios_delete":
+		return s.startFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "portfolio_delete")
+
+	case "gf_portfolio_rename":
+		return s.startFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "portfolio_rename")
 
-	case cb.Data == "gf_portfolio_rename":
-		s.sessions.setTempField(tgUserID, "NextAction", "rename")
-		return s.ShowPortfolios(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, "rename")
+	case "gf_portfolio_change_default":
+		return s.startFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "portfolio_change_default")
 
-	case cb.Data == "gf_portfolio_change_default":
-		s.sessions.setTempField(tgUserID, "NextAction", "change_default")
-		return s.ShowPortfolios(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, "change_default")
+	case "gf_portfolio_rename_default":
+		return s.renameDefaultPortfolio(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv)
 
-	case cb.Data == "gf_portfolio_get_default":
+	case "gf_portfolio_get_default":
 		return s.showDefaultPortfolio(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
 
-	case cb.Data == "gf_delete_transaction":
-		return s.gfTransactionsDelete(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+	// ------- HISTORY -------
+	// transactions are edited and deleted from their row in the history
+	case "gf_history", "gf_edit_transaction", "gf_delete_transaction":
+		sv.History = historyState{}
+		return s.showTransactionHistory(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, &sv.History, "first")
+
+	case "hist_show", "hist_f_reset", "hist_sort", "hist_next", "hist_prev":
+		move := "first"
+		switch data.Action {
+		case "hist_f_reset":
+			sv.History.Filter = t.TransactionFilter{OldestFirst: sv.History.Filter.OldestFirst}
+		case "hist_sort":
+			sv.History.Filter.OldestFirst = !sv.History.Filter.OldestFirst
+		case "hist_next":
+			move = "next"
+		case "hist_prev":
+			move = "prev"
+		}
+		return s.showTransactionHistory(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, &sv.History, move)
+
+	case "hist_filters":
+		return s.showHistoryFilters(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, &sv.History)
+
+	case "hist_f_portfolio":
+		return s.askHistoryPortfolio(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+
+	case "hist_fp":
+		portfolioID, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.setHistoryPortfolio(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, portfolioID, &sv.History)
+
+	case "hist_f_type":
+		return s.askHistoryType(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
+
+	case "hist_ft":
+		return s.setHistoryType(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, data.Arg(0), &sv.History)
+
+	case "hist_f_asset":
+		return s.startFlowAt(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "history_filter", "waiting_history_asset")
+
+	case "hist_f_dates":
+		return s.startFlowAt(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "history_filter", "waiting_history_dates")
+
+	case "hist_f_tag":
+		return s.askHistoryTag(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
 
-	case strings.Contains(cb.Data, "gf_delete_transaction_confirmation_"):
-		return s.gfDeleteTransactionConfirmation(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, cb.Data, &sv.TempTransaction)
+	case "hist_fg":
+		tagID, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.setHistoryTag(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, tagID, &sv.History)
+	// ------- HISTORY -------
+
+	// ------- TAGS -------
+	case "gf_tags":
+		return s.gfTagsMain(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+
+	case "tag_pick":
+		tagID, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.showTagActions(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, tagID, &sv.SelectedTag)
+
+	case "tag_history":
+		sv.History = historyState{Filter: t.TransactionFilter{Tag: sv.SelectedTag.Name}}
+		return s.showTransactionHistory(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, &sv.History, "first")
+
+	case "tag_rename":
+		return s.startFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "tag_rename")
+
+	case "tag_delete":
+		return s.askTagDeleteConfirmation(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, sv.SelectedTag)
+
+	case "tag_delete_confirmed":
+		return s.tagDeleteConfirmed(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.SelectedTag)
+	// ------- TAGS -------
 
-	case cb.Data == "gf_delete_transaction_confirmed":
+	// ------- IMPORT -------
+	case "gf_import":
+		return s.startImportFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv)
+	// ------- IMPORT -------
+
+	// ------- EXPORT -------
+	case "gf_export":
+		return s.askExportFormat(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
+
+	case "exp":
+		return s.sendExport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, data.Arg(0))
+	// ------- EXPORT -------
+
+	case "tx_edit_pick":
+		txID, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.startTransactionEdit(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, txID)
+
+	case "gf_delete_transaction_confirmation":
+		txID, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.gfDeleteTransactionConfirmation(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, txID, &sv.TempTransaction)
+
+	case "gf_delete_transaction_confirmed":
 		return s.gfDeleteTransactionConfirmed(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.TempTransaction.ID, sv.BotMessageID)
 
 	// ----------- REPORTS -----------
-	case cb.Data == "gf_reports_main":
-		return s.gfReportsMain(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
+	case "gf_reports_main":
+		return s.gfReportsMain(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, sv.ReportTag)
+
+	case "gf_reports_general":
+		return s.showPortfolioGeneralReport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.ReportTag)
 
-	case cb.Data == "gf_reports_general":
-		return s.showPortfolioGeneralReport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+	case "gf_reports_advanced":
+		return s.showPortfolioAdvancedReport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.ReportTag)
 
-	case cb.Data == "gf_reports_advanced":
-		return s.showPortfolioAdvancedReport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+	case "gf_reports_chart":
+		return s.showPortfolioChartReport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.ReportTag)
+
+	case "gf_reports_tax":
+		return s.askTaxReportYear(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.ReportTag)
+
+	case "tax_year":
+		year, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.sendTaxReport(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, int(year), sv.ReportTag)
+
+	case "gf_reports_tag":
+		return s.askReportTag(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+
+	case "report_tag":
+		tagID, err := data.Int64(0)
+		if err != nil {
+			return err
+		}
+		return s.setReportTag(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, tagID, &sv.ReportTag)
 
 	// ----------- REPORTS -----------
 
-	case strings.Contains(cb.Data, "::"):
-		// log.Infof("callback data: %s", cb.Data)
-		return s.performActionForPortfolio(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, cb.Data)
+	// ----------- SETTINGS -----------
+	case "gf_settings":
+		return s.gfSettingsMain(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case cb.Data == "confirm_portfolio_deletion":
-		return s.portfolioDeletinonConfirmed(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.SelectedPortfolioName)
+	case "gf_settings_currency":
+		return s.askReportingCurrency(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case cb.Data == "confirm_portfolio_rename":
-		return s.portfolioRenameConfirmed(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.SelectedPortfolioName, sv.TempPortfolioName)
+	case "set_cur":
+		return s.reportingCurrencyChosen(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, data.Arg(0))
 
-	case cb.Data == "confirm_portfolio_change_default":
-		return s.portfolioChangeDefaultConfirmed(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.SelectedPortfolioName)
+	case "gf_settings_timezone":
+		return s.startFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv, "settings_timezone")
 
-	// ------- TRANSACTIONS -------
-	case cb.Data == "gf_transactions_main":
-		return s.gfTransactionsMain(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
+	case "gf_settings_date_format":
+		return s.askDateFormat(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case cb.Data == "gf_add_transaction":
-		return s.askTransactionType(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+	case "set_df":
+		return s.dateFormatChosen(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, data.Arg(0))
 
-	case cb.Data == "gf_show_last_5_transactions":
-		return s.showLast5Transactions(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID)
+	case "gf_settings_cost_basis":
+		return s.askCostBasisMethod(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case strings.Contains(cb.Data, "tx_type_"):
-		return s.askTransactionAsset(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, &sv.TempTransaction, cb.Data)
+	case "set_cb":
+		return s.costBasisMethodChosen(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, data.Arg(0))
 
-	case cb.Data == "tx_confirm_transaction":
-		return s.transactionConfirmed(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, &sv.TempTransaction)
+	case "gf_settings_language":
+		return s.askLanguage(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case strings.Contains(cb.Data, "tx_asset_chosen_"):
-		return s.askTransactionAssetAmount(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, cb.Data, &sv.TempTransaction)
+	case "set_lang":
+		return s.languageChosen(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, data.Arg(0))
 
-	case strings.Contains(cb.Data, "tx_date_"):
-		return s.asktransactionConfirmation(cb.Message.Chat.ID, tgUserID, sv.BotMessageID, cb.Data, &sv.TempTransaction)
+	case "gf_settings_ttl":
+		return s.askMessageTTL(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
 
-	case cb.Data == "cancel_action":
+	case "set_ttl":
+		return s.messageTTLChosen(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, data.Arg(0))
+	// ----------- SETTINGS -----------
+
+	// ------- TRANSACTIONS -------
+	case "gf_transactions_main":
+		return s.gfTransactionsMain(cb.Message.Chat.ID, tgUserID, sv.BotMessageID)
+
+	case "gf_add_transaction":
+		return s.startTransactionFlow(ctx, cb.Message.Chat.ID, tgUserID, dbUserID, sv)
+
+	case "cancel_action":
 		s.sessions.clearSession(tgUserID)
 		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(cb.Message.Chat.ID, sv.BotMessageID))
 		return s.showMainMenu(cb.Message.Chat.ID, tgUserID)
@@ -114,6 +262,22 @@ func (s *Service) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery
 	return nil
 }
 
+// old bot versions and forged payloads end up here, the user just gets the menu back
+func (s *Service) rejectCallback(chatID, tgUserID int64, messageID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID))
+
+	msg := tgbotapi.NewMessage(chatID,
+		"⚠️ *Outdated button*\n\n"+
+			"This button is outdated or invalid. Please use the main menu below or enter /start.")
+	msg.ParseMode = "Markdown"
+
+	if err := s.sendTemporaryMessage(msg, tgUserID, 5*time.Second); err != nil {
+		return err
+	}
+
+	return s.showMainMenu(chatID, tgUserID)
+}
+
 func (s *Service) handleMessage(ctx context.Context, msg *tgbotapi.Message, sv *UserSession, tgUserID int64) error {
 	// tgUserID := msg.From.ID
 	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID))
@@ -128,28 +292,17 @@ func (s *Service) handleMessage(ctx context.Context, msg *tgbotapi.Message, sv *
 		return errors.Wrap(err, "failed to get user from DB")
 	}
 
-	switch state {
-	case "waiting_portfolio_name":
-		return s.waitPortfolionName(ctx, msg.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, msg.Text)
-
-	case "waiting_portfolio_description":
-		return s.waitPortfolionDescription(ctx, msg.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.TempPortfolioName, msg.Text)
-
-	case "waiting_for_new_portfolio_name":
-		return s.waitNewPortfolionName(ctx, msg.Chat.ID, tgUserID, dbUserID, sv.BotMessageID, sv.SelectedPortfolioName, msg.Text)
-
-	case "waiting_transaction_asset":
-		return s.askTransactionAssetAmount(msg.Chat.ID, tgUserID, sv.BotMessageID, msg.Text, &sv.TempTransaction)
-
-	case "waiting_transaction_asset_amount":
-		return s.askTransactionAssetPrice(msg.Chat.ID, tgUserID, sv.BotMessageID, msg.Text, &sv.TempTransaction)
-
-	case "waiting_transaction_asset_price":
-		return s.askTransactionDate(msg.Chat.ID, tgUserID, sv.BotMessageID, msg.Text, &sv.TempTransaction)
-
-	case "waiting_transaction_date":
-		return s.asktransactionConfirmation(msg.Chat.ID, tgUserID, sv.BotMessageID, msg.Text, &sv.TempTransaction)
+	ev := fsm.Text(msg.Text)
+	if msg.Document != nil {
+		ev = fsm.File(msg.Document.FileID)
+	}
+	if s.flowAccepts(sv, ev) {
+		env := &flowEnv{TgUserID: tgUserID, DBUserID: dbUserID, Session: sv, Document: msg.Document}
+		return s.handleFlowEvent(ctx, msg.Chat.ID, env, ev)
+	}
 
+	// everything typed outside of a flow is the reply keyboard
+	switch state {
 	case "main_menu":
 		text := msg.Text
 
@@ -164,7 +317,11 @@ func (s *Service) handleMessage(ctx context.Context, msg *tgbotapi.Message, sv *
 
 		case "Reports":
 			log.Infof("main menu: %s", text)
-			return s.gfReportsMain(msg.Chat.ID, tgUserID, sv.BotMessageID)
+			return s.gfReportsMain(msg.Chat.ID, tgUserID, sv.BotMessageID, sv.ReportTag)
+
+		case "Settings":
+			log.Infof("main menu: %s", text)
+			return s.gfSettingsMain(msg.Chat.ID, tgUserID, sv.BotMessageID)
 
 		case "Help":
 			log.Infof("main menu: %s", text)
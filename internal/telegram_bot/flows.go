@@ -0,0 +1,177 @@
+package telegram_bot
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/exporter"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/internal/importer"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// what flow handlers work with, the flow position itself is in Session.Flow
+type flowEnv struct {
+	TgUserID int64
+	DBUserID int64
+	Session  *UserSession
+	Document *tgbotapi.Document // file the user sent, nil for text and buttons
+
+	// set by a handler for the prompt of the next state, they live for one event only
+	importResult *importer.Result
+	export       *exporter.Document
+	summary      string
+}
+
+func (e *flowEnv) tx() *t.TempTransactionData {
+	return &e.Session.TempTransaction
+}
+
+type (
+	flowState = fsm.State[*flowEnv]
+	flowInput = fsm.Input[*flowEnv]
+)
+
+// all wizards of the bot, see the *_flow.go files and transactions_edit.go,
+// transactions_history.go and transactions_import.go
+func (s *Service) newFlows() *fsm.Machine[*flowEnv] {
+	return fsm.NewMachine(
+		s.portfolioCreateFlow(),
+		s.portfolioRenameFlow(),
+		s.portfolioDeleteFlow(),
+		s.portfolioChangeDefaultFlow(),
+		s.transactionFlow(),
+		s.transactionEditFlow(),
+		s.historyFilterFlow(),
+		s.tagRenameFlow(),
+		s.timezoneFlow(),
+		s.importFlow(),
+	)
+}
+
+// true when the user is inside a flow and its current state takes the event.
+// pressing any menu button changes the session state, so the flow stops there
+func (s *Service) flowAccepts(sv *UserSession, ev fsm.Event) bool {
+	if sv == nil || !sv.Flow.Active() || sv.State != sv.Flow.State {
+		return false
+	}
+	return s.flows.Accepts(sv.Flow, ev)
+}
+
+func (s *Service) startFlow(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	sv *UserSession,
+	flow string,
+) error {
+	env := &flowEnv{TgUserID: tgUserID, DBUserID: dbUserID, Session: sv}
+	reply, err := s.flows.Start(ctx, &sv.Flow, flow, env)
+	return s.sendFlowReply(chatID, env, reply, err)
+}
+
+// starts the flow in the middle, data of skipped states has to be in the session already
+func (s *Service) startFlowAt(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	sv *UserSession,
+	flow, state string,
+) error {
+	env := &flowEnv{TgUserID: tgUserID, DBUserID: dbUserID, Session: sv}
+	reply, err := s.flows.StartAt(ctx, &sv.Flow, flow, state, env)
+	return s.sendFlowReply(chatID, env, reply, err)
+}
+
+func (s *Service) handleFlowEvent(ctx context.Context, chatID int64, env *flowEnv, ev fsm.Event) error {
+	reply, err := s.flows.Handle(ctx, &env.Session.Flow, env, ev)
+	return s.sendFlowReply(chatID, env, reply, err)
+}
+
+func (s *Service) sendFlowReply(chatID int64, env *flowEnv, reply fsm.Reply, err error) error {
+	tgUserID, sv := env.TgUserID, env.Session
+
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, sv.BotMessageID))
+	if err != nil {
+		sv.Flow = fsm.Position{}
+		_ = s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID, "Ops, something went wrong. please try again."),
+			tgUserID, s.messageTTL(tgUserID))
+		if menuErr := s.showMainMenu(chatID, tgUserID); menuErr != nil {
+			log.Error("failed to show main menu", "error", menuErr)
+		}
+		return err
+	}
+
+	if err := s.sendPrompt(chatID, tgUserID, reply); err != nil {
+		return err
+	}
+
+	if reply.Done {
+		// final prompt with buttons is a screen of its own, the main menu would only push it up
+		if len(reply.Prompt.Buttons) > 0 {
+			s.sessions.setState(tgUserID, "main_menu")
+			return nil
+		}
+		return s.showMainMenu(chatID, tgUserID)
+	}
+
+	s.sessions.setState(tgUserID, sv.Flow.State)
+	return nil
+}
+
+// renders a prompt of a regular screen, outside of a flow it needs no Cancel button
+func (s *Service) sendScreen(chatID, tgUserID int64, p fsm.Prompt) error {
+	return s.sendPrompt(chatID, tgUserID, fsm.Reply{Prompt: p, Done: true})
+}
+
+// renders the prompt, unfinished flows get a Cancel button next to Back
+func (s *Service) sendPrompt(chatID, tgUserID int64, reply fsm.Reply) error {
+	p := reply.Prompt
+
+	text := p.Text
+	if reply.Error != "" {
+		errText := reply.Error
+		if p.Markdown {
+			errText = tgbotapi.EscapeText(tgbotapi.ModeMarkdown, errText)
+		}
+		text = "❌ " + errText + "\n\n" + text
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if p.Markdown {
+		msg.ParseMode = "Markdown"
+	}
+
+	buttons := p.Buttons
+	if !reply.Done {
+		cancel := fsm.Button{Text: "Cancel", Action: "cancel_action"}
+		if n := len(buttons); n > 0 && len(buttons[n-1]) == 1 && buttons[n-1][0].Action == fsm.Back {
+			buttons = append(buttons[:n-1:n-1], []fsm.Button{buttons[n-1][0], cancel})
+		} else {
+			buttons = append(buttons, []fsm.Button{cancel})
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, bs := range buttons {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, b := range bs {
+			if b.Arg != "" {
+				row = append(row, s.button(b.Text, b.Action, b.Arg))
+			} else {
+				row = append(row, s.button(b.Text, b.Action))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = s.messageTTL(tgUserID)
+	}
+	return s.sendTemporaryMessage(msg, tgUserID, ttl)
+}
@@ -7,6 +7,7 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
+	"gitlab.com/avolkov/wood_post/internal/callback"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
@@ -25,7 +26,7 @@ func (s *Service) handleStart(ctx context.Context, msg *tgbotapi.Message) error
 				tgbotapi.NewMessage(msg.Chat.ID,
 					"Failed to create user. Please try again later."),
 				tgUserID,
-				20*time.Second)
+				s.messageTTL(tgUserID))
 
 			if sendErr != nil {
 				return fmt.Errorf("failed to notify user about user creation error: %w", err)
@@ -43,12 +44,12 @@ func (s *Service) showWelcome(chatID, tgUserID int64) error {
 	msg := tgbotapi.NewMessage(chatID, "Welcome! Let's create your first portfolio.")
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Create portfolio", "create_portfolio"),
-			tgbotapi.NewInlineKeyboardButtonData("Who am I?", "who_am_i"),
+			s.button("Create portfolio", "create_portfolio"),
+			s.button("Who am I?", "who_am_i"),
 		),
 	)
 	// return s.sendTgMessage(msg, tgUserID)
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 }
 
 func (s *Service) showMainMenu(chatID, tgUserID int64) error {
@@ -64,11 +65,12 @@ func (s *Service) showMainMenu(chatID, tgUserID int64) error {
 			tgbotapi.NewKeyboardButton("Reports"),
 		),
 		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("Settings"),
 			tgbotapi.NewKeyboardButton("Help"),
 		),
 	)
 
-	return s.sendTemporaryMessage(mainMenu, tgUserID, 20*time.Second)
+	return s.sendTemporaryMessage(mainMenu, tgUserID, s.messageTTL(tgUserID))
 }
 
 func (s *Service) showServiceInfo(chatID, tgUserID int64, BotMsgID int) error {
@@ -79,11 +81,22 @@ func (s *Service) showServiceInfo(chatID, tgUserID int64, BotMsgID int) error {
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🔙 Back", "cancel_action"),
+			s.button("🔙 Back", "cancel_action"),
 		),
 	)
 
-	return s.sendTemporaryMessage(msg, tgUserID, 200*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+// inline button with encoded and signed callback data. payload that doesn't fit
+// in 64 bytes is a programming error, it panics instead of sending a button that
+// does something else, handleUpdate recovers and tells the user
+func (s *Service) button(text, action string, args ...string) tgbotapi.InlineKeyboardButton {
+	data, err := s.callbacks.Encode(callback.New(action, args...))
+	if err != nil {
+		panic(fmt.Sprintf("encode callback data of %q: %v", action, err))
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data)
 }
 
 // func (s *Service) sendTgMessage(msg tgbotapi.Chattable, tgUserID int64) error {
@@ -104,6 +117,12 @@ func (s *Service) sendTemporaryMessage(msg tgbotapi.Chattable, tgUserID int64, d
 
 	s.sessions.setTempField(tgUserID, "BotMessageID", sentMsg.MessageID)
 
+	// regular messages pass the user's auto-delete setting, only short notices their own delay.
+	// 0 keeps the message
+	if delay <= 0 {
+		return nil
+	}
+
 	go func() {
 		time.Sleep(delay)
 		deleteMsg := tgbotapi.NewDeleteMessage(sentMsg.Chat.ID, sentMsg.MessageID)
@@ -2,14 +2,15 @@ package telegram_bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
-	"strings"
-	"time"
+	"strconv"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
 	"gitlab.com/avolkov/wood_post/pkg/log"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
 )
 
 func (s *Service) checkBeforeCreatePortfolio(
@@ -31,7 +32,7 @@ func (s *Service) checkBeforeCreatePortfolio(
 				chatID,
 				"Oh, we could not create portfolio for you, please try again."),
 			tgUserID,
-			20*time.Second,
+			s.messageTTL(tgUserID),
 		)
 	}
 	log.Infof("user_id: %d, portfolios limit reached: %t", dbUserID, limitReached)
@@ -40,7 +41,7 @@ func (s *Service) checkBeforeCreatePortfolio(
 		err := s.sendTemporaryMessage(
 			tgbotapi.NewMessage(chatID, "Sorry, you can create up to 2 portfolios. Gimmi ur munney to create more portfolios oi."),
 			tgUserID,
-			20*time.Second,
+			s.messageTTL(tgUserID),
 		)
 		// err := s.editMessageText(
 		// 	chatID,
@@ -52,451 +53,382 @@ func (s *Service) checkBeforeCreatePortfolio(
 		return s.showMainMenu(chatID, tgUserID)
 	}
 
-	s.sessions.setState(tgUserID, "waiting_portfolio_name")
-
-	// err := s.editMessageText(asdasdasd
-	// 	chatID,
-	// 	r.BotMessageID,
-	// 	"Please enter a name for your portfolio without special characters:")
-
-	msg := tgbotapi.NewMessage(chatID, "Please enter a name for your portfolio without special characters:")
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-		),
-	)
-
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.startFlow(ctx, chatID, tgUserID, dbUserID, r, "portfolio_create")
 }
 
-func (s *Service) ShowPortfolios(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	action string,
-) error {
+func (s *Service) gfPortfoliosMain(chatID, tgUserID int64, BotMsgID int) error {
 	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
 
-	onlyNonDefault := (action == "change_default")
-
-	ps, err := s.store.GetPortfoliosFiltered(ctx, dbUserID, onlyNonDefault)
-	if err != nil {
-		log.Errorf("could not show portfolios: %s", err)
-		return s.sendTemporaryMessage(
-			tgbotapi.NewMessage(chatID,
-				"Sorry, we cannot get your portfolios, please try again."),
-			tgUserID,
-			20*time.Second,
-		)
-	}
-
-	if len(ps) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "You have no another portfolio, let's create a new one!")
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("New portfolio", "create_portfolio"),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-			),
-		)
-		return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	actions := []t.Actiontype{
+		{TgText: "New portfolio", CallBackName: "create_portfolio"}, // already exists
+		{TgText: "Delete portfolio", CallBackName: "gf_portfolios_delete"},
+		{TgText: "Get default", CallBackName: "gf_portfolio_get_default"},
+		{TgText: "Change default", CallBackName: "gf_portfolio_change_default"},
+		{TgText: "Rename", CallBackName: "gf_portfolio_rename"},
+		{TgText: "Back to main menu", CallBackName: "cancel_action"},
 	}
-	// jfc
-	log.Infof("user_id: %d, portfolios list: %s", dbUserID, ps)
 
 	var rows [][]tgbotapi.InlineKeyboardButton
-	for _, p := range ps {
-		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(p, fmt.Sprintf("%s::%s", action, p)),
-		))
-	}
 
-	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-	))
+	for i := 0; i < len(actions); i += 2 {
+		row := []tgbotapi.InlineKeyboardButton{
+			s.button(actions[i].TgText, actions[i].CallBackName),
+		}
+		if i+1 < len(actions) {
+			row = append(row, s.button(actions[i+1].TgText, actions[i+1].CallBackName))
+		}
+		rows = append(rows, row)
+	}
 
-	msg := tgbotapi.NewMessage(chatID, "Select a portfolio to perform an action:")
+	msg := tgbotapi.NewMessage(chatID, "Choose an action:")
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 
-	// TODO: add Back button
-
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 }
 
-func (s *Service) askPortfolioConfirmation(
-	chatID, tgUserID int64,
+func (s *Service) showDefaultPortfolio(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
 	BotMsgID int,
-	nextAction string,
-	args ...interface{},
 ) error {
-	deleteMsg := tgbotapi.NewDeleteMessage(chatID, BotMsgID)
-	_, _ = s.bot.Request(deleteMsg)
-
-	template, ok := t.ConfirmationTemplates[nextAction]
-	if !ok {
-		return fmt.Errorf("unknown confirmation template for action: %s", nextAction)
+	pName, err := s.store.GetDefaultPortfolio(ctx, dbUserID)
+	if err != nil {
+		// return err
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+		msg := tgbotapi.NewMessage(chatID, "You have no default portfolio yet. Let's add your first one!")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				s.button("New portfolio", "create_portfolio"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				s.button("Back to main menu", "cancel_action"),
+			),
+		)
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 	}
 
-	text := fmt.Sprintf(template.MessageText, args...)
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
 
-	msg := tgbotapi.NewMessage(chatID, text)
+	msg := tgbotapi.NewMessage(chatID,
+		fmt.Sprintf("Your default portfolio name is *%s*.", pName))
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(template.ConfirmText, template.ConfirmCallback),
-			tgbotapi.NewInlineKeyboardButtonData(template.CancelText, template.CancelCallback),
+			s.button("Change default", "gf_portfolio_change_default"),
+			s.button("Rename", "gf_portfolio_rename_default"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Back", "cancel_action"),
 		),
 	)
 
-	s.sessions.setState(tgUserID, template.NextState)
-
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 }
 
-func (s *Service) portfolioRenameConfirmed(
+// Rename button of the default portfolio skips picking it
+func (s *Service) renameDefaultPortfolio(
 	ctx context.Context,
 	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	oldName, newName string,
+	sv *UserSession,
 ) error {
-	err := s.store.RenamePortfolio(ctx, dbUserID, oldName, newName)
+	pName, err := s.store.GetDefaultPortfolio(ctx, dbUserID)
 	if err != nil {
-		err := s.editMessageText(
-			chatID,
-			BotMsgID,
-			"Could not rename portfolio, please try again.")
-		if err != nil {
-			return nil // ignore error cause we need to return db error
-		}
 		return err
 	}
 
-	log.Infof("portfolio renamed: user_id=%d, old_portfolio_name=%s, new_portfolio_name=%s", dbUserID, oldName, newName)
+	sv.SelectedPortfolioName = pName
+	return s.startFlowAt(ctx, chatID, tgUserID, dbUserID, sv, "portfolio_rename", "waiting_for_new_portfolio_name")
+}
 
-	err = s.editMessageText(
-		chatID,
-		BotMsgID,
-		"Portfolio renamed successfully.")
-	if err != nil {
-		return err
-	}
-	return s.showMainMenu(chatID, tgUserID)
+// create: name -> description
+func (s *Service) portfolioCreateFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("portfolio_create",
+		flowState{
+			Name: "waiting_portfolio_name",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: "Please enter a name for your portfolio without special characters:"}, nil
+			},
+			Inputs: []flowInput{
+				{Next: []string{"waiting_portfolio_description"}, Handle: s.portfolioNameEntered("waiting_portfolio_description")},
+			},
+		},
+		flowState{
+			Name: "waiting_portfolio_description",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: fmt.Sprintf("Please enter description for portfolio: %s", env.Session.TempPortfolioName)}, nil
+			},
+			Inputs: []flowInput{
+				{Next: []string{"portfolio_created"}, Handle: s.portfolioDescriptionEntered},
+			},
+		},
+		flowState{
+			Name: "portfolio_created",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: fmt.Sprintf("Portfolio '%s' created successfully!", env.Session.TempPortfolioName)}, nil
+			},
+		},
+	)
 }
 
-func (s *Service) portfolioDeletinonConfirmed(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	pName string,
-) error {
-	err := s.editMessageText(
-		chatID,
-		BotMsgID,
-		"Deleting portfolio...")
-	if err != nil {
-		return err
-	}
+// rename: portfolio -> new name -> confirm. rename of the default portfolio starts at the name
+func (s *Service) portfolioRenameFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("portfolio_rename",
+		flowState{
+			Name:   "waiting_rename_portfolio_pick",
+			Prompt: s.promptPortfolioPick(false),
+			Inputs: []flowInput{
+				{Action: "pf_pick", Next: []string{"waiting_for_new_portfolio_name"}, Handle: s.portfolioPicked("waiting_for_new_portfolio_name")},
+			},
+		},
+		flowState{
+			Name: "waiting_for_new_portfolio_name",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{
+					Text:     fmt.Sprintf("Please enter a new name for portfolio *'%s'* without special characters.", env.Session.SelectedPortfolioName),
+					Markdown: true,
+				}, nil
+			},
+			Inputs: []flowInput{
+				{Next: []string{"waiting_rename_portfolio_decision"}, Handle: s.portfolioNameEntered("waiting_rename_portfolio_decision")},
+			},
+		},
+		flowState{
+			Name: "waiting_rename_portfolio_decision",
+			Prompt: portfolioConfirmationPrompt("rename_portfolio", func(env *flowEnv) []any {
+				return []any{env.Session.SelectedPortfolioName, env.Session.TempPortfolioName}
+			}),
+			Inputs: []flowInput{
+				{Action: "confirm_portfolio_rename", Next: []string{"portfolio_renamed"}, Handle: s.portfolioRenameConfirmed},
+			},
+		},
+		flowState{
+			Name: "portfolio_renamed",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: "Portfolio renamed successfully."}, nil
+			},
+		},
+	)
+}
 
-	time.Sleep(1 * time.Second)
+// delete: portfolio -> confirm, the default portfolio can't be deleted
+func (s *Service) portfolioDeleteFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("portfolio_delete",
+		flowState{
+			Name:   "waiting_delete_portfolio_pick",
+			Prompt: s.promptPortfolioPick(false),
+			Inputs: []flowInput{
+				{Action: "pf_pick", Next: []string{"waiting_delete_portfolio_decision"}, Handle: s.portfolioToDeletePicked},
+			},
+		},
+		flowState{
+			Name: "waiting_delete_portfolio_decision",
+			Prompt: portfolioConfirmationPrompt("delete_portfolio", func(env *flowEnv) []any {
+				return []any{env.Session.SelectedPortfolioName}
+			}),
+			Inputs: []flowInput{
+				{Action: "confirm_portfolio_deletion", Next: []string{"portfolio_deleted"}, Handle: s.portfolioDeletionConfirmed},
+			},
+		},
+		flowState{
+			Name: "portfolio_deleted",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: "Portfolio deleted successfully."}, nil
+			},
+		},
+	)
+}
 
-	err = s.store.DeletePortfolio(ctx, dbUserID, pName)
-	if err != nil {
-		err := s.editMessageText(
-			chatID,
-			BotMsgID,
-			"Could not delete portfolio, please try again.")
+// change default: one of non-default portfolios -> confirm
+func (s *Service) portfolioChangeDefaultFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("portfolio_change_default",
+		flowState{
+			Name:   "waiting_change_default_portfolio_pick",
+			Prompt: s.promptPortfolioPick(true),
+			Inputs: []flowInput{
+				{Action: "pf_pick", Next: []string{"waiting_change_default_portfolio_decision"}, Handle: s.portfolioPicked("waiting_change_default_portfolio_decision")},
+			},
+		},
+		flowState{
+			Name: "waiting_change_default_portfolio_decision",
+			Prompt: portfolioConfirmationPrompt("change_default_portfolio", func(env *flowEnv) []any {
+				return []any{env.Session.SelectedPortfolioName}
+			}),
+			Inputs: []flowInput{
+				{Action: "confirm_portfolio_change_default", Next: []string{"default_portfolio_changed"}, Handle: s.portfolioChangeDefaultConfirmed},
+			},
+		},
+		flowState{
+			Name: "default_portfolio_changed",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: "Default portfolio changed successfully."}, nil
+			},
+		},
+	)
+}
+
+func (s *Service) promptPortfolioPick(onlyNonDefault bool) func(context.Context, *flowEnv) (fsm.Prompt, error) {
+	return func(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+		ps, err := s.store.GetPortfoliosFiltered(ctx, env.DBUserID, onlyNonDefault)
 		if err != nil {
-			return nil // ignore error cause we need to return db error
+			return fsm.Prompt{}, fmt.Errorf("get portfolios: %w", err)
 		}
-		return err
-	}
 
-	log.Infof("portfolio deleted: user_id=%d, portfolio_name=%s", dbUserID, pName)
+		if len(ps) == 0 {
+			return fsm.Prompt{
+				Text:    "You have no another portfolio, let's create a new one!",
+				Buttons: [][]fsm.Button{{{Text: "New portfolio", Action: "create_portfolio"}}},
+			}, nil
+		}
+		log.Infof("user_id: %d, portfolios list: %v", env.DBUserID, ps)
 
-	err = s.editMessageText(chatID, BotMsgID, "Portfolio deleted successfully.")
-	if err != nil {
-		return err
+		var rows [][]fsm.Button
+		for _, p := range ps {
+			rows = append(rows, []fsm.Button{{Text: p.Name, Action: "pf_pick", Arg: strconv.FormatInt(p.ID, 10)}})
+		}
+
+		return fsm.Prompt{Text: "Select a portfolio to perform an action:", Buttons: rows}, nil
 	}
-	return s.showMainMenu(chatID, tgUserID)
 }
 
-func (s *Service) portfolioChangeDefaultConfirmed(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	pName string,
-) error {
-	err := s.store.ChangeDefaultPortfolio(ctx, dbUserID, pName)
-	if err != nil {
-		err := s.editMessageText(
-			chatID,
-			BotMsgID,
-			"Could not change default portfolio, please try again.")
-		if err != nil {
-			return nil // ignore error cause we need to return db error
+func portfolioConfirmationPrompt(template string, args func(env *flowEnv) []any) func(context.Context, *flowEnv) (fsm.Prompt, error) {
+	return func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+		tpl, ok := t.ConfirmationTemplates[template]
+		if !ok {
+			return fsm.Prompt{}, fmt.Errorf("unknown confirmation template: %s", template)
 		}
-		return err
-	}
 
-	log.Infof("default portfolio changed: user_id=%d, portfolio_name=%s", dbUserID, pName)
-
-	err = s.editMessageText(
-		chatID,
-		BotMsgID,
-		"Default portfolio changed successfully.")
-	if err != nil {
-		return err
+		return fsm.Prompt{
+			Text:     fmt.Sprintf(tpl.MessageText, args(env)...),
+			Markdown: true,
+			Buttons:  [][]fsm.Button{{{Text: tpl.ConfirmText, Action: tpl.ConfirmCallback}}},
+		}, nil
 	}
-	return s.showMainMenu(chatID, tgUserID)
 }
 
-func (s *Service) gfPortfoliosMain(chatID, tgUserID int64, BotMsgID int) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
-	actions := []t.Actiontype{
-		{TgText: "New portfolio", CallBackName: "create_portfolio"}, // already exists
-		{TgText: "Delete portfolio", CallBackName: "gf_portfolios_delete"},
-		{TgText: "Get default", CallBackName: "gf_portfolio_get_default"},
-		{TgText: "Change default", CallBackName: "gf_portfolio_change_default"},
-		{TgText: "Rename", CallBackName: "gf_portfolio_rename"},
-		{TgText: "Back to main menu", CallBackName: "cancel_action"},
+// name of the user's portfolio with the id from a pf_pick button
+func (s *Service) pickedPortfolioName(ctx context.Context, dbUserID int64, rawID string) (string, error) {
+	portfolioID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return "", fsm.Invalid("Portfolio not found.")
 	}
 
-	var rows [][]tgbotapi.InlineKeyboardButton
-
-	for i := 0; i < len(actions); i += 2 {
-		row := []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData(actions[i].TgText, actions[i].CallBackName),
-		}
-		if i+1 < len(actions) {
-			row = append(row, tgbotapi.NewInlineKeyboardButtonData(actions[i+1].TgText, actions[i+1].CallBackName))
+	portfolios, err := s.store.GetPortfolioRefs(ctx, dbUserID)
+	if err != nil {
+		return "", fmt.Errorf("get portfolios: %w", err)
+	}
+	for _, p := range portfolios {
+		if p.ID == portfolioID {
+			return p.Name, nil
 		}
-		rows = append(rows, row)
 	}
+	return "", fsm.Invalid("Portfolio not found.")
+}
 
-	msg := tgbotapi.NewMessage(chatID, "Choose an action:")
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+func (s *Service) portfolioPicked(next string) func(context.Context, *flowEnv, string) (string, error) {
+	return func(ctx context.Context, env *flowEnv, rawID string) (string, error) {
+		pName, err := s.pickedPortfolioName(ctx, env.DBUserID, rawID)
+		if err != nil {
+			return "", err
+		}
 
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+		env.Session.SelectedPortfolioName = pName
+		return next, nil
+	}
 }
 
-func (s *Service) performActionForPortfolio(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	cb string,
-) error {
-	name, err := s.store.GetDefaultPortfolio(ctx, dbUserID)
+func (s *Service) portfolioToDeletePicked(ctx context.Context, env *flowEnv, rawID string) (string, error) {
+	pName, err := s.pickedPortfolioName(ctx, env.DBUserID, rawID)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	parts := strings.Split(cb, "::")
-	action := parts[0]
-	portfolio := parts[1]
-
-	s.sessions.setTempField(tgUserID, "SelectedPortfolioName", portfolio)
+	defaultName, err := s.store.GetDefaultPortfolio(ctx, env.DBUserID)
+	if err != nil {
+		return "", err
+	}
 
-	switch action {
-	case "delete":
-		if portfolio == name {
-			_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+	if pName == defaultName {
+		return "", fsm.Invalid(fmt.Sprintf("You cannot delete default portfolio '%s'. Change default one first.", pName))
+	}
 
-			msg := tgbotapi.NewMessage(chatID,
-				fmt.Sprintf("You cannot delete *default* portfolio '*%s*'. Change default one first.", portfolio))
-			msg.ParseMode = "Markdown"
-			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData("Change default", "gf_portfolio_change_default"),
-					tgbotapi.NewInlineKeyboardButtonData("Cancel", "cancel_action"),
-				),
-			)
+	env.Session.SelectedPortfolioName = pName
+	return "waiting_delete_portfolio_decision", nil
+}
 
-			return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+// checks the typed name for create and rename
+func (s *Service) portfolioNameEntered(next string) func(context.Context, *flowEnv, string) (string, error) {
+	return func(ctx context.Context, env *flowEnv, text string) (string, error) {
+		pName := t.PrettyPortfolioName(text)
+		if pName == "" {
+			return "", fsm.Invalid("Portfolio name must contain latin letters or digits.")
 		}
 
-		// return s.askDeletePortfolioConfirmation(chatID, tgUserID, BotMsgID, portfolio)
-		return s.askPortfolioConfirmation(chatID, tgUserID, BotMsgID, "delete_portfolio", portfolio)
-
-	case "rename":
-		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-		s.sessions.setState(tgUserID, "waiting_for_new_portfolio_name")
-		msg := tgbotapi.NewMessage(
-			chatID,
-			fmt.Sprintf("Please enter a new name for portfolio *'%s'* without special characters.", portfolio))
-		msg.ParseMode = "Markdown"
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-			),
-		)
-
-		return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
-
-	case "change_default":
-		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-		return s.askPortfolioConfirmation(chatID, tgUserID, BotMsgID, "change_default_portfolio", portfolio)
-
-	default:
-		log.Errorf("invalid action in performActionForPortfolio: %s", err)
-
-		return s.sendTemporaryMessage(
-			tgbotapi.NewMessage(chatID, "Ops, something went wrong. please try again."),
-			tgUserID, 20*time.Second)
-	}
-}
-
-func (s *Service) prettyPortfolioName(portfolioName string) string {
-	r := regexp.MustCompile(`[^a-zA-Z0-9_]+`)
-	pName := r.ReplaceAllString(strings.ReplaceAll(portfolioName, " ", "_"), "")
+		nameTaken, err := s.store.PortfolioNameExists(ctx, env.DBUserID, pName)
+		if err != nil {
+			return "", fmt.Errorf("failed to check portfolio existence: %w", err)
+		}
 
-	ru := regexp.MustCompile(`_+`)
-	pName = ru.ReplaceAllString(strings.ToLower(pName), "_")
+		if nameTaken {
+			return "", fsm.Invalid(fmt.Sprintf("Portfolio with name '%s' already exists, try another name.", pName))
+		}
 
-	if len(pName) > 40 {
-		pName = pName[:40]
+		env.Session.TempPortfolioName = pName
+		return next, nil
 	}
-
-	return pName
 }
 
-func (s *Service) showDefaultPortfolio(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-) error {
-	pName, err := s.store.GetDefaultPortfolio(ctx, dbUserID)
+func (s *Service) portfolioDescriptionEntered(ctx context.Context, env *flowEnv, text string) (string, error) {
+	err := s.store.CreatePortfolio(ctx, env.DBUserID, env.Session.TempPortfolioName, text)
 	if err != nil {
-		// return err
-		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-		msg := tgbotapi.NewMessage(chatID, "You have no default portfolio yet. Let's add your first one!")
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("New portfolio", "create_portfolio"),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back to main menu", "cancel_action"),
-			),
-		)
-		return s.sendTemporaryMessage(msg, tgUserID, 30*time.Second)
+		return "", fmt.Errorf("failed to create portfolio: %w", err)
 	}
 
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
-	msg := tgbotapi.NewMessage(chatID,
-		fmt.Sprintf("Your default portfolio name is *%s*.", pName))
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Change default", "gf_portfolio_change_default"),
-			tgbotapi.NewInlineKeyboardButtonData("Rename", fmt.Sprintf("rename::%s", pName)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-		),
-	)
-
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	log.Infof("portfolio created: user_id=%d, portfolio_name=%s", env.DBUserID, env.Session.TempPortfolioName)
+	return "portfolio_created", nil
 }
 
-func (s *Service) waitPortfolionName(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	msgText string,
-) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
-	pName := s.prettyPortfolioName(msgText)
+func (s *Service) portfolioRenameConfirmed(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	oldName, newName := env.Session.SelectedPortfolioName, env.Session.TempPortfolioName
 
-	nameTaken, err := s.store.PortfolioNameExists(ctx, dbUserID, pName)
-	if err != nil {
-		log.Errorf("could not check PortfolioNameExists: %s", err)
-		return s.sendTemporaryMessage(
-			tgbotapi.NewMessage(chatID,
-				"Oh, we could not create portfolio for you, please try again."),
-			tgUserID, 20*time.Second)
+	err := s.store.RenamePortfolio(ctx, env.DBUserID, oldName, newName)
+	if errors.Is(err, store.ErrPortfolioNotFound) {
+		return "", fsm.Invalid("Portfolio not found.")
 	}
-
-	if nameTaken {
-		t := fmt.Sprintf("Portfolio with name '%s' already exists, try another name.", pName)
-		return s.sendTemporaryMessage(tgbotapi.NewMessage(chatID, t),
-			tgUserID, 20*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to rename portfolio: %w", err)
 	}
 
-	s.sessions.setTempField(tgUserID, "TempPortfolioName", pName)
-	s.sessions.setState(tgUserID, "waiting_portfolio_description")
-
-	// t := fmt.Sprintf("Please enter description for portfolio: %s", pName)asdasdasd
-	// return s.editMessageText(chatID, BotMsgID, t)
-	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Please enter description for portfolio: %s", pName))
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-		),
-	)
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	log.Infof("portfolio renamed: user_id=%d, old_portfolio_name=%s, new_portfolio_name=%s", env.DBUserID, oldName, newName)
+	return "portfolio_renamed", nil
 }
 
-func (s *Service) waitPortfolionDescription(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	portfolioName, msgText string,
-) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-	portfolioDesc := msgText
+func (s *Service) portfolioDeletionConfirmed(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	pName := env.Session.SelectedPortfolioName
 
-	err := s.store.CreatePortfolio(ctx, dbUserID, portfolioName, portfolioDesc)
-	if err != nil {
-		return fmt.Errorf("failed to create portfolio: %w", err)
+	err := s.store.DeletePortfolio(ctx, env.DBUserID, pName)
+	if errors.Is(err, store.ErrPortfolioNotFound) {
+		return "", fsm.Invalid("Portfolio not found.")
 	}
-
-	s.sessions.clearSession(tgUserID)
-
-	err = s.sendTemporaryMessage(
-		tgbotapi.NewMessage(
-			chatID,
-			fmt.Sprintf("Portfolio '%s' created successfully!", portfolioName)),
-		tgUserID,
-		20*time.Second)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to delete portfolio: %w", err)
 	}
-	return s.showMainMenu(chatID, tgUserID)
+
+	log.Infof("portfolio deleted: user_id=%d, portfolio_name=%s", env.DBUserID, pName)
+	return "portfolio_deleted", nil
 }
 
-func (s *Service) waitNewPortfolionName(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	SelectedPortfolioName, msgText string,
-) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-	pName := s.prettyPortfolioName(msgText)
+func (s *Service) portfolioChangeDefaultConfirmed(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	pName := env.Session.SelectedPortfolioName
 
-	nameTaken, err := s.store.PortfolioNameExists(ctx, dbUserID, pName)
-	if err != nil {
-		return fmt.Errorf("failed to check portfolio existence: %w", err)
+	err := s.store.ChangeDefaultPortfolio(ctx, env.DBUserID, pName)
+	if errors.Is(err, store.ErrPortfolioNotFound) {
+		return "", fsm.Invalid("Portfolio not found.")
 	}
-
-	if nameTaken {
-		msg := fmt.Sprintf("Portfolio with name '%s' already exists, try another name.", pName)
-		return s.sendTemporaryMessage(
-			tgbotapi.NewMessage(chatID, msg),
-			tgUserID, 20*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to change default portfolio: %w", err)
 	}
 
-	s.sessions.setTempField(tgUserID, "TempPortfolioName", pName)
-
-	return s.askPortfolioConfirmation(
-		chatID,
-		tgUserID,
-		BotMsgID,
-		"rename_portfolio",
-		SelectedPortfolioName,
-		pName)
+	log.Infof("default portfolio changed: user_id=%d, portfolio_name=%s", env.DBUserID, pName)
+	return "default_portfolio_changed", nil
 }
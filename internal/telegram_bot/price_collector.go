@@ -0,0 +1,87 @@
+package telegram_bot
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/internal/prices"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// saves daily price snapshots for every asset from transactions,
+// snapshot of the current day is overwritten until the day is over
+func (s *Service) runPriceCollector(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Warn("price collector disabled", "interval", interval)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.collectPrices(ctx); err != nil {
+			log.Error("price collector failed", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Info("price collector stopping")
+			return
+		}
+	}
+}
+
+func (s *Service) collectPrices(ctx context.Context) error {
+	assets, err := s.store.GetTrackedAssets(ctx)
+	if err != nil {
+		return err
+	}
+
+	// rates of quote and reporting currencies are kept next to asset prices
+	currencies, err := s.store.GetTrackedCurrencies(ctx)
+	if err != nil {
+		return err
+	}
+	for _, currency := range currencies {
+		if !slices.Contains(assets, currency) {
+			assets = append(assets, currency)
+		}
+	}
+
+	if len(assets) == 0 {
+		return nil
+	}
+
+	quotes, err := s.prices.CurrentPrices(ctx, assets, prices.DefaultQuote)
+	if err != nil {
+		return err
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var records []t.PriceRecord
+	for _, q := range quotes {
+		// price from DB itself, nothing new to save
+		if q.Source == prices.StoredSourceName {
+			continue
+		}
+		records = append(records, t.PriceRecord{
+			Asset:  q.Asset,
+			Quote:  q.Currency,
+			Time:   day,
+			Price:  q.Price,
+			Source: q.Source,
+		})
+	}
+
+	if err := s.store.SavePrices(ctx, records); err != nil {
+		return err
+	}
+
+	log.Infof("price collector: saved %d of %d assets", len(records), len(assets))
+	return nil
+}
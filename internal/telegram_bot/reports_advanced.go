@@ -3,17 +3,18 @@ package telegram_bot
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/costbasis"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
 	"gitlab.com/avolkov/wood_post/pkg/log"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
 // displays the full PnL report with current prices (like screenshot 1)
-func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
+func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, tag string) error {
 	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
 
 	// show loading message since this operation can take a few seconds
@@ -24,8 +25,10 @@ func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUse
 		log.Warn("Failed to send loading message", "error", err)
 	}
 
-	// get aggregated transaction data from database
-	reportData, err := s.store.GetReportData(ctx, dbUserID)
+	cur := s.getReportCurrency(ctx, dbUserID)
+
+	// replay user's transactions with the chosen cost basis method
+	reportData, closed, method, err := s.getPositionsPnLData(ctx, dbUserID, tag, cur)
 	if err != nil {
 		log.Error("Failed to get report data", "error", err, "user_id", dbUserID)
 
@@ -36,11 +39,11 @@ func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUse
 
 		return s.sendTemporaryMessage(
 			tgbotapi.NewMessage(chatID, "❌ Sorry, couldn't retrieve your transaction data. Please try again."),
-			tgUserID, 20*time.Second)
+			tgUserID, s.messageTTL(tgUserID))
 	}
 
-	// check if user has any active positions
-	if len(reportData) == 0 {
+	// check if user has any active or closed positions
+	if len(reportData) == 0 && len(closed) == 0 {
 		// delete loading message
 		if loadingMessage.MessageID != 0 {
 			_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, loadingMessage.MessageID))
@@ -50,23 +53,25 @@ func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUse
 		msg.ParseMode = "Markdown"
 		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Add Transaction", "gf_add_transaction"),
+				s.button("Add Transaction", "gf_add_transaction"),
 			),
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Main Menu", "cancel_action"),
+				s.button("Main Menu", "cancel_action"),
 			),
 		)
-		return s.sendTemporaryMessage(msg, tgUserID, 30*time.Second)
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 	}
 
-	// initialize PnL calculator with Binance API
-	pnlCalc := &PnLCalculator{
-		binanceAPIURL: s.cfg.BinanceAPIURL,
-		httpClient:    &http.Client{Timeout: 15 * time.Second},
-	}
+	// initialize PnL calculator with the configured price provider
+	pnlCalc := NewPnLCalculator(s.prices)
 
 	// calculate comprehensive PnL report
-	report, err := s.calculateAdvancedReport(ctx, pnlCalc, reportData)
+	report, err := s.calculateAdvancedReport(ctx, pnlCalc, cur, reportData, closed,
+		s.userSettings(tgUserID).FormatDateTime(time.Now()))
+	if report != nil {
+		report.CostBasisMethod = method.Title()
+		report.Tag = tag
+	}
 	if err != nil {
 		log.Error("Failed to calculate PnL report", "error", err, "user_id", dbUserID)
 
@@ -75,49 +80,21 @@ func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUse
 			_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, loadingMessage.MessageID))
 		}
 
-		errorMsg := "❌ Failed to fetch current prices or calculate PnL.\n\n"
-
-		// provide specific error messages based on the error type
-		errorStr := err.Error()
-		if strings.Contains(errorStr, "no valid prices found") {
-			errorMsg += "🔍 **Price Data Issue:**\n"
-			errorMsg += "None of your cryptocurrency pairs were found on Binance.\n\n"
-			errorMsg += "**Possible reasons:**\n"
-			errorMsg += "• Pairs might not be listed on Binance\n"
-			errorMsg += "• Incorrect pair format (should be like BTCUSDT)\n"
-			errorMsg += "• Pairs might have been delisted\n\n"
-			errorMsg += "💡 **Tip:** Check if your pairs are actively traded on Binance."
-		} else if strings.Contains(errorStr, "no valid price data available") {
-			errorMsg += "🔍 **Price Data Issue:**\n"
-			errorMsg += "No current price data available for your pairs.\n\n"
-			errorMsg += "This might be due to:\n"
-			errorMsg += "• Binance API maintenance\n"
-			errorMsg += "• Network connectivity issues\n"
-			errorMsg += "• Temporary API unavailability\n\n"
-			errorMsg += "Please try again in a few minutes."
-		} else if strings.Contains(errorStr, "Binance API error") {
-			errorMsg += "🔌 **Binance API Error:**\n"
-			errorMsg += fmt.Sprintf("API returned an error: %s\n\n", err.Error())
-			errorMsg += "This might be due to:\n"
-			errorMsg += "• API rate limiting (too many requests)\n"
-			errorMsg += "• Binance server issues\n"
-			errorMsg += "• API maintenance\n\n"
-			errorMsg += "Please wait a few minutes and try again."
-		} else {
-			errorMsg += "This might be due to:\n"
-			errorMsg += "• Network connectivity issues\n"
-			errorMsg += "• Binance API temporary unavailability\n"
-			errorMsg += "• Invalid currency pairs\n\n"
-			errorMsg += "Please try again in a few minutes."
-		}
+		// the chain tries every price source, so the failure isn't tied to one of them
+		errorMsg := "❌ Failed to fetch current prices or calculate PnL.\n\n" +
+			"This might be due to:\n" +
+			"• Network connectivity issues\n" +
+			"• Price sources being unavailable or rate limited\n" +
+			"• Invalid currency pairs\n\n" +
+			"Please try again in a few minutes."
 
 		return s.sendTemporaryMessage(
 			tgbotapi.NewMessage(chatID, errorMsg),
-			tgUserID, 30*time.Second)
+			tgUserID, s.messageTTL(tgUserID))
 	}
 
 	// format the report for display
-	reportText := s.formatAdvancedReport(report)
+	reportText := s.formatAdvancedReport(report, cur)
 
 	// delete loading message
 	if loadingMessage.MessageID != 0 {
@@ -129,123 +106,197 @@ func (s *Service) showPortfolioAdvancedReport(ctx context.Context, chatID, tgUse
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("📊 General Report", "gf_reports_general"),
-			tgbotapi.NewInlineKeyboardButtonData("➕ Add Transaction", "gf_add_transaction"),
+			s.button("📊 General Report", "gf_reports_general"),
+			s.button("➕ Add Transaction", "gf_add_transaction"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Main Menu", "cancel_action"),
+			s.button("🧮 Cost basis method", "gf_reports_cost_basis"),
+			s.button("Main Menu", "cancel_action"),
 		),
 	)
 
 	log.Info("Advanced PnL report sent successfully", "user_id", dbUserID)
-	return s.sendTemporaryMessage(msg, tgUserID, 120*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+// replays user's transactions and splits positions into open and fully closed ones.
+// the whole history is always replayed, with a tag a position is made only of the open lots
+// bought by tagged transactions and the gains of tagged sales. amounts come out in the report currency
+func (s *Service) getPositionsPnLData(ctx context.Context, dbUserID int64, tag string, cur reportCurrency) ([]t.CurrencyPnLData, []t.CurrencyPnLData, costbasis.Method, error) {
+	rawMethod, err := s.store.GetCostBasisMethod(ctx, dbUserID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	method, err := costbasis.ParseMethod(rawMethod)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	txs, err := s.store.GetTransactionsForUser(ctx, dbUserID, "")
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	positions, err := costbasis.Replay(method, cur.convertTransactions(txs))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	tagged := taggedTxIDs(txs, tag)
+
+	var open, closed []t.CurrencyPnLData
+	for _, pos := range positions {
+		if tagged != nil {
+			pos = taggedPosition(pos, tagged)
+		}
+
+		data := t.CurrencyPnLData{
+			Asset:                pos.Asset,
+			TotalAssetAmount:     pos.Amount,
+			TotalInvestedUSD:     pos.CostBasis,
+			AveragePurchasePrice: pos.AverageCost(),
+			RealizedPnLUSD:       pos.RealizedPnL,
+		}
+
+		if pos.Amount.IsPositive() {
+			open = append(open, data)
+		} else if len(pos.Disposals) > 0 {
+			closed = append(closed, data)
+		}
+	}
+
+	return open, closed, method, nil
 }
 
-// performs all PnL calculations using the mathematical formulas
-func (s *Service) calculateAdvancedReport(ctx context.Context, calc *PnLCalculator, reportData []t.CurrencyPnLData) (*t.GeneralReport, error) {
+// part of the position that belongs to the tagged transactions
+func taggedPosition(pos *costbasis.Position, tagged map[int64]bool) *costbasis.Position {
+	part := &costbasis.Position{Asset: pos.Asset}
+
+	for _, lot := range pos.OpenLots {
+		if tagged[lot.TxID] {
+			part.OpenLots = append(part.OpenLots, lot)
+			part.Amount = part.Amount.Add(lot.Amount)
+			part.CostBasis = part.CostBasis.Add(lot.CostUSD)
+		}
+	}
+
+	for _, d := range pos.Disposals {
+		if tagged[d.SaleTxID] {
+			part.Disposals = append(part.Disposals, d)
+			part.RealizedPnL = part.RealizedPnL.Add(d.Gain)
+		}
+	}
+
+	return part
+}
+
+// performs all PnL calculations using the mathematical formulas. positions are already in the report
+// currency, current prices are converted with today's rate
+func (s *Service) calculateAdvancedReport(
+	ctx context.Context,
+	calc *PnLCalculator,
+	cur reportCurrency,
+	reportData []t.CurrencyPnLData,
+	closed []t.CurrencyPnLData,
+	generatedAt string, // already in user's timezone and date format
+) (*t.GeneralReport, error) {
+	totalRealized := decimal.Zero
+	for _, data := range closed {
+		totalRealized = totalRealized.Add(data.RealizedPnLUSD)
+	}
+
 	if len(reportData) == 0 {
 		return &t.GeneralReport{
-			CurrencyData: []t.CurrencyPnLData{},
-			LastUpdated:  time.Now().Format("2006-01-02 15:04:05"),
+			CurrencyData:        []t.CurrencyPnLData{},
+			TotalRealizedPnLUSD: totalRealized,
+			TotalPnLUSD:         totalRealized,
+			ClosedPositions:     closed,
+			LastUpdated:         generatedAt,
 		}, nil
 	}
 
-	// extract all assets and convert to pairs for API call
-	pairs := make([]string, len(reportData))
+	// extract all assets for the price lookup
+	assets := make([]string, len(reportData))
 	for i, data := range reportData {
-		pairs[i] = data.Asset + "USDT" //FIXME move to global vars (or config)
+		assets[i] = data.Asset
 	}
 
-	// FIXME here we have logic where we pop pairs that have no dresponse from Binance,
-	// but we make 1 api request with list of pairs
-	// we should make 1 api request per pair, and then pop pairs that have no response (new branch)
-
-	// fetch current prices
-	currentPrices, err := calc.FetchCurrentPrices(ctx, pairs)
+	// fetch current prices, every asset goes through the sources chain until priced
+	currentPrices, err := calc.FetchCurrentPrices(ctx, assets)
 	if err != nil {
 		return nil, fmt.Errorf("fetch current prices: %w", err)
 	}
 
 	// calculate PnL for each currency pair
 	var calculatedData []t.CurrencyPnLData
-	var totalInvested, totalCurrentValue float64
-	var skippedPairs []string
+	totalInvested, totalCurrentValue := decimal.Zero, decimal.Zero
+	var unpriced []t.CurrencyPnLData
 
 	for _, data := range reportData {
-		pair := data.Asset + "USDT" // FIXME Convert asset ticker to USDT pair for price lookup (check if its required)
-		currentPrice, priceExists := currentPrices[pair]
+		// realized PnL does not depend on current price
+		totalRealized = totalRealized.Add(data.RealizedPnLUSD)
+
+		quote, priceExists := currentPrices[data.Asset]
 		if !priceExists {
-			log.Warn("No current price found for asset", "asset", data.Asset, "pair", pair)
-			skippedPairs = append(skippedPairs, data.Asset)
+			log.Warn("No current price found for asset", "asset", data.Asset)
+			unpriced = append(unpriced, data)
 			continue
 		}
+		currentPrice := cur.convert(quote.Price)
+		data.PriceSource = quote.Source
+		data.PriceUpdatedAt = quote.UpdatedAt
 
 		// apply the mathematical formulas:
 		data.CurrentPrice = currentPrice
-		data.CurrentValueUSD = data.TotalAssetAmount * currentPrice
-		data.PnLUSD = data.CurrentValueUSD - data.TotalInvestedUSD
-
-		// calculate PnL percentage - handle negative assets properly
-		if data.TotalInvestedUSD > 0 {
-			data.PnLPercentage = ((data.CurrentValueUSD / data.TotalInvestedUSD) - 1) * 100
-		} else if data.TotalInvestedUSD < 0 {
-			// negative invested means they took out more than they put in
-			// in this case, any remaining value is pure profit
-			data.PnLPercentage = 999.99 // Indicates "pure profit" scenario
-		} else {
-			// edge case: exactly zero net invested
-			data.PnLPercentage = 0
+		data.CurrentValueUSD = data.TotalAssetAmount.Mul(currentPrice)
+		data.UnrealizedPnLUSD = data.CurrentValueUSD.Sub(data.TotalInvestedUSD)
+		data.PnLUSD = data.RealizedPnLUSD.Add(data.UnrealizedPnLUSD)
+
+		// edge case: holdings with zero cost basis
+		if data.TotalInvestedUSD.IsPositive() {
+			data.PnLPercentage = pnlPercent(data.CurrentValueUSD, data.TotalInvestedUSD)
 		}
 
-		data.LastUpdated = time.Now().Format("2006-01-02 15:04:05")
+		data.LastUpdated = generatedAt
 
 		calculatedData = append(calculatedData, data)
-		totalInvested += data.TotalInvestedUSD
-		totalCurrentValue += data.CurrentValueUSD
-	}
-
-	// log skipped pairs if any
-	if len(skippedPairs) > 0 {
-		log.Warn("Skipped pairs due to missing price data", "skipped_pairs", skippedPairs, "skipped_count", len(skippedPairs))
+		totalInvested = totalInvested.Add(data.TotalInvestedUSD)
+		totalCurrentValue = totalCurrentValue.Add(data.CurrentValueUSD)
 	}
 
-	// check if we have any valid data to report
-	if len(calculatedData) == 0 {
-		return nil, fmt.Errorf("no valid price data available for any of the requested pairs")
+	// log unpriced assets if any
+	if len(unpriced) > 0 {
+		log.Warn("Some assets were not priced by any source", "unpriced_count", len(unpriced))
 	}
 
 	// calculate overall portfolio metrics
-	totalPnL := totalCurrentValue - totalInvested
-	var totalPnLPercent float64
-	if totalInvested > 0 {
-		totalPnLPercent = ((totalCurrentValue / totalInvested) - 1) * 100
-	} else if totalInvested < 0 {
-		totalPnLPercent = 999.99
+	totalUnrealized := totalCurrentValue.Sub(totalInvested)
+	totalPnLPercent := decimal.Zero
+	if totalInvested.IsPositive() {
+		totalPnLPercent = pnlPercent(totalCurrentValue, totalInvested)
 	}
 
 	report := &t.GeneralReport{
-		CurrencyData:       calculatedData,
-		TotalInvestedUSD:   totalInvested,
-		TotalCurrentUSD:    totalCurrentValue,
-		TotalPnLUSD:        totalPnL,
-		TotalPnLPercentage: totalPnLPercent,
-		LastUpdated:        time.Now().Format("2006-01-02 15:04:05"),
-	}
-
-	// add skipped pairs information to the report for display
-	if len(skippedPairs) > 0 {
-		// store skipped pairs in the report for later display
-		// we'll add this as a custom field or handle it in the format function
-		log.Info("Report generated with some skipped pairs", "processed_pairs", len(calculatedData), "skipped_pairs", len(skippedPairs))
+		CurrencyData:          calculatedData,
+		TotalInvestedUSD:      totalInvested,
+		TotalCurrentUSD:       totalCurrentValue,
+		TotalRealizedPnLUSD:   totalRealized,
+		TotalUnrealizedPnLUSD: totalUnrealized,
+		TotalPnLUSD:           totalRealized.Add(totalUnrealized),
+		TotalPnLPercentage:    totalPnLPercent,
+		LastUpdated:           generatedAt,
+		UnpricedAssets:        unpriced,
+		ClosedPositions:       closed,
 	}
 
 	return report, nil
 }
 
 // creates the advanced report with the specific format requested:
-// asset | total_asset_amount | total_invested_amount_usd | PnL% | PnL USD | current_value | average_purchase_price
-func (s *Service) formatAdvancedReport(report *t.GeneralReport) string {
-	if len(report.CurrencyData) == 0 {
+// asset | holdings | cost basis | current value | avg cost | unrealized PnL | realized PnL
+func (s *Service) formatAdvancedReport(report *t.GeneralReport, cur reportCurrency) string {
+	if len(report.CurrencyData) == 0 && len(report.UnpricedAssets) == 0 && len(report.ClosedPositions) == 0 {
 		return "*📊 General Portfolio Report*\n\n" +
 			"🤷‍♂️ No active positions found.\n" +
 			"Add some transactions to see your PnL analysis!"
@@ -255,7 +306,13 @@ func (s *Service) formatAdvancedReport(report *t.GeneralReport) string {
 
 	// header
 	builder.WriteString("📊 *Advanced Portfolios Report*\n")
-	builder.WriteString(fmt.Sprintf("📅 Generated: `%s`\n\n", report.LastUpdated))
+	builder.WriteString(fmt.Sprintf("📅 Generated: `%s`\n", report.LastUpdated))
+	builder.WriteString(fmt.Sprintf("🧮 Cost basis: `%s`\n", report.CostBasisMethod))
+	if report.Tag != "" {
+		builder.WriteString(fmt.Sprintf("🏷 Tag: `%s`\n", report.Tag))
+	}
+	builder.WriteString(cur.note())
+	builder.WriteString("\n")
 
 	// individual currency data
 	builder.WriteString("💰 *Assets over all portfolios:*\n\n")
@@ -264,9 +321,9 @@ func (s *Service) formatAdvancedReport(report *t.GeneralReport) string {
 		// choose emoji based on PnL
 		var pnlEmoji string
 		switch {
-		case data.PnLUSD > 0:
+		case data.PnLUSD.IsPositive():
 			pnlEmoji = "🟢"
-		case data.PnLUSD < 0:
+		case data.PnLUSD.IsNegative():
 			pnlEmoji = "🔴"
 		default:
 			pnlEmoji = "⚪"
@@ -275,37 +332,9 @@ func (s *Service) formatAdvancedReport(report *t.GeneralReport) string {
 		// use the asset ticker directly (we already have BTC, ETH, etc.)
 		baseCurrency := data.Asset
 
-		// format invested amount - handle negative case (when more was taken out than invested)
-		var investedText string
-		if data.TotalInvestedUSD >= 0 {
-			investedText = fmt.Sprintf("Net Invested: `$%.2f`", data.TotalInvestedUSD)
-		} else {
-			// negative invested means they took out more than they put in
-			investedText = fmt.Sprintf("Net Profit Taken: `$%.2f`", -data.TotalInvestedUSD)
-		}
-
-		// format PnL with proper signs
-		var pnlUSDText, pnlPercentText string
-		if data.PnLUSD >= 0 {
-			pnlUSDText = fmt.Sprintf("+$%.2f", data.PnLUSD)
-		} else {
-			pnlUSDText = fmt.Sprintf("$%.2f", data.PnLUSD) // negative sign already included
-		}
-
-		if data.PnLPercentage >= 0 {
-			pnlPercentText = fmt.Sprintf("+%.2f%%", data.PnLPercentage)
-		} else {
-			pnlPercentText = fmt.Sprintf("%.2f%%", data.PnLPercentage) // already has negative sign
-		}
-
-		// handle special "pure profit" case
-		if data.PnLPercentage == 999.99 {
-			pnlPercentText = "🚀 PURE PROFIT"
-		}
-
 		// show break-even status
 		var breakEvenStatus string
-		if data.CurrentPrice < data.AveragePurchasePrice {
+		if data.CurrentPrice.LessThan(data.AveragePurchasePrice) {
 			breakEvenStatus = "📉 *Below break-even*"
 		} else {
 			breakEvenStatus = "📈 *Above break-even*"
@@ -313,22 +342,27 @@ func (s *Service) formatAdvancedReport(report *t.GeneralReport) string {
 
 		builder.WriteString(fmt.Sprintf(
 			"%s *%s*\n"+
-				"Holdings: `%.8g %s`\n"+
-				"%s\n"+
-				"Current Value: `$%.2f` @ `$%.2f`\n"+
-				"Avg Buy Price: `$%.2f` %s\n"+
-				"PnL: `%s` (`%s`)\n",
+				"Holdings: `%v %s`\n"+
+				"Cost Basis: `%s`\n"+
+				"Current Value: `%s` @ `%s`\n"+
+				"Price Source: `%s` (%s)\n"+
+				"Avg Cost: `%s` %s\n"+
+				"Unrealized PnL: `%s` (`%s`)\n"+
+				"Realized PnL: `%s`\n",
 			pnlEmoji,
 			data.Asset,
 			data.TotalAssetAmount,
 			baseCurrency,
-			investedText,
-			data.CurrentValueUSD,
-			data.CurrentPrice,
-			data.AveragePurchasePrice,
+			cur.formatAmount(data.TotalInvestedUSD),
+			cur.formatAmount(data.CurrentValueUSD),
+			cur.formatAmount(data.CurrentPrice),
+			data.PriceSource,
+			formatPriceAge(time.Since(data.PriceUpdatedAt)),
+			cur.formatAmount(data.AveragePurchasePrice),
 			breakEvenStatus,
-			pnlUSDText,
-			pnlPercentText,
+			cur.formatSigned(data.UnrealizedPnLUSD),
+			formatSignedPercent(data.PnLPercentage),
+			cur.formatSigned(data.RealizedPnLUSD),
 		))
 
 		// add separator except for the last item
@@ -337,56 +371,87 @@ func (s *Service) formatAdvancedReport(report *t.GeneralReport) string {
 		}
 	}
 
+	// assets without price are listed instead of being hidden
+	if len(report.UnpricedAssets) > 0 {
+		builder.WriteString("\n" + strings.Repeat("─", 19) + "\n\n")
+		builder.WriteString("⚠️ *No price from any source (not in totals):*\n")
+		for _, data := range report.UnpricedAssets {
+			builder.WriteString(fmt.Sprintf("%s: `%v %s`, cost basis `%s`, realized `%s`\n",
+				data.Asset,
+				data.TotalAssetAmount,
+				data.Asset,
+				cur.formatAmount(data.TotalInvestedUSD),
+				cur.formatSigned(data.RealizedPnLUSD),
+			))
+		}
+	}
+
+	// fully sold assets only have realized PnL
+	if len(report.ClosedPositions) > 0 {
+		builder.WriteString("\n" + strings.Repeat("─", 19) + "\n\n")
+		builder.WriteString("📦 *Closed positions:*\n")
+		for _, data := range report.ClosedPositions {
+			builder.WriteString(fmt.Sprintf("%s: realized `%s`\n", data.Asset, cur.formatSigned(data.RealizedPnLUSD)))
+		}
+	}
+
 	// overall portfolio summary
 	builder.WriteString("\n" + strings.Repeat("—", 20) + "\n\n")
 
 	var totalEmoji string
 	switch {
-	case report.TotalPnLUSD > 0:
+	case report.TotalPnLUSD.IsPositive():
 		totalEmoji = "🚀"
-	case report.TotalPnLUSD < 0:
+	case report.TotalPnLUSD.IsNegative():
 		totalEmoji = "📉"
 	default:
 		totalEmoji = "⚖️"
 	}
 
-	// format total amounts with proper signs
-	var totalInvestedText string
-	if report.TotalInvestedUSD >= 0 {
-		totalInvestedText = fmt.Sprintf("💸 Net Invested: `$%.2f`", report.TotalInvestedUSD)
-	} else {
-		totalInvestedText = fmt.Sprintf("💰 Net Profit Taken: `$%.2f`", -report.TotalInvestedUSD)
-	}
-
-	var totalPnLUSDText, totalPnLPercentText string
-	if report.TotalPnLUSD >= 0 {
-		totalPnLUSDText = fmt.Sprintf("+$%.2f", report.TotalPnLUSD)
-	} else {
-		totalPnLUSDText = fmt.Sprintf("$%.2f", report.TotalPnLUSD)
-	}
-
-	if report.TotalPnLPercentage >= 0 {
-		totalPnLPercentText = fmt.Sprintf("+%.2f%%", report.TotalPnLPercentage)
-	} else {
-		totalPnLPercentText = fmt.Sprintf("%.2f%%", report.TotalPnLPercentage)
-	}
-
-	// handle special "pure profit" case for total
-	if report.TotalPnLPercentage == 999.99 {
-		totalPnLPercentText = "🚀 PURE PROFIT"
-	}
-
 	builder.WriteString(fmt.Sprintf(
 		"%s *Total Overview:*\n\n"+
-			"%s\n"+
-			"💎 Current Value: `$%.2f`\n"+
-			"📊 Total PnL: `%s` (`%s`)\n",
+			"💸 Cost Basis: `%s`\n"+
+			"💎 Current Value: `%s`\n"+
+			"📊 Unrealized PnL: `%s` (`%s`)\n"+
+			"💰 Realized PnL: `%s`\n"+
+			"🧾 Total PnL: `%s`\n",
 		totalEmoji,
-		totalInvestedText,
-		report.TotalCurrentUSD,
-		totalPnLUSDText,
-		totalPnLPercentText,
+		cur.formatAmount(report.TotalInvestedUSD),
+		cur.formatAmount(report.TotalCurrentUSD),
+		cur.formatSigned(report.TotalUnrealizedPnLUSD),
+		formatSignedPercent(report.TotalPnLPercentage),
+		cur.formatSigned(report.TotalRealizedPnLUSD),
+		cur.formatSigned(report.TotalPnLUSD),
 	))
 
 	return builder.String()
 }
+
+// formats USD amount with explicit sign: +$1.00, -$1.00
+func formatSignedPercent(v decimal.Decimal) string {
+	if !v.IsNegative() {
+		return fmt.Sprintf("+%.2f%%", v)
+	}
+	return fmt.Sprintf("%.2f%%", v)
+}
+
+var hundred = decimal.NewFromInt(100)
+
+// (value / invested - 1) * 100
+func pnlPercent(value, invested decimal.Decimal) decimal.Decimal {
+	return value.Sub(invested).Mul(hundred).Div(invested)
+}
+
+// human readable age of a cached quote
+func formatPriceAge(age time.Duration) string {
+	switch {
+	case age < 5*time.Second:
+		return "live"
+	case age < time.Minute:
+		return fmt.Sprintf("%ds ago", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	default:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	}
+}
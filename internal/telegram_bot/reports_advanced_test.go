@@ -0,0 +1,67 @@
+package telegram_bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gitlab.com/avolkov/wood_post/internal/prices"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// source that knows prices only of the given assets
+type fixedPrices map[string]decimal.Decimal
+
+func (fixedPrices) Name() string { return "fixed" }
+
+func (p fixedPrices) CurrentPrices(_ context.Context, assets []string, _ string) (map[string]prices.Quote, error) {
+	quotes := make(map[string]prices.Quote)
+	for _, asset := range assets {
+		if price, ok := p[asset]; ok {
+			quotes[asset] = prices.Quote{Asset: asset, Price: price, Source: "fixed"}
+		}
+	}
+	return quotes, nil
+}
+
+func TestCalculateAdvancedReportUnpriced(t *testing.T) {
+	open := []types.CurrencyPnLData{
+		{Asset: "BTC", TotalAssetAmount: decimal.NewFromInt(1), TotalInvestedUSD: decimal.NewFromInt(20000)},
+		{Asset: "OBSCURE", TotalAssetAmount: decimal.NewFromInt(100), TotalInvestedUSD: decimal.NewFromInt(50)},
+	}
+
+	tests := []struct {
+		name         string
+		prices       fixedPrices
+		wantPriced   int
+		wantUnpriced int
+		wantCurrent  string
+	}{
+		{name: "some priced", prices: fixedPrices{"BTC": decimal.NewFromInt(30000)}, wantPriced: 1, wantUnpriced: 1, wantCurrent: "30000"},
+		{name: "nothing priced", prices: fixedPrices{}, wantUnpriced: 2, wantCurrent: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			report, err := s.calculateAdvancedReport(context.Background(), NewPnLCalculator(tt.prices), usdReportCurrency, open, nil, "now")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(report.CurrencyData) != tt.wantPriced || len(report.UnpricedAssets) != tt.wantUnpriced {
+				t.Errorf("got %d priced, %d unpriced, want %d, %d",
+					len(report.CurrencyData), len(report.UnpricedAssets), tt.wantPriced, tt.wantUnpriced)
+			}
+			if report.TotalCurrentUSD.String() != tt.wantCurrent {
+				t.Errorf("got current value %s, want %s", report.TotalCurrentUSD, tt.wantCurrent)
+			}
+
+			text := s.formatAdvancedReport(report, usdReportCurrency)
+			if !strings.Contains(text, "OBSCURE") {
+				t.Errorf("unpriced asset is missing in the report:\n%s", text)
+			}
+		})
+	}
+}
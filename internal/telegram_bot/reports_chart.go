@@ -0,0 +1,280 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/prices"
+	"gitlab.com/avolkov/wood_post/pkg/chart"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// one day of the reconstructed portfolio history
+type valuePoint struct {
+	Date     time.Time
+	Value    decimal.Decimal // holdings valued with the price known on that day
+	Invested decimal.Decimal // net invested: buys minus sells
+}
+
+// sends PNG chart of the portfolio value vs net invested over time
+func (s *Service) showPortfolioChartReport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, tag string) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	loadingMsg := tgbotapi.NewMessage(chatID, "🔄 *Building performance chart...*\n\nLoading price history...")
+	loadingMsg.ParseMode = "Markdown"
+	loadingMessage, err := s.bot.Send(loadingMsg)
+	if err != nil {
+		log.Warn("Failed to send loading message", "error", err)
+	}
+	defer func() {
+		if loadingMessage.MessageID != 0 {
+			_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, loadingMessage.MessageID))
+		}
+	}()
+
+	// the chart follows amounts and cash flows without matching lots, so with a tag
+	// it's made of the tagged transactions alone
+	txs, err := s.store.GetTransactionsForUser(ctx, dbUserID, tag)
+	if err != nil {
+		log.Error("Failed to get transactions for chart", "error", err, "user_id", dbUserID)
+		return s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID, "❌ Sorry, couldn't retrieve your transaction data. Please try again."),
+			tgUserID, s.messageTTL(tgUserID))
+	}
+
+	if len(txs) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "📈 *Performance Chart*\n\n🤷‍♂️ No transactions found.\n\nAdd some transactions to see how your portfolio performs over time!")
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				s.button("Add Transaction", "gf_add_transaction"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				s.button("Main Menu", "cancel_action"),
+			),
+		)
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+	}
+
+	from := utcDay(txs[0].TransactionDate)
+	// the user's today, transaction dates are stored in their wall clock time
+	to := utcDay(s.userSettings(tgUserID).WallClockNow())
+
+	assetsSet := make(map[string]struct{})
+	var assets []string
+	for _, tx := range txs {
+		if t.IsFiatFlow(tx.Type) {
+			continue
+		}
+		if _, ok := assetsSet[tx.Asset]; !ok {
+			assetsSet[tx.Asset] = struct{}{}
+			assets = append(assets, tx.Asset)
+		}
+	}
+
+	cur := s.getReportCurrency(ctx, dbUserID)
+
+	backfill := assets
+	if !cur.isUSD() {
+		backfill = append(backfill[:len(backfill):len(backfill)], cur.Code)
+	}
+	s.backfillPriceHistory(ctx, backfill, from, to)
+
+	history, err := s.store.GetPriceHistory(ctx, assets, prices.DefaultQuote, from, to)
+	if err != nil {
+		// transaction prices are still enough for a rough chart
+		log.Warn("Failed to get price history for chart", "error", err, "user_id", dbUserID)
+	}
+
+	points := buildValueSeries(txs, history, from, to)
+
+	if !cur.isUSD() {
+		rates, err := s.store.GetPriceHistory(ctx, []string{cur.Code}, prices.DefaultQuote, from, to)
+		if err != nil {
+			log.Warn("Failed to get rate history for chart", "error", err, "currency", cur.Code)
+		}
+		convertValueSeries(points, rates, cur.Rate)
+	}
+
+	c := &chart.LineChart{
+		Width:  1000,
+		Height: 560,
+		Dates:  make([]time.Time, len(points)),
+		Series: []chart.Series{
+			{Name: "Value", Color: chart.ColorBlue, Values: make([]float64, len(points))},
+			{Name: "Net invested", Color: chart.ColorOrange, Values: make([]float64, len(points))},
+		},
+	}
+	for i, p := range points {
+		c.Dates[i] = p.Date
+		// pixels don't need exact values
+		c.Series[0].Values[i] = p.Value.Float64()
+		c.Series[1].Values[i] = p.Invested.Float64()
+	}
+
+	img, err := c.PNG()
+	if err != nil {
+		log.Error("Failed to render chart", "error", err, "user_id", dbUserID)
+		return s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID, "❌ Not enough history to draw a chart yet. Try again tomorrow."),
+			tgUserID, s.messageTTL(tgUserID))
+	}
+
+	last := points[len(points)-1]
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "portfolio.png", Bytes: img})
+	photo.ParseMode = "Markdown"
+	photo.Caption = fmt.Sprintf(
+		"📈 *Portfolio value vs net invested*\n"+
+			"Period: `%s` — `%s`\n"+
+			"💎 Value: `%s`\n"+
+			"💸 Net Invested: `%s`",
+		s.formatDate(tgUserID, from),
+		s.formatDate(tgUserID, to),
+		cur.formatAmount(last.Value),
+		cur.formatAmount(last.Invested),
+	)
+	if tag != "" {
+		photo.Caption += fmt.Sprintf("\n🏷 Tag: `%s`", tag)
+	}
+	photo.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Back to Reports", "gf_reports_main"),
+			s.button("Main Menu", "cancel_action"),
+		),
+	)
+
+	log.Info("Performance chart sent", "user_id", dbUserID, "points", len(points))
+	return s.sendTemporaryMessage(photo, tgUserID, s.messageTTL(tgUserID))
+}
+
+// loads missing daily prices from the series provider into the prices table
+func (s *Service) backfillPriceHistory(ctx context.Context, assets []string, from, to time.Time) {
+	series, ok := s.history.(prices.SeriesProvider)
+	if !ok {
+		return
+	}
+
+	days := int(to.Sub(from).Hours()/24) + 1
+
+	for _, asset := range assets {
+		stored, err := s.store.GetPriceHistory(ctx, []string{asset}, prices.DefaultQuote, from, to)
+		if err != nil {
+			log.Warn("Failed to check price history", "asset", asset, "error", err)
+			continue
+		}
+		if len(stored) >= days {
+			continue
+		}
+
+		quotes, err := series.DailyPrices(ctx, asset, prices.DefaultQuote, from, to)
+		if err != nil {
+			log.Warn("Failed to backfill price history", "asset", asset, "error", err)
+			continue
+		}
+
+		records := make([]t.PriceRecord, 0, len(quotes))
+		for _, q := range quotes {
+			records = append(records, t.PriceRecord{
+				Asset:  q.Asset,
+				Quote:  q.Currency,
+				Time:   q.UpdatedAt,
+				Price:  q.Price,
+				Source: q.Source,
+			})
+		}
+
+		if err := s.store.SavePrices(ctx, records); err != nil {
+			log.Warn("Failed to save backfilled prices", "asset", asset, "error", err)
+			continue
+		}
+		log.Infof("backfilled %d daily prices for %s", len(records), asset)
+	}
+}
+
+// replays transactions day by day and values holdings with the last known price of each asset,
+// transaction prices are used until price history has a value
+func buildValueSeries(txs []t.Transaction, history []t.PriceRecord, from, to time.Time) []valuePoint {
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+
+	holdings := make(map[string]decimal.Decimal)
+	lastPrice := make(map[string]decimal.Decimal)
+	invested := decimal.Zero
+
+	var points []valuePoint
+	txIdx, histIdx := 0, 0
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		dayEnd := day.AddDate(0, 0, 1)
+
+		for txIdx < len(txs) && txs[txIdx].TransactionDate.Before(dayEnd) {
+			tx := txs[txIdx]
+			sign := decimal.NewFromInt(int64(tx.Sign()))
+			holdings[tx.Asset] = holdings[tx.Asset].Add(sign.Mul(tx.AssetAmount))
+			// rewards and airdrops weren't paid for, so they only show up in value
+			if !t.IsIncome(tx.Type) {
+				invested = invested.Add(sign.Mul(tx.NetUSD()))
+			}
+			// gifts have no price
+			if tx.AssetPrice.IsPositive() {
+				lastPrice[tx.Asset] = tx.AssetPrice
+			}
+			txIdx++
+		}
+
+		for histIdx < len(history) && history[histIdx].Time.Before(dayEnd) {
+			lastPrice[history[histIdx].Asset] = history[histIdx].Price
+			histIdx++
+		}
+
+		value := decimal.Zero
+		for asset, amount := range holdings {
+			value = value.Add(amount.Mul(lastPrice[asset]))
+		}
+
+		points = append(points, valuePoint{
+			Date:     day,
+			Value:    value,
+			Invested: invested,
+		})
+	}
+
+	return points
+}
+
+// converts USD points with the rate known on each day, days before the first known rate use it too,
+// fallback is used when there is no rate history at all
+func convertValueSeries(points []valuePoint, rates []t.PriceRecord, fallback decimal.Decimal) {
+	sort.SliceStable(rates, func(i, j int) bool {
+		return rates[i].Time.Before(rates[j].Time)
+	})
+
+	rate := fallback
+	if len(rates) > 0 {
+		rate = rates[0].Price
+	}
+
+	idx := 0
+	for i := range points {
+		dayEnd := points[i].Date.AddDate(0, 0, 1)
+		for idx < len(rates) && rates[idx].Time.Before(dayEnd) {
+			if rates[idx].Price.IsPositive() {
+				rate = rates[idx].Price
+			}
+			idx++
+		}
+		points[i].Value = points[i].Value.Div(rate)
+		points[i].Invested = points[i].Invested.Div(rate)
+	}
+}
+
+func utcDay(date time.Time) time.Time {
+	y, m, d := date.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,125 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// currency the reports are shown in, amounts are kept in USD and converted only for display.
+// current values use today's rate, historical amounts the rate of the day they happened
+type reportCurrency struct {
+	Code   string
+	Rate   decimal.Decimal                 // USD value of one unit today
+	rateAt func(time.Time) decimal.Decimal // USD value of one unit on a day, nil for USD
+}
+
+var usdReportCurrency = reportCurrency{Code: t.FiatUSD, Rate: decimal.NewFromInt(1)}
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+}
+
+// user's reporting currency with its current rate, USD when the rate is unknown
+func (s *Service) getReportCurrency(ctx context.Context, dbUserID int64) reportCurrency {
+	code, err := s.store.GetReportingCurrency(ctx, dbUserID)
+	if err != nil {
+		log.Error("Failed to get reporting currency", "error", err, "user_id", dbUserID)
+		return usdReportCurrency
+	}
+	if t.IsUSD(code) {
+		return usdReportCurrency
+	}
+
+	rate, err := s.rates.Rate(ctx, code)
+	if err != nil {
+		log.Warn("No rate for reporting currency, falling back to USD", "currency", code, "error", err)
+		return usdReportCurrency
+	}
+
+	// one lookup per day, reports convert many transactions of the same days
+	daily := make(map[time.Time]decimal.Decimal)
+	rateAt := func(date time.Time) decimal.Decimal {
+		day := utcDay(date)
+		if r, ok := daily[day]; ok {
+			return r
+		}
+		r, err := s.rates.RateAt(ctx, code, day)
+		if err != nil {
+			log.Warn("No historical rate for reporting currency, using current rate", "currency", code, "date", day, "error", err)
+			r = rate
+		}
+		daily[day] = r
+		return r
+	}
+
+	return reportCurrency{Code: code, Rate: rate, rateAt: rateAt}
+}
+
+func (c reportCurrency) isUSD() bool {
+	return t.IsUSD(c.Code)
+}
+
+func (c reportCurrency) convert(usd decimal.Decimal) decimal.Decimal {
+	if c.isUSD() {
+		return usd
+	}
+	return usd.Div(c.Rate)
+}
+
+// converts a USD amount of a past day with the rate of that day
+func (c reportCurrency) convertAt(usd decimal.Decimal, date time.Time) decimal.Decimal {
+	if c.isUSD() || c.rateAt == nil {
+		return c.convert(usd)
+	}
+	return usd.Div(c.rateAt(date))
+}
+
+// copies of the transactions with USD amounts converted at their dates, cost basis and gains
+// replayed from them come out in this currency
+func (c reportCurrency) convertTransactions(txs []t.Transaction) []t.Transaction {
+	if c.isUSD() {
+		return txs
+	}
+
+	converted := make([]t.Transaction, len(txs))
+	for i, tx := range txs {
+		tx.AssetPrice = c.convertAt(tx.AssetPrice, tx.TransactionDate)
+		tx.USDAmount = c.convertAt(tx.USDAmount, tx.TransactionDate)
+		tx.FeeUSD = c.convertAt(tx.FeeUSD, tx.TransactionDate)
+		converted[i] = tx
+	}
+	return converted
+}
+
+// formats an amount already in this currency: $1.50, €1.50, 0.00012345 BTC
+func (c reportCurrency) formatAmount(v decimal.Decimal) string {
+	if symbol, ok := currencySymbols[c.Code]; ok {
+		if v.IsNegative() {
+			return fmt.Sprintf("-%s%.2f", symbol, v.Neg())
+		}
+		return fmt.Sprintf("%s%.2f", symbol, v)
+	}
+	return fmt.Sprintf("%.8f %s", v, c.Code)
+}
+
+// formats an amount already in this currency with explicit sign: +$1.00, -€1.00
+func (c reportCurrency) formatSigned(v decimal.Decimal) string {
+	if v.IsNegative() {
+		return c.formatAmount(v)
+	}
+	return "+" + c.formatAmount(v)
+}
+
+// line for report headers, empty for USD
+func (c reportCurrency) note() string {
+	if c.isUSD() {
+		return ""
+	}
+	return fmt.Sprintf("💱 Currency: `%s` (1 %s = $%s now, past amounts at the rate of their day)\n", c.Code, c.Code, c.Rate.Round(2))
+}
@@ -3,45 +3,55 @@ package telegram_bot
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
-	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
 	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
 // displays the historical cost basis report (like screenshot 2)
-func (s *Service) showPortfolioGeneralReport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
+func (s *Service) showPortfolioGeneralReport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, tag string) error {
 	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
 
+	cur := s.getReportCurrency(ctx, dbUserID)
+
 	// get portfolio summaries for historical cost basis
-	summaries, err := s.store.GetPortfolioSummariesForUser(ctx, dbUserID)
+	summaries, err := s.portfolioSummaries(ctx, dbUserID, tag, cur)
 	if err != nil {
 		log.Error("Failed to get portfolio summaries", "error", err, "user_id", dbUserID)
 		return s.sendTemporaryMessage(
 			tgbotapi.NewMessage(chatID, "Sorry, couldn't retrieve your portfolio data. Please try again."),
-			tgUserID, 20*time.Second)
+			tgUserID, s.messageTTL(tgUserID))
 	}
 
 	if len(summaries) == 0 {
 		msg := tgbotapi.NewMessage(chatID, "You don't have any portfolios with assets yet. Start by creating a portfolio and adding some transactions!")
 		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("New portfolio", "create_portfolio"),
+				s.button("New portfolio", "create_portfolio"),
 			),
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
+				s.button("Back", "cancel_action"),
 			),
 		)
-		return s.sendTemporaryMessage(msg, tgUserID, 30*time.Second)
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 	}
 
 	// build the basic report message (like screenshot 2)
 	var reportText strings.Builder
 	reportText.WriteString("📊 *GENERAL PORTFOLIO REPORT*\n")
-	reportText.WriteString("_(Historical cost basis only)_\n\n")
+	reportText.WriteString("_(Historical cost basis only)_\n")
+	if tag != "" {
+		reportText.WriteString(fmt.Sprintf("🏷 Tag: `%s`\n", tag))
+	}
+	reportText.WriteString(cur.note())
+	reportText.WriteString("\n")
 
-	var grandTotalUSD float64
+	grandTotalUSD := decimal.Zero
 	for i, summary := range summaries {
 		if i > 0 {
 			reportText.WriteString("\n")
@@ -49,42 +59,160 @@ func (s *Service) showPortfolioGeneralReport(ctx context.Context, chatID, tgUser
 
 		reportText.WriteString(fmt.Sprintf("*Portfolio: %s*\n", summary.Name))
 
-		var portfolioTotalUSD float64
+		portfolioTotalUSD := decimal.Zero
 		for _, asset := range summary.Assets {
 			// use asset ticker directly (we already have BTC, ETH, etc.)
 			baseCurrency := asset.Asset
 
-			reportText.WriteString(fmt.Sprintf("%s: %.6g %s, invested: %.2f USD\n",
+			reportText.WriteString(fmt.Sprintf("%s: %v %s, invested: %s\n",
 				asset.Asset,
 				asset.TotalAmount,
 				baseCurrency,
-				asset.TotalUSD))
+				cur.formatAmount(asset.TotalUSD)))
 
-			portfolioTotalUSD += asset.TotalUSD
+			portfolioTotalUSD = portfolioTotalUSD.Add(asset.TotalUSD)
 		}
 
-		reportText.WriteString(fmt.Sprintf("*Portfolio Total: %.2f USD*\n", portfolioTotalUSD))
-		grandTotalUSD += portfolioTotalUSD
+		reportText.WriteString(fmt.Sprintf("*Portfolio Total: %s*\n", cur.formatAmount(portfolioTotalUSD)))
+		grandTotalUSD = grandTotalUSD.Add(portfolioTotalUSD)
 
 		if i < len(summaries)-1 {
 			reportText.WriteString(strings.Repeat("─", 21) + "\n")
 		}
 	}
 
-	reportText.WriteString(fmt.Sprintf("\n🎯 *GRAND TOTAL: %.2f USD*\n", grandTotalUSD))
+	reportText.WriteString(fmt.Sprintf("\n🎯 *GRAND TOTAL: %s*\n", cur.formatAmount(grandTotalUSD)))
+
+	// income is kept apart from trading gains, they are usually taxed differently
+	incomeText, err := s.buildIncomeSection(ctx, dbUserID, tag, cur)
+	if err != nil {
+		log.Error("Failed to build income section", "error", err, "user_id", dbUserID)
+	} else {
+		reportText.WriteString(incomeText)
+	}
 	reportText.WriteString("\n💡 _This shows historical cost basis. For current PnL analysis, use the Advanced Report._")
 
 	msg := tgbotapi.NewMessage(chatID, reportText.String())
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("📈 Advanced PnL Report", "gf_reports_advanced"),
+			s.button("📈 Advanced PnL Report", "gf_reports_advanced"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back to Reports", "gf_reports_main"),
-			tgbotapi.NewInlineKeyboardButtonData("Main Menu", "cancel_action"),
+			s.button("Back to Reports", "gf_reports_main"),
+			s.button("Main Menu", "cancel_action"),
 		),
 	)
 
-	return s.sendTemporaryMessage(msg, tgUserID, 90*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) buildIncomeSection(ctx context.Context, dbUserID int64, tag string, cur reportCurrency) (string, error) {
+	totals, err := s.totalsByType(ctx, dbUserID, []string{"income", "airdrop", "deposit", "withdrawal"}, tag, cur)
+	if err != nil {
+		return "", err
+	}
+
+	disposals, method, err := s.getUserDisposals(ctx, dbUserID, tag, cur)
+	if err != nil {
+		return "", err
+	}
+
+	tradingGains := decimal.Zero
+	for _, d := range disposals {
+		tradingGains = tradingGains.Add(d.Gain)
+	}
+
+	if len(totals) == 0 && len(disposals) == 0 {
+		return "", nil
+	}
+
+	var b strings.Builder
+	b.WriteString("\n💵 *INCOME & TRADING*\n")
+	b.WriteString(fmt.Sprintf("Rewards / interest: %s\n", cur.formatAmount(totals["income"])))
+	b.WriteString(fmt.Sprintf("Airdrops: %s\n", cur.formatAmount(totals["airdrop"])))
+	b.WriteString(fmt.Sprintf("*Total income: %s*\n", cur.formatAmount(totals["income"].Add(totals["airdrop"]))))
+	b.WriteString(fmt.Sprintf("Trading gains (realized, %s): %s\n", method.Title(), cur.formatSigned(tradingGains)))
+
+	if !totals["deposit"].IsZero() || !totals["withdrawal"].IsZero() {
+		b.WriteString(fmt.Sprintf("Fiat deposited: %s, withdrawn: %s\n", cur.formatAmount(totals["deposit"]), cur.formatAmount(totals["withdrawal"])))
+	}
+
+	return b.String(), nil
+}
+
+// holdings and invested amounts per portfolio. the store sums them in USD, for other currencies
+// every transaction is converted at its date first
+func (s *Service) portfolioSummaries(ctx context.Context, dbUserID int64, tag string, cur reportCurrency) ([]t.PortfolioSummary, error) {
+	if cur.isUSD() {
+		return s.store.GetPortfolioSummariesForUser(ctx, dbUserID, tag)
+	}
+
+	txs, err := s.store.GetTransactionsForUser(ctx, dbUserID, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// same sums as the store query
+	assets := make(map[string]map[string]*t.PortfolioAsset)
+	for _, tx := range cur.convertTransactions(txs) {
+		sign := tx.Sign()
+		if sign == 0 {
+			continue
+		}
+		if assets[tx.PortfolioName] == nil {
+			assets[tx.PortfolioName] = make(map[string]*t.PortfolioAsset)
+		}
+		asset := assets[tx.PortfolioName][tx.Asset]
+		if asset == nil {
+			asset = &t.PortfolioAsset{Asset: tx.Asset}
+			assets[tx.PortfolioName][tx.Asset] = asset
+		}
+
+		signed := decimal.NewFromInt(int64(sign))
+		asset.TotalAmount = asset.TotalAmount.Add(tx.AssetAmount.Mul(signed))
+		asset.TotalUSD = asset.TotalUSD.Add(tx.NetUSD().Mul(signed))
+	}
+
+	var summaries []t.PortfolioSummary
+	for name, byAsset := range assets {
+		summary := t.PortfolioSummary{Name: name}
+		for _, asset := range byAsset {
+			if asset.TotalAmount.IsPositive() {
+				summary.Assets = append(summary.Assets, *asset)
+			}
+		}
+		if len(summary.Assets) == 0 {
+			continue
+		}
+		sort.Slice(summary.Assets, func(i, j int) bool {
+			return summary.Assets[i].Asset < summary.Assets[j].Asset
+		})
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+
+	return summaries, nil
+}
+
+// totals of the given transaction types, converted at the date of every transaction when not in USD
+func (s *Service) totalsByType(ctx context.Context, dbUserID int64, types []string, tag string, cur reportCurrency) (map[string]decimal.Decimal, error) {
+	if cur.isUSD() {
+		return s.store.GetUSDTotalsByType(ctx, dbUserID, types, tag)
+	}
+
+	txs, err := s.store.GetTransactionsForUser(ctx, dbUserID, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]decimal.Decimal)
+	for _, tx := range txs {
+		if slices.Contains(types, tx.Type) {
+			totals[tx.Type] = totals[tx.Type].Add(cur.convertAt(tx.USDAmount, tx.TransactionDate))
+		}
+	}
+	return totals, nil
 }
@@ -0,0 +1,280 @@
+package telegram_bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/costbasis"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// totals of the disposals within a tax year
+type taxSummary struct {
+	Count         int
+	Proceeds      decimal.Decimal
+	CostBasis     decimal.Decimal
+	Gain          decimal.Decimal
+	ShortTermGain decimal.Decimal
+	LongTermGain  decimal.Decimal
+}
+
+// replays transactions with the user's method and returns all disposals in disposal date order.
+// the whole history is always replayed so lots bought without the tag still match, with a tag
+// only disposals of tagged sales are returned. cost basis and proceeds are converted to cur at
+// the dates of the transactions they come from
+func (s *Service) getUserDisposals(ctx context.Context, dbUserID int64, tag string, cur reportCurrency) ([]costbasis.Disposal, costbasis.Method, error) {
+	rawMethod, err := s.store.GetCostBasisMethod(ctx, dbUserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	method, err := costbasis.ParseMethod(rawMethod)
+	if err != nil {
+		return nil, "", err
+	}
+
+	txs, err := s.store.GetTransactionsForUser(ctx, dbUserID, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	positions, err := costbasis.Replay(method, cur.convertTransactions(txs))
+	if err != nil {
+		return nil, "", err
+	}
+
+	tagged := taggedTxIDs(txs, tag)
+
+	var disposals []costbasis.Disposal
+	for _, pos := range positions {
+		for _, d := range pos.Disposals {
+			if tagged == nil || tagged[d.SaleTxID] {
+				disposals = append(disposals, d)
+			}
+		}
+	}
+	sort.SliceStable(disposals, func(i, j int) bool {
+		return disposals[i].Disposed.Before(disposals[j].Disposed)
+	})
+
+	return disposals, method, nil
+}
+
+// ids of the transactions with the tag, nil without a tag. tags match case-insensitively like in the store
+func taggedTxIDs(txs []t.Transaction, tag string) map[int64]bool {
+	if tag == "" {
+		return nil
+	}
+
+	ids := make(map[int64]bool)
+	for _, tx := range txs {
+		for _, txTag := range tx.Tags {
+			if strings.EqualFold(txTag, tag) {
+				ids[tx.ID] = true
+				break
+			}
+		}
+	}
+	return ids
+}
+
+func (s *Service) askTaxReportYear(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, tag string) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	// only the years are needed here, amounts stay in USD so no rates are looked up
+	disposals, _, err := s.getUserDisposals(ctx, dbUserID, tag, usdReportCurrency)
+	if err != nil {
+		log.Error("Failed to get disposals", "error", err, "user_id", dbUserID)
+		return s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID, "❌ Sorry, couldn't retrieve your transaction data. Please try again."),
+			tgUserID, s.messageTTL(tgUserID))
+	}
+
+	if len(disposals) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "🧾 *Tax Report*\n\nYou have no sells yet, so there are no capital gains to report.")
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				s.button("Back to Reports", "gf_reports_main"),
+				s.button("Main Menu", "cancel_action"),
+			),
+		)
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+	}
+
+	// newest years first
+	var years []int
+	seen := make(map[int]struct{})
+	for i := len(disposals) - 1; i >= 0; i-- {
+		y := disposals[i].Disposed.Year()
+		if _, ok := seen[y]; !ok {
+			seen[y] = struct{}{}
+			years = append(years, y)
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(years); i += 3 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, y := range years[i:min(i+3, len(years))] {
+			row = append(row, s.button(strconv.Itoa(y), "tax_year", strconv.Itoa(y)))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		s.button("Back to Reports", "gf_reports_main"),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, "🧾 Choose a tax year for the capital gains report:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+// sends CSV with disposals of the year and a summary of totals
+func (s *Service) sendTaxReport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, year int, tag string) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	cur := s.getReportCurrency(ctx, dbUserID)
+	disposals, method, err := s.getUserDisposals(ctx, dbUserID, tag, cur)
+	if err != nil {
+		log.Error("Failed to get disposals", "error", err, "user_id", dbUserID)
+		return s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID, "❌ Sorry, couldn't retrieve your transaction data. Please try again."),
+			tgUserID, s.messageTTL(tgUserID))
+	}
+
+	var yearDisposals []costbasis.Disposal
+	for _, d := range disposals {
+		if d.Disposed.Year() == year {
+			yearDisposals = append(yearDisposals, d)
+		}
+	}
+
+	csvData, err := buildTaxCSV(yearDisposals, cur)
+	if err != nil {
+		return err
+	}
+
+	summary := summarizeDisposals(yearDisposals)
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("capital_gains_%d.csv", year),
+		Bytes: csvData,
+	})
+	doc.ParseMode = "Markdown"
+	doc.Caption = fmt.Sprintf(
+		"🧾 *Capital gains %d*\n"+
+			"%s"+
+			"Method: `%s`\n"+
+			"Disposals: `%d`\n"+
+			"Proceeds: `%s`\n"+
+			"Cost Basis: `%s`\n"+
+			"Short-term gain: `%s`\n"+
+			"Long-term gain: `%s`\n"+
+			"*Total gain: `%s`*",
+		year,
+		cur.note(),
+		method.Title(),
+		summary.Count,
+		cur.formatAmount(summary.Proceeds),
+		cur.formatAmount(summary.CostBasis),
+		cur.formatSigned(summary.ShortTermGain),
+		cur.formatSigned(summary.LongTermGain),
+		cur.formatSigned(summary.Gain),
+	)
+	if tag != "" {
+		doc.Caption += fmt.Sprintf("\nTag: `%s`", tag)
+	}
+
+	// document is kept in chat, accountants need it later
+	if _, err := s.bot.Send(doc); err != nil {
+		return fmt.Errorf("send tax report document: %w", err)
+	}
+
+	log.Info("Tax report sent", "user_id", dbUserID, "year", year, "disposals", summary.Count)
+
+	msg := tgbotapi.NewMessage(chatID, "What would you like to do next?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Another year", "gf_reports_tax"),
+			s.button("Back to Reports", "gf_reports_main"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Main Menu", "cancel_action"),
+		),
+	)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func summarizeDisposals(disposals []costbasis.Disposal) taxSummary {
+	var sum taxSummary
+	for _, d := range disposals {
+		sum.Count++
+		sum.Proceeds = sum.Proceeds.Add(d.Proceeds)
+		sum.CostBasis = sum.CostBasis.Add(d.CostBasis)
+		sum.Gain = sum.Gain.Add(d.Gain)
+		if d.Term() == costbasis.LongTerm {
+			sum.LongTermGain = sum.LongTermGain.Add(d.Gain)
+		} else {
+			sum.ShortTermGain = sum.ShortTermGain.Add(d.Gain)
+		}
+	}
+	return sum
+}
+
+// amount columns are in cur, named after it: cost_basis_usd, cost_basis_eur
+func buildTaxCSV(disposals []costbasis.Disposal, cur reportCurrency) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	suffix := "_" + strings.ToLower(cur.Code)
+	header := []string{
+		"asset",
+		"acquisition_date",
+		"disposal_date",
+		"quantity",
+		"cost_basis" + suffix,
+		"proceeds" + suffix,
+		"gain" + suffix,
+		"term",
+		"sale_transaction_id",
+		"lot_transaction_id",
+	}
+	if err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("write csv header: %w", err)
+	}
+
+	for _, d := range disposals {
+		record := []string{
+			d.Asset,
+			d.Acquired.Format("2006-01-02"),
+			d.Disposed.Format("2006-01-02"),
+			d.Amount.StringFixed(8),
+			d.CostBasis.StringFixed(2),
+			d.Proceeds.StringFixed(2),
+			d.Gain.StringFixed(2),
+			d.Term(),
+			strconv.FormatInt(d.SaleTxID, 10),
+			strconv.FormatInt(d.LotTxID, 10),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, fmt.Errorf("write csv record: %w", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("flush csv: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"gitlab.com/avolkov/wood_post/config"
+	"gitlab.com/avolkov/wood_post/internal/callback"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/internal/prices"
 	"gitlab.com/avolkov/wood_post/pkg/log"
 	"gitlab.com/avolkov/wood_post/store"
 
@@ -13,24 +16,64 @@ import (
 )
 
 type Service struct {
-	bot      *tgbotapi.BotAPI
-	store    *store.Store
-	sessions *SessionManager
-	cfg      *config.Config
+	bot       *tgbotapi.BotAPI
+	store     *store.Store
+	sessions  *SessionManager
+	cfg       *config.Config
+	prices    prices.Provider
+	history   prices.HistoricalProvider
+	rates     *prices.Converter
+	flows     *fsm.Machine[*flowEnv]
+	callbacks *callback.Codec
 }
 
-func New(token string, db *store.Store, cfg *config.Config) (*Service, error) {
+func New(
+	token string,
+	db *store.Store,
+	cfg *config.Config,
+	priceProvider prices.Provider,
+	historyProvider prices.HistoricalProvider,
+) (*Service, error) {
 	bot, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Telegram bot: %w", err)
 	}
 
-	return &Service{
+	cache := prices.NewCache(priceProvider, cfg.PriceCacheTTL, cfg.PriceCacheStale)
+
+	sessionStore, err := NewSessionStore(cfg.SessionStore, db)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("telegram_bot: %s session store", cfg.SessionStore)
+
+	secret := cfg.CallbackSecret
+	if secret == "" {
+		if secret, err = callback.RandomSecret(); err != nil {
+			return nil, err
+		}
+		log.Warn("CALLBACK_SECRET is not set, a random one is used, buttons sent before a restart won't work")
+	}
+
+	callbacks, err := callback.NewCodec(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
 		bot:      bot,
 		store:    db,
-		sessions: NewSessionManager(),
+		sessions: NewSessionManager(sessionStore),
 		cfg:      cfg,
-	}, nil
+		prices:   cache,
+		history:  historyProvider,
+		rates:    prices.NewConverter(cache, historyProvider),
+
+		callbacks: callbacks,
+	}
+	s.flows = s.newFlows()
+
+	return s, nil
 }
 
 func (s *Service) Run(ctx context.Context) error {
@@ -43,7 +86,7 @@ func (s *Service) Run(ctx context.Context) error {
 		for {
 			select {
 			case <-ticker.C:
-				s.sessions.cleanOldSessions(5 * time.Minute)
+				s.sessions.cleanOldSessions(ctx, s.cfg.SessionTTL)
 			case <-ctx.Done():
 				log.Info("session cleaner stopping")
 				return
@@ -51,28 +94,17 @@ func (s *Service) Run(ctx context.Context) error {
 		}
 	}()
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates := s.bot.GetUpdatesChan(u)
-
-	// listening = long polling
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info("bot polling stopped by context")
-			return nil
-
-		case update, ok := <-updates:
-			if !ok {
-				log.Warn("updates channel closed")
-				return nil
-			}
+	go s.runPriceCollector(ctx, s.cfg.PriceCollectorInterval)
 
-			if err := s.handleUpdate(ctx, update); err != nil {
-				log.Error("update handling error:", err)
-			}
-		}
-	}
+	d := newDispatcher(s.cfg.UpdateWorkers, s.cfg.UpdateQueueSize, s.handleUpdate)
+	d.start(ctx)
+	defer func() {
+		log.Info("waiting for queued updates")
+		d.stop()
+		log.Info("all queued updates handled")
+	}()
+
+	return s.receiveUpdates(ctx, d)
 }
 
 func (s *Service) handleUpdate(ctx context.Context, update tgbotapi.Update) error {
@@ -94,8 +126,9 @@ func (s *Service) handleUpdate(ctx context.Context, update tgbotapi.Update) erro
 			}
 
 			if chatID != 0 && userID != 0 {
-				// clear any existing session for this user
+				// clear any existing session for this user, also the saved copy
 				s.sessions.clearSession(userID)
+				s.sessions.persistSession(ctx, userID)
 
 				// Delete the problematic message if it's a callback
 				if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
@@ -117,20 +150,18 @@ func (s *Service) handleUpdate(ctx context.Context, update tgbotapi.Update) erro
 		}
 	}()
 
-	var tgUserID int64
-
-	if update.CallbackQuery != nil {
-		tgUserID = update.CallbackQuery.From.ID
-	} else if update.Message != nil {
-		tgUserID = update.Message.From.ID
-	} else {
+	tgUserID := updateUserID(update)
+	if tgUserID == 0 {
 		return nil
 	}
 
-	// get or create session - this ensures session exists
-	userSession, sessionExists := s.sessions.getSessionVars(tgUserID)
+	// session from memory or the saved one after restart
+	userSession, sessionExists := s.sessions.restoreSession(ctx, tgUserID)
 
-	// if this is a callback query but no session exists, it means the service was restarted
+	// runs before the panic recovery above, which deletes the saved copy again
+	defer s.sessions.persistSession(ctx, tgUserID)
+
+	// if this is a callback query but no session exists, it expired or was lost on restart with the memory store
 	// and the user is clicking on an old button
 	if update.CallbackQuery != nil && !sessionExists {
 		chatID := update.CallbackQuery.Message.Chat.ID
@@ -142,7 +173,7 @@ func (s *Service) handleUpdate(ctx context.Context, update tgbotapi.Update) erro
 		// send session expired message
 		expiredMsg := tgbotapi.NewMessage(chatID,
 			"⚠️ *Session Expired*\n\n"+
-				"This button is from before the service restart. Please use the main menu below or enter /start.")
+				"This button belongs to an expired session. Please use the main menu below or enter /start.")
 		expiredMsg.ParseMode = "Markdown"
 
 		err := s.sendTemporaryMessage(expiredMsg, tgUserID, 5*time.Second)
@@ -153,10 +184,24 @@ func (s *Service) handleUpdate(ctx context.Context, update tgbotapi.Update) erro
 		return s.showMainMenu(chatID, tgUserID)
 	}
 
+	s.loadUserSettings(ctx, tgUserID)
+
 	switch {
 	case update.Message != nil && update.Message.Text == "/start":
 		return s.handleStart(ctx, update.Message)
 
+	case update.Message != nil && update.Message.Text == "/import":
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(update.Message.Chat.ID, update.Message.MessageID))
+		dbUserID, err := s.store.GetUserIDByTelegramID(ctx, tgUserID)
+		if err != nil {
+			return fmt.Errorf("failed to get user from DB: %w", err)
+		}
+		return s.startImportFlow(ctx, update.Message.Chat.ID, tgUserID, dbUserID, userSession)
+
+	case update.Message != nil && update.Message.Text == "/export":
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(update.Message.Chat.ID, update.Message.MessageID))
+		return s.askExportFormat(update.Message.Chat.ID, tgUserID, userSession.BotMessageID)
+
 	// case update.Message != nil && update.Message.Text == "qwe":
 	// 	resp := tgbotapi.NewMessage(update.Message.Chat.ID, "jopa")
 	// 	err := s.sendTgMessage(resp, update.Message.From.ID)
@@ -0,0 +1,134 @@
+package telegram_bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+// keeps sessions between updates, SessionManager works with sessions in memory
+// and saves them here after every update
+type SessionStore interface {
+	// nil session without error when user has none
+	Load(ctx context.Context, tgUserID int64) (*UserSession, error)
+	Save(ctx context.Context, tgUserID int64, session *UserSession) error
+	Delete(ctx context.Context, tgUserID int64) error
+	// deletes sessions not updated since before, returns how many were deleted
+	DeleteOlderThan(ctx context.Context, before time.Time) (int, error)
+}
+
+// "memory" loses sessions on restart, "postgres" keeps them in bot_sessions
+func NewSessionStore(kind string, db *store.Store) (SessionStore, error) {
+	switch kind {
+	case "", "memory":
+		return newMemorySessionStore(), nil
+	case "postgres":
+		return newPostgresSessionStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown session store: %s", kind)
+	}
+}
+
+type memorySessionStore struct {
+	sessions map[int64]*UserSession
+	mu       sync.RWMutex
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{
+		sessions: make(map[int64]*UserSession),
+	}
+}
+
+// sessions are copied in and out, the live session is changed by its worker
+// while DeleteOlderThan reads the saved one
+func (m *memorySessionStore) Load(_ context.Context, tgUserID int64) (*UserSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	saved, ok := m.sessions[tgUserID]
+	if !ok {
+		return nil, nil
+	}
+	session := *saved
+	return &session, nil
+}
+
+func (m *memorySessionStore) Save(_ context.Context, tgUserID int64, session *UserSession) error {
+	saved := *session
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[tgUserID] = &saved
+	return nil
+}
+
+func (m *memorySessionStore) Delete(_ context.Context, tgUserID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, tgUserID)
+	return nil
+}
+
+func (m *memorySessionStore) DeleteOlderThan(_ context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for tgUserID, session := range m.sessions {
+		if session.UpdatedAt.Before(before) {
+			delete(m.sessions, tgUserID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// sessions as JSONB, so a half-finished wizard survives deploys
+type postgresSessionStore struct {
+	db *store.Store
+}
+
+func newPostgresSessionStore(db *store.Store) *postgresSessionStore {
+	return &postgresSessionStore{db: db}
+}
+
+func (p *postgresSessionStore) Load(ctx context.Context, tgUserID int64) (*UserSession, error) {
+	data, err := p.db.GetSession(ctx, tgUserID)
+	if err != nil {
+		if errors.Is(err, store.ErrSessionNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var session UserSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+	return &session, nil
+}
+
+func (p *postgresSessionStore) Save(ctx context.Context, tgUserID int64, session *UserSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("encode session: %w", err)
+	}
+	return p.db.SaveSession(ctx, tgUserID, data, session.UpdatedAt)
+}
+
+func (p *postgresSessionStore) Delete(ctx context.Context, tgUserID int64) error {
+	return p.db.DeleteSession(ctx, tgUserID)
+}
+
+func (p *postgresSessionStore) DeleteOlderThan(ctx context.Context, before time.Time) (int, error) {
+	deleted, err := p.db.DeleteSessionsBefore(ctx, before)
+	return int(deleted), err
+}
@@ -1,16 +1,19 @@
 package telegram_bot
 
 import (
+	"context"
 	"sync"
 	"time"
 
+	"gitlab.com/avolkov/wood_post/internal/fsm"
 	"gitlab.com/avolkov/wood_post/pkg/log"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
 // setState updates the user's session state and refreshes session timestamp.
 
-// save user's state
+// save user's state. the session belongs to the dispatcher worker of its user,
+// handlers change fields directly, other goroutines go through SessionManager
 type UserSession struct {
 	State                 string
 	TempPortfolioName     string
@@ -18,26 +21,33 @@ type UserSession struct {
 	BotMessageID          int
 	UpdatedAt             time.Time
 	TempTransaction       t.TempTransactionData
+	EditField             string // field of TempTransaction.ID being edited
+	History               historyState
+	SelectedTag           t.Tag
+	ReportTag             string // reports use only transactions with this tag, empty for all
+	Import                importState
+	Settings              *t.UserSettings `json:"-"` // loaded once per session, nil until then
+	Flow                  fsm.Position    // position in a wizard, see flows.go
 }
 
-// manage all user's sessions
+// manage all user's sessions, active ones are kept in memory
+// and saved to the store after every update
 type SessionManager struct {
 	sessions map[int64]*UserSession
+	store    SessionStore
 	mu       sync.RWMutex
 }
 
 // create new session manager
-func NewSessionManager() *SessionManager {
+func NewSessionManager(store SessionStore) *SessionManager {
 	return &SessionManager{
 		sessions: make(map[int64]*UserSession),
+		store:    store,
 	}
 }
 
-// return existing session or creates new
+// return existing session or creates new, sm.mu must be held
 func (sm *SessionManager) getOrCreateSession(tgUserID int64) (*UserSession, bool) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	session, exists := sm.sessions[tgUserID]
 	if !exists {
 		session = &UserSession{}
@@ -47,10 +57,21 @@ func (sm *SessionManager) getOrCreateSession(tgUserID int64) (*UserSession, bool
 	return session, exists
 }
 
+// changes the session under the lock, so readers of other goroutines
+// (getState, session cleaner) never see a half-written session
+func (sm *SessionManager) update(tgUserID int64, fn func(session *UserSession)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, _ := sm.getOrCreateSession(tgUserID)
+	fn(session)
+}
+
 // update user state
 func (sm *SessionManager) setState(tgUserID int64, state string) {
-	session, _ := sm.getOrCreateSession(tgUserID)
-	session.State = state
+	sm.update(tgUserID, func(session *UserSession) {
+		session.State = state
+	})
 }
 
 // return user state
@@ -66,6 +87,9 @@ func (sm *SessionManager) getState(tgUserID int64) (string, bool) {
 }
 
 func (sm *SessionManager) setTempField(tgUserID int64, field string, value interface{}) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	session, _ := sm.getOrCreateSession(tgUserID)
 
 	switch field {
@@ -81,15 +105,30 @@ func (sm *SessionManager) setTempField(tgUserID int64, field string, value inter
 		if v, ok := value.(int); ok {
 			session.BotMessageID = v
 		}
-	case "NextAction":
-		if v, ok := value.(int); ok {
-			session.BotMessageID = v
-		}
 	default:
 		log.Errorf("unknown field name: %s", field)
 	}
 }
 
+// cache user's settings in the session
+func (sm *SessionManager) setSettings(tgUserID int64, settings t.UserSettings) {
+	sm.update(tgUserID, func(session *UserSession) {
+		session.Settings = &settings
+	})
+}
+
+// return cached settings, false when they were not loaded yet
+func (sm *SessionManager) getSettings(tgUserID int64) (t.UserSettings, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, exists := sm.sessions[tgUserID]
+	if !exists || session.Settings == nil {
+		return t.UserSettings{}, false
+	}
+	return *session.Settings, true
+}
+
 func (sm *SessionManager) getSessionVars(tgUserID int64) (*UserSession, bool) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -98,6 +137,47 @@ func (sm *SessionManager) getSessionVars(tgUserID int64) (*UserSession, bool) {
 	return session, exists
 }
 
+// return session from memory or from the store, e.g. after restart
+func (sm *SessionManager) restoreSession(ctx context.Context, tgUserID int64) (*UserSession, bool) {
+	if session, exists := sm.getSessionVars(tgUserID); exists {
+		return session, true
+	}
+
+	session, err := sm.store.Load(ctx, tgUserID)
+	if err != nil {
+		log.Error("failed to load session", "error", err, "tg_user_id", tgUserID)
+		return nil, false
+	}
+	if session == nil {
+		return nil, false
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	// another update of the same user could restore it meanwhile
+	if existing, exists := sm.sessions[tgUserID]; exists {
+		return existing, true
+	}
+	sm.sessions[tgUserID] = session
+	return session, true
+}
+
+// save session to the store, cleared session is deleted there too
+func (sm *SessionManager) persistSession(ctx context.Context, tgUserID int64) {
+	session, exists := sm.getSessionVars(tgUserID)
+
+	var err error
+	if exists {
+		err = sm.store.Save(ctx, tgUserID, session)
+	} else {
+		err = sm.store.Delete(ctx, tgUserID)
+	}
+	if err != nil {
+		log.Error("failed to persist session", "error", err, "tg_user_id", tgUserID)
+	}
+}
+
 // delete user session
 func (sm *SessionManager) clearSession(tgUserID int64) {
 	sm.mu.Lock()
@@ -107,21 +187,31 @@ func (sm *SessionManager) clearSession(tgUserID int64) {
 }
 
 // delete sessions, that were not updated more then defined period
-func (sm *SessionManager) cleanOldSessions(timeout time.Duration) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
+func (sm *SessionManager) cleanOldSessions(ctx context.Context, timeout time.Duration) {
 	now := time.Now()
-	deletedCount := 0
 
+	sm.mu.Lock()
+	deletedCount := 0
 	for tgUserID, session := range sm.sessions {
 		if now.Sub(session.UpdatedAt) > timeout {
 			delete(sm.sessions, tgUserID)
 			deletedCount++
 		}
 	}
+	remaining := len(sm.sessions)
+	// the store may be slow, updates shouldn't wait for it
+	sm.mu.Unlock()
 
 	if deletedCount > 0 {
-		log.Infof("cleaned %d old sessions, %d active sessions remaining", deletedCount, len(sm.sessions))
+		log.Infof("cleaned %d old sessions, %d active sessions remaining", deletedCount, remaining)
+	}
+
+	stored, err := sm.store.DeleteOlderThan(ctx, now.Add(-timeout))
+	if err != nil {
+		log.Error("failed to clean stored sessions", "error", err)
+		return
+	}
+	if stored > 0 {
+		log.Infof("cleaned %d stored sessions", stored)
 	}
 }
@@ -0,0 +1,129 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// workers of different users, the session cleaner and readers run at the same time,
+// run with -race
+func TestSessionManagerConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	sm := NewSessionManager(newMemorySessionStore())
+
+	const (
+		users   = 8
+		updates = 200
+	)
+
+	var wg sync.WaitGroup
+	for user := int64(1); user <= users; user++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				// like handleUpdate: the saved session comes back after it was cleaned
+				sm.restoreSession(ctx, user)
+				sm.setState(user, fmt.Sprintf("state_%d", i))
+				sm.setTempField(user, "BotMessageID", i)
+				sm.setSettings(user, types.UserSettings{})
+				sm.update(user, func(session *UserSession) {
+					session.ReportTag = "dca"
+				})
+				sm.persistSession(ctx, user)
+
+				if i%50 == 0 {
+					sm.clearSession(user)
+					sm.persistSession(ctx, user)
+				}
+			}
+		}()
+	}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sm.cleanOldSessions(ctx, time.Millisecond)
+			}
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				for user := int64(1); user <= users; user++ {
+					sm.getState(user)
+					sm.getSettings(user)
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	background.Wait()
+}
+
+// store which blocks DeleteOlderThan until released
+type blockingSessionStore struct {
+	*memorySessionStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSessionStore) DeleteOlderThan(ctx context.Context, before time.Time) (int, error) {
+	close(b.entered)
+	<-b.release
+	return b.memorySessionStore.DeleteOlderThan(ctx, before)
+}
+
+func TestCleanOldSessionsDoesNotBlockUpdates(t *testing.T) {
+	st := &blockingSessionStore{
+		memorySessionStore: newMemorySessionStore(),
+		entered:            make(chan struct{}),
+		release:            make(chan struct{}),
+	}
+	sm := NewSessionManager(st)
+	sm.setState(1, "old")
+
+	cleaned := make(chan struct{})
+	go func() {
+		defer close(cleaned)
+		sm.cleanOldSessions(context.Background(), time.Hour)
+	}()
+	<-st.entered
+
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		sm.setState(2, "new")
+	}()
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("session update waited for the session store")
+	}
+
+	close(st.release)
+	<-cleaned
+
+	if state, ok := sm.getState(2); !ok || state != "new" {
+		t.Errorf("got state %q, %v, want \"new\", true", state, ok)
+	}
+}
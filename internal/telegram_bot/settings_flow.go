@@ -0,0 +1,337 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/costbasis"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+// loads user's settings into the session once, later updates read the cached copy
+func (s *Service) loadUserSettings(ctx context.Context, tgUserID int64) {
+	if _, ok := s.sessions.getSettings(tgUserID); ok {
+		return
+	}
+
+	settings, err := s.store.GetUserSettingsByTelegramID(ctx, tgUserID)
+	if err != nil {
+		log.Error("Failed to load user settings", "error", err, "tg_user_id", tgUserID)
+		return
+	}
+	s.sessions.setSettings(tgUserID, settings)
+}
+
+// cached settings of the user, defaults when they are not loaded
+func (s *Service) userSettings(tgUserID int64) t.UserSettings {
+	if settings, ok := s.sessions.getSettings(tgUserID); ok {
+		return settings
+	}
+	return store.DefaultUserSettings
+}
+
+// how long regular bot messages stay in chat, 0 keeps them
+func (s *Service) messageTTL(tgUserID int64) time.Duration {
+	return s.userSettings(tgUserID).MessageTTL
+}
+
+func (s *Service) formatDate(tgUserID int64, date time.Time) string {
+	return s.userSettings(tgUserID).FormatDate(date)
+}
+
+// updates cached copy after a setting was saved
+func (s *Service) updateUserSettings(tgUserID int64, update func(*t.UserSettings)) {
+	settings := s.userSettings(tgUserID)
+	update(&settings)
+	s.sessions.setSettings(tgUserID, settings)
+}
+
+func (s *Service) gfSettingsMain(chatID, tgUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	// leave the typed timezone input if user came back without it
+	s.sessions.setState(tgUserID, "main_menu")
+
+	return s.sendScreen(chatID, tgUserID, s.settingsPrompt(tgUserID))
+}
+
+func (s *Service) settingsPrompt(tgUserID int64) fsm.Prompt {
+	settings := s.userSettings(tgUserID)
+
+	method, err := costbasis.ParseMethod(settings.CostBasisMethod)
+	if err != nil {
+		method = costbasis.FIFO
+	}
+
+	language := settings.Language
+	for _, l := range t.Languages {
+		if l.Code == settings.Language {
+			language = l.Name
+		}
+	}
+
+	text := fmt.Sprintf("*Settings*\n\n"+
+		"💱 Reporting currency: `%s`\n"+
+		"🌍 Timezone: `%s`\n"+
+		"📅 Date format: `%s`\n"+
+		"🧮 Cost basis: `%s`\n"+
+		"🗣 Language: `%s`\n"+
+		"⏱ Delete messages after: `%s`",
+		settings.ReportingCurrency,
+		settings.Timezone,
+		settings.DateFormat,
+		method.Title(),
+		language,
+		t.FormatMessageTTL(settings.MessageTTL),
+	)
+
+	actions := []t.Actiontype{
+		{TgText: "Reporting currency", CallBackName: "gf_settings_currency"},
+		{TgText: "Timezone", CallBackName: "gf_settings_timezone"},
+		{TgText: "Date format", CallBackName: "gf_settings_date_format"},
+		{TgText: "Cost basis method", CallBackName: "gf_settings_cost_basis"},
+		{TgText: "Language", CallBackName: "gf_settings_language"},
+		{TgText: "Message auto-delete", CallBackName: "gf_settings_ttl"},
+		{TgText: "Back to main menu", CallBackName: "cancel_action"},
+	}
+
+	var rows [][]fsm.Button
+	for _, a := range actions {
+		rows = append(rows, []fsm.Button{{Text: a.TgText, Action: a.CallBackName}})
+	}
+
+	return fsm.Prompt{Text: text, Markdown: true, Buttons: rows}
+}
+
+// one value of a setting, Value is passed as the callback argument
+type settingOption struct {
+	Text  string
+	Value string
+}
+
+// sends a list of options, current one is marked
+func (s *Service) sendSettingOptions(
+	chatID, tgUserID int64,
+	BotMsgID int,
+	text, action string,
+	options []settingOption,
+	current string,
+) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	return s.sendScreen(chatID, tgUserID, settingOptionsPrompt(text, action, options, current))
+}
+
+func settingOptionsPrompt(text, action string, options []settingOption, current string) fsm.Prompt {
+	var rows [][]fsm.Button
+	for _, o := range options {
+		label := o.Text
+		if o.Value == current {
+			label = "✅ " + label
+		}
+		rows = append(rows, []fsm.Button{{Text: label, Action: action, Arg: o.Value}})
+	}
+	rows = append(rows, []fsm.Button{{Text: "Back to Settings", Action: "gf_settings"}})
+
+	return fsm.Prompt{Text: text, Markdown: true, Buttons: rows}
+}
+
+func (s *Service) askReportingCurrency(chatID, tgUserID int64, BotMsgID int) error {
+	var options []settingOption
+	for _, code := range t.ReportingCurrencies {
+		options = append(options, settingOption{Text: code, Value: code})
+	}
+
+	return s.sendSettingOptions(chatID, tgUserID, BotMsgID,
+		"*Reporting currency*\n\n"+
+			"Report totals are converted from USD at the current rate, the chart and tax reports use the rate of each day.",
+		"set_cur", options, s.userSettings(tgUserID).ReportingCurrency)
+}
+
+func (s *Service) reportingCurrencyChosen(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, code string) error {
+	if !slices.Contains(t.ReportingCurrencies, code) {
+		return fmt.Errorf("unknown reporting currency: %s", code)
+	}
+
+	if err := s.store.SetReportingCurrency(ctx, dbUserID, code); err != nil {
+		return err
+	}
+	s.updateUserSettings(tgUserID, func(us *t.UserSettings) { us.ReportingCurrency = code })
+
+	return s.gfSettingsMain(chatID, tgUserID, BotMsgID)
+}
+
+func (s *Service) askCostBasisMethod(chatID, tgUserID int64, BotMsgID int) error {
+	var options []settingOption
+	for _, m := range costbasis.Methods {
+		options = append(options, settingOption{Text: m.Title(), Value: string(m)})
+	}
+
+	return s.sendSettingOptions(chatID, tgUserID, BotMsgID,
+		"*Cost basis method*\n\n"+
+			"Defines which buys are matched against your sells:\n"+
+			"• *FIFO* - oldest coins are sold first\n"+
+			"• *LIFO* - newest coins are sold first\n"+
+			"• *Weighted average* - every coin costs the average price",
+		"set_cb", options, s.userSettings(tgUserID).CostBasisMethod)
+}
+
+func (s *Service) costBasisMethodChosen(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, value string) error {
+	method, err := costbasis.ParseMethod(value)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.SetCostBasisMethod(ctx, dbUserID, string(method)); err != nil {
+		return err
+	}
+	s.updateUserSettings(tgUserID, func(us *t.UserSettings) { us.CostBasisMethod = string(method) })
+
+	return s.gfSettingsMain(chatID, tgUserID, BotMsgID)
+}
+
+// timezone can be typed, so it's a flow: zone -> settings screen
+func (s *Service) timezoneFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("settings_timezone",
+		flowState{
+			Name:   "waiting_settings_timezone",
+			Prompt: s.promptTimezone,
+			Inputs: []flowInput{
+				{Action: "set_tz", Next: []string{"settings"}, Handle: s.timezoneChosen},
+				{Next: []string{"settings"}, Handle: s.timezoneChosen},
+			},
+		},
+		flowState{
+			Name: "settings",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return s.settingsPrompt(env.TgUserID), nil
+			},
+		},
+	)
+}
+
+func (s *Service) promptTimezone(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	var options []settingOption
+	for _, tz := range t.Timezones {
+		options = append(options, settingOption{Text: tz, Value: tz})
+	}
+
+	return settingOptionsPrompt(
+		"*Timezone*\n\n"+
+			"Used for \"today\" when adding transactions and for report times.\n"+
+			"Choose one below or type any IANA name (e.g. `Asia/Kolkata`).",
+		"set_tz", options, s.userSettings(env.TgUserID).Timezone), nil
+}
+
+// value is either the button argument or typed zone name
+func (s *Service) timezoneChosen(ctx context.Context, env *flowEnv, value string) (string, error) {
+	name := strings.TrimSpace(value)
+
+	// LoadLocation treats "" and "Local" as valid, neither means anything to the user
+	if _, err := time.LoadLocation(name); err != nil || name == "" || name == "Local" {
+		return "", fsm.Invalid(fmt.Sprintf("Unknown timezone %q. Use a name like Europe/Paris or America/Sao_Paulo.", name))
+	}
+
+	if err := s.store.SetTimezone(ctx, env.DBUserID, name); err != nil {
+		return "", err
+	}
+	s.updateUserSettings(env.TgUserID, func(us *t.UserSettings) { us.Timezone = name })
+
+	return "settings", nil
+}
+
+func (s *Service) askDateFormat(chatID, tgUserID int64, BotMsgID int) error {
+	example := time.Date(2025, time.June, 15, 0, 0, 0, 0, time.UTC)
+
+	var options []settingOption
+	for _, f := range t.DateFormats {
+		options = append(options, settingOption{
+			Text:  fmt.Sprintf("%s (%s)", f.Name, example.Format(f.Layout)),
+			Value: f.Name,
+		})
+	}
+
+	return s.sendSettingOptions(chatID, tgUserID, BotMsgID,
+		"*Date format*\n\n"+
+			"Used to show dates and to read the dates you type. YYYY-MM-DD is always accepted.",
+		"set_df", options, s.userSettings(tgUserID).DateFormat)
+}
+
+func (s *Service) dateFormatChosen(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, name string) error {
+	if !slices.ContainsFunc(t.DateFormats, func(f t.DateFormat) bool { return f.Name == name }) {
+		return fmt.Errorf("unknown date format: %s", name)
+	}
+
+	if err := s.store.SetDateFormat(ctx, dbUserID, name); err != nil {
+		return err
+	}
+	s.updateUserSettings(tgUserID, func(us *t.UserSettings) { us.DateFormat = name })
+
+	return s.gfSettingsMain(chatID, tgUserID, BotMsgID)
+}
+
+func (s *Service) askLanguage(chatID, tgUserID int64, BotMsgID int) error {
+	var options []settingOption
+	for _, l := range t.Languages {
+		options = append(options, settingOption{Text: l.Name, Value: l.Code})
+	}
+
+	return s.sendSettingOptions(chatID, tgUserID, BotMsgID,
+		"*Language*\n\nMore languages are coming.",
+		"set_lang", options, s.userSettings(tgUserID).Language)
+}
+
+func (s *Service) languageChosen(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, code string) error {
+	if !slices.ContainsFunc(t.Languages, func(l t.Language) bool { return l.Code == code }) {
+		return fmt.Errorf("unknown language: %s", code)
+	}
+
+	if err := s.store.SetLanguage(ctx, dbUserID, code); err != nil {
+		return err
+	}
+	s.updateUserSettings(tgUserID, func(us *t.UserSettings) { us.Language = code })
+
+	return s.gfSettingsMain(chatID, tgUserID, BotMsgID)
+}
+
+func (s *Service) askMessageTTL(chatID, tgUserID int64, BotMsgID int) error {
+	var options []settingOption
+	for _, ttl := range t.MessageTTLs {
+		options = append(options, settingOption{
+			Text:  t.FormatMessageTTL(ttl),
+			Value: strconv.Itoa(int(ttl.Seconds())),
+		})
+	}
+
+	return s.sendSettingOptions(chatID, tgUserID, BotMsgID,
+		"*Message auto-delete*\n\n"+
+			"How long menus, reports and notifications stay in chat.",
+		"set_ttl", options, strconv.Itoa(int(s.messageTTL(tgUserID).Seconds())))
+}
+
+func (s *Service) messageTTLChosen(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, value string) error {
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("unknown message ttl: %s", value)
+	}
+
+	ttl := time.Duration(seconds) * time.Second
+	if !slices.Contains(t.MessageTTLs, ttl) {
+		return fmt.Errorf("unknown message ttl: %s", value)
+	}
+
+	if err := s.store.SetMessageTTL(ctx, dbUserID, ttl); err != nil {
+		return err
+	}
+	s.updateUserSettings(tgUserID, func(us *t.UserSettings) { us.MessageTTL = ttl })
+
+	return s.gfSettingsMain(chatID, tgUserID, BotMsgID)
+}
@@ -0,0 +1,303 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+const maxTagsPerTransaction = 10
+
+var (
+	// tags are marked with # inside of a note, e.g. "monthly buy #DCA #ledger"
+	noteTagRe = regexp.MustCompile(`#([\p{L}\p{N}_-]{1,32})`)
+	tagNameRe = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+)
+
+// splits note text into the note itself and its #tags
+func splitNoteAndTags(text string) (string, []string) {
+	var tags []string
+	for _, m := range noteTagRe.FindAllStringSubmatch(text, -1) {
+		tags = append(tags, m[1])
+	}
+
+	note := strings.Join(strings.Fields(noteTagRe.ReplaceAllString(text, "")), " ")
+	return note, uniqueTags(tags)
+}
+
+// parses tags separated by spaces or commas, # is optional. Returns a message for the user on error
+func parseTagList(text string) ([]string, string) {
+	var tags []string
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name := strings.TrimPrefix(field, "#")
+		if !tagNameRe.MatchString(name) {
+			return nil, fmt.Sprintf("Wrong tag `%s`. Use up to 32 letters, digits, _ or -.", field)
+		}
+		tags = append(tags, name)
+	}
+
+	tags = uniqueTags(tags)
+	if len(tags) > maxTagsPerTransaction {
+		return nil, fmt.Sprintf("Too many tags, maximum %d per transaction.", maxTagsPerTransaction)
+	}
+	return tags, ""
+}
+
+// drops repeated tags, the first spelling wins
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]struct{})
+	var result []string
+	for _, tag := range tags {
+		key := strings.ToLower(tag)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, tag)
+	}
+	return result
+}
+
+// returns name of the user's tag, empty for 0 and unknown ids
+func (s *Service) tagNameByID(ctx context.Context, dbUserID, tagID int64) (string, error) {
+	if tagID == 0 {
+		return "", nil
+	}
+
+	tags, err := s.store.GetTags(ctx, dbUserID)
+	if err != nil {
+		return "", fmt.Errorf("get tags: %w", err)
+	}
+
+	for _, tag := range tags {
+		if tag.ID == tagID {
+			return tag.Name, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *Service) gfTagsMain(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	prompt, err := s.tagsPrompt(ctx, dbUserID)
+	if err != nil {
+		return err
+	}
+	return s.sendScreen(chatID, tgUserID, prompt)
+}
+
+func (s *Service) tagsPrompt(ctx context.Context, dbUserID int64) (fsm.Prompt, error) {
+	tags, err := s.store.GetTags(ctx, dbUserID)
+	if err != nil {
+		return fsm.Prompt{}, fmt.Errorf("get tags: %w", err)
+	}
+
+	text := "*Your tags*\n\nChoose a tag to rename or delete it."
+	if len(tags) == 0 {
+		text = "*Your tags*\n\nYou have no tags yet. Add them with # in the transaction note, e.g. `#DCA`."
+	}
+
+	var rows [][]fsm.Button
+	for _, tag := range tags {
+		rows = append(rows, []fsm.Button{{
+			Text:   fmt.Sprintf("%s (%d)", tag.Name, tag.Count),
+			Action: "tag_pick",
+			Arg:    strconv.FormatInt(tag.ID, 10),
+		}})
+	}
+	rows = append(rows, []fsm.Button{{Text: "Back to transactions menu", Action: "gf_transactions_main"}})
+
+	return fsm.Prompt{Text: text, Markdown: true, Buttons: rows}, nil
+}
+
+func (s *Service) showTagActions(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	BotMsgID int,
+	tagID int64,
+	selected *t.Tag,
+) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	tags, err := s.store.GetTags(ctx, dbUserID)
+	if err != nil {
+		return fmt.Errorf("get tags: %w", err)
+	}
+
+	*selected = t.Tag{}
+	for _, tag := range tags {
+		if tag.ID == tagID {
+			*selected = tag
+		}
+	}
+
+	if selected.ID == 0 {
+		return s.sendTagNotFound(chatID, tgUserID)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"🏷 *%s*\nUsed in %d transaction(s).", selected.Name, selected.Count))
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Show transactions", "tag_history"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Rename", "tag_rename"),
+			s.button("Delete", "tag_delete"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Back", "gf_tags"),
+		),
+	)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+// rename: new name -> tags list, the tag is picked on the tags screen before
+func (s *Service) tagRenameFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("tag_rename",
+		flowState{
+			Name: "waiting_tag_name",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{
+					Text:     fmt.Sprintf("Enter a new name for the tag *%s*:", env.Session.SelectedTag.Name),
+					Markdown: true,
+					Buttons:  [][]fsm.Button{{{Text: "Back to tags", Action: "gf_tags"}}},
+				}, nil
+			},
+			Inputs: []flowInput{
+				{Next: []string{"tag_renamed", "tag_missing"}, Handle: s.tagNameEntered},
+			},
+		},
+		flowState{
+			Name: "tag_renamed",
+			Prompt: func(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return s.tagsPrompt(ctx, env.DBUserID)
+			},
+		},
+		flowState{
+			Name: "tag_missing",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return tagNotFoundPrompt, nil
+			},
+		},
+	)
+}
+
+func (s *Service) tagNameEntered(ctx context.Context, env *flowEnv, msgText string) (string, error) {
+	selected := env.Session.SelectedTag
+
+	names, errText := parseTagList(msgText)
+	if errText == "" && len(names) != 1 {
+		errText = "Enter exactly one tag name."
+	}
+	if errText != "" {
+		return "", fsm.Invalid(errText)
+	}
+
+	err := s.store.RenameTag(ctx, env.DBUserID, selected.ID, names[0])
+	switch {
+	case errors.Is(err, store.ErrTagNotFound):
+		return "tag_missing", nil
+	case errors.Is(err, store.ErrTagExists):
+		return "", fsm.Invalid(fmt.Sprintf("You already have a tag named %s.", names[0]))
+	case err != nil:
+		return "", err
+	}
+
+	log.Info("tag renamed", "user_id", env.DBUserID, "tag_id", selected.ID)
+
+	return "tag_renamed", nil
+}
+
+func (s *Service) askTagDeleteConfirmation(chatID, tgUserID int64, BotMsgID int, selected t.Tag) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"Delete the tag *%s*? It will be removed from %d transaction(s), the transactions stay.",
+		selected.Name, selected.Count))
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Delete", "tag_delete_confirmed"),
+			s.button("Back", "gf_tags"),
+		),
+	)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) tagDeleteConfirmed(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, selected t.Tag) error {
+	err := s.store.DeleteTag(ctx, dbUserID, selected.ID)
+	if errors.Is(err, store.ErrTagNotFound) {
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+		return s.sendTagNotFound(chatID, tgUserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info("tag deleted", "user_id", dbUserID, "tag_id", selected.ID)
+
+	return s.gfTagsMain(ctx, chatID, tgUserID, dbUserID, BotMsgID)
+}
+
+var tagNotFoundPrompt = fsm.Prompt{
+	Text:    "❌ Tag not found.",
+	Buttons: [][]fsm.Button{{{Text: "Back to tags", Action: "gf_tags"}}},
+}
+
+func (s *Service) sendTagNotFound(chatID, tgUserID int64) error {
+	return s.sendScreen(chatID, tgUserID, tagNotFoundPrompt)
+}
+
+// reports can be limited to the transactions with one tag, the choice lives in the session
+func (s *Service) askReportTag(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	tags, err := s.store.GetTags(ctx, dbUserID)
+	if err != nil {
+		return fmt.Errorf("get tags: %w", err)
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(s.button("All transactions", "report_tag", "0")),
+	}
+	for _, tag := range tags {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			s.button(tag.Name, "report_tag", strconv.FormatInt(tag.ID, 10)),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Build reports from transactions with tag:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) setReportTag(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	BotMsgID int,
+	tagID int64,
+	reportTag *string,
+) error {
+	var err error
+	*reportTag, err = s.tagNameByID(ctx, dbUserID, tagID)
+	if err != nil {
+		return err
+	}
+
+	return s.gfReportsMain(chatID, tgUserID, BotMsgID, *reportTag)
+}
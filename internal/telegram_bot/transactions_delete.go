@@ -2,93 +2,52 @@ package telegram_bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
-	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
 )
 
-func (s *Service) gfTransactionsDelete(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
-	var tx []t.Transaction
-
-	tx, err := s.store.GetLast5TransactionsForUser(ctx, dbUserID)
-	if err != nil {
-		return fmt.Errorf("get last 5 transactions: %w", err)
-	}
-
-	if len(tx) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "You have no transactions yet. Let's add your first one!")
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Add transaction", "gf_add_transaction"),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back to main menu", "cancel_action"),
-			),
-		)
-		return s.sendTemporaryMessage(msg, tgUserID, 30*time.Second)
-	}
-
-	var rows [][]tgbotapi.InlineKeyboardButton
-	for _, t := range tx {
-		var typeEmoji string
-		switch strings.ToLower(t.Type) {
-		case "buy":
-			typeEmoji = "🟢"
-		case "sell":
-			typeEmoji = "🔴"
-		default:
-			typeEmoji = "🔵"
-		}
-
-		// FIXME: add tx id to callback and use it in gfDeleteTransactionConfirmed
-		txText := fmt.Sprintf("%s%s | %.8g %s | %.2f usd", typeEmoji, t.Type, t.AssetAmount, t.Asset, t.USDAmount)
-		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(txText, "gf_delete_transaction_confirmation_"+strconv.FormatInt(t.ID, 10)),
-		))
-	}
-
-	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
-	))
-
-	msg := tgbotapi.NewMessage(chatID, "Select a transaction that you want to delete:")
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
-
-	return s.sendTemporaryMessage(msg, tgUserID, 30*time.Second)
-}
-
 func (s *Service) gfDeleteTransactionConfirmation(
-	chatID, tgUserID int64,
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
 	BotMsgID int,
-	cbData string,
+	txID int64,
 	txData *t.TempTransactionData,
 ) error {
 	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
 
-	txID := strings.TrimPrefix(cbData, "gf_delete_transaction_confirmation_")
-	txIDInt, err := strconv.ParseInt(txID, 10, 64)
+	// id comes from callback data, so it's checked against the user before anything else
+	tx, err := s.store.GetTransactionByID(ctx, dbUserID, txID)
+	if errors.Is(err, store.ErrTransactionNotFound) {
+		return s.sendTransactionNotFound(chatID, tgUserID)
+	}
 	if err != nil {
-		return fmt.Errorf("parse tx id: %w", err)
+		return fmt.Errorf("get transaction: %w", err)
 	}
 
-	txData.ID = txIDInt
+	txData.ID = tx.ID
+
+	text := fmt.Sprintf("Are you sure you want to delete this transaction?\n\n%s %s | %v %s | %.2f usd | %s",
+		txTypeEmoji(tx.Type), strings.ToLower(txTypeLabel(tx)), tx.AssetAmount, tx.Asset, tx.USDAmount,
+		s.formatDate(tgUserID, tx.TransactionDate))
+	if tx.Type == "transfer" {
+		text += "\n\nBoth sides of the transfer will be deleted."
+	}
 
-	msg := tgbotapi.NewMessage(chatID, "Are you sure you want to delete this transaction?")
+	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Yes, delete", "gf_delete_transaction_confirmed"),
-			tgbotapi.NewInlineKeyboardButtonData("Back", "gf_delete_transaction"),
+			s.button("Yes, delete", "gf_delete_transaction_confirmed"),
+			s.button("Back to history", "hist_show"),
 		),
 	)
 
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 }
 
 func (s *Service) gfDeleteTransactionConfirmed(
@@ -97,6 +56,10 @@ func (s *Service) gfDeleteTransactionConfirmed(
 	BotMsgID int,
 ) error {
 	err := s.store.DeleteTransaction(ctx, dbUserID, txID)
+	if errors.Is(err, store.ErrTransactionNotFound) {
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+		return s.sendTransactionNotFound(chatID, tgUserID)
+	}
 	if err != nil {
 		return fmt.Errorf("delete transaction: %w", err)
 	}
@@ -109,3 +72,12 @@ func (s *Service) gfDeleteTransactionConfirmed(
 	}
 	return s.showMainMenu(chatID, tgUserID)
 }
+
+var transactionNotFoundPrompt = fsm.Prompt{
+	Text:    "❌ Transaction not found. It may have been deleted already.",
+	Buttons: [][]fsm.Button{{{Text: "Back to transactions menu", Action: "gf_transactions_main"}}},
+}
+
+func (s *Service) sendTransactionNotFound(chatID, tgUserID int64) error {
+	return s.sendScreen(chatID, tgUserID, transactionNotFoundPrompt)
+}
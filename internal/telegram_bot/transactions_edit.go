@@ -0,0 +1,323 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+const maxNoteLength = 500
+
+// sent as the tx_edit_field argument
+var editFields = []settingOption{
+	{Text: "Asset", Value: "asset"},
+	{Text: "Amount", Value: "amount"},
+	{Text: "Price", Value: "price"},
+	{Text: "Date", Value: "date"},
+	{Text: "Type", Value: "type"},
+	{Text: "Portfolio", Value: "portfolio"},
+	{Text: "Note", Value: "note"},
+	{Text: "Tags", Value: "tags"},
+}
+
+// type can only change within the same kind, fiat flows have no asset to hold
+var (
+	editableCryptoTypes = []string{"buy", "sell", "income", "airdrop", "gift"}
+	editableFiatTypes   = []string{"deposit", "withdrawal"}
+)
+
+// edit: field -> value -> field ..., every value is saved right away
+func (s *Service) transactionEditFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("transaction_edit",
+		flowState{
+			Name:   "waiting_transaction_edit_field",
+			Prompt: s.promptEditFields,
+			Inputs: []flowInput{
+				{Action: "tx_edit_field", Next: []string{"waiting_transaction_edit_value"}, Handle: editFieldChosen},
+				{
+					Action: "tx_edit_done",
+					Next:   []string{"transaction_edit_done"},
+					Handle: func(context.Context, *flowEnv, string) (string, error) { return "transaction_edit_done", nil },
+				},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_edit_value",
+			Prompt: s.promptEditValue,
+			Inputs: []flowInput{
+				{Action: "tx_edit_set", Next: []string{"waiting_transaction_edit_field", "transaction_edit_missing"}, Handle: s.editValueEntered},
+				{Next: []string{"waiting_transaction_edit_field", "transaction_edit_missing"}, Handle: s.editValueEntered},
+			},
+		},
+		flowState{
+			Name: "transaction_edit_done",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{
+					Text: "✅ Changes are saved.",
+					Buttons: [][]fsm.Button{
+						{{Text: "Transaction history", Action: "gf_history"}},
+						{{Text: "Back to transactions menu", Action: "gf_transactions_main"}},
+					},
+				}, nil
+			},
+		},
+		flowState{
+			Name: "transaction_edit_missing",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return transactionNotFoundPrompt, nil
+			},
+		},
+	)
+}
+
+// id comes from callback data, so the transaction is loaded for the user before the flow starts
+func (s *Service) startTransactionEdit(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	sv *UserSession,
+	txID int64,
+) error {
+	tx, err := s.store.GetTransactionByID(ctx, dbUserID, txID)
+	if errors.Is(err, store.ErrTransactionNotFound) {
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, sv.BotMessageID))
+		return s.sendTransactionNotFound(chatID, tgUserID)
+	}
+	if err != nil {
+		return fmt.Errorf("get transaction: %w", err)
+	}
+
+	if tx.Type == "transfer" {
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, sv.BotMessageID))
+		return s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID, "❌ Transfers can't be edited, delete it and add it again."),
+			tgUserID, s.messageTTL(tgUserID))
+	}
+
+	sv.TempTransaction = editData(tx)
+	return s.startFlow(ctx, chatID, tgUserID, dbUserID, sv, "transaction_edit")
+}
+
+// edit flow keeps only what it needs to validate new values and reprice date changes
+func editData(tx t.Transaction) t.TempTransactionData {
+	return t.TempTransactionData{
+		ID:              tx.ID,
+		Type:            tx.Type,
+		Asset:           tx.Asset,
+		QuoteCurrency:   tx.QuoteCurrency,
+		QuotePrice:      tx.QuotePrice,
+		TransactionDate: tx.TransactionDate,
+	}
+}
+
+// shows the saved transaction, so every change is visible right after it's made
+func (s *Service) promptEditFields(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	tx, err := s.store.GetTransactionByID(ctx, env.DBUserID, env.tx().ID)
+	if err != nil {
+		return fsm.Prompt{}, fmt.Errorf("get transaction: %w", err)
+	}
+	*env.tx() = editData(tx)
+
+	text := fmt.Sprintf(
+		"*Editing transaction:*\n\n"+
+			"%s *%s %s*\n"+
+			"Portfolio: `%s`\n"+
+			"Amount: `%v %s`\n"+
+			"Price: `%s`\n"+
+			"Total: `$%.2f`\n"+
+			"Date: `%s`\n",
+		txTypeEmoji(tx.Type),
+		txTypeLabel(tx),
+		tx.Asset,
+		tx.PortfolioName,
+		tx.AssetAmount,
+		tx.Asset,
+		formatTxPrice(tx.QuoteCurrency, tx.QuotePrice, tx.AssetPrice),
+		tx.USDAmount,
+		s.formatDate(env.TgUserID, tx.TransactionDate),
+	)
+	if tx.Note != "" {
+		text += fmt.Sprintf("📝 Note: `%s`\n", tx.Note)
+	}
+	if len(tx.Tags) > 0 {
+		text += fmt.Sprintf("🏷 Tags: `%s`\n", strings.Join(tx.Tags, ", "))
+	}
+	text += "\nChoose a field to change:"
+
+	var rows [][]fsm.Button
+	for i := 0; i < len(editFields); i += 2 {
+		row := []fsm.Button{{Text: editFields[i].Text, Action: "tx_edit_field", Arg: editFields[i].Value}}
+		if i+1 < len(editFields) {
+			row = append(row, fsm.Button{Text: editFields[i+1].Text, Action: "tx_edit_field", Arg: editFields[i+1].Value})
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, []fsm.Button{{Text: "Done", Action: "tx_edit_done"}})
+
+	return fsm.Prompt{Text: text, Markdown: true, Buttons: rows}, nil
+}
+
+func editFieldChosen(_ context.Context, env *flowEnv, field string) (string, error) {
+	if !slices.ContainsFunc(editFields, func(o settingOption) bool { return o.Value == field }) {
+		return "", fmt.Errorf("unknown edit field: %s", field)
+	}
+
+	env.Session.EditField = field
+	return "waiting_transaction_edit_value", nil
+}
+
+func (s *Service) promptEditValue(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
+
+	var text string
+	var rows [][]fsm.Button
+
+	switch env.Session.EditField {
+	case "asset":
+		text = "Enter the new asset ticker (e.g. BTC, eth, DoGe)."
+	case "amount":
+		text = "Enter the new asset amount (e.g. 1234, 12.34)."
+	case "price":
+		text = "Enter the new asset price (e.g. `15500` for USD, `14000 EUR`, `0.05 BTC`)."
+	case "date":
+		settings := s.userSettings(env.TgUserID)
+		text = fmt.Sprintf("Enter the new date in format *%s* (e.g. %s).",
+			settings.DateFormat, settings.FormatDate(settings.Now()))
+	case "note":
+		text = fmt.Sprintf("Enter the new note (up to %d characters).", maxNoteLength)
+	case "tags":
+		text = "Enter the tags separated by spaces (e.g. `#DCA #ledger`), they replace the current ones."
+		rows = append(rows, []fsm.Button{{Text: "Remove all tags", Action: "tx_edit_set"}})
+	case "type":
+		text = "Choose the new transaction type:"
+		types := editableCryptoTypes
+		if t.IsFiatFlow(txData.Type) {
+			types = editableFiatTypes
+		}
+		for _, txType := range types {
+			if txType == txData.Type {
+				continue
+			}
+			rows = append(rows, []fsm.Button{{Text: txTypeEmoji(txType) + " " + txType, Action: "tx_edit_set", Arg: txType}})
+		}
+	case "portfolio":
+		text = "Choose the portfolio to move the transaction to:"
+		holdings, err := s.store.GetPortfolioHoldings(ctx, env.DBUserID, txData.Asset)
+		if err != nil {
+			return fsm.Prompt{}, fmt.Errorf("get portfolio holdings: %w", err)
+		}
+		for _, h := range holdings {
+			rows = append(rows, []fsm.Button{{Text: h.Name, Action: "tx_edit_set", Arg: strconv.FormatInt(h.PortfolioID, 10)}})
+		}
+	default:
+		return fsm.Prompt{}, fmt.Errorf("unknown edit field: %s", env.Session.EditField)
+	}
+
+	return fsm.Prompt{Text: text, Markdown: true, Buttons: rows}, nil
+}
+
+// rawValue comes either from a text message or from a tx_edit_set button
+func (s *Service) editValueEntered(ctx context.Context, env *flowEnv, rawValue string) (string, error) {
+	txData := env.tx()
+	field := env.Session.EditField
+
+	value, errText := s.parseEditValue(env.TgUserID, rawValue, field, txData)
+	if errText != "" {
+		return "", fsm.Invalid(errText)
+	}
+
+	// prices are valued in USD with the rate of the transaction date
+	if price, ok := value.(t.QuotedPrice); ok {
+		var err error
+		if value, err = s.quotePrice(ctx, price, txData.TransactionDate); err != nil {
+			return "", fsm.Invalid(fmt.Sprintf("No %s rate known for %s. Enter the price in USD or in another currency.",
+				price.Currency, s.formatDate(env.TgUserID, txData.TransactionDate)))
+		}
+	}
+
+	// the USD value of a trade in another currency follows its date
+	if date, ok := value.(time.Time); ok && !t.IsUSD(txData.QuoteCurrency) {
+		price, err := s.quotePrice(ctx, t.QuotedPrice{Currency: txData.QuoteCurrency, Price: txData.QuotePrice}, date)
+		if err != nil {
+			return "", fsm.Invalid(fmt.Sprintf(
+				"No %s rate known for %s, the USD value of the transaction can't be updated. Choose another date.",
+				txData.QuoteCurrency, s.formatDate(env.TgUserID, date)))
+		}
+		value = t.RepricedDate{Date: date, Price: price}
+	}
+
+	var err error
+	if field == "tags" {
+		err = s.store.SetTransactionTags(ctx, env.DBUserID, txData.ID, value.([]string))
+	} else {
+		err = s.store.UpdateTransaction(ctx, env.DBUserID, txData.ID, field, value)
+	}
+	switch {
+	case errors.Is(err, store.ErrTransactionNotFound):
+		return "transaction_edit_missing", nil
+	case errors.Is(err, store.ErrTransferNotEditable):
+		return "", fsm.Invalid("Transfers can't be edited, delete it and add it again.")
+	case errors.Is(err, store.ErrPortfolioNotFound):
+		return "", fsm.Invalid("Portfolio not found.")
+	case err != nil:
+		return "", err
+	}
+
+	log.Info("transaction updated", "user_id", env.DBUserID, "tx_id", txData.ID, "field", field)
+
+	return "waiting_transaction_edit_field", nil
+}
+
+// returns the value ready for the store or a message for the user
+func (s *Service) parseEditValue(tgUserID int64, rawValue, field string, txData *t.TempTransactionData) (any, string) {
+	switch field {
+	case "asset", "amount", "price", "date":
+		result, err := s.transactionValidateInput(tgUserID, rawValue, field)
+		if err != nil {
+			return nil, result.(string)
+		}
+		return result, ""
+
+	case "type":
+		if !slices.Contains(editableCryptoTypes, rawValue) && !slices.Contains(editableFiatTypes, rawValue) {
+			return nil, "Unknown transaction type."
+		}
+		if t.IsFiatFlow(rawValue) != t.IsFiatFlow(txData.Type) {
+			return nil, "Fiat deposits and withdrawals can't be changed into asset transactions and back."
+		}
+		return rawValue, ""
+
+	case "portfolio":
+		portfolioID, err := strconv.ParseInt(rawValue, 10, 64)
+		if err != nil {
+			return nil, "Unknown portfolio."
+		}
+		return portfolioID, ""
+
+	case "note":
+		note := strings.TrimSpace(rawValue)
+		if utf8.RuneCountInString(note) > maxNoteLength {
+			return nil, fmt.Sprintf("Note is too long, maximum %d characters.", maxNoteLength)
+		}
+		return note, ""
+
+	case "tags":
+		tags, errText := parseTagList(rawValue)
+		if errText != "" {
+			return nil, errText
+		}
+		return tags, ""
+	}
+
+	return nil, "Unknown field."
+}
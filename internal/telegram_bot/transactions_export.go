@@ -0,0 +1,205 @@
+package telegram_bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/exporter"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+// telegram doesn't let bots download bigger files
+const maxRestoreFileSize = 20 << 20
+
+func (s *Service) askExportFormat(chatID, tgUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	msg := tgbotapi.NewMessage(chatID,
+		"*Export all your data*\n\n"+
+			"• *CSV* - a table for spreadsheets, columns match the generic import format\n"+
+			"• *JSON* - a full backup, send it back with /import to restore portfolios and transactions")
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("CSV", "exp", "csv"),
+			s.button("JSON", "exp", "json"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Back to transactions menu", "gf_transactions_main"),
+		),
+	)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) sendExport(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int, formatName string) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	format, err := exporter.ParseFormat(formatName)
+	if err != nil {
+		return err
+	}
+
+	portfolios, err := s.store.GetPortfolios(ctx, dbUserID)
+	if err != nil {
+		return err
+	}
+	if len(portfolios) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "You have no portfolios yet, nothing to export.")
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+	}
+
+	now := time.Now()
+
+	// rows go straight from the database cursor into the upload
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := exporter.NewWriter(format, pw, portfolios, now)
+		if err == nil {
+			err = s.store.StreamTransactionsForUser(ctx, dbUserID, w.Write)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{
+		Name:   fmt.Sprintf("wood_post_export_%s.%s", now.Format("2006-01-02"), format),
+		Reader: pr,
+	})
+	doc.ParseMode = "Markdown"
+	doc.Caption = fmt.Sprintf("📦 *Export*\nPortfolios: `%d`\nFormat: `%s`", len(portfolios), strings.ToUpper(string(format)))
+	if format == exporter.JSON {
+		doc.Caption += "\nSend this file with /import to restore it."
+	}
+
+	// the file is kept in chat, it's the user's backup
+	_, err = s.bot.Send(doc)
+	// stops the writer if the upload failed half way
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		log.Error("failed to send export", "error", err, "user_id", dbUserID, "format", format)
+		msg := tgbotapi.NewMessage(chatID, "❌ Sorry, couldn't export your data. Please try again.")
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+	}
+
+	log.Info("data exported", "user_id", dbUserID, "format", format)
+
+	msg := tgbotapi.NewMessage(chatID, "What would you like to do next?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Another format", "gf_export"),
+			s.button("Back to transactions menu", "gf_transactions_main"),
+		),
+	)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+// reads the JSON export the user sent, the restore is confirmed on its preview
+func (s *Service) restoreFileReceived(ctx context.Context, env *flowEnv) (string, error) {
+	doc, err := s.readExportFile(ctx, &env.Session.Import)
+	var downloadErr *downloadError
+	switch {
+	case errors.As(err, &downloadErr):
+		log.Error("failed to download export file", "error", err, "tg_user_id", env.TgUserID)
+		return "", fsm.Invalid("Sorry, couldn't download the file. Please send it again.")
+	case errors.Is(err, exporter.ErrUnsupportedVersion):
+		return "", fsm.Invalid("This export was made by a newer version of the bot and can't be restored yet.")
+	case errors.Is(err, exporter.ErrTooManyRows):
+		return "", fsm.Invalid(fmt.Sprintf("The export has more than %d transactions, it can't be restored at once.", exporter.MaxRows))
+	case err != nil:
+		log.Warn("failed to read export file", "error", err, "tg_user_id", env.TgUserID)
+		return "", fsm.Invalid("This JSON file is not a valid export of this bot.")
+	}
+
+	env.export = doc
+	return "restore_preview", nil
+}
+
+// the file is read again when the preview is reached with Back
+func (s *Service) promptRestorePreview(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	doc := env.export
+	if doc == nil {
+		var err error
+		if doc, err = s.readExportFile(ctx, &env.Session.Import); err != nil {
+			return fsm.Prompt{}, fmt.Errorf("read export file: %w", err)
+		}
+	}
+
+	var names []string
+	for _, p := range doc.Portfolios {
+		names = append(names, strings.ReplaceAll(p.Name, "`", "'"))
+	}
+
+	return fsm.Prompt{
+		Text: fmt.Sprintf(
+			"*Restore from export*\n"+
+				"Exported: `%s`\n"+
+				"Portfolios: `%s`\n"+
+				"Transactions: `%d`\n\n"+
+				"Missing portfolios are created, transactions you already have are skipped.",
+			doc.ExportedAt.Format("2006-01-02 15:04"),
+			strings.Join(names, ", "),
+			len(doc.Transactions),
+		),
+		Markdown: true,
+		Buttons:  [][]fsm.Button{{{Text: "Restore", Action: "imp_restore"}}},
+	}, nil
+}
+
+func (s *Service) readExportFile(ctx context.Context, is *importState) (*exporter.Document, error) {
+	data, err := s.downloadDocument(ctx, is.FileID, maxRestoreFileSize)
+	if err != nil {
+		return nil, &downloadError{err: err}
+	}
+	return exporter.Read(bytes.NewReader(data))
+}
+
+func (s *Service) restoreConfirmed(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	is := &env.Session.Import
+	if is.FileID == "" || !is.isExport() {
+		return "", fsm.Invalid("Nothing to restore, please send the file again.")
+	}
+
+	// the file was read for the preview already, failing now means it's gone
+	doc, err := s.readExportFile(ctx, is)
+	if err != nil {
+		log.Error("failed to read export file again", "error", err, "tg_user_id", env.TgUserID)
+		return "", fsm.Invalid("Sorry, couldn't read the file again. Please send it again.")
+	}
+
+	created, inserted, skipped, err := s.store.RestoreTransactions(ctx, env.DBUserID,
+		doc.PortfolioList(), doc.TransactionList())
+	if errors.Is(err, store.ErrPortfolioLimitReached) {
+		return "", fsm.Invalid("Restoring would exceed your portfolio limit. Rename or delete a portfolio so the names match the export.")
+	}
+	if errors.Is(err, store.ErrPortfolioNameInvalid) {
+		return "", fsm.Invalid("The export has a portfolio name without latin letters or digits, it can't be restored.")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("export restored", "user_id", env.DBUserID, "portfolios_created", created, "inserted", inserted, "skipped", skipped)
+
+	env.summary = fmt.Sprintf("✅ Restored %d transaction(s).", inserted)
+	if created > 0 {
+		env.summary += fmt.Sprintf("\nCreated %d portfolio(s).", created)
+	}
+	if skipped > 0 {
+		env.summary += fmt.Sprintf("\nSkipped %d already present.", skipped)
+	}
+
+	*is = importState{}
+	return "import_done", nil
+}
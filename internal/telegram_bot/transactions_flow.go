@@ -2,16 +2,20 @@ package telegram_bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/internal/prices"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
 	"gitlab.com/avolkov/wood_post/pkg/log"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
 )
 
 func (s *Service) gfTransactionsMain(chatID, tgUserID int64, BotMsgID int) error {
@@ -19,8 +23,12 @@ func (s *Service) gfTransactionsMain(chatID, tgUserID int64, BotMsgID int) error
 
 	actions := []t.Actiontype{
 		{TgText: "Add transaction", CallBackName: "gf_add_transaction"},
-		{TgText: "Show last 5 added transactions", CallBackName: "gf_show_last_5_transactions"},
+		{TgText: "Transaction history", CallBackName: "gf_history"},
+		{TgText: "Edit transaction", CallBackName: "gf_edit_transaction"},
 		{TgText: "Delete transaction", CallBackName: "gf_delete_transaction"},
+		{TgText: "Tags", CallBackName: "gf_tags"},
+		{TgText: "Import", CallBackName: "gf_import"},
+		{TgText: "Export", CallBackName: "gf_export"},
 		// {TgText: "Change default", CallBackName: "gf_portfolio_change_default"},
 		// {TgText: "Rename", CallBackName: "gf_portfolio_rename"},
 		{TgText: "Back to main menu", CallBackName: "cancel_action"},
@@ -29,7 +37,7 @@ func (s *Service) gfTransactionsMain(chatID, tgUserID int64, BotMsgID int) error
 	var rows [][]tgbotapi.InlineKeyboardButton
 	for _, a := range actions {
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(a.TgText, a.CallBackName),
+			s.button(a.TgText, a.CallBackName),
 		))
 	}
 
@@ -37,327 +45,478 @@ func (s *Service) gfTransactionsMain(chatID, tgUserID int64, BotMsgID int) error
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
 }
 
-func (s *Service) askTransactionType(
+// every transaction type goes through this flow, transfer has its own portfolio steps:
+// type -> asset -> amount -> date -> price -> fee -> note -> confirm
+// type -> asset -> amount -> source portfolio -> destination -> date -> confirm
+func (s *Service) transactionFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("transaction",
+		flowState{
+			Name:   "waiting_transaction_type",
+			Prompt: promptTransactionType,
+			Inputs: []flowInput{
+				{
+					Action: "tx_type",
+					Next:   []string{"waiting_transaction_asset", "waiting_transaction_asset_amount"},
+					Handle: transactionTypeChosen,
+				},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_asset",
+			Prompt: s.promptTransactionAsset,
+			Inputs: []flowInput{
+				{Action: "tx_asset_chosen", Next: []string{"waiting_transaction_asset_amount"}, Handle: s.transactionAssetChosen},
+				{Next: []string{"waiting_transaction_asset_amount"}, Handle: s.transactionAssetChosen},
+			},
+		},
+		flowState{
+			Name: "waiting_transaction_asset_amount",
+			Prompt: func(context.Context, *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{Text: "Enter the asset amount (e.g. 1234, 12.34)."}, nil
+			},
+			Inputs: []flowInput{
+				{Next: []string{"waiting_transaction_date", "waiting_transfer_source"}, Handle: s.transactionAmountEntered},
+			},
+		},
+		flowState{
+			Name:   "waiting_transfer_source",
+			Prompt: s.promptTransferSource,
+			Inputs: []flowInput{
+				{Action: "tr_from", Next: []string{"waiting_transfer_destination"}, Handle: s.transferSourceChosen},
+			},
+		},
+		flowState{
+			Name:   "waiting_transfer_destination",
+			Prompt: s.promptTransferDestination,
+			Inputs: []flowInput{
+				{Action: "tr_to", Next: []string{"waiting_transaction_date"}, Handle: s.transferDestinationChosen},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_date",
+			Prompt: s.promptTransactionDate,
+			Inputs: []flowInput{
+				{
+					Action: "tx_date",
+					Next:   []string{"waiting_transaction_asset_price", "waiting_transaction_note", "waiting_transfer_confirmation"},
+					Handle: s.transactionDateChosen,
+				},
+				{
+					Next:   []string{"waiting_transaction_asset_price", "waiting_transaction_note", "waiting_transfer_confirmation"},
+					Handle: s.transactionDateChosen,
+				},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_asset_price",
+			Prompt: s.promptTransactionPrice,
+			Inputs: []flowInput{
+				{Action: "tx_price_market", Next: []string{"waiting_transaction_fee"}, Handle: s.transactionMarketPriceChosen},
+				{Next: []string{"waiting_transaction_fee"}, Handle: s.transactionPriceEntered},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_fee",
+			Prompt: promptTransactionFee,
+			Inputs: []flowInput{
+				{Action: "tx_fee_none", Next: []string{"waiting_transaction_note"}, Handle: s.transactionFeeEntered},
+				{Next: []string{"waiting_transaction_note"}, Handle: s.transactionFeeEntered},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_note",
+			Prompt: promptTransactionNote,
+			Inputs: []flowInput{
+				{Action: "tx_note_skip", Next: []string{"waiting_transaction_confirmation"}, Handle: s.transactionNoteEntered},
+				{Next: []string{"waiting_transaction_confirmation"}, Handle: s.transactionNoteEntered},
+			},
+		},
+		flowState{
+			Name:   "waiting_transaction_confirmation",
+			Prompt: s.promptTransactionConfirmation,
+			Inputs: []flowInput{
+				{Action: "tx_confirm_transaction", Next: []string{"transaction_added"}, Handle: s.transactionConfirmed},
+			},
+		},
+		flowState{
+			Name:   "waiting_transfer_confirmation",
+			Prompt: s.promptTransferConfirmation,
+			Inputs: []flowInput{
+				{Action: "tr_confirm_transfer", Next: []string{"transfer_added"}, Handle: s.transferConfirmed},
+			},
+		},
+		flowState{
+			Name: "transaction_added",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				tx := env.tx()
+				return fsm.Prompt{
+					Text: fmt.Sprintf("Transaction added successfully: %s, %.2f USD!", tx.Asset, tx.USDAmount),
+					TTL:  10 * time.Second,
+				}, nil
+			},
+		},
+		flowState{
+			Name:   "transfer_added",
+			Prompt: promptTransferAdded,
+		},
+	)
+}
+
+// the flow needs a portfolio to add transactions to
+func (s *Service) startTransactionFlow(
 	ctx context.Context,
 	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
+	sv *UserSession,
 ) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
 	exists, err := s.store.PortfolioExists(ctx, dbUserID)
 	if err != nil {
 		return err
 	}
 
 	if !exists {
+		_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, sv.BotMessageID))
+
 		msg := tgbotapi.NewMessage(chatID, "You have no portfolios yet. Let's create a new one!")
 		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("New portfolio", "create_portfolio"),
+				s.button("New portfolio", "create_portfolio"),
 			),
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back", "cancel_action"),
+				s.button("Back", "cancel_action"),
 			),
 		)
-		return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
-	}
-
-	msg := tgbotapi.NewMessage(chatID,
-		"Choose what type of transaction do you want to add:")
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Buy", "tx_type_buy"),
-			tgbotapi.NewInlineKeyboardButtonData("Sell", "tx_type_sell"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Main menu", "cancel_action"),
-		),
-	)
+		return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+	}
 
-	s.sessions.setState(tgUserID, "waiting_transaction_type")
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return s.startFlow(ctx, chatID, tgUserID, dbUserID, sv, "transaction")
 }
 
-func (s *Service) askTransactionAsset(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	txData *t.TempTransactionData,
-	txType string,
-) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
-	log.Info("raw tx type: ", txType)
-
-	txTypeClean := strings.TrimPrefix(txType, "tx_type_")
-	txData.Type = txTypeClean
+func promptTransactionType(context.Context, *flowEnv) (fsm.Prompt, error) {
+	return fsm.Prompt{
+		Text: "Choose what type of transaction do you want to add:",
+		Buttons: [][]fsm.Button{
+			{{Text: "Buy", Action: "tx_type", Arg: "buy"}, {Text: "Sell", Action: "tx_type", Arg: "sell"}},
+			{{Text: "Transfer", Action: "tx_type", Arg: "transfer"}},
+			{{Text: "Reward / interest", Action: "tx_type", Arg: "income"}, {Text: "Airdrop", Action: "tx_type", Arg: "airdrop"}},
+			{{Text: "Gift received", Action: "tx_type", Arg: "gift"}},
+			{{Text: "Fiat deposit", Action: "tx_type", Arg: "deposit"}, {Text: "Fiat withdrawal", Action: "tx_type", Arg: "withdrawal"}},
+		},
+	}, nil
+}
 
-	log.Info("chosen tx type: ", txTypeClean)
+func transactionTypeChosen(_ context.Context, env *flowEnv, txType string) (string, error) {
+	log.Info("chosen tx type: ", txType)
 
-	msg := tgbotapi.NewMessage(chatID,
-		"Please choose an asset ticker or enter a new one (e.g. BTC, eth, DoGe).")
-	msg.ParseMode = "Markdown"
+	// new flow starts here, nothing from the previous one should leak into it
+	*env.tx() = t.TempTransactionData{Type: txType}
 
-	var topAssets []string
+	// fiat flows are USD only for now, there is no asset to choose
+	if t.IsFiatFlow(txType) {
+		env.tx().Asset = t.FiatUSD
+		return "waiting_transaction_asset_amount", nil
+	}
+	return "waiting_transaction_asset", nil
+}
 
-	topAssets, err := s.store.GetTopAssetsForUser(ctx, dbUserID)
+func (s *Service) promptTransactionAsset(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	topAssets, err := s.store.GetTopAssetsForUser(ctx, env.DBUserID)
 	if err != nil {
-		return err
+		return fsm.Prompt{}, err
 	}
 
-	defaultAssets := t.DefaultCryptoPairs
-
-	allAssets := s.mergeUniqueAssets(defaultAssets, topAssets)
+	allAssets := s.mergeUniqueAssets(t.DefaultCryptoPairs, topAssets)
 
-	var rows [][]tgbotapi.InlineKeyboardButton
+	var rows [][]fsm.Button
 	for i := 0; i < len(allAssets); i += 2 {
-		row := []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData(allAssets[i], "tx_asset_chosen_"+allAssets[i]),
-		}
+		row := []fsm.Button{{Text: allAssets[i], Action: "tx_asset_chosen", Arg: allAssets[i]}}
 		if i+1 < len(allAssets) {
-			row = append(row, tgbotapi.NewInlineKeyboardButtonData(allAssets[i+1], "tx_asset_chosen_"+allAssets[i+1]))
+			row = append(row, fsm.Button{Text: allAssets[i+1], Action: "tx_asset_chosen", Arg: allAssets[i+1]})
 		}
 		rows = append(rows, row)
 	}
 
-	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData("Back", "gf_add_transaction"),
-	))
-
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
-
-	s.sessions.setState(tgUserID, "waiting_transaction_asset")
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return fsm.Prompt{
+		Text:     "Please choose an asset ticker or enter a new one (e.g. BTC, eth, DoGe).",
+		Markdown: true,
+		Buttons:  rows,
+	}, nil
 }
 
-func (s *Service) askTransactionAssetAmount(
-	chatID, tgUserID int64,
-	BotMsgID int,
-	msgText string,
-	txData *t.TempTransactionData,
-) error {
-	selectedAsset := strings.TrimPrefix(msgText, "tx_asset_chosen_")
+func (s *Service) transactionAssetChosen(_ context.Context, env *flowEnv, value string) (string, error) {
+	result, err := s.validateTransactionInput(env.TgUserID, value, "asset")
+	if err != nil {
+		return "", err
+	}
 
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+	env.tx().Asset = result.(string)
+	return "waiting_transaction_asset_amount", nil
+}
 
-	result, err := s.handleTransactionValidationError(selectedAsset, "asset", chatID, tgUserID)
+func (s *Service) transactionAmountEntered(_ context.Context, env *flowEnv, value string) (string, error) {
+	result, err := s.validateTransactionInput(env.TgUserID, value, "amount")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	txData.Asset = result.(string)
+	env.tx().AssetAmount = result.(decimal.Decimal)
 
-	msg := tgbotapi.NewMessage(chatID,
-		"Enter the asset amount (e.g. 1234, 12.34).")
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back", "gf_add_transaction"),
-		),
-	)
+	if env.tx().Type == "transfer" {
+		return "waiting_transfer_source", nil
+	}
+	return "waiting_transaction_date", nil
+}
 
-	s.sessions.setState(tgUserID, "waiting_transaction_asset_amount")
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+func (s *Service) promptTransactionDate(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	settings := s.userSettings(env.TgUserID)
+	return fsm.Prompt{
+		Text: fmt.Sprintf("Select transaction date or enter manually in format *%s* (e.g. %s):",
+			settings.DateFormat, settings.FormatDate(settings.Now())),
+		Markdown: true,
+		Buttons: [][]fsm.Button{
+			{{Text: "Today", Action: "tx_date", Arg: "today"}, {Text: "Yesterday", Action: "tx_date", Arg: "yesterday"}},
+			{{Text: "2 days ago", Action: "tx_date", Arg: "2days"}, {Text: "1 week ago", Action: "tx_date", Arg: "1week"}},
+			{{Text: "1 month ago", Action: "tx_date", Arg: "1month"}},
+		},
+	}, nil
 }
 
-func (s *Service) askTransactionAssetPrice(
-	chatID, tgUserID int64,
-	BotMsgID int,
-	msgText string,
-	txData *t.TempTransactionData,
-) error {
-	fmt.Println("amount", msgText)
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+// date is known at this step, so the market price of that day is looked up for the price step
+func (s *Service) transactionDateChosen(ctx context.Context, env *flowEnv, value string) (string, error) {
+	result, err := s.validateTransactionInput(env.TgUserID, value, "date")
+	if err != nil {
+		return "", err
+	}
 
-	result, err := s.handleTransactionValidationError(msgText, "amount", chatID, tgUserID)
+	txData := env.tx()
+	txData.TransactionDate = result.(time.Time)
+	txData.MarketPrice = decimal.Zero
+	txData.MarketSource = ""
+
+	// these types have a known price, so price and fee steps are skipped
+	switch {
+	case txData.Type == "transfer":
+		if err := s.setTransferCostBasis(ctx, env.DBUserID, txData); err != nil {
+			return "", err
+		}
+		return "waiting_transfer_confirmation", nil
+	case t.IsFiatFlow(txData.Type):
+		txData.QuoteCurrency = t.FiatUSD
+		txData.QuotePrice = decimal.NewFromInt(1)
+		txData.AssetPrice = txData.QuotePrice
+		txData.USDAmount = txData.AssetAmount
+		txData.FeeAmount, txData.FeeCurrency, txData.FeeUSD = decimal.Zero, t.FeeCurrencyUSD, decimal.Zero
+		return "waiting_transaction_note", nil
+	case txData.Type == "gift":
+		txData.QuoteCurrency = t.FiatUSD
+		txData.QuotePrice = decimal.Zero
+		txData.AssetPrice = decimal.Zero
+		txData.USDAmount = decimal.Zero
+		txData.FeeAmount, txData.FeeCurrency, txData.FeeUSD = decimal.Zero, t.FeeCurrencyUSD, decimal.Zero
+		return "waiting_transaction_note", nil
+	}
+
+	quote, err := s.history.PriceAt(ctx, txData.Asset, prices.DefaultQuote, txData.TransactionDate)
 	if err != nil {
-		return err
+		// manual input still works, so only log it
+		log.Warn("could not get historical price", "asset", txData.Asset, "date", txData.TransactionDate.Format("2006-01-02"), "error", err)
+	} else {
+		txData.MarketPrice = quote.Price
+		txData.MarketSource = quote.Source
 	}
 
-	txData.AssetAmount = result.(float64)
+	return "waiting_transaction_asset_price", nil
+}
+
+func (s *Service) promptTransactionPrice(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
 
-	msg := tgbotapi.NewMessage(chatID,
-		"Enter the asset price (e.g. bought BTC for a 15500 usdt).")
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back", "gf_add_transaction"),
-		),
-	)
+	text := "Enter the asset price (e.g. bought BTC for a 15500 usdt)."
+	if t.IsIncome(txData.Type) {
+		text = "Enter the asset price at the moment you received it, it's used as fair value of the income."
+	}
+	text += "\n\nA plain number is USD, add the currency for other quotes (e.g. `14000 EUR`, `0.05 BTC`)."
+
+	var rows [][]fsm.Button
+	if txData.MarketPrice.IsPositive() {
+		text += fmt.Sprintf("\n\nMarket price on %s: `$%s` (%s)",
+			s.formatDate(env.TgUserID, txData.TransactionDate),
+			formatPriceInput(txData.MarketPrice),
+			txData.MarketSource)
+		rows = append(rows, []fsm.Button{{
+			Text:   fmt.Sprintf("Use market price $%s", formatPriceInput(txData.MarketPrice)),
+			Action: "tx_price_market",
+		}})
+	}
 
-	s.sessions.setState(tgUserID, "waiting_transaction_asset_price")
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return fsm.Prompt{Text: text, Markdown: true, Buttons: rows}, nil
 }
 
-func (s *Service) askTransactionDate(
-	chatID, tgUserID int64,
-	BotMsgID int,
-	msgText string,
-	txData *t.TempTransactionData,
-) error {
-	fmt.Println("price", msgText)
+func (s *Service) transactionMarketPriceChosen(_ context.Context, env *flowEnv, _ string) (string, error) {
+	txData := env.tx()
+	if !txData.MarketPrice.IsPositive() {
+		return "", fsm.Invalid("Market price is unknown for this date, enter the price.")
+	}
 
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+	setTransactionPrice(txData, t.QuotedPrice{Currency: t.FiatUSD, Price: txData.MarketPrice, USD: txData.MarketPrice})
+	return "waiting_transaction_fee", nil
+}
 
-	result, err := s.handleTransactionValidationError(msgText, "price", chatID, tgUserID)
+func (s *Service) transactionPriceEntered(ctx context.Context, env *flowEnv, value string) (string, error) {
+	result, err := s.validateTransactionInput(env.TgUserID, value, "price")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	fmt.Printf("priceFloat: %s\n", reflect.TypeOf(result))
-
-	txData.AssetPrice = result.(float64)
+	txData := env.tx()
+	price, err := s.quotePrice(ctx, result.(t.QuotedPrice), txData.TransactionDate)
+	if err != nil {
+		return "", fsm.Invalid(fmt.Sprintf("No %s rate known for %s. Enter the price in USD or in another currency.",
+			price.Currency, s.formatDate(env.TgUserID, txData.TransactionDate)))
+	}
 
-	msg := tgbotapi.NewMessage(chatID,
-		"Select transaction date or enter manually in format *YYYY-MM-DD* (e.g. 2025-06-15):")
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Today", "tx_date_today"),
-			tgbotapi.NewInlineKeyboardButtonData("Yesterday", "tx_date_yesterday"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("2 days ago", "tx_date_2days"),
-			tgbotapi.NewInlineKeyboardButtonData("1 week ago", "tx_date_1week"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("1 month ago", "tx_date_1month"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back", "gf_add_transaction"),
-		),
-	)
+	setTransactionPrice(txData, price)
+	return "waiting_transaction_fee", nil
+}
 
-	s.sessions.setState(tgUserID, "waiting_transaction_date")
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+func setTransactionPrice(txData *t.TempTransactionData, price t.QuotedPrice) {
+	txData.QuoteCurrency = price.Currency
+	txData.QuotePrice = price.Price
+	txData.AssetPrice = price.USD
+	txData.USDAmount = txData.AssetAmount.Mul(price.USD)
 }
 
-func (s *Service) asktransactionConfirmation(
-	chatID, tgUserID int64,
-	BotMsgID int,
-	dateString string,
-	txData *t.TempTransactionData,
-) error {
+func promptTransactionFee(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
 
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+	feeHint := fmt.Sprintf("add the asset ticker for fees taken in %s (e.g. `0.0001 %s`)", txData.Asset, txData.Asset)
+	if !t.IsUSD(txData.Quote()) {
+		feeHint = fmt.Sprintf("add %s or %s for fees taken in them (e.g. `0.0001 %s`)", txData.Asset, txData.Quote(), txData.Asset)
+	}
 
-	log.Info("date raw", dateString)
-	dateValue := strings.TrimPrefix(dateString, "tx_date_")
-	log.Info("date", dateValue)
+	return fsm.Prompt{
+		Text: "Enter the fee paid for this transaction.\n\n" +
+			"Use a plain number for USD (e.g. `1.25`) or " + feeHint + ".",
+		Markdown: true,
+		Buttons:  [][]fsm.Button{{{Text: "No fee", Action: "tx_fee_none"}}},
+	}, nil
+}
 
-	result, err := s.handleTransactionValidationError(dateValue, "date", chatID, tgUserID)
-	if err != nil {
-		return err
+// value is empty when "No fee" is pressed
+func (s *Service) transactionFeeEntered(_ context.Context, env *flowEnv, value string) (string, error) {
+	fee := feeInput{Currency: t.FeeCurrencyUSD}
+	if value != "" {
+		result, err := s.validateTransactionInput(env.TgUserID, value, "fee")
+		if err != nil {
+			return "", err
+		}
+		fee = result.(feeInput)
 	}
 
-	fmt.Printf("dateTime: %s\n", reflect.TypeOf(result))
+	txData := env.tx()
+	txData.FeeAmount = fee.Amount
+	txData.FeeCurrency = fee.Currency
 
-	txData.TransactionDate = result.(time.Time)
+	feeUSD, ok := txData.FeeToUSD()
+	if !ok {
+		supported := "USD or " + txData.Asset
+		if !t.IsUSD(txData.QuoteCurrency) {
+			supported = fmt.Sprintf("USD, %s or %s", txData.Asset, txData.QuoteCurrency)
+		}
+		return "", fsm.Invalid(fmt.Sprintf("Fee currency %s is not supported. Use %s.", fee.Currency, supported))
+	}
 
-	txData.USDAmount =
-		txData.AssetAmount * txData.AssetPrice
-
-	// Old table format
-	// tableText := fmt.Sprintf(
-	// 	"*You are about to add a new transaction. Please confirm:*\n\n"+
-	// 		"```\n"+
-	// 		"| %-12s | %-8s | %-13s | %-11s | %-10s | %-10s |\n"+
-	// 		"|--------------+----------+---------------+-------------+------------+------------|\n"+
-	// 		"| %-12s | %-8s | %-13.4f | %-11.4f | %-10.2f | %-10s |\n"+
-	// 		"```",
-	// 	"Pair", "Type", "Asset Amount", "Asset Price", "USD Amount", "Date",
-	// 	txData.Pair,
-	// 	strings.ToUpper(txData.Type),
-	// 	txData.AssetAmount,
-	// 	txData.AssetPrice,
-	// 	txData.USDAmount,
-	// 	txData.TransactionDate.Format("2006-01-02"),
-	// )
-
-	var typeEmoji string
-	switch strings.ToLower(txData.Type) {
-	case "buy":
-		typeEmoji = "🟢"
-	case "sell":
-		typeEmoji = "🔴"
-	default:
-		typeEmoji = "🔵"
+	txData.FeeUSD = feeUSD
+	return "waiting_transaction_note", nil
+}
+
+func promptTransactionNote(context.Context, *flowEnv) (fsm.Prompt, error) {
+	return fsm.Prompt{
+		Text: fmt.Sprintf(
+			"Add a note for this transaction (up to %d characters).\n\n"+
+				"Words starting with # become tags you can filter by later (e.g. `monthly buy #DCA #ledger`).",
+			maxNoteLength),
+		Markdown: true,
+		Buttons:  [][]fsm.Button{{{Text: "Skip", Action: "tx_note_skip"}}},
+	}, nil
+}
+
+// value is empty when "Skip" is pressed
+func (s *Service) transactionNoteEntered(_ context.Context, env *flowEnv, value string) (string, error) {
+	txData := env.tx()
+	txData.Note = ""
+	txData.Tags = nil
+
+	if value != "" {
+		result, err := s.validateTransactionInput(env.TgUserID, value, "note")
+		if err != nil {
+			return "", err
+		}
+
+		note := result.(noteInput)
+		txData.Note = note.Note
+		txData.Tags = note.Tags
 	}
 
-	// portfolioID, err := s.store.GetDefaultPortfolioID(ctx, dbUserID)
-	// if err != nil {
-	// 	return err
-	// }
+	return "waiting_transaction_confirmation", nil
+}
+
+func (s *Service) promptTransactionConfirmation(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
 
-	// New simplified format
 	tableText := fmt.Sprintf(
 		"*You are about to add a new transaction. Please confirm:*\n\n"+
 			"%s *%s %s*\n"+
 			"Type: `%s`\n"+
-			"Amount: `%.8g %s`\n"+
-			"Price: `$%.2f`\n"+
+			"Amount: `%v %s`\n"+
+			"Price: `%s`\n"+
 			"Total: `$%.2f`\n"+
+			"Fee: `%s`\n"+
 			"Date: `%s`\n",
-		typeEmoji,
+		txTypeEmoji(txData.Type),
 		strings.ToUpper(txData.Type),
 		txData.Asset, // FIXME check if its correct
 		strings.ToUpper(txData.Type),
 		txData.AssetAmount,
 		txData.Asset,
-		txData.AssetPrice,
+		formatTxPrice(txData.Quote(), txData.QuotePrice, txData.AssetPrice),
 		txData.USDAmount,
-		txData.TransactionDate.Format("2006-01-02"),
+		formatFee(txData.FeeAmount, txData.FeeCurrency, txData.FeeUSD),
+		s.formatDate(env.TgUserID, txData.TransactionDate),
 	)
+	if txData.Note != "" {
+		tableText += fmt.Sprintf("📝 Note: `%s`\n", txData.Note)
+	}
+	if len(txData.Tags) > 0 {
+		tableText += fmt.Sprintf("🏷 Tags: `%s`\n", strings.Join(txData.Tags, ", "))
+	}
 
-	msg := tgbotapi.NewMessage(chatID, tableText)
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Confirm", "tx_confirm_transaction"),
-			tgbotapi.NewInlineKeyboardButtonData("Back", "gf_add_transaction"),
-		),
-	)
-	msg.ParseMode = "Markdown"
-
-	s.sessions.setState(tgUserID, "waiting_transaction_confirmation")
-	return s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
+	return fsm.Prompt{
+		Text:     tableText,
+		Markdown: true,
+		Buttons:  [][]fsm.Button{{{Text: "Confirm", Action: "tx_confirm_transaction"}}},
+	}, nil
 }
 
-func (s *Service) transactionConfirmed(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-	txData *t.TempTransactionData,
-) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
-
-	portfolioID, err := s.store.GetDefaultPortfolioID(ctx, dbUserID)
-	if err != nil {
-		return err
+func (s *Service) transactionConfirmed(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	portfolioID, err := s.store.GetDefaultPortfolioID(ctx, env.DBUserID)
+	if err == nil {
+		err = s.store.AddNewTransaction(ctx, env.DBUserID, portfolioID, env.tx())
 	}
-
-	err = s.store.AddNewTransaction(ctx, dbUserID, portfolioID, txData)
-	if err != nil {
-		return err
+	if errors.Is(err, store.ErrPortfolioNotFound) {
+		return "", fsm.Invalid("Default portfolio not found. Please choose or create one and try again.")
 	}
-	err = s.sendTemporaryMessage(
-		tgbotapi.NewMessage(
-			chatID,
-			fmt.Sprintf("Transaction added successfully: %s, %.2f USD!", txData.Asset, txData.USDAmount)),
-		tgUserID,
-		10*time.Second)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	log.Info("transaction added successfully", "user_id", dbUserID)
-
-	return s.showMainMenu(chatID, tgUserID)
+	log.Info("transaction added successfully", "user_id", env.DBUserID)
+	return "transaction_added", nil
 }
 
-func (s *Service) transactionValidateInput(rawText string, inputType string) (any, error) {
+func (s *Service) transactionValidateInput(tgUserID int64, rawText string, inputType string) (any, error) {
 	text := strings.TrimSpace(rawText)
 
 	switch inputType {
@@ -380,51 +539,95 @@ func (s *Service) transactionValidateInput(rawText string, inputType string) (an
 				fmt.Errorf("invalid amount format")
 		}
 
-		val, err := strconv.ParseFloat(text, 64)
+		val, err := decimal.NewFromString(text)
 		if err != nil {
 			return "Could not parse amount. Please try again.", err
 		}
 
-		if val <= 0 {
+		if !val.IsPositive() {
 			return "Amount must be greater than 0.", fmt.Errorf("amount must be positive")
 		}
-		if val > 1000000000 {
+		if val.GreaterThan(t.MaxAssetAmount) {
 			return "Amount too large. Maximum allowed: 1,000,000,000.", fmt.Errorf("amount too large")
 		}
-		if val < 0.00000001 {
+		if val.LessThan(t.MinTransactionNumber) {
 			return "Amount too small. Minimum allowed: 0.00000001.", fmt.Errorf("amount too small")
 		}
 
 		return val, nil
 
 	case "price":
-		if !regexp.MustCompile(`^\d+(\.\d{1,8})?$`).MatchString(text) {
-			return "Wrong price format. Use numbers with up to 8 decimal places (e.g. 1234, 12.345, 0.00000001).",
+		m := regexp.MustCompile(`^(\d+(?:\.\d{1,8})?)\s*([A-Za-z]{3,8})?$`).FindStringSubmatch(text)
+		if m == nil {
+			return "Wrong price format. Use numbers with up to 8 decimal places, optionally followed by a currency (e.g. 1234, 12.345, 0.05 BTC).",
 				fmt.Errorf("invalid price format")
 		}
 
 		//FIXME add sending message to tg
-		val, err := strconv.ParseFloat(text, 64)
+		val, err := decimal.NewFromString(m[1])
 		if err != nil {
 			return "Could not parse price. Please try again.", err
 		}
 
-		if val <= 0 {
+		if !val.IsPositive() {
 			return "Price must be greater than 0.", fmt.Errorf("price must be positive")
 		}
-		if val > 10000000 {
+		if val.GreaterThan(t.MaxAssetPrice) {
 			return "Price too high. Maximum allowed: 10,000,000.", fmt.Errorf("price too high")
 		}
-		if val < 0.00000001 {
+		if val.LessThan(t.MinTransactionNumber) {
 			return "Price too small. Minimum allowed: 0.00000001.", fmt.Errorf("price too small")
 		}
 
-		return val, nil
+		currency := strings.ToUpper(m[2])
+		if currency == "" {
+			currency = t.FiatUSD
+		}
+
+		// USD value is filled by quotePrice once the date is known
+		return t.QuotedPrice{Currency: currency, Price: val}, nil
+
+	case "fee":
+		m := regexp.MustCompile(`^(\d+(?:\.\d{1,8})?)\s*([A-Za-z]{3,8})?$`).FindStringSubmatch(text)
+		if m == nil {
+			return "Wrong fee format. Use a number with up to 8 decimal places, optionally followed by a ticker (e.g. 1.25, 0.0001 BTC).",
+				fmt.Errorf("invalid fee format")
+		}
+
+		val, err := decimal.NewFromString(m[1])
+		if err != nil {
+			return "Could not parse fee. Please try again.", err
+		}
+
+		if val.GreaterThan(t.MaxAssetPrice) {
+			return "Fee too large. Maximum allowed: 10,000,000.", fmt.Errorf("fee too large")
+		}
+
+		currency := strings.ToUpper(m[2])
+		if currency == "" {
+			currency = t.FeeCurrencyUSD
+		}
+
+		return feeInput{Amount: val, Currency: currency}, nil
+
+	case "note":
+		note, tags := splitNoteAndTags(text)
+		if utf8.RuneCountInString(note) > maxNoteLength {
+			return fmt.Sprintf("Note is too long, maximum %d characters.", maxNoteLength),
+				fmt.Errorf("note too long")
+		}
+		if len(tags) > maxTagsPerTransaction {
+			return fmt.Sprintf("Too many tags, maximum %d per transaction.", maxTagsPerTransaction),
+				fmt.Errorf("too many tags")
+		}
+
+		return noteInput{Note: note, Tags: tags}, nil
 
 		// FIXME week and month looks unnecessary
 
 	case "date":
-		now := time.Now()
+		settings := s.userSettings(tgUserID)
+		now := settings.WallClockNow()
 		switch strings.ToLower(text) {
 		case "today":
 			return now, nil
@@ -438,14 +641,10 @@ func (s *Service) transactionValidateInput(rawText string, inputType string) (an
 			return now.AddDate(0, -1, 0), nil
 		}
 
-		if !regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`).MatchString(text) {
-			return "Wrong date format. Use YYYY-MM-DD (e.g. 2024-06-14) or select a date button.",
-				fmt.Errorf("invalid date format")
-		}
-
-		parsedTime, err := time.Parse("2006-01-02", text)
+		parsedTime, err := settings.ParseDate(text)
 		if err != nil {
-			return "Could not parse the date. Please use YYYY-MM-DD format.", err
+			return fmt.Sprintf("Wrong date format. Use %s (e.g. %s) or select a date button.",
+				settings.DateFormat, settings.FormatDate(now)), err
 		}
 
 		if parsedTime.After(now.AddDate(0, 0, 1)) {
@@ -462,133 +661,95 @@ func (s *Service) transactionValidateInput(rawText string, inputType string) (an
 	}
 }
 
-// helper method to handle validation errors consistently
-// It validates input and sends error message if validation fails
-func (s *Service) handleTransactionValidationError(
-	msgText, inputType string,
-	chatID, tgUserID int64,
-) (any, error) {
-	result, err := s.transactionValidateInput(msgText, inputType)
+// validates typed value, the flow shows the prompt again with the error text
+func (s *Service) validateTransactionInput(tgUserID int64, rawText, inputType string) (any, error) {
+	result, err := s.transactionValidateInput(tgUserID, rawText, inputType)
 	if err != nil {
-		errorText := result.(string)
-		msg := tgbotapi.NewMessage(chatID, errorText)
-		msg.ParseMode = "Markdown"
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Try again", "gf_add_transaction"),
-			),
-		)
-		sendErr := s.sendTemporaryMessage(msg, tgUserID, 20*time.Second)
-		if sendErr != nil {
-			return nil, sendErr
+		if text, ok := result.(string); ok {
+			return nil, fsm.Invalid(text)
 		}
-		// Return the original validation error, not the send error
 		return nil, err
 	}
 	return result, nil
 }
 
-func (s *Service) mergeUniqueAssets(defaultAssets, topAssets []string) []string {
-	unique := make(map[string]struct{})
-	var result []string
+func txTypeEmoji(txType string) string {
+	switch strings.ToLower(txType) {
+	case "buy":
+		return "🟢"
+	case "sell":
+		return "🔴"
+	case "income", "airdrop", "gift":
+		return "🎁"
+	case "deposit", "withdrawal":
+		return "💵"
+	default:
+		return "🔵"
+	}
+}
 
-	for _, asset := range append(defaultAssets, topAssets...) {
-		if _, exists := unique[asset]; !exists {
-			unique[asset] = struct{}{}
-			result = append(result, asset)
-		}
+// transfer legs are shown with their direction, e.g. TRANSFER OUT
+func txTypeLabel(tx t.Transaction) string {
+	if tx.Direction != "" {
+		return strings.ToUpper(tx.Type + " " + tx.Direction)
 	}
-	return result
+	return strings.ToUpper(tx.Type)
 }
 
-func (s *Service) showLast5Transactions(
-	ctx context.Context,
-	chatID, tgUserID, dbUserID int64,
-	BotMsgID int,
-) error {
-	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+type feeInput struct {
+	Amount   decimal.Decimal
+	Currency string
+}
 
-	transactions, err := s.store.GetLast5TransactionsForUser(ctx, dbUserID)
-	if err != nil {
-		log.Errorf("could not get last 5 transactions: %s", err)
-		return s.sendTemporaryMessage(
-			tgbotapi.NewMessage(chatID,
-				"Sorry, we cannot get your transactions, please try again."),
-			tgUserID,
-			20*time.Second,
-		)
+type noteInput struct {
+	Note string
+	Tags []string
+}
+
+// shows fee in its own currency and its USD value when it was paid in asset
+func formatFee(amount decimal.Decimal, currency string, usd decimal.Decimal) string {
+	if amount.IsZero() {
+		return "none"
+	}
+	if currency == "" || currency == t.FeeCurrencyUSD {
+		return fmt.Sprintf("$%.2f", amount)
 	}
+	return fmt.Sprintf("%v %s ($%.2f)", amount, currency, usd)
+}
 
-	if len(transactions) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "You have no transactions yet. Let's add your first one!")
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Add transaction", "gf_add_transaction"),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Back to main menu", "cancel_action"),
-			),
-		)
-		return s.sendTemporaryMessage(msg, tgUserID, 30*time.Second)
-	}
-
-	// format transactions in a user-friendly way
-	var messageText strings.Builder
-	messageText.WriteString("*Your Last 5 Transactions:*\n\n")
-
-	for i, tx := range transactions {
-		var typeEmoji string
-		switch strings.ToLower(tx.Type) {
-		case "buy":
-			typeEmoji = "🟢"
-		case "sell":
-			typeEmoji = "🔴"
-		default:
-			typeEmoji = "🔵"
-		}
+// price in the quote currency with its USD value when they differ
+func formatTxPrice(currency string, quotePrice, usdPrice decimal.Decimal) string {
+	if t.IsUSD(currency) {
+		return fmt.Sprintf("$%.2f", usdPrice)
+	}
+	return fmt.Sprintf("%v %s ($%.2f)", quotePrice, currency, usdPrice)
+}
 
-		messageText.WriteString(fmt.Sprintf(
-			"%s *%s %s*\n"+
-				"Portfolio: `%s`\n"+
-				"Amount: `%.8g %s`\n"+
-				"Price: `$%.2f`\n"+
-				"Total: `$%.2f`\n"+
-				"Date: `%s`\n",
-			typeEmoji,
-			strings.ToUpper(tx.Type),
-			tx.Asset, // FIXME check if its correct
-			tx.PortfolioName,
-			tx.AssetAmount,
-			tx.Asset,
-			tx.AssetPrice,
-			tx.USDAmount,
-			tx.TransactionDate.Format("2006-01-02"),
-		))
+// values the typed price in USD with the rate of its currency on the transaction date
+func (s *Service) quotePrice(ctx context.Context, price t.QuotedPrice, date time.Time) (t.QuotedPrice, error) {
+	rate, err := s.rates.RateAt(ctx, price.Currency, date)
+	if err != nil {
+		log.Warn("no rate for price currency", "currency", price.Currency, "date", date.Format("2006-01-02"), "error", err)
+		return price, err
+	}
+	price.USD = price.Price.Mul(rate).Round(8)
+	return price, nil
+}
 
-		// add note if available
-		if tx.Note != "" {
-			messageText.WriteString(fmt.Sprintf("📝 Note: `%s`\n", tx.Note))
-		}
+// formats price the way it passes "price" validation: up to 8 decimals without trailing zeros
+func formatPriceInput(price decimal.Decimal) string {
+	return price.Round(8).String()
+}
+
+func (s *Service) mergeUniqueAssets(defaultAssets, topAssets []string) []string {
+	unique := make(map[string]struct{})
+	var result []string
 
-		// add separator except for last transaction
-		if i < len(transactions)-1 {
-			messageText.WriteString("\n" + strings.Repeat("─", 17) + "\n\n")
+	for _, asset := range append(defaultAssets, topAssets...) {
+		if _, exists := unique[asset]; !exists {
+			unique[asset] = struct{}{}
+			result = append(result, asset)
 		}
 	}
-
-	msg := tgbotapi.NewMessage(chatID, messageText.String())
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Add new transaction", "gf_add_transaction"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back to transactions menu", "gf_transactions_main"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Back to main menu", "cancel_action"),
-		),
-	)
-
-	return s.sendTemporaryMessage(msg, tgUserID, 40*time.Second)
+	return result
 }
@@ -0,0 +1,441 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+const historyPageSize = 5
+
+// position of the user in the history browser, kept in session between pages
+type historyState struct {
+	Filter        t.TransactionFilter
+	PortfolioName string // name of Filter.PortfolioID for display
+	Page          int    // 0 is the first page
+	First, Last   t.TransactionCursor
+	HasNext       bool
+}
+
+var historyTypes = []string{"buy", "sell", "transfer", "income", "airdrop", "gift", "deposit", "withdrawal"}
+
+// move is one of "first", "next", "prev"
+func (s *Service) showTransactionHistory(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	BotMsgID int,
+	hs *historyState,
+	move string,
+) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	var cursor *t.TransactionCursor
+	backward := false
+	page := 0
+
+	switch move {
+	case "next":
+		cursor = &hs.Last
+		page = hs.Page + 1
+	case "prev":
+		if hs.Page > 1 {
+			cursor = &hs.First
+			backward = true
+			page = hs.Page - 1
+		}
+	}
+
+	txs, more, err := s.store.GetTransactionsPage(ctx, dbUserID, hs.Filter, cursor, backward, historyPageSize)
+	if err == nil && len(txs) == 0 && cursor != nil {
+		// rows around the cursor were deleted meanwhile, start over
+		page = 0
+		backward = false
+		txs, more, err = s.store.GetTransactionsPage(ctx, dbUserID, hs.Filter, nil, false, historyPageSize)
+	}
+	if err != nil {
+		log.Errorf("could not get transactions page: %s", err)
+		return s.sendTemporaryMessage(
+			tgbotapi.NewMessage(chatID,
+				"Sorry, we cannot get your transactions, please try again."),
+			tgUserID,
+			s.messageTTL(tgUserID),
+		)
+	}
+
+	hs.Page = page
+	// after going back there is always the page we came from
+	hs.HasNext = more || backward
+	if len(txs) > 0 {
+		hs.First = t.TransactionCursor{Date: txs[0].TransactionDate, ID: txs[0].ID}
+		hs.Last = t.TransactionCursor{Date: txs[len(txs)-1].TransactionDate, ID: txs[len(txs)-1].ID}
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("*Transaction history* (page %d)\n", hs.Page+1))
+	text.WriteString(fmt.Sprintf("_%s_\n\n", hs.describeFilter(s.userSettings(tgUserID))))
+
+	if len(txs) == 0 {
+		text.WriteString("No transactions match the filters.")
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, tx := range txs {
+		num := i + 1
+		text.WriteString(fmt.Sprintf(
+			"%d. %s *%s %s* `%v` | `$%.2f` | `%s` | %s\n",
+			num,
+			txTypeEmoji(tx.Type),
+			txTypeLabel(tx),
+			tx.Asset,
+			tx.AssetAmount,
+			tx.USDAmount,
+			s.formatDate(tgUserID, tx.TransactionDate),
+			tx.PortfolioName,
+		))
+		if tx.Note != "" {
+			text.WriteString(fmt.Sprintf("    📝 `%s`\n", tx.Note))
+		}
+		if len(tx.Tags) > 0 {
+			text.WriteString(fmt.Sprintf("    🏷 `%s`\n", strings.Join(tx.Tags, ", ")))
+		}
+
+		id := strconv.FormatInt(tx.ID, 10)
+		row := []tgbotapi.InlineKeyboardButton{}
+		// transfer legs can only be deleted, see startTransactionEdit
+		if tx.Type != "transfer" {
+			row = append(row, s.button(fmt.Sprintf("✏️ %d", num), "tx_edit_pick", id))
+		}
+		row = append(row, s.button(fmt.Sprintf("🗑 %d", num), "gf_delete_transaction_confirmation", id))
+		rows = append(rows, row)
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if hs.Page > 0 {
+		nav = append(nav, s.button("⬅️ Prev", "hist_prev"))
+	}
+	if hs.HasNext {
+		nav = append(nav, s.button("Next ➡️", "hist_next"))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+
+	sortText := "Sort: oldest first"
+	if hs.Filter.OldestFirst {
+		sortText = "Sort: newest first"
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("🔍 Filters", "hist_filters"),
+			s.button(sortText, "hist_sort"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			s.button("Back to transactions menu", "gf_transactions_main"),
+		),
+	)
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	s.sessions.setState(tgUserID, "browsing_history")
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (hs *historyState) describeFilter(settings t.UserSettings) string {
+	var parts []string
+	if hs.Filter.PortfolioID != 0 {
+		parts = append(parts, "portfolio "+hs.PortfolioName)
+	}
+	if hs.Filter.Asset != "" {
+		parts = append(parts, "asset "+hs.Filter.Asset)
+	}
+	if hs.Filter.Type != "" {
+		parts = append(parts, "type "+hs.Filter.Type)
+	}
+	if hs.Filter.Tag != "" {
+		parts = append(parts, "tag "+hs.Filter.Tag)
+	}
+	if !hs.Filter.From.IsZero() || !hs.Filter.To.IsZero() {
+		parts = append(parts, "dates "+formatDateRange(hs.Filter.From, hs.Filter.To, settings))
+	}
+
+	order := "newest first"
+	if hs.Filter.OldestFirst {
+		order = "oldest first"
+	}
+
+	if len(parts) == 0 {
+		return "All transactions, " + order
+	}
+	return "Filtered by " + strings.Join(parts, ", ") + "; " + order
+}
+
+func formatDateRange(from, to time.Time, settings t.UserSettings) string {
+	fromText, toText := "…", "…"
+	if !from.IsZero() {
+		fromText = settings.FormatDate(from)
+	}
+	if !to.IsZero() {
+		toText = settings.FormatDate(to)
+	}
+	return fromText + " – " + toText
+}
+
+func (s *Service) showHistoryFilters(chatID, tgUserID int64, BotMsgID int, hs *historyState) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	s.sessions.setState(tgUserID, "browsing_history")
+	return s.sendScreen(chatID, tgUserID, s.historyFiltersPrompt(tgUserID, hs))
+}
+
+func (s *Service) historyFiltersPrompt(tgUserID int64, hs *historyState) fsm.Prompt {
+	return fsm.Prompt{
+		Text:     "*History filters*\n_" + hs.describeFilter(s.userSettings(tgUserID)) + "_",
+		Markdown: true,
+		Buttons: [][]fsm.Button{
+			{{Text: "Portfolio", Action: "hist_f_portfolio"}, {Text: "Asset", Action: "hist_f_asset"}},
+			{{Text: "Type", Action: "hist_f_type"}, {Text: "Date range", Action: "hist_f_dates"}},
+			{{Text: "Tag", Action: "hist_f_tag"}},
+			{{Text: "Reset filters", Action: "hist_f_reset"}, {Text: "Show results", Action: "hist_show"}},
+		},
+	}
+}
+
+func (s *Service) askHistoryPortfolio(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	portfolios, err := s.store.GetPortfolioRefs(ctx, dbUserID)
+	if err != nil {
+		return fmt.Errorf("get portfolios: %w", err)
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(s.button("All portfolios", "hist_fp", "0")),
+	}
+	for _, p := range portfolios {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			s.button(p.Name, "hist_fp", strconv.FormatInt(p.ID, 10)),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Show transactions of portfolio:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) setHistoryPortfolio(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	BotMsgID int,
+	portfolioID int64,
+	hs *historyState,
+) error {
+	hs.Filter.PortfolioID = 0
+	hs.PortfolioName = ""
+
+	if portfolioID != 0 {
+		portfolios, err := s.store.GetPortfolioRefs(ctx, dbUserID)
+		if err != nil {
+			return fmt.Errorf("get portfolios: %w", err)
+		}
+		for _, p := range portfolios {
+			if p.ID == portfolioID {
+				hs.Filter.PortfolioID = p.ID
+				hs.PortfolioName = p.Name
+			}
+		}
+	}
+
+	return s.showHistoryFilters(chatID, tgUserID, BotMsgID, hs)
+}
+
+func (s *Service) askHistoryTag(ctx context.Context, chatID, tgUserID, dbUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	tags, err := s.store.GetTags(ctx, dbUserID)
+	if err != nil {
+		return fmt.Errorf("get tags: %w", err)
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(s.button("All tags", "hist_fg", "0")),
+	}
+	for _, tag := range tags {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			s.button(tag.Name, "hist_fg", strconv.FormatInt(tag.ID, 10)),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Show transactions with tag:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) setHistoryTag(
+	ctx context.Context,
+	chatID, tgUserID, dbUserID int64,
+	BotMsgID int,
+	tagID int64,
+	hs *historyState,
+) error {
+	var err error
+	hs.Filter.Tag, err = s.tagNameByID(ctx, dbUserID, tagID)
+	if err != nil {
+		return err
+	}
+
+	return s.showHistoryFilters(chatID, tgUserID, BotMsgID, hs)
+}
+
+func (s *Service) askHistoryType(chatID, tgUserID int64, BotMsgID int) error {
+	_, _ = s.bot.Request(tgbotapi.NewDeleteMessage(chatID, BotMsgID))
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(s.button("All types", "hist_ft", "all")),
+	}
+	for i := 0; i < len(historyTypes); i += 2 {
+		row := []tgbotapi.InlineKeyboardButton{
+			s.button(txTypeEmoji(historyTypes[i])+" "+historyTypes[i], "hist_ft", historyTypes[i]),
+		}
+		if i+1 < len(historyTypes) {
+			row = append(row, s.button(
+				txTypeEmoji(historyTypes[i+1])+" "+historyTypes[i+1], "hist_ft", historyTypes[i+1]))
+		}
+		rows = append(rows, row)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Show transactions of type:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return s.sendTemporaryMessage(msg, tgUserID, s.messageTTL(tgUserID))
+}
+
+func (s *Service) setHistoryType(chatID, tgUserID int64, BotMsgID int, txType string, hs *historyState) error {
+	hs.Filter.Type = ""
+	for _, known := range historyTypes {
+		if known == txType {
+			hs.Filter.Type = txType
+		}
+	}
+
+	return s.showHistoryFilters(chatID, tgUserID, BotMsgID, hs)
+}
+
+// typed filters: asset or dates -> filters screen. each input is its own entry point
+func (s *Service) historyFilterFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("history_filter",
+		flowState{
+			Name:   "waiting_history_asset",
+			Prompt: promptHistoryAsset,
+			Inputs: []flowInput{
+				{
+					Action: "hist_fa_all",
+					Next:   []string{"history_filters"},
+					Handle: func(_ context.Context, env *flowEnv, _ string) (string, error) {
+						env.Session.History.Filter.Asset = ""
+						return "history_filters", nil
+					},
+				},
+				{Next: []string{"history_filters"}, Handle: s.historyAssetEntered},
+			},
+		},
+		flowState{
+			Name:   "waiting_history_dates",
+			Prompt: s.promptHistoryDates,
+			Inputs: []flowInput{
+				{
+					Action: "hist_fd_all",
+					Next:   []string{"history_filters"},
+					Handle: func(_ context.Context, env *flowEnv, _ string) (string, error) {
+						env.Session.History.Filter.From = time.Time{}
+						env.Session.History.Filter.To = time.Time{}
+						return "history_filters", nil
+					},
+				},
+				{Next: []string{"history_filters"}, Handle: s.historyDatesEntered},
+			},
+		},
+		flowState{
+			Name: "history_filters",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return s.historyFiltersPrompt(env.TgUserID, &env.Session.History), nil
+			},
+		},
+	)
+}
+
+func promptHistoryAsset(context.Context, *flowEnv) (fsm.Prompt, error) {
+	return fsm.Prompt{
+		Text: "Enter the asset ticker to show (e.g. BTC, eth).",
+		Buttons: [][]fsm.Button{
+			{{Text: "All assets", Action: "hist_fa_all"}},
+			{{Text: "Back to filters", Action: "hist_filters"}},
+		},
+	}, nil
+}
+
+func (s *Service) historyAssetEntered(_ context.Context, env *flowEnv, msgText string) (string, error) {
+	result, err := s.transactionValidateInput(env.TgUserID, msgText, "asset")
+	if err != nil {
+		return "", fsm.Invalid(result.(string))
+	}
+
+	env.Session.History.Filter.Asset = result.(string)
+	return "history_filters", nil
+}
+
+func (s *Service) promptHistoryDates(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	settings := s.userSettings(env.TgUserID)
+	return fsm.Prompt{
+		Text: fmt.Sprintf(
+			"Enter the date range as *%s %s* (e.g. %s %s).\n"+
+				"A single date shows everything since that day.",
+			settings.DateFormat, settings.DateFormat,
+			settings.FormatDate(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)),
+			settings.FormatDate(time.Date(2025, time.March, 31, 0, 0, 0, 0, time.UTC))),
+		Markdown: true,
+		Buttons: [][]fsm.Button{
+			{{Text: "All dates", Action: "hist_fd_all"}},
+			{{Text: "Back to filters", Action: "hist_filters"}},
+		},
+	}, nil
+}
+
+func (s *Service) historyDatesEntered(_ context.Context, env *flowEnv, msgText string) (string, error) {
+	parts := strings.Fields(msgText)
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", fsm.Invalid(fmt.Sprintf("Wrong date range format. Use %[1]s %[1]s.", s.userSettings(env.TgUserID).DateFormat))
+	}
+
+	var dates []time.Time
+	for _, part := range parts {
+		result, err := s.transactionValidateInput(env.TgUserID, part, "date")
+		if err != nil {
+			return "", fsm.Invalid(result.(string))
+		}
+		dates = append(dates, utcDay(result.(time.Time)))
+	}
+
+	from := dates[0]
+	var to time.Time
+	if len(dates) == 2 {
+		to = dates[1]
+		if to.Before(from) {
+			return "", fsm.Invalid("End date is before start date.")
+		}
+	}
+
+	env.Session.History.Filter.From = from
+	env.Session.History.Filter.To = to
+	return "history_filters", nil
+}
@@ -0,0 +1,400 @@
+package telegram_bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/internal/importer"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+const (
+	maxImportFileSize = 5 << 20
+	importPreviewRows = 10
+)
+
+// uploaded file kept in session until its rows are imported. sessions are saved on every update,
+// so only the file reference is kept and the file is downloaded and parsed again when it's needed
+type importState struct {
+	FileID   string
+	FileName string
+	Format   importer.Format // detected or chosen when the file was parsed, empty for a JSON export
+}
+
+// JSON export of this bot, restored instead of imported
+func (is importState) isExport() bool {
+	return strings.HasSuffix(strings.ToLower(is.FileName), ".json")
+}
+
+// import: file -> preview -> portfolio, a file the format of which isn't detected asks for it first.
+// JSON export of this bot goes file -> restore preview instead
+func (s *Service) importFlow() *fsm.Flow[*flowEnv] {
+	return fsm.NewFlow("import",
+		flowState{
+			Name:   "waiting_import_file",
+			Prompt: promptImportFile,
+			Inputs: []flowInput{
+				{
+					Action: fsm.Upload,
+					Next:   []string{"waiting_import_format", "import_preview", "restore_preview"},
+					Handle: s.importFileReceived,
+				},
+				{
+					Handle: func(context.Context, *flowEnv, string) (string, error) {
+						return "", fsm.Invalid("Please send the CSV file as a document.")
+					},
+				},
+			},
+		},
+		flowState{
+			Name:   "waiting_import_format",
+			Prompt: promptImportFormat,
+			Inputs: []flowInput{
+				{Action: "imp_fmt", Next: []string{"import_preview"}, Handle: s.importFormatChosen},
+			},
+		},
+		flowState{
+			Name:   "import_preview",
+			Prompt: s.promptImportPreview,
+			Inputs: []flowInput{
+				{
+					Action: "imp_choose_portfolio",
+					Next:   []string{"waiting_import_portfolio"},
+					Handle: func(context.Context, *flowEnv, string) (string, error) { return "waiting_import_portfolio", nil },
+				},
+				{
+					Action: "imp_formats",
+					Next:   []string{"waiting_import_format"},
+					Handle: func(context.Context, *flowEnv, string) (string, error) { return "waiting_import_format", nil },
+				},
+			},
+		},
+		flowState{
+			Name:   "waiting_import_portfolio",
+			Prompt: s.promptImportPortfolio,
+			Inputs: []flowInput{
+				{Action: "imp_pf", Next: []string{"import_done"}, Handle: s.importConfirmed},
+			},
+		},
+		flowState{
+			Name:   "restore_preview",
+			Prompt: s.promptRestorePreview,
+			Inputs: []flowInput{
+				{Action: "imp_restore", Next: []string{"import_done"}, Handle: s.restoreConfirmed},
+			},
+		},
+		flowState{
+			Name: "import_done",
+			Prompt: func(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+				return fsm.Prompt{
+					Text: env.summary,
+					Buttons: [][]fsm.Button{
+						{{Text: "Transaction history", Action: "gf_history"}},
+						{{Text: "Back to transactions menu", Action: "gf_transactions_main"}},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (s *Service) startImportFlow(ctx context.Context, chatID, tgUserID, dbUserID int64, sv *UserSession) error {
+	sv.Import = importState{}
+	return s.startFlow(ctx, chatID, tgUserID, dbUserID, sv, "import")
+}
+
+func promptImportFile(context.Context, *flowEnv) (fsm.Prompt, error) {
+	var formats strings.Builder
+	for _, f := range importer.Formats {
+		formats.WriteString("• " + f.Title() + "\n")
+	}
+
+	return fsm.Prompt{
+		Text: "*Import transactions*\n\n" +
+			"Send me the CSV file as a document. Supported formats:\n" + formats.String() + "\n" +
+			"Generic format columns: `date,type,asset,amount,price`, optional `fee,fee_currency,note,tags`.\n" +
+			"A JSON export of this bot restores all its portfolios and transactions.\n" +
+			"You will see a preview before anything is saved.",
+		Markdown: true,
+		Buttons:  [][]fsm.Button{{{Text: "Back to transactions menu", Action: "gf_transactions_main"}}},
+	}, nil
+}
+
+func (s *Service) importFileReceived(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	doc := env.Document
+	if doc == nil {
+		return "", fsm.Invalid("Please send the CSV file as a document.")
+	}
+
+	is := &env.Session.Import
+	*is = importState{FileID: doc.FileID, FileName: doc.FileName}
+
+	limit := maxImportFileSize
+	if is.isExport() {
+		limit = maxRestoreFileSize
+	}
+	if doc.FileSize > limit {
+		*is = importState{}
+		return "", fsm.Invalid(fmt.Sprintf("The file is too large, maximum is %d MB.", limit>>20))
+	}
+
+	if is.isExport() {
+		return s.restoreFileReceived(ctx, env)
+	}
+
+	return s.parseImportFile(ctx, env)
+}
+
+// downloads the file again and parses it with its format, empty format is detected
+func (s *Service) parseImportFile(ctx context.Context, env *flowEnv) (string, error) {
+	is := &env.Session.Import
+
+	res, err := s.readImportFile(ctx, is)
+	var downloadErr *downloadError
+	switch {
+	case errors.As(err, &downloadErr):
+		log.Error("failed to download import file", "error", err, "tg_user_id", env.TgUserID)
+		return "", fsm.Invalid("Sorry, couldn't download the file. Please send it again.")
+	case errors.Is(err, importer.ErrUnknownFormat):
+		return "waiting_import_format", nil
+	case errors.Is(err, importer.ErrNoHeader):
+		return "", fsm.Invalid(fmt.Sprintf("The file doesn't look like %s. Choose another format.", is.Format.Title()))
+	case errors.Is(err, importer.ErrTooManyRows):
+		return "", fsm.Invalid(fmt.Sprintf("The file has more than %d rows, please split it.", importer.MaxRows))
+	case err != nil:
+		log.Warn("failed to parse import file", "error", err, "tg_user_id", env.TgUserID)
+		return "", fsm.Invalid("Sorry, couldn't read the file. Is it a CSV?")
+	}
+
+	is.Format = res.Format
+	env.importResult = res
+	return "import_preview", nil
+}
+
+// failure to fetch the file, told apart from a file that can't be parsed
+type downloadError struct {
+	err error
+}
+
+func (e *downloadError) Error() string { return e.err.Error() }
+func (e *downloadError) Unwrap() error { return e.err }
+
+// downloads the import file and parses it with is.Format, rows priced in EUR, BTC etc.
+// are valued with the rate of their day
+func (s *Service) readImportFile(ctx context.Context, is *importState) (*importer.Result, error) {
+	data, err := s.downloadDocument(ctx, is.FileID, maxImportFileSize)
+	if err != nil {
+		return nil, &downloadError{err: err}
+	}
+
+	res, err := importer.Parse(bytes.NewReader(data), is.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	importer.ConvertQuotes(res, func(currency string, date time.Time) (decimal.Decimal, error) {
+		return s.rates.RateAt(ctx, currency, date)
+	})
+	return res, nil
+}
+
+func promptImportFormat(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	text := "Choose the file format:"
+	if env.Session.Import.Format == "" {
+		text = "Couldn't recognize the file format. Which exchange is it from?"
+	}
+
+	var rows [][]fsm.Button
+	for _, f := range importer.Formats {
+		rows = append(rows, []fsm.Button{{Text: f.Title(), Action: "imp_fmt", Arg: string(f)}})
+	}
+
+	return fsm.Prompt{Text: text, Buttons: rows}, nil
+}
+
+func (s *Service) importFormatChosen(ctx context.Context, env *flowEnv, formatName string) (string, error) {
+	if env.Session.Import.FileID == "" {
+		return "", fsm.Invalid("The file is gone, please send it again.")
+	}
+
+	format, err := importer.ParseFormat(formatName)
+	if err != nil {
+		return "", err
+	}
+	env.Session.Import.Format = format
+
+	return s.parseImportFile(ctx, env)
+}
+
+// the file is parsed again when the preview is reached with Back
+func (s *Service) promptImportPreview(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	res := env.importResult
+	if res == nil {
+		var err error
+		if res, err = s.readImportFile(ctx, &env.Session.Import); err != nil {
+			return fsm.Prompt{}, fmt.Errorf("read import file: %w", err)
+		}
+	}
+
+	var text strings.Builder
+	text.WriteString("*Import preview*\n")
+	text.WriteString(fmt.Sprintf("Format: `%s`\n", res.Format.Title()))
+	text.WriteString(fmt.Sprintf("Valid rows: `%d`\n", len(res.Rows)))
+	text.WriteString(fmt.Sprintf("Rows with errors: `%d`\n", len(res.Errors)))
+
+	// user data goes into code blocks, it may contain markdown characters
+	if len(res.Rows) > 0 {
+		text.WriteString("\n```\n")
+		for _, row := range res.Rows[:min(importPreviewRows, len(res.Rows))] {
+			text.WriteString(fmt.Sprintf("%4d %-10s %s %v %s @ %s\n",
+				row.Line,
+				s.formatDate(env.TgUserID, row.Tx.TransactionDate),
+				strings.ToUpper(row.Tx.Type),
+				row.Tx.AssetAmount,
+				row.Tx.Asset,
+				formatTxPrice(row.Tx.Quote(), row.Tx.QuotePrice, row.Tx.AssetPrice),
+			))
+		}
+		if len(res.Rows) > importPreviewRows {
+			text.WriteString(fmt.Sprintf("… and %d more\n", len(res.Rows)-importPreviewRows))
+		}
+		text.WriteString("```\n")
+	}
+
+	if len(res.Errors) > 0 {
+		text.WriteString("\n*Errors (these rows are skipped):*\n```\n")
+		for _, rowErr := range res.Errors[:min(importPreviewRows, len(res.Errors))] {
+			errText := strings.ReplaceAll(rowErr.Err, "`", "'")
+			if len(errText) > 80 {
+				errText = errText[:80] + "…"
+			}
+			text.WriteString(fmt.Sprintf("line %d: %s\n", rowErr.Line, errText))
+		}
+		if len(res.Errors) > importPreviewRows {
+			text.WriteString(fmt.Sprintf("… and %d more\n", len(res.Errors)-importPreviewRows))
+		}
+		text.WriteString("```\n")
+	}
+
+	var rows [][]fsm.Button
+	if len(res.Rows) > 0 {
+		rows = append(rows, []fsm.Button{{Text: fmt.Sprintf("Import %d rows", len(res.Rows)), Action: "imp_choose_portfolio"}})
+	}
+	rows = append(rows, []fsm.Button{{Text: "Change format", Action: "imp_formats"}})
+
+	return fsm.Prompt{Text: text.String(), Markdown: true, Buttons: rows}, nil
+}
+
+func (s *Service) promptImportPortfolio(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	portfolios, err := s.store.GetPortfolioRefs(ctx, env.DBUserID)
+	if err != nil {
+		return fsm.Prompt{}, fmt.Errorf("get portfolios: %w", err)
+	}
+
+	if len(portfolios) == 0 {
+		return fsm.Prompt{
+			Text:    "You have no portfolios yet. Let's create a new one!",
+			Buttons: [][]fsm.Button{{{Text: "New portfolio", Action: "create_portfolio"}}},
+		}, nil
+	}
+
+	var rows [][]fsm.Button
+	for _, p := range portfolios {
+		rows = append(rows, []fsm.Button{{Text: p.Name, Action: "imp_pf", Arg: strconv.FormatInt(p.ID, 10)}})
+	}
+
+	return fsm.Prompt{Text: "Choose the portfolio to import the transactions into:", Buttons: rows}, nil
+}
+
+func (s *Service) importConfirmed(ctx context.Context, env *flowEnv, value string) (string, error) {
+	portfolioID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("parse portfolio id: %w", err)
+	}
+
+	is := &env.Session.Import
+	if is.FileID == "" || is.isExport() {
+		return "", fsm.Invalid("Nothing to import, please send the file again.")
+	}
+
+	// the file was parsed for the preview already, failing now means it's gone or rates are down
+	res, err := s.readImportFile(ctx, is)
+	if err != nil {
+		log.Error("failed to read import file again", "error", err, "tg_user_id", env.TgUserID)
+		return "", fsm.Invalid("Sorry, couldn't read the file again. Please send it again.")
+	}
+	if len(res.Rows) == 0 {
+		return "", fsm.Invalid("Nothing to import, please send the file again.")
+	}
+
+	txs := make([]t.TempTransactionData, 0, len(res.Rows))
+	for _, row := range res.Rows {
+		txs = append(txs, row.Tx)
+	}
+
+	inserted, skipped, err := s.store.ImportTransactions(ctx, env.DBUserID, portfolioID, txs)
+	if errors.Is(err, store.ErrPortfolioNotFound) {
+		return "", fsm.Invalid("Portfolio not found.")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("transactions imported", "user_id", env.DBUserID, "format", is.Format,
+		"inserted", inserted, "skipped", skipped, "invalid", len(res.Errors))
+
+	env.summary = fmt.Sprintf("✅ Imported %d transaction(s).", inserted)
+	if skipped > 0 {
+		env.summary += fmt.Sprintf("\nSkipped %d already imported.", skipped)
+	}
+	if len(res.Errors) > 0 {
+		env.summary += fmt.Sprintf("\n%d row(s) with errors were not imported.", len(res.Errors))
+	}
+
+	*is = importState{}
+	return "import_done", nil
+}
+
+// fetches a file the user sent, files bigger than limit are refused
+func (s *Service) downloadDocument(ctx context.Context, fileID string, limit int64) ([]byte, error) {
+	fileURL, err := s.bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("get file url: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create file request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,246 @@
+package telegram_bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/internal/costbasis"
+	"gitlab.com/avolkov/wood_post/internal/fsm"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+	"gitlab.com/avolkov/wood_post/store"
+)
+
+// transfer steps of the transaction flow: source portfolio -> destination -> date -> confirm
+
+func (s *Service) promptTransferSource(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
+
+	holdings, err := s.store.GetPortfolioHoldings(ctx, env.DBUserID, txData.Asset)
+	if err != nil {
+		return fsm.Prompt{}, fmt.Errorf("get portfolio holdings: %w", err)
+	}
+
+	var rows [][]fsm.Button
+	for _, h := range holdings {
+		if h.Amount.LessThan(txData.AssetAmount) {
+			continue
+		}
+		rows = append(rows, []fsm.Button{{
+			Text:   fmt.Sprintf("%s (%v %s)", h.Name, h.Amount, txData.Asset),
+			Action: "tr_from", Arg: strconv.FormatInt(h.PortfolioID, 10),
+		}})
+	}
+
+	text := fmt.Sprintf("Choose the portfolio to transfer %v %s from:", txData.AssetAmount, txData.Asset)
+	if len(rows) == 0 {
+		text = fmt.Sprintf("None of your portfolios holds %v %s, nothing to transfer.", txData.AssetAmount, txData.Asset)
+	}
+
+	return fsm.Prompt{Text: text, Buttons: rows}, nil
+}
+
+func (s *Service) transferSourceChosen(ctx context.Context, env *flowEnv, value string) (string, error) {
+	fromID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("parse source portfolio id: %w", err)
+	}
+
+	txData := env.tx()
+
+	holdings, err := s.store.GetPortfolioHoldings(ctx, env.DBUserID, txData.Asset)
+	if err != nil {
+		return "", fmt.Errorf("get portfolio holdings: %w", err)
+	}
+
+	for _, h := range holdings {
+		if h.PortfolioID != fromID {
+			continue
+		}
+
+		// cost basis is known once the date is chosen
+		txData.FromPortfolioID = h.PortfolioID
+		txData.FromPortfolioName = h.Name
+		return "waiting_transfer_destination", nil
+	}
+
+	return "", fsm.Invalid("Portfolio not found.")
+}
+
+func (s *Service) promptTransferDestination(ctx context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
+
+	holdings, err := s.store.GetPortfolioHoldings(ctx, env.DBUserID, txData.Asset)
+	if err != nil {
+		return fsm.Prompt{}, fmt.Errorf("get portfolio holdings: %w", err)
+	}
+
+	var rows [][]fsm.Button
+	for _, h := range holdings {
+		if h.PortfolioID == txData.FromPortfolioID {
+			continue
+		}
+		rows = append(rows, []fsm.Button{{Text: h.Name, Action: "tr_to", Arg: strconv.FormatInt(h.PortfolioID, 10)}})
+	}
+	rows = append(rows, []fsm.Button{{Text: "External wallet", Action: "tr_to", Arg: "external"}})
+
+	return fsm.Prompt{
+		Text: fmt.Sprintf("Where do you move %v %s from %s?",
+			txData.AssetAmount, txData.Asset, txData.FromPortfolioName),
+		Buttons: rows,
+	}, nil
+}
+
+func (s *Service) transferDestinationChosen(ctx context.Context, env *flowEnv, value string) (string, error) {
+	txData := env.tx()
+	txData.ToPortfolioID = 0
+	txData.ToPortfolioName = "External wallet"
+
+	if value == "external" {
+		return "waiting_transaction_date", nil
+	}
+
+	toID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("parse destination portfolio id: %w", err)
+	}
+
+	holdings, err := s.store.GetPortfolioHoldings(ctx, env.DBUserID, txData.Asset)
+	if err != nil {
+		return "", fmt.Errorf("get portfolio holdings: %w", err)
+	}
+
+	for _, h := range holdings {
+		if h.PortfolioID == toID && toID != txData.FromPortfolioID {
+			txData.ToPortfolioID = h.PortfolioID
+			txData.ToPortfolioName = h.Name
+			return "waiting_transaction_date", nil
+		}
+	}
+
+	return "", fsm.Invalid("Portfolio not found.")
+}
+
+func (s *Service) promptTransferConfirmation(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
+
+	text := fmt.Sprintf(
+		"*You are about to add a new transfer. Please confirm:*\n\n"+
+			"🔵 *TRANSFER %s*\n"+
+			"Amount: `%v %s`\n"+
+			"From: `%s`\n"+
+			"To: `%s`\n"+
+			"Cost basis moved: `$%.2f`\n"+
+			"Date: `%s`\n",
+		txData.Asset,
+		txData.AssetAmount,
+		txData.Asset,
+		txData.FromPortfolioName,
+		txData.ToPortfolioName,
+		txData.USDAmount,
+		s.formatDate(env.TgUserID, txData.TransactionDate),
+	)
+
+	return fsm.Prompt{
+		Text:     text,
+		Markdown: true,
+		Buttons:  [][]fsm.Button{{{Text: "Confirm", Action: "tr_confirm_transfer"}}},
+	}, nil
+}
+
+// setTransferCostBasis moves the cost basis of the lots taken from the source portfolio with the
+// user's method. the amount must be held at the transfer date and still be held now, holdings
+// may have changed since the source was chosen
+func (s *Service) setTransferCostBasis(ctx context.Context, dbUserID int64, txData *t.TempTransactionData) error {
+	rawMethod, err := s.store.GetCostBasisMethod(ctx, dbUserID)
+	if err != nil {
+		return err
+	}
+
+	method, err := costbasis.ParseMethod(rawMethod)
+	if err != nil {
+		return err
+	}
+
+	txs, err := s.store.GetTransactionsForUser(ctx, dbUserID, "")
+	if err != nil {
+		return err
+	}
+
+	var held, heldAtDate []t.Transaction
+	for _, tx := range txs {
+		if tx.PortfolioID != txData.FromPortfolioID || tx.Asset != txData.Asset {
+			continue
+		}
+		held = append(held, tx)
+		if !tx.TransactionDate.After(txData.TransactionDate) {
+			heldAtDate = append(heldAtDate, tx)
+		}
+	}
+
+	_, missing, err := lotsCost(method, held, txData.AssetAmount)
+	if err != nil {
+		return err
+	}
+	if missing.IsPositive() {
+		return fsm.Invalid(fmt.Sprintf("%s no longer holds %v %s.",
+			txData.FromPortfolioName, txData.AssetAmount, txData.Asset))
+	}
+
+	cost, missing, err := lotsCost(method, heldAtDate, txData.AssetAmount)
+	if err != nil {
+		return err
+	}
+	if missing.IsPositive() {
+		return fsm.Invalid(fmt.Sprintf("%s didn't hold %v %s on that date yet. Please choose a later date.",
+			txData.FromPortfolioName, txData.AssetAmount, txData.Asset))
+	}
+
+	txData.USDAmount = cost
+	txData.AssetPrice = cost.Div(txData.AssetAmount)
+	return nil
+}
+
+// cost of amount taken from the lots left by txs, all of one asset, and the part of it not held
+func lotsCost(method costbasis.Method, txs []t.Transaction, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	positions, err := costbasis.Replay(method, txs)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	if len(positions) == 0 {
+		return decimal.Zero, amount, nil
+	}
+
+	cost, missing := positions[0].Cost(method, amount)
+	return cost, missing, nil
+}
+
+func (s *Service) transferConfirmed(ctx context.Context, env *flowEnv, _ string) (string, error) {
+	if err := s.setTransferCostBasis(ctx, env.DBUserID, env.tx()); err != nil {
+		return "", err
+	}
+
+	err := s.store.AddTransfer(ctx, env.DBUserID, env.tx())
+	if errors.Is(err, store.ErrPortfolioNotFound) {
+		return "", fsm.Invalid("Portfolio not found. Please go back and choose another one.")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("transfer added successfully", "user_id", env.DBUserID)
+	return "transfer_added", nil
+}
+
+func promptTransferAdded(_ context.Context, env *flowEnv) (fsm.Prompt, error) {
+	txData := env.tx()
+	return fsm.Prompt{
+		Text: fmt.Sprintf("Transfer added successfully: %v %s from %s to %s!",
+			txData.AssetAmount, txData.Asset, txData.FromPortfolioName, txData.ToPortfolioName),
+		TTL: 10 * time.Second,
+	}, nil
+}
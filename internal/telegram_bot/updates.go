@@ -0,0 +1,191 @@
+package telegram_bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+)
+
+// header Telegram sends with the secret_token given to setWebhook
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// receives updates with long polling or with the webhook server and hands them to the dispatcher,
+// returns when ctx is done and no more updates can reach the dispatcher
+func (s *Service) receiveUpdates(ctx context.Context, d *dispatcher) error {
+	switch s.cfg.UpdatesMode {
+	case "webhook":
+		return s.serveWebhook(ctx, d)
+	case "", "polling":
+		return s.pollUpdates(ctx, d)
+	}
+
+	return fmt.Errorf("unknown updates mode: %s", s.cfg.UpdatesMode)
+}
+
+func (s *Service) pollUpdates(ctx context.Context, d *dispatcher) error {
+	// getUpdates doesn't work while a webhook is set, e.g. after switching modes
+	if _, err := s.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	updates := s.bot.GetUpdatesChan(u)
+	log.Info("receiving updates with long polling")
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.bot.StopReceivingUpdates()
+			drainUpdates(updates, d)
+			log.Info("bot stopped receiving updates by context")
+			return nil
+
+		case update, ok := <-updates:
+			if !ok {
+				log.Warn("updates channel closed")
+				return nil
+			}
+
+			// Telegram got the offset past it already, it won't come again,
+			// so it waits for a free queue even on shutdown
+			d.dispatch(context.WithoutCancel(ctx), update)
+		}
+	}
+}
+
+// updates buffered by the library are confirmed to Telegram already,
+// they are handed to the dispatcher instead of being lost on shutdown
+func drainUpdates(updates tgbotapi.UpdatesChannel, d *dispatcher) {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			d.dispatch(context.Background(), update)
+		default:
+			return
+		}
+	}
+}
+
+// serves the webhook until ctx is done. the handler hands updates to the dispatcher itself,
+// so nothing waits in between and an update is confirmed only once the dispatcher has it
+func (s *Service) serveWebhook(ctx context.Context, d *dispatcher) error {
+	hook, err := url.Parse(s.cfg.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("parse webhook url: %w", err)
+	}
+	path := hook.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, webhookHandler(ctx, s.cfg.WebhookSecret, d.dispatch))
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+
+	// listen before setWebhook, so a busy port fails the start instead of losing updates
+	ln, err := net.Listen("tcp", s.cfg.WebhookListen)
+	if err != nil {
+		return fmt.Errorf("listen webhook: %w", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+
+	if err := s.setWebhook(); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	log.Info("receiving updates with webhook", "listen", s.cfg.WebhookListen, "path", path)
+
+	select {
+	case <-ctx.Done():
+	case err := <-served:
+		return fmt.Errorf("webhook server stopped: %w", err)
+	}
+
+	// waits for handlers in flight, their updates are in the dispatcher when it returns
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error("failed to shut down webhook server", "error", err)
+	}
+
+	log.Info("bot stopped receiving updates by context")
+	return nil
+}
+
+// WebhookConfig of the library has no secret_token yet, so the request is built by hand
+func (s *Service) setWebhook() error {
+	params := tgbotapi.Params{
+		"url":          s.cfg.WebhookURL,
+		"secret_token": s.cfg.WebhookSecret,
+	}
+	if err := params.AddInterface("allowed_updates", []string{"message", "callback_query"}); err != nil {
+		return fmt.Errorf("set webhook: %w", err)
+	}
+
+	if _, err := s.bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("set webhook: %w", err)
+	}
+	return nil
+}
+
+// webhookHandler accepts updates only with the right secret token. the response is sent
+// when the update is dispatched, so a full queue slows Telegram down instead of dropping updates
+func webhookHandler(
+	ctx context.Context,
+	secret string,
+	dispatch func(ctx context.Context, update tgbotapi.Update) bool,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			log.Warn("webhook request with wrong secret token", "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+			log.Warn("failed to decode webhook update", "error", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// waits for a free queue until shutdown or until Telegram gives up on the request
+		dispatchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(r.Context(), cancel)
+		defer stop()
+
+		if !dispatch(dispatchCtx, update) {
+			// not handled, Telegram sends it again later
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,287 @@
+package telegram_bot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gitlab.com/avolkov/wood_post/config"
+)
+
+const testUpdate = `{"update_id": 10, "message": {"message_id": 1, "from": {"id": 42}, "chat": {"id": 42}, "text": "hi"}}`
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		secret     string
+		body       string
+		dispatchOK bool
+		wantStatus int
+		wantUpdate bool
+	}{
+		{name: "valid update", method: http.MethodPost, secret: "s3cret", body: testUpdate, dispatchOK: true, wantStatus: http.StatusOK, wantUpdate: true},
+		{name: "not a POST", method: http.MethodGet, secret: "s3cret", wantStatus: http.StatusMethodNotAllowed},
+		{name: "missing secret", method: http.MethodPost, body: testUpdate, wantStatus: http.StatusForbidden},
+		{name: "wrong secret", method: http.MethodPost, secret: "guess", body: testUpdate, wantStatus: http.StatusForbidden},
+		{name: "malformed json", method: http.MethodPost, secret: "s3cret", body: `{"update_id":`, wantStatus: http.StatusBadRequest},
+		{name: "dispatcher gave up", method: http.MethodPost, secret: "s3cret", body: testUpdate, wantStatus: http.StatusServiceUnavailable, wantUpdate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []tgbotapi.Update
+			dispatch := func(_ context.Context, update tgbotapi.Update) bool {
+				got = append(got, update)
+				return tt.dispatchOK
+			}
+
+			req := httptest.NewRequest(tt.method, "/hook", strings.NewReader(tt.body))
+			if tt.secret != "" {
+				req.Header.Set(webhookSecretHeader, tt.secret)
+			}
+			rec := httptest.NewRecorder()
+
+			webhookHandler(context.Background(), "s3cret", dispatch).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !tt.wantUpdate {
+				if len(got) != 0 {
+					t.Errorf("dispatched %d updates, want none", len(got))
+				}
+				return
+			}
+			if len(got) != 1 || got[0].UpdateID != 10 || got[0].Message.From.ID != 42 {
+				t.Errorf("dispatched %+v, want update 10 of user 42", got)
+			}
+		})
+	}
+}
+
+// stubAPI is a Telegram Bot API server that records the calls of the bot
+type stubAPI struct {
+	t       *testing.T
+	srv     *httptest.Server
+	updates string // result of the first getUpdates
+
+	mu    sync.Mutex
+	calls []stubCall
+}
+
+type stubCall struct {
+	method string
+	params url.Values
+}
+
+func newStubAPI(t *testing.T, updates string) *stubAPI {
+	api := &stubAPI{t: t, updates: updates}
+	api.srv = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.srv.Close)
+	return api
+}
+
+func (api *stubAPI) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		api.t.Errorf("bad API request: %v", err)
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	api.mu.Lock()
+	api.calls = append(api.calls, stubCall{method: method, params: r.PostForm})
+	first := len(api.callsOf("getUpdates")) == 1
+	api.mu.Unlock()
+
+	result := "true"
+	switch method {
+	case "getMe":
+		result = `{"id": 1, "is_bot": true, "first_name": "Wood Post", "username": "wood_post_bot"}`
+	case "getUpdates":
+		result = "[]"
+		if first {
+			result = "[" + api.updates + "]"
+		} else {
+			// long polling with nothing new
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	fmt.Fprintf(w, `{"ok": true, "result": %s}`, result)
+}
+
+// api.mu must be held
+func (api *stubAPI) callsOf(method string) []stubCall {
+	var calls []stubCall
+	for _, call := range api.calls {
+		if call.method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (api *stubAPI) methods() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var methods []string
+	for _, call := range api.calls {
+		if len(methods) == 0 || methods[len(methods)-1] != call.method {
+			methods = append(methods, call.method)
+		}
+	}
+	return methods
+}
+
+func (api *stubAPI) bot() *tgbotapi.BotAPI {
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", api.srv.URL+"/bot%s/%s")
+	if err != nil {
+		api.t.Fatalf("create bot: %v", err)
+	}
+	return bot
+}
+
+// runs receiveUpdates with a dispatcher which sends handled updates to the returned channel
+func startReceiving(t *testing.T, s *Service) (handled chan tgbotapi.Update, stop func()) {
+	handled = make(chan tgbotapi.Update, 10)
+	d := newDispatcher(2, 4, func(_ context.Context, update tgbotapi.Update) error {
+		handled <- update
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.start(ctx)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.receiveUpdates(ctx, d)
+	}()
+
+	return handled, func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("receive updates: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("receive updates didn't stop")
+		}
+		d.stop()
+	}
+}
+
+func waitUpdate(t *testing.T, handled <-chan tgbotapi.Update) tgbotapi.Update {
+	t.Helper()
+	select {
+	case update := <-handled:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("update wasn't handled")
+		return tgbotapi.Update{}
+	}
+}
+
+func TestServeWebhook(t *testing.T) {
+	api := newStubAPI(t, "")
+
+	// a free port for the webhook server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	s := &Service{
+		bot: api.bot(),
+		cfg: &config.Config{
+			UpdatesMode:   "webhook",
+			WebhookURL:    "https://bot.example.com/telegram/hook",
+			WebhookListen: addr,
+			WebhookSecret: "s3cret",
+		},
+	}
+	handled, stop := startReceiving(t, s)
+
+	// the webhook is set once the server listens
+	var setWebhook []stubCall
+	for deadline := time.Now().Add(5 * time.Second); len(setWebhook) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("setWebhook wasn't called")
+		}
+		api.mu.Lock()
+		setWebhook = api.callsOf("setWebhook")
+		api.mu.Unlock()
+	}
+
+	params := setWebhook[0].params
+	if params.Get("url") != s.cfg.WebhookURL {
+		t.Errorf("got url %q, want %q", params.Get("url"), s.cfg.WebhookURL)
+	}
+	if params.Get("secret_token") != "s3cret" {
+		t.Errorf("got secret_token %q, want %q", params.Get("secret_token"), "s3cret")
+	}
+	if params.Get("allowed_updates") != `["message","callback_query"]` {
+		t.Errorf("got allowed_updates %q", params.Get("allowed_updates"))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/telegram/hook", strings.NewReader(testUpdate))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set(webhookSecretHeader, "s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post update: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if update := waitUpdate(t, handled); update.UpdateID != 10 {
+		t.Errorf("handled update %d, want 10", update.UpdateID)
+	}
+	stop()
+
+	if got := api.methods(); strings.Join(got, ",") != "getMe,setWebhook" {
+		t.Errorf("got API calls %v, want getMe, setWebhook", got)
+	}
+}
+
+func TestPollUpdates(t *testing.T) {
+	api := newStubAPI(t, testUpdate)
+
+	s := &Service{
+		bot: api.bot(),
+		cfg: &config.Config{UpdatesMode: "polling"},
+	}
+	handled, stop := startReceiving(t, s)
+
+	if update := waitUpdate(t, handled); update.UpdateID != 10 {
+		t.Errorf("handled update %d, want 10", update.UpdateID)
+	}
+	stop()
+
+	got := api.methods()
+	if len(got) < 3 || strings.Join(got[:3], ",") != "getMe,deleteWebhook,getUpdates" {
+		t.Errorf("got API calls %v, want getMe, deleteWebhook, getUpdates", got)
+	}
+
+	// the next poll confirms the update to Telegram
+	api.mu.Lock()
+	polls := api.callsOf("getUpdates")
+	api.mu.Unlock()
+	if len(polls) > 1 && polls[1].params.Get("offset") != "11" {
+		t.Errorf("got offset %q after update 10, want 11", polls[1].params.Get("offset"))
+	}
+}
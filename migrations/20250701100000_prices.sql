@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE IF NOT EXISTS prices (
+    id BIGSERIAL PRIMARY KEY,
+    asset TEXT NOT NULL,  -- Asset ticker like "BTC", "ETH"
+    quote TEXT NOT NULL,  -- Quote currency like "USDT"
+    price_time TIMESTAMP NOT NULL, -- start of the UTC day for daily snapshots
+    price NUMERIC(24,8) NOT NULL,
+    source TEXT NOT NULL, -- price source name like "binance"
+    created_at TIMESTAMP DEFAULT now(),
+    UNIQUE (asset, quote, price_time)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS prices;
+
+-- +goose StatementEnd
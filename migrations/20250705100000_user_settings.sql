@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    cost_basis_method TEXT NOT NULL DEFAULT 'fifo' CHECK (cost_basis_method IN ('fifo', 'lifo', 'average')),
+    updated_at TIMESTAMP DEFAULT now()
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS user_settings;
+
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- existing rows get zero fee, which is what they effectively had
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS fee_amount NUMERIC(18,8) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS fee_currency TEXT NOT NULL DEFAULT 'USD',
+    ADD COLUMN IF NOT EXISTS fee_usd NUMERIC(12,2) NOT NULL DEFAULT 0; -- fee valued at transaction date
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS fee_usd,
+    DROP COLUMN IF EXISTS fee_currency,
+    DROP COLUMN IF EXISTS fee_amount;
+
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- both legs of a transfer share transfer_id, which is the id of the outgoing leg.
+-- transfers to external wallets only have the outgoing leg
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS transfer_id BIGINT,
+    ADD COLUMN IF NOT EXISTS direction TEXT CHECK (direction IN ('in', 'out'));
+
+ALTER TABLE transactions
+    ADD CONSTRAINT transactions_transfer_direction_check
+    CHECK ((type = 'transfer') = (direction IS NOT NULL AND transfer_id IS NOT NULL));
+
+CREATE INDEX IF NOT EXISTS transactions_transfer_id_idx ON transactions (transfer_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM transactions WHERE type = 'transfer';
+
+DROP INDEX IF EXISTS transactions_transfer_id_idx;
+
+ALTER TABLE transactions
+    DROP CONSTRAINT IF EXISTS transactions_transfer_direction_check,
+    DROP COLUMN IF EXISTS direction,
+    DROP COLUMN IF EXISTS transfer_id;
+
+-- +goose StatementEnd
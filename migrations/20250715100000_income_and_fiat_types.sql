@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- income, airdrop: asset received at fair value (asset_price), the value is income
+-- gift: asset received for free, zero cost basis
+-- deposit, withdrawal: fiat moved in or out, asset is the fiat currency and holdings are not affected
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_type_check;
+ALTER TABLE transactions
+    ADD CONSTRAINT transactions_type_check
+    CHECK (type IN ('buy', 'sell', 'transfer', 'income', 'airdrop', 'gift', 'deposit', 'withdrawal'));
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM transactions WHERE type IN ('income', 'airdrop', 'gift', 'deposit', 'withdrawal');
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_type_check;
+ALTER TABLE transactions
+    ADD CONSTRAINT transactions_type_check
+    CHECK (type IN ('buy', 'sell', 'transfer'));
+
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- audit of edits made to transactions, values are stored as text
+CREATE TABLE IF NOT EXISTS transaction_changes (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    field TEXT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    changed_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS transaction_changes_transaction_id_idx ON transaction_changes (transaction_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS transaction_changes;
+
+-- +goose StatementEnd
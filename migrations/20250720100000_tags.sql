@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE IF NOT EXISTS tags (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+-- tag names are case insensitive per user
+CREATE UNIQUE INDEX IF NOT EXISTS tags_user_id_name_idx ON tags (user_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS transaction_tags (
+    transaction_id BIGINT NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (transaction_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS transaction_tags_tag_id_idx ON transaction_tags (tag_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS transaction_tags;
+DROP TABLE IF EXISTS tags;
+
+-- +goose StatementEnd
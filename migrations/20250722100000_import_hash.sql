@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- rows imported from files remember where they came from, so importing the same file again skips them
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS import_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_portfolio_id_import_hash_idx
+    ON transactions (portfolio_id, import_hash)
+    WHERE import_hash IS NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS transactions_portfolio_id_import_hash_idx;
+ALTER TABLE transactions DROP COLUMN IF EXISTS import_hash;
+
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- every transaction keeps the currency it was traded for and the price in it,
+-- asset_price and amount_usd stay in USD and are what reports are built from
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS quote_currency TEXT NOT NULL DEFAULT 'USD',
+    ADD COLUMN IF NOT EXISTS quote_price NUMERIC(36,18);
+
+UPDATE transactions SET quote_price = COALESCE(asset_price, 0) WHERE quote_price IS NULL;
+
+ALTER TABLE transactions
+    ALTER COLUMN quote_price SET NOT NULL,
+    ALTER COLUMN quote_price SET DEFAULT 0;
+
+-- totals converted from other currencies don't fit in cents and twelve digits
+ALTER TABLE transactions
+    ALTER COLUMN amount_usd TYPE NUMERIC(28,8),
+    ALTER COLUMN fee_usd TYPE NUMERIC(28,8);
+
+ALTER TABLE user_settings
+    ADD COLUMN IF NOT EXISTS reporting_currency TEXT NOT NULL DEFAULT 'USD';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE user_settings DROP COLUMN IF EXISTS reporting_currency;
+
+ALTER TABLE transactions
+    ALTER COLUMN amount_usd TYPE NUMERIC(12,2),
+    ALTER COLUMN fee_usd TYPE NUMERIC(12,2);
+
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS quote_price,
+    DROP COLUMN IF EXISTS quote_currency;
+
+-- +goose StatementEnd
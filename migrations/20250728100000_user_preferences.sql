@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE user_settings
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC',
+    ADD COLUMN IF NOT EXISTS date_format TEXT NOT NULL DEFAULT 'YYYY-MM-DD',
+    ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT 'en',
+    ADD COLUMN IF NOT EXISTS message_ttl INTEGER NOT NULL DEFAULT 20 CHECK (message_ttl >= 0);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE user_settings
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS date_format,
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS message_ttl;
+
+-- +goose StatementEnd
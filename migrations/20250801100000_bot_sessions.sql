@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- wizard state of the bot, restored after restarts
+CREATE TABLE IF NOT EXISTS bot_sessions (
+    telegram_id BIGINT PRIMARY KEY,
+    data JSONB NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_bot_sessions_updated_at ON bot_sessions (updated_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS bot_sessions;
+
+-- +goose StatementEnd
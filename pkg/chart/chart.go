@@ -0,0 +1,222 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ColorBlue   = color.RGBA{R: 33, G: 113, B: 181, A: 255}
+	ColorOrange = color.RGBA{R: 230, G: 126, B: 34, A: 255}
+
+	colorBackground = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	colorAxis       = color.RGBA{R: 90, G: 90, B: 90, A: 255}
+	colorGrid       = color.RGBA{R: 225, G: 225, B: 225, A: 255}
+	colorText       = color.RGBA{R: 40, G: 40, B: 40, A: 255}
+)
+
+const (
+	textScale   = 2
+	paddingLeft = 110
+	paddingTop  = 50
+	paddingLow  = 50
+	paddingEnd  = 30
+	gridLines   = 5
+)
+
+// Series is one line of the chart, values are aligned with LineChart.Dates
+type Series struct {
+	Name   string
+	Color  color.RGBA
+	Values []float64
+}
+
+// LineChart renders date based line series into PNG using only the standard library
+type LineChart struct {
+	Width  int
+	Height int
+	Dates  []time.Time
+	Series []Series
+}
+
+func (c *LineChart) PNG() ([]byte, error) {
+	if len(c.Dates) < 2 {
+		return nil, fmt.Errorf("at least 2 points are required, got %d", len(c.Dates))
+	}
+	for _, s := range c.Series {
+		if len(s.Values) != len(c.Dates) {
+			return nil, fmt.Errorf("series %s has %d values for %d dates", s.Name, len(s.Values), len(c.Dates))
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+	fillRect(img, img.Bounds(), colorBackground)
+
+	plot := image.Rect(paddingLeft, paddingTop, c.Width-paddingEnd, c.Height-paddingLow)
+
+	minY, maxY := c.bounds()
+	scaleY := func(v float64) int {
+		return plot.Max.Y - int(math.Round((v-minY)/(maxY-minY)*float64(plot.Dy())))
+	}
+	scaleX := func(i int) int {
+		return plot.Min.X + int(math.Round(float64(i)/float64(len(c.Dates)-1)*float64(plot.Dx())))
+	}
+
+	// horizontal grid with value labels
+	for i := 0; i <= gridLines; i++ {
+		v := minY + (maxY-minY)*float64(i)/gridLines
+		y := scaleY(v)
+		drawLine(img, plot.Min.X, y, plot.Max.X, y, colorGrid)
+
+		label := FormatValue(v)
+		drawText(img, plot.Min.X-10-textWidth(label), y-glyphHeight*textScale/2, label, colorText)
+	}
+
+	// axes
+	drawLine(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, colorAxis)
+	drawLine(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, colorAxis)
+
+	// first, middle and last dates
+	for _, i := range []int{0, (len(c.Dates) - 1) / 2, len(c.Dates) - 1} {
+		label := c.Dates[i].Format("2006-01-02")
+		x := scaleX(i) - textWidth(label)/2
+		x = max(0, min(x, c.Width-textWidth(label)))
+		drawText(img, x, plot.Max.Y+12, label, colorText)
+	}
+
+	// series lines, 2px thick
+	for _, s := range c.Series {
+		for i := 1; i < len(s.Values); i++ {
+			x0, y0 := scaleX(i-1), scaleY(s.Values[i-1])
+			x1, y1 := scaleX(i), scaleY(s.Values[i])
+			drawLine(img, x0, y0, x1, y1, s.Color)
+			drawLine(img, x0, y0+1, x1, y1+1, s.Color)
+		}
+	}
+
+	// legend above the plot
+	x := plot.Min.X
+	for _, s := range c.Series {
+		fillRect(img, image.Rect(x, 18, x+14, 32), s.Color)
+		x += 20
+		drawText(img, x, 20, strings.ToUpper(s.Name), colorText)
+		x += textWidth(s.Name) + 30
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// bounds returns value range of all series with some headroom
+func (c *LineChart) bounds() (float64, float64) {
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, s := range c.Series {
+		for _, v := range s.Values {
+			minY = math.Min(minY, v)
+			maxY = math.Max(maxY, v)
+		}
+	}
+
+	if minY > 0 {
+		minY = 0
+	}
+	if maxY <= minY {
+		maxY = minY + 1
+	}
+	return minY, maxY + (maxY-minY)*0.05
+}
+
+// FormatValue shortens big numbers for axis labels: 1500 -> $1.5K
+func FormatValue(v float64) string {
+	abs := math.Abs(v)
+	switch {
+	case abs >= 1e9:
+		return fmt.Sprintf("$%.1fB", v/1e9)
+	case abs >= 1e6:
+		return fmt.Sprintf("$%.1fM", v/1e6)
+	case abs >= 1e3:
+		return fmt.Sprintf("$%.1fK", v/1e3)
+	default:
+		return fmt.Sprintf("$%.0f", v)
+	}
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawLine uses Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx := abs(x1 - x0)
+	dy := -abs(y1 - y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func drawText(img *image.RGBA, x, y int, text string, c color.RGBA) {
+	for _, r := range strings.ToUpper(text) {
+		glyph, ok := glyphs[r]
+		if !ok {
+			glyph = glyphs[' ']
+		}
+		for gy, row := range glyph {
+			for gx, px := range row {
+				if px != '#' {
+					continue
+				}
+				fillRect(img, image.Rect(
+					x+gx*textScale, y+gy*textScale,
+					x+(gx+1)*textScale, y+(gy+1)*textScale,
+				), c)
+			}
+		}
+		x += (glyphWidth + 1) * textScale
+	}
+}
+
+func textWidth(text string) int {
+	return len([]rune(text)) * (glyphWidth + 1) * textScale
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,414 @@
+// Package decimal is an exact decimal number for money and asset amounts.
+// values are immutable, every operation returns a new one, the zero value is 0
+package decimal
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DivisionPrecision is the number of digits after the point kept by Div
+const DivisionPrecision = 18
+
+// Decimal is value * 10^-scale
+type Decimal struct {
+	value *big.Int // nil means 0
+	scale int32
+}
+
+var Zero = Decimal{}
+
+var (
+	bigTen    = big.NewInt(10)
+	bigOne    = big.NewInt(1)
+	powCache  = map[int32]*big.Int{}
+	maxCached = int32(40)
+)
+
+func init() {
+	p := big.NewInt(1)
+	for i := int32(0); i <= maxCached; i++ {
+		powCache[i] = new(big.Int).Set(p)
+		p.Mul(p, bigTen)
+	}
+}
+
+// 10^n, results must not be modified
+func pow10(n int32) *big.Int {
+	if n <= maxCached {
+		return powCache[n]
+	}
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) int() *big.Int {
+	if d.value == nil {
+		return new(big.Int)
+	}
+	return d.value
+}
+
+func NewFromInt(v int64) Decimal {
+	return Decimal{value: big.NewInt(v)}
+}
+
+// New returns value * 10^-scale
+func New(value int64, scale int32) Decimal {
+	if scale < 0 {
+		return Decimal{value: new(big.Int).Mul(big.NewInt(value), pow10(-scale))}
+	}
+	return Decimal{value: big.NewInt(value), scale: scale}
+}
+
+// NewFromFloat takes the shortest decimal representation of v, it's exact for values typed by people
+// and returned by price APIs, not for results of float arithmetic
+func NewFromFloat(v float64) Decimal {
+	d, err := NewFromString(strconv.FormatFloat(v, 'f', -1, 64))
+	if err != nil {
+		return Zero
+	}
+	return d
+}
+
+// limits of parsed strings, they come from users and files, so "1e2000000000" must not
+// turn into a number with two billion digits
+const (
+	MaxStringDigits   = 100
+	MaxStringExponent = 64
+)
+
+// NewFromString parses "-123.456", exponents ("1.5e-7") are accepted too
+func NewFromString(raw string) (Decimal, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return Zero, fmt.Errorf("decimal: empty string")
+	}
+
+	var exp int64
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return Zero, fmt.Errorf("decimal: wrong exponent in %q", raw)
+		}
+		if e > MaxStringExponent || e < -MaxStringExponent {
+			return Zero, fmt.Errorf("decimal: exponent of %q is out of range", raw)
+		}
+		exp = e
+		s = s[:i]
+	}
+	if len(strings.Replace(strings.TrimLeft(s, "+-"), ".", "", 1)) > MaxStringDigits {
+		return Zero, fmt.Errorf("decimal: %q has too many digits", raw)
+	}
+
+	var scale int64
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		scale = int64(len(s) - i - 1)
+		s = s[:i] + s[i+1:]
+	}
+	if s == "" || s == "-" || s == "+" {
+		return Zero, fmt.Errorf("decimal: can't parse %q", raw)
+	}
+
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Zero, fmt.Errorf("decimal: can't parse %q", raw)
+	}
+
+	scale -= exp
+	if scale < 0 {
+		v.Mul(v, pow10(int32(-scale)))
+		scale = 0
+	}
+	return Decimal{value: v, scale: int32(scale)}, nil
+}
+
+// RequireFromString is NewFromString for constants, it panics on error
+func RequireFromString(raw string) Decimal {
+	d, err := NewFromString(raw)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// both values with the same scale
+func align(a, b Decimal) (*big.Int, *big.Int, int32) {
+	switch {
+	case a.scale == b.scale:
+		return a.int(), b.int(), a.scale
+	case a.scale > b.scale:
+		return a.int(), new(big.Int).Mul(b.int(), pow10(a.scale-b.scale)), a.scale
+	default:
+		return new(big.Int).Mul(a.int(), pow10(b.scale-a.scale)), b.int(), b.scale
+	}
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	a, b, scale := align(d, other)
+	return Decimal{value: new(big.Int).Add(a, b), scale: scale}
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	a, b, scale := align(d, other)
+	return Decimal{value: new(big.Int).Sub(a, b), scale: scale}
+}
+
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{value: new(big.Int).Mul(d.int(), other.int()), scale: d.scale + other.scale}
+}
+
+// Div rounds the result to DivisionPrecision digits after the point, division by zero gives 0
+func (d Decimal) Div(other Decimal) Decimal {
+	return d.DivRound(other, DivisionPrecision)
+}
+
+// DivRound divides and rounds half away from zero to places digits after the point
+func (d Decimal) DivRound(other Decimal, places int32) Decimal {
+	if other.IsZero() {
+		return Zero
+	}
+
+	// d / other = d.value * 10^(other.scale - d.scale) / other.value, one extra digit to round
+	num := new(big.Int).Mul(d.int(), pow10(places+1+other.scale))
+	den := new(big.Int).Mul(other.int(), pow10(d.scale))
+
+	q := new(big.Int).Quo(num, den)
+	return Decimal{value: roundLastDigit(q), scale: places}
+}
+
+// drops the last digit rounding half away from zero
+func roundLastDigit(v *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(v, bigTen, new(big.Int))
+	if r.CmpAbs(big.NewInt(5)) >= 0 {
+		if v.Sign() < 0 {
+			q.Sub(q, bigOne)
+		} else {
+			q.Add(q, bigOne)
+		}
+	}
+	return q
+}
+
+// Round rounds half away from zero to places digits after the point,
+// negative places round to tens, hundreds etc.
+func (d Decimal) Round(places int32) Decimal {
+	if places >= d.scale {
+		return d
+	}
+	v := roundLastDigit(new(big.Int).Quo(d.int(), pow10(d.scale-places-1)))
+	// scale stays non-negative, like New and NewFromString keep it
+	if places < 0 {
+		return Decimal{value: v.Mul(v, pow10(-places))}
+	}
+	return Decimal{value: v, scale: places}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{value: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.Sign() >= 0 {
+		return d
+	}
+	return d.Neg()
+}
+
+func (d Decimal) Sign() int {
+	if d.value == nil {
+		return 0
+	}
+	return d.value.Sign()
+}
+
+func (d Decimal) IsZero() bool     { return d.Sign() == 0 }
+func (d Decimal) IsPositive() bool { return d.Sign() > 0 }
+func (d Decimal) IsNegative() bool { return d.Sign() < 0 }
+
+// Cmp returns -1, 0 or 1 like big.Int.Cmp
+func (d Decimal) Cmp(other Decimal) int {
+	a, b, _ := align(d, other)
+	return a.Cmp(b)
+}
+
+func (d Decimal) Equal(other Decimal) bool              { return d.Cmp(other) == 0 }
+func (d Decimal) LessThan(other Decimal) bool           { return d.Cmp(other) < 0 }
+func (d Decimal) LessThanOrEqual(other Decimal) bool    { return d.Cmp(other) <= 0 }
+func (d Decimal) GreaterThan(other Decimal) bool        { return d.Cmp(other) > 0 }
+func (d Decimal) GreaterThanOrEqual(other Decimal) bool { return d.Cmp(other) >= 0 }
+
+func Min(first Decimal, rest ...Decimal) Decimal {
+	m := first
+	for _, d := range rest {
+		if d.LessThan(m) {
+			m = d
+		}
+	}
+	return m
+}
+
+func Max(first Decimal, rest ...Decimal) Decimal {
+	m := first
+	for _, d := range rest {
+		if d.GreaterThan(m) {
+			m = d
+		}
+	}
+	return m
+}
+
+func Sum(values ...Decimal) Decimal {
+	total := Zero
+	for _, d := range values {
+		total = total.Add(d)
+	}
+	return total
+}
+
+// Float64 is for charts and other places where exactness doesn't matter
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// digits of the absolute value with the point placed, trailing zeros kept
+func (d Decimal) fixed() string {
+	digits := new(big.Int).Abs(d.int()).String()
+	if d.scale <= 0 {
+		return digits
+	}
+	if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.scale)
+	return digits[:point] + "." + digits[point:]
+}
+
+// String is the shortest exact representation, "1.5", "-0.00000001", "42"
+func (d Decimal) String() string {
+	s := d.fixed()
+	if strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if d.Sign() < 0 {
+		return "-" + s
+	}
+	return s
+}
+
+// StringFixed rounds to places digits after the point and keeps trailing zeros, "1.50"
+func (d Decimal) StringFixed(places int32) string {
+	r := d.Round(places)
+	if r.scale < places {
+		r = Decimal{value: new(big.Int).Mul(r.int(), pow10(places-r.scale)), scale: places}
+	}
+	s := r.fixed()
+	if r.Sign() < 0 {
+		return "-" + s
+	}
+	return s
+}
+
+// Format makes decimals work with fmt verbs, %f and %v are exact, %g and %e go through float64
+func (d Decimal) Format(f fmt.State, verb rune) {
+	var s string
+	switch verb {
+	case 'f', 'F':
+		places, ok := f.Precision()
+		if !ok {
+			places = 6
+		}
+		s = d.StringFixed(int32(places))
+	case 'v', 's':
+		s = d.String()
+	case 'g', 'G', 'e', 'E':
+		format := "%"
+		if p, ok := f.Precision(); ok {
+			format += "." + strconv.Itoa(p)
+		}
+		s = fmt.Sprintf(format+string(verb), d.Float64())
+	default:
+		fmt.Fprintf(f, "%%!%c(decimal.Decimal=%s)", verb, d.String())
+		return
+	}
+
+	if f.Flag('+') && !strings.HasPrefix(s, "-") {
+		s = "+" + s
+	}
+
+	if width, ok := f.Width(); ok && len(s) < width {
+		pad := strings.Repeat(" ", width-len(s))
+		switch {
+		case f.Flag('-'):
+			s += pad
+		case f.Flag('0'):
+			sign := ""
+			if s[0] == '-' || s[0] == '+' {
+				sign, s = s[:1], s[1:]
+			}
+			s = sign + strings.Repeat("0", width-len(s)-len(sign)) + s
+		default:
+			s = pad + s
+		}
+	}
+
+	_, _ = f.Write([]byte(s))
+}
+
+// Scan reads NUMERIC columns, NULL is 0
+func (d *Decimal) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Zero
+		return nil
+	case []byte:
+		parsed, err := NewFromString(string(v))
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	case string:
+		parsed, err := NewFromString(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	case int64:
+		*d = NewFromInt(v)
+		return nil
+	case float64:
+		*d = NewFromFloat(v)
+		return nil
+	}
+	return fmt.Errorf("decimal: can't scan %T", src)
+}
+
+// Value stores the exact string, postgres converts it to NUMERIC
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// MarshalJSON writes a JSON number with all digits
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts numbers and quoted numbers
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*d = Zero
+		return nil
+	}
+	parsed, err := NewFromString(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
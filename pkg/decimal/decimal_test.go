@@ -0,0 +1,312 @@
+package decimal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewFromString(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "123.456", want: "123.456"},
+		{raw: "-0.00000001", want: "-0.00000001"},
+		{raw: " 42 ", want: "42"},
+		{raw: "+7.50", want: "7.5"},
+		{raw: ".5", want: "0.5"},
+		{raw: "1.5e-7", want: "0.00000015"},
+		{raw: "2E3", want: "2000"},
+		{raw: "-1.25e+2", want: "-125"},
+		{raw: "", wantErr: true},
+		{raw: "-", wantErr: true},
+		{raw: "1.2.3", wantErr: true},
+		{raw: "1e", wantErr: true},
+		{raw: "abc", wantErr: true},
+		{raw: "1e64", want: "1" + strings.Repeat("0", 64)},
+		{raw: "1e2000000000", wantErr: true},
+		{raw: "1e-65", wantErr: true},
+		{raw: strings.Repeat("9", 101), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := NewFromString(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := RequireFromString("0.1")
+	b := RequireFromString("0.2")
+
+	if got := a.Add(b); !got.Equal(RequireFromString("0.3")) {
+		t.Errorf("0.1 + 0.2 = %s, want 0.3", got)
+	}
+	if got := a.Sub(b); got.String() != "-0.1" {
+		t.Errorf("0.1 - 0.2 = %s, want -0.1", got)
+	}
+	if got := RequireFromString("1.5").Mul(RequireFromString("-0.02")); got.String() != "-0.03" {
+		t.Errorf("1.5 * -0.02 = %s, want -0.03", got)
+	}
+	if got := NewFromInt(10).Sub(RequireFromString("10.00")); !got.IsZero() || got.Sign() != 0 {
+		t.Errorf("10 - 10.00 = %s, want 0", got)
+	}
+	if !RequireFromString("1.50").Equal(RequireFromString("1.5")) {
+		t.Error("1.50 != 1.5")
+	}
+	if RequireFromString("-2").Cmp(RequireFromString("-1.999")) != -1 {
+		t.Error("-2 is not less than -1.999")
+	}
+	if got := Sum(a, b, NewFromInt(-1)); got.String() != "-0.7" {
+		t.Errorf("sum = %s, want -0.7", got)
+	}
+	if got := Max(a, b, NewFromInt(-1)); !got.Equal(b) {
+		t.Errorf("max = %s, want 0.2", got)
+	}
+	if got := Min(a, b, NewFromInt(-1)); got.String() != "-1" {
+		t.Errorf("min = %s, want -1", got)
+	}
+	if got := Zero.Add(New(5, 2)); got.String() != "0.05" {
+		t.Errorf("zero value + 0.05 = %s", got)
+	}
+}
+
+func TestDivRound(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		places int32
+		want   string
+	}{
+		{a: "1", b: "3", places: 2, want: "0.33"},
+		{a: "2", b: "3", places: 2, want: "0.67"},
+		{a: "-2", b: "3", places: 2, want: "-0.67"},
+		{a: "2", b: "-3", places: 2, want: "-0.67"},
+		{a: "-2", b: "-3", places: 2, want: "0.67"},
+		{a: "1", b: "8", places: 2, want: "0.13"}, // 0.125, half away from zero
+		{a: "-1", b: "8", places: 2, want: "-0.13"},
+		{a: "1", b: "8", places: 3, want: "0.125"},
+		{a: "1.5", b: "0.25", places: 2, want: "6"},
+		{a: "0.0001", b: "3", places: 4, want: "0"},
+		{a: "100", b: "7", places: 0, want: "14"},
+		{a: "29", b: "2", places: 0, want: "15"},
+		{a: "-29", b: "2", places: 0, want: "-15"},
+		{a: "5", b: "0", places: 2, want: "0"}, // division by zero
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s@%d", tt.a, tt.b, tt.places), func(t *testing.T) {
+			got := RequireFromString(tt.a).DivRound(RequireFromString(tt.b), tt.places)
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := NewFromInt(1).Div(NewFromInt(3)); got.String() != "0.333333333333333333" {
+		t.Errorf("Div keeps %d places, got %s", DivisionPrecision, got)
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		value  string
+		places int32
+		want   string
+	}{
+		{value: "1.25", places: 1, want: "1.3"},
+		{value: "1.24", places: 1, want: "1.2"},
+		{value: "-1.25", places: 1, want: "-1.3"},
+		{value: "-1.24", places: 1, want: "-1.2"},
+		{value: "-1.2549", places: 2, want: "-1.25"},
+		{value: "-1.2450001", places: 2, want: "-1.25"},
+		{value: "-0.004", places: 2, want: "0"},
+		{value: "-0.005", places: 2, want: "-0.01"},
+		{value: "2.5", places: 0, want: "3"},
+		{value: "-2.5", places: 0, want: "-3"},
+		{value: "1.5", places: 4, want: "1.5"}, // fewer digits than places
+		{value: "-125", places: -1, want: "-130"},
+		{value: "1234", places: -2, want: "1200"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s@%d", tt.value, tt.places), func(t *testing.T) {
+			got := RequireFromString(tt.value).Round(tt.places)
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		value Decimal
+		want  string
+		fixed string // StringFixed(2)
+	}{
+		{value: Zero, want: "0", fixed: "0.00"},
+		{value: New(0, 4), want: "0", fixed: "0.00"},
+		{value: New(150, 2), want: "1.5", fixed: "1.50"},
+		{value: New(-1, 8), want: "-0.00000001", fixed: "0.00"},
+		{value: New(-1005, 3), want: "-1.005", fixed: "-1.01"},
+		{value: New(12, -2), want: "1200", fixed: "1200.00"},
+		{value: NewFromInt(-42), want: "-42", fixed: "-42.00"},
+		{value: RequireFromString("99999999999999999999.995"), want: "99999999999999999999.995", fixed: "100000000000000000000.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.value.String(); got != tt.want {
+				t.Errorf("String() = %s, want %s", got, tt.want)
+			}
+			if got := tt.value.StringFixed(2); got != tt.fixed {
+				t.Errorf("StringFixed(2) = %s, want %s", got, tt.fixed)
+			}
+		})
+	}
+
+	if got := RequireFromString("2.5").StringFixed(0); got != "3" {
+		t.Errorf("StringFixed(0) = %s, want 3", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	d := RequireFromString("-1.5")
+
+	tests := []struct {
+		format string
+		value  Decimal
+		want   string
+	}{
+		{format: "%v", value: d, want: "-1.5"},
+		{format: "%s", value: d, want: "-1.5"},
+		{format: "%.2f", value: d, want: "-1.50"},
+		{format: "%f", value: d, want: "-1.500000"},
+		{format: "%8.2f|", value: d, want: "   -1.50|"},
+		{format: "%-8.2f|", value: d, want: "-1.50   |"},
+		{format: "%08.2f", value: d, want: "-0001.50"},
+		{format: "%+.1f", value: NewFromInt(2), want: "+2.0"},
+		{format: "%g", value: d, want: "-1.5"},
+		{format: "%d", value: d, want: "%!d(decimal.Decimal=-1.5)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := fmt.Sprintf(tt.format, tt.value); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		want    string
+		wantErr bool
+	}{
+		{name: "NUMERIC as bytes", src: []byte("123.45000000"), want: "123.45"},
+		{name: "negative NUMERIC", src: []byte("-0.00000001"), want: "-0.00000001"},
+		{name: "string", src: "42.1", want: "42.1"},
+		{name: "int64", src: int64(-7), want: "-7"},
+		{name: "float64", src: 0.1, want: "0.1"},
+		{name: "NULL", src: nil, want: "0"},
+		{name: "garbage", src: []byte("NaN"), wantErr: true},
+		{name: "unsupported type", src: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFromInt(99) // Scan must overwrite it
+			err := d.Scan(tt.src)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %s, want error", d)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.String() != tt.want {
+				t.Errorf("got %s, want %s", d, tt.want)
+			}
+		})
+	}
+
+	v, err := RequireFromString("-1.50").Value()
+	if err != nil || v != "-1.5" {
+		t.Errorf("Value() = %v, %v, want -1.5", v, err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type row struct {
+		Amount Decimal  `json:"amount"`
+		Price  Decimal  `json:"price"`
+		Fee    *Decimal `json:"fee,omitempty"`
+	}
+
+	in := row{
+		Amount: RequireFromString("0.00000001"),
+		Price:  RequireFromString("-65432.10"),
+	}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(data) != `{"amount":0.00000001,"price":-65432.1}` {
+		t.Errorf("got %s", data)
+	}
+
+	var out row
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !out.Amount.Equal(in.Amount) || !out.Price.Equal(in.Price) {
+		t.Errorf("round trip gave %s, %s", out.Amount, out.Price)
+	}
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: `{"amount":"1.5"}`, want: "1.5"},
+		{raw: `{"amount":1e-8}`, want: "0.00000001"},
+		{raw: `{"amount":null}`, want: "0"},
+		{raw: `{"amount":12345678901234567890.123456789}`, want: "12345678901234567890.123456789"},
+	}
+	for _, tt := range tests {
+		var got row
+		if err := json.Unmarshal([]byte(tt.raw), &got); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.raw, err)
+			continue
+		}
+		if got.Amount.String() != tt.want {
+			t.Errorf("unmarshal %s = %s, want %s", tt.raw, got.Amount, tt.want)
+		}
+	}
+
+	var bad row
+	if err := json.Unmarshal([]byte(`{"amount":"lots"}`), &bad); err == nil {
+		t.Error("unmarshal of a word succeeded")
+	}
+}
@@ -13,10 +13,17 @@ const ServiceDescription = `*🤖 Wood Post - Crypto Portfolio Tracker*
 
 💰 *Transaction Tracking*
 • You add transactions to default portfolio every time so change default portfolion if you want to add transactions to another one
-• Record BUY/SELL transactions
+• Record BUY/SELL transactions and transfers between portfolios
+• Track rewards, airdrops, gifts and fiat deposits/withdrawals
 • Support for all major crypto pairs (BTCUSDT, ETHUSDT, etc.)
-• Automatic USD value calculation
+• Prices in USD, stablecoins, EUR or BTC with automatic USD value calculation
+• Reports in USD, EUR, BTC or ETH
 • View your last 5 transactions with beautiful formatting
+• Import exchange CSV files with /import and export all your data with /export
+
+⚙️ *Settings*
+• Reporting currency, timezone, date format and cost basis method
+• Choose how long bot messages stay in chat
 
 📊 *Smart Features*
 • Remembers your most-used trading pairs
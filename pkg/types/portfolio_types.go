@@ -1,12 +1,35 @@
 package types
 
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxPortfolioNameLength is the longest portfolio name, longer ones are cut
+const MaxPortfolioNameLength = 40
+
+var (
+	portfolioNameJunk       = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+	portfolioNameUnderscore = regexp.MustCompile(`_+`)
+)
+
+// PrettyPortfolioName turns any text into a portfolio name: lower case latin letters, digits
+// and single underscores, at most MaxPortfolioNameLength long. empty when nothing is left
+func PrettyPortfolioName(portfolioName string) string {
+	pName := portfolioNameJunk.ReplaceAllString(strings.ReplaceAll(portfolioName, " ", "_"), "")
+	pName = portfolioNameUnderscore.ReplaceAllString(strings.ToLower(pName), "_")
+
+	if len(pName) > MaxPortfolioNameLength {
+		pName = pName[:MaxPortfolioNameLength]
+	}
+
+	return pName
+}
+
 type ConfirmationTemplateType struct {
 	MessageText     string
 	ConfirmText     string
 	ConfirmCallback string
-	CancelText      string
-	CancelCallback  string
-	NextState       string
 }
 
 var ConfirmationTemplates = map[string]ConfirmationTemplateType{
@@ -14,25 +37,16 @@ var ConfirmationTemplates = map[string]ConfirmationTemplateType{
 		MessageText:     "Are you sure you want to rename portfolio *'%s'* to *'%s'*?",
 		ConfirmText:     "Yes, rename",
 		ConfirmCallback: "confirm_portfolio_rename",
-		CancelText:      "Cancel",
-		CancelCallback:  "cancel_action",
-		NextState:       "waiting_rename_portfolio_decision",
 	},
 	"delete_portfolio": {
 		MessageText:     "Are you sure? This will permanently delete the portfolio *'%s'* and its transactions.",
 		ConfirmText:     "Yes, delete",
 		ConfirmCallback: "confirm_portfolio_deletion",
-		CancelText:      "Cancel",
-		CancelCallback:  "cancel_action",
-		NextState:       "waiting_delete_portfolio_decision",
 	},
 	"change_default_portfolio": {
 		MessageText:     "Are you sure you want to set *'%s'* as *default* portfolio?",
 		ConfirmText:     "Yes, change default",
 		ConfirmCallback: "confirm_portfolio_change_default",
-		CancelText:      "Cancel",
-		CancelCallback:  "cancel_action",
-		// NextState:       "waiting_delete_portfolio_decision",
 	},
 }
 
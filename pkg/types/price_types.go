@@ -0,0 +1,19 @@
+package types
+
+import (
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
+// represents a stored price snapshot
+type PriceRecord struct {
+	Asset  string
+	Quote  string
+	Time   time.Time // start of the UTC day for daily snapshots
+	Price  decimal.Decimal
+	Source string
+}
+
+// currencies reports can be shown in, amounts are converted from USD with stored rates
+var ReportingCurrencies = []string{"USD", "EUR", "BTC", "ETH"}
@@ -1,26 +1,42 @@
 package types
 
+import (
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
+
 // represents PnL data for a specific asset across all portfolios
 type CurrencyPnLData struct {
-	Asset                string  // "BTC", "ETH"
-	TotalAssetAmount     float64 // Total BTC held (sum of all BUY - SELL)
-	TotalInvestedUSD     float64 // Total USD invested (sum of all BUY transactions)
-	CurrentPrice         float64 // Current price from Binance API
-	CurrentValueUSD      float64 // TotalAssetAmount * CurrentPrice
-	PnLUSD               float64 // CurrentValueUSD - TotalInvestedUSD
-	PnLPercentage        float64 // ((CurrentValueUSD / TotalInvestedUSD) - 1) * 100
-	AveragePurchasePrice float64 // TotalInvestedUSD / TotalAssetAmount
-	LastUpdated          string  // Current date
+	Asset                string          // "BTC", "ETH"
+	TotalAssetAmount     decimal.Decimal // Amount still held after matching sales against lots
+	TotalInvestedUSD     decimal.Decimal // Cost basis of the amount still held
+	CurrentPrice         decimal.Decimal // Current price from the price sources chain
+	PriceSource          string          // Name of the source that priced the asset
+	PriceUpdatedAt       time.Time       // When the price was fetched, cached quotes may be older than the report
+	CurrentValueUSD      decimal.Decimal // TotalAssetAmount * CurrentPrice
+	RealizedPnLUSD       decimal.Decimal // Sum of gains of all matched sales
+	UnrealizedPnLUSD     decimal.Decimal // CurrentValueUSD - TotalInvestedUSD
+	PnLUSD               decimal.Decimal // RealizedPnLUSD + UnrealizedPnLUSD
+	PnLPercentage        decimal.Decimal // ((CurrentValueUSD / TotalInvestedUSD) - 1) * 100
+	AveragePurchasePrice decimal.Decimal // TotalInvestedUSD / TotalAssetAmount
+	LastUpdated          string          // Current date
 }
 
 // represents the complete general report data
 type GeneralReport struct {
-	CurrencyData       []CurrencyPnLData
-	TotalInvestedUSD   float64 // Sum of all investments
-	TotalCurrentUSD    float64 // Sum of all current values
-	TotalPnLUSD        float64 // Total profit/loss
-	TotalPnLPercentage float64 // Overall PnL percentage
-	LastUpdated        string  // Report generation date
+	CurrencyData          []CurrencyPnLData
+	TotalInvestedUSD      decimal.Decimal   // Cost basis of all holdings
+	TotalCurrentUSD       decimal.Decimal   // Sum of all current values
+	TotalRealizedPnLUSD   decimal.Decimal   // Realized PnL of all assets, including closed positions
+	TotalUnrealizedPnLUSD decimal.Decimal   // TotalCurrentUSD - TotalInvestedUSD
+	TotalPnLUSD           decimal.Decimal   // Total profit/loss
+	TotalPnLPercentage    decimal.Decimal   // Overall unrealized PnL percentage
+	CostBasisMethod       string            // Method used to match sales against buys
+	Tag                   string            // Only transactions with this tag are included, empty for all
+	LastUpdated           string            // Report generation date
+	UnpricedAssets        []CurrencyPnLData // Assets no price source could price, not included in totals
+	ClosedPositions       []CurrencyPnLData // Fully sold assets, only realized PnL is meaningful
 }
 
 // represents the response from Binance API
@@ -31,8 +47,8 @@ type BinancePriceResponse struct {
 
 type PortfolioAsset struct {
 	Asset       string
-	TotalAmount float64
-	TotalUSD    float64
+	TotalAmount decimal.Decimal
+	TotalUSD    decimal.Decimal
 }
 
 type PortfolioSummary struct {
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// user preferences, stored in user_settings
+type UserSettings struct {
+	CostBasisMethod   string
+	ReportingCurrency string
+	Timezone          string // IANA name, e.g. Europe/Berlin
+	DateFormat        string // name from DateFormats, e.g. DD.MM.YYYY
+	Language          string
+	MessageTTL        time.Duration // how long bot messages stay in chat, 0 keeps them
+}
+
+type DateFormat struct {
+	Name   string // shown to users, e.g. YYYY-MM-DD
+	Layout string // go layout
+}
+
+var DateFormats = []DateFormat{
+	{Name: "YYYY-MM-DD", Layout: "2006-01-02"},
+	{Name: "DD.MM.YYYY", Layout: "02.01.2006"},
+	{Name: "MM/DD/YYYY", Layout: "01/02/2006"},
+}
+
+type Language struct {
+	Code string
+	Name string
+}
+
+// only english texts exist for now
+var Languages = []Language{
+	{Code: "en", Name: "English"},
+}
+
+// zones offered as buttons, any other IANA name can be typed
+var Timezones = []string{
+	"UTC",
+	"Europe/London",
+	"Europe/Berlin",
+	"Europe/Moscow",
+	"Asia/Dubai",
+	"Asia/Singapore",
+	"Asia/Tokyo",
+	"America/New_York",
+	"America/Chicago",
+	"America/Los_Angeles",
+}
+
+var MessageTTLs = []time.Duration{
+	20 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	0,
+}
+
+// user's timezone, UTC when unknown
+func (us UserSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(us.Timezone)
+	if err != nil || us.Timezone == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// current time in user's timezone
+func (us UserSettings) Now() time.Time {
+	return time.Now().In(us.Location())
+}
+
+// user's wall clock time as a UTC value. transaction dates are stored
+// without timezone, so "today" has to be the user's today
+func (us UserSettings) WallClockNow() time.Time {
+	now := us.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(),
+		now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC)
+}
+
+func (us UserSettings) DateLayout() string {
+	for _, f := range DateFormats {
+		if f.Name == us.DateFormat {
+			return f.Layout
+		}
+	}
+	return DateFormats[0].Layout
+}
+
+func (us UserSettings) FormatDate(d time.Time) string {
+	return d.Format(us.DateLayout())
+}
+
+func (us UserSettings) FormatDateTime(d time.Time) string {
+	return d.In(us.Location()).Format(us.DateLayout() + " 15:04:05")
+}
+
+// parses date in user's format, YYYY-MM-DD is always accepted
+func (us UserSettings) ParseDate(text string) (time.Time, error) {
+	if d, err := time.Parse(us.DateLayout(), text); err == nil {
+		return d, nil
+	}
+	if d, err := time.Parse(DateFormats[0].Layout, text); err == nil {
+		return d, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected %s", text, us.DateFormat)
+}
+
+// e.g. 20 sec, 5 min, never
+func FormatMessageTTL(ttl time.Duration) string {
+	switch {
+	case ttl <= 0:
+		return "never"
+	case ttl < time.Minute:
+		return fmt.Sprintf("%d sec", int(ttl.Seconds()))
+	default:
+		return fmt.Sprintf("%d min", int(ttl.Minutes()))
+	}
+}
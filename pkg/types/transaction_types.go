@@ -1,29 +1,215 @@
 package types
 
-import "time"
+import (
+	"slices"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+)
 
 type TempTransactionData struct {
 	ID              int64
 	Asset           string
 	Type            string
-	AssetAmount     float64
-	AssetPrice      float64
-	USDAmount       float64
+	AssetAmount     decimal.Decimal
+	AssetPrice      decimal.Decimal // USD price
+	QuoteCurrency   string          // currency the asset was traded for, USD when empty
+	QuotePrice      decimal.Decimal // price in QuoteCurrency
+	USDAmount       decimal.Decimal
+	FeeAmount       decimal.Decimal
+	FeeCurrency     string
+	FeeUSD          decimal.Decimal
 	TransactionDate time.Time
+	MarketPrice     decimal.Decimal // historical price suggested for TransactionDate, 0 if unknown
+	MarketSource    string          // where MarketPrice comes from
+	Note            string
+	Tags            []string
+	ImportHash      string // identifies rows imported from files, empty for manual input
+
+	// transfer only
+	FromPortfolioID   int64
+	FromPortfolioName string
+	ToPortfolioID     int64 // 0 means external wallet
+	ToPortfolioName   string
 }
 
 // represents a complete transaction for display purposes
 type Transaction struct {
 	ID              int64
+	PortfolioID     int64
 	PortfolioName   string
 	Type            string
 	Asset           string
-	AssetAmount     float64
-	AssetPrice      float64
-	USDAmount       float64
+	AssetAmount     decimal.Decimal
+	AssetPrice      decimal.Decimal // USD price
+	QuoteCurrency   string          // currency the asset was traded for
+	QuotePrice      decimal.Decimal // price in QuoteCurrency
+	USDAmount       decimal.Decimal
+	FeeAmount       decimal.Decimal
+	FeeCurrency     string
+	FeeUSD          decimal.Decimal
 	TransactionDate time.Time
 	Note            string
 	CreatedAt       time.Time
+	TransferID      int64  // shared by both legs of a transfer, 0 for other types
+	Direction       string // "in" or "out" for transfers
+	Tags            []string
+	ImportHash      string // set for rows imported from files
+}
+
+type Tag struct {
+	ID    int64
+	Name  string
+	Count int // number of transactions with the tag
+}
+
+// filters of the transaction history, zero values mean no filter
+type TransactionFilter struct {
+	PortfolioID int64
+	Asset       string
+	Type        string
+	Tag         string
+	From        time.Time // inclusive day
+	To          time.Time // inclusive day
+	OldestFirst bool      // newest first by default
+}
+
+// position in the history for keyset pagination
+type TransactionCursor struct {
+	Date time.Time
+	ID   int64
+}
+
+type Portfolio struct {
+	ID          int64
+	Name        string
+	Description string
+	IsDefault   bool
+	CreatedAt   time.Time
+}
+
+type PortfolioRef struct {
+	ID   int64
+	Name string
+}
+
+const (
+	TransferIn  = "in"
+	TransferOut = "out"
+)
+
+// amount and cost basis of one asset held in a portfolio
+type PortfolioHolding struct {
+	PortfolioID int64
+	Name        string
+	Amount      decimal.Decimal
+	CostUSD     decimal.Decimal
+}
+
+// returns 1 for transaction types that add asset to a portfolio, -1 for those that remove it
+// and 0 for fiat flows which never touch holdings, transfers depend on direction of the leg
+func AmountSign(txType, direction string) int {
+	switch txType {
+	case "buy", "income", "airdrop", "gift":
+		return 1
+	case "transfer":
+		if direction == TransferIn {
+			return 1
+		}
+		return -1
+	case "deposit", "withdrawal":
+		return 0
+	}
+	return -1
+}
+
+// income and airdrops are acquired at fair value and that value is income, not a trading gain
+func IsIncome(txType string) bool {
+	return txType == "income" || txType == "airdrop"
+}
+
+// fiat deposits and withdrawals only move cash in and out, asset is always a fiat currency
+func IsFiatFlow(txType string) bool {
+	return txType == "deposit" || txType == "withdrawal"
+}
+
+const FiatUSD = "USD"
+
+func (tx Transaction) Sign() int {
+	return AmountSign(tx.Type, tx.Direction)
+}
+
+// NetUSD is the cost of an acquisition or the proceeds of a disposal after fee
+func (tx Transaction) NetUSD() decimal.Decimal {
+	if tx.Sign() > 0 {
+		return tx.USDAmount.Add(tx.FeeUSD)
+	}
+	return tx.USDAmount.Sub(tx.FeeUSD)
+}
+
+const FeeCurrencyUSD = "USD"
+
+// limits of amounts, prices and fees, typed or imported. they keep values and their USD totals
+// inside the numeric columns of transactions
+var (
+	MinTransactionNumber = decimal.New(1, 8)
+	MaxAssetAmount       = decimal.NewFromInt(1000000000)
+	MaxAssetPrice        = decimal.NewFromInt(10000000)
+)
+
+// dollar stablecoins are counted as USD everywhere
+var usdCurrencies = []string{"USD", "USDT", "USDC", "BUSD", "FDUSD"}
+
+func IsUSD(currency string) bool {
+	return currency == "" || slices.Contains(usdCurrencies, currency)
+}
+
+// Quote returns the quote currency, USD for rows entered before currencies were recorded
+func (tx TempTransactionData) Quote() string {
+	if tx.QuoteCurrency == "" {
+		return FiatUSD
+	}
+	return tx.QuoteCurrency
+}
+
+// ApplyQuoteRate fills the USD price, total and fee from the quote price,
+// rate is the USD value of one unit of the quote currency
+func (tx *TempTransactionData) ApplyQuoteRate(rate decimal.Decimal) {
+	tx.AssetPrice = tx.QuotePrice.Mul(rate).Round(8)
+	tx.USDAmount = tx.AssetAmount.Mul(tx.QuotePrice).Mul(rate)
+	tx.FeeUSD, _ = tx.FeeToUSD()
+}
+
+// FeeToUSD values the fee paid in USD, in the traded asset or in the quote currency,
+// ok is false for any other currency
+func (tx TempTransactionData) FeeToUSD() (decimal.Decimal, bool) {
+	switch {
+	case IsUSD(tx.FeeCurrency):
+		return tx.FeeAmount, true
+	case tx.FeeCurrency == tx.Asset:
+		return tx.FeeAmount.Mul(tx.AssetPrice), true
+	case tx.FeeCurrency == tx.QuoteCurrency && tx.QuotePrice.IsPositive():
+		return tx.FeeAmount.Mul(tx.AssetPrice).Div(tx.QuotePrice), true
+	}
+	return decimal.Zero, false
+}
+
+// QuotedPrice is a price in the currency of the trade together with its USD value
+type QuotedPrice struct {
+	Currency string
+	Price    decimal.Decimal
+	USD      decimal.Decimal
+}
+
+func (p QuotedPrice) String() string {
+	return p.Price.String() + " " + p.Currency
+}
+
+// RepricedDate is a new date of a trade priced in another currency than USD,
+// Price.USD is the price valued with the rate of that date
+type RepricedDate struct {
+	Date  time.Time
+	Price QuotedPrice
 }
 
 var DefaultCryptoPairs = []string{
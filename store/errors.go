@@ -6,4 +6,12 @@ import "errors"
 var (
 	ErrPortfolioLimitReached = errors.New("portfolio limit reached")
 	ErrPortfolioNameExists   = errors.New("portfolio with this name already exists")
+	ErrPriceNotFound         = errors.New("price not found")
+	ErrPortfolioNotFound     = errors.New("portfolio not found")
+	ErrPortfolioNameInvalid  = errors.New("portfolio name has no latin letters or digits")
+	ErrTransactionNotFound   = errors.New("transaction not found")
+	ErrTransferNotEditable   = errors.New("transfer legs can't be edited")
+	ErrTagNotFound           = errors.New("tag not found")
+	ErrTagExists             = errors.New("tag with this name already exists")
+	ErrSessionNotFound       = errors.New("session not found")
 )
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// every write and read by id must stay inside the user's own portfolios
+func TestCrossUserAccessIsRejected(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	alice, alicePortfolio := createTestUser(t, s, 1001)
+	bob, bobPortfolio := createTestUser(t, s, 1002)
+	bobSecond := createTestPortfolio(t, s, bob, "cold")
+
+	bobTx := addTestTransaction(t, s, bob, bobPortfolio, types.TempTransactionData{Tags: []string{"dca"}})
+	aliceTx := addTestTransaction(t, s, alice, alicePortfolio, types.TempTransactionData{})
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{
+			name:    "delete other user's transaction",
+			call:    func() error { return s.DeleteTransaction(ctx, alice, bobTx) },
+			wantErr: ErrTransactionNotFound,
+		},
+		{
+			name: "get other user's transaction",
+			call: func() error {
+				_, err := s.GetTransactionByID(ctx, alice, bobTx)
+				return err
+			},
+			wantErr: ErrTransactionNotFound,
+		},
+		{
+			name:    "update other user's transaction",
+			call:    func() error { return s.UpdateTransaction(ctx, alice, bobTx, "note", "mine now") },
+			wantErr: ErrTransactionNotFound,
+		},
+		{
+			name:    "move other user's transaction",
+			call:    func() error { return s.UpdateTransaction(ctx, alice, bobTx, "portfolio", alicePortfolio) },
+			wantErr: ErrTransactionNotFound,
+		},
+		{
+			name:    "move own transaction into other user's portfolio",
+			call:    func() error { return s.UpdateTransaction(ctx, alice, aliceTx, "portfolio", bobPortfolio) },
+			wantErr: ErrPortfolioNotFound,
+		},
+		{
+			name:    "tag other user's transaction",
+			call:    func() error { return s.SetTransactionTags(ctx, alice, bobTx, []string{"stolen"}) },
+			wantErr: ErrTransactionNotFound,
+		},
+		{
+			name: "add transaction into other user's portfolio",
+			call: func() error {
+				tx := types.TempTransactionData{
+					Type:            "buy",
+					Asset:           "ETH",
+					AssetAmount:     decimal.NewFromInt(2),
+					AssetPrice:      decimal.NewFromInt(3000),
+					USDAmount:       decimal.NewFromInt(6000),
+					TransactionDate: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+					Tags:            []string{"sneaky"},
+				}
+				return s.AddNewTransaction(ctx, alice, int(bobPortfolio), &tx)
+			},
+			wantErr: ErrPortfolioNotFound,
+		},
+		{
+			name:    "transfer from other user's portfolio",
+			call:    func() error { return s.AddTransfer(ctx, alice, testTransfer(bobPortfolio, alicePortfolio)) },
+			wantErr: ErrPortfolioNotFound,
+		},
+		{
+			name:    "transfer into other user's portfolio",
+			call:    func() error { return s.AddTransfer(ctx, alice, testTransfer(alicePortfolio, bobPortfolio)) },
+			wantErr: ErrPortfolioNotFound,
+		},
+		{
+			name:    "transfer between other user's portfolios",
+			call:    func() error { return s.AddTransfer(ctx, alice, testTransfer(bobPortfolio, bobSecond)) },
+			wantErr: ErrPortfolioNotFound,
+		},
+		{
+			name:    "transfer from other user's portfolio to external wallet",
+			call:    func() error { return s.AddTransfer(ctx, alice, testTransfer(bobPortfolio, 0)) },
+			wantErr: ErrPortfolioNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := dataDigest(t, s)
+
+			err := tt.call()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if after := dataDigest(t, s); after != before {
+				t.Errorf("rows changed:\nbefore %s\nafter  %s", before, after)
+			}
+		})
+	}
+
+	// bob's data is still there and untouched
+	tx, err := s.GetTransactionByID(ctx, bob, bobTx)
+	if err != nil {
+		t.Fatalf("owner can't read the transaction: %v", err)
+	}
+	if tx.Note != "" || len(tx.Tags) != 1 || tx.Tags[0] != "dca" {
+		t.Errorf("transaction changed: note %q, tags %v", tx.Note, tx.Tags)
+	}
+}
+
+func testTransfer(from, to int64) *types.TempTransactionData {
+	return &types.TempTransactionData{
+		Type:            "transfer",
+		Asset:           "BTC",
+		AssetAmount:     decimal.NewFromInt(1),
+		AssetPrice:      decimal.NewFromInt(100),
+		USDAmount:       decimal.NewFromInt(100),
+		TransactionDate: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		FromPortfolioID: from,
+		ToPortfolioID:   to,
+	}
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// GetPortfolios returns all user's portfolios, default one first
+func (s *Store) GetPortfolios(ctx context.Context, dbUserID int64) ([]t.Portfolio, error) {
+	query, args, err := s.sqlBuilder.
+		Select("id", "name", "COALESCE(description, '')", "is_default", "COALESCE(created_at, now())").
+		From("portfolios").
+		Where(sq.Eq{"user_id": dbUserID}).
+		OrderBy("is_default DESC", "name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetPortfolios query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec GetPortfolios query: %w", err)
+	}
+	defer rows.Close()
+
+	var portfolios []t.Portfolio
+	for rows.Next() {
+		var p t.Portfolio
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.IsDefault, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan GetPortfolios result: %w", err)
+		}
+		portfolios = append(portfolios, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return portfolios, nil
+}
+
+// StreamTransactionsForUser calls fn for every user's transaction in transaction date order
+// without loading them all into memory, an error from fn stops the iteration and is returned as is
+func (s *Store) StreamTransactionsForUser(ctx context.Context, dbUserID int64, fn func(tx t.Transaction) error) error {
+	query, args, err := s.sqlBuilder.
+		Select(transactionColumns...).
+		From("transactions t").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
+		Where(sq.Eq{
+			"p.user_id": dbUserID,
+		}).
+		OrderBy("t.transaction_date", "t.id").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build stream transactions query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec stream transactions query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return fmt.Errorf("scan transaction: %w", err)
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return nil
+}
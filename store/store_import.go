@@ -0,0 +1,404 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"gitlab.com/avolkov/wood_post/internal/importer"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// ImportTransactions inserts all rows into the portfolio in one DB transaction, rows with an import hash
+// already present in any of the user's portfolios are skipped
+func (s *Store) ImportTransactions(
+	ctx context.Context,
+	dbUserID, portfolioID int64,
+	txs []t.TempTransactionData,
+) (inserted, skipped int, err error) {
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin import transactions: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
+	query, args, err := s.sqlBuilder.
+		Select("COUNT(*)").
+		From("portfolios").
+		Where(sq.Eq{
+			"id":      portfolioID,
+			"user_id": dbUserID,
+		}).
+		ToSql()
+	if err != nil {
+		return 0, 0, fmt.Errorf("build portfolio ownership query: %w", err)
+	}
+
+	var owned int
+	if err = dbTx.QueryRowContext(ctx, query, args...).Scan(&owned); err != nil {
+		return 0, 0, fmt.Errorf("exec portfolio ownership query: %w", err)
+	}
+	if owned == 0 {
+		return 0, 0, ErrPortfolioNotFound
+	}
+
+	var hashes []string
+	for _, tx := range txs {
+		if tx.ImportHash != "" {
+			hashes = append(hashes, tx.ImportHash)
+		}
+	}
+
+	known, err := s.knownImportHashes(ctx, dbTx, dbUserID, hashes)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range txs {
+		if _, ok := known[txs[i].ImportHash]; ok {
+			skipped++
+			continue
+		}
+
+		ok, err := s.insertTransaction(ctx, dbTx, dbUserID, portfolioID, &txs[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		inserted++
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("commit import transactions: %w", err)
+	}
+
+	return inserted, skipped, nil
+}
+
+// RestoreTransactions puts back portfolios and transactions of an export. portfolios are matched by name and
+// missing ones are created with the name normalized like in the bot. rows with an import hash already present
+// in the user's portfolios are skipped, rows added by hand have no hash in the DB, they are matched by the hash
+// the exporter computes from the content. both legs of a transfer are restored or skipped together
+func (s *Store) RestoreTransactions(
+	ctx context.Context,
+	dbUserID int64,
+	portfolios []t.Portfolio,
+	txs []t.Transaction,
+) (created, inserted, skipped int, err error) {
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("begin restore transactions: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
+	ids, created, err := s.restorePortfolios(ctx, dbTx, dbUserID, portfolios, txs)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var hashes []string
+	for _, tx := range txs {
+		if tx.ImportHash != "" {
+			hashes = append(hashes, tx.ImportHash)
+		}
+	}
+	known, err := s.knownImportHashes(ctx, dbTx, dbUserID, hashes)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if err = s.addContentHashes(ctx, dbTx, dbUserID, known); err != nil {
+		return 0, 0, 0, err
+	}
+
+	// incoming legs are restored together with their outgoing ones
+	incoming := make(map[int64]t.Transaction)
+	for _, tx := range txs {
+		if tx.Type == "transfer" && tx.Direction == t.TransferIn {
+			incoming[tx.TransferID] = tx
+		}
+	}
+
+	for _, tx := range txs {
+		if tx.Type == "transfer" && tx.Direction == t.TransferIn {
+			continue
+		}
+
+		legs := 1
+		in, hasIn := incoming[tx.TransferID]
+		if tx.Type == "transfer" && hasIn {
+			legs = 2
+		}
+
+		if _, ok := known[tx.ImportHash]; ok && tx.ImportHash != "" {
+			skipped += legs
+			continue
+		}
+
+		temp := t.TempTransactionData{
+			Asset:           tx.Asset,
+			Type:            tx.Type,
+			AssetAmount:     tx.AssetAmount,
+			AssetPrice:      tx.AssetPrice,
+			QuoteCurrency:   tx.QuoteCurrency,
+			QuotePrice:      tx.QuotePrice,
+			USDAmount:       tx.USDAmount,
+			FeeAmount:       tx.FeeAmount,
+			FeeCurrency:     tx.FeeCurrency,
+			FeeUSD:          tx.FeeUSD,
+			TransactionDate: tx.TransactionDate,
+			Note:            tx.Note,
+			Tags:            tx.Tags,
+			ImportHash:      tx.ImportHash,
+		}
+
+		if tx.Type == "transfer" {
+			temp.FromPortfolioID = ids[tx.PortfolioName]
+			if hasIn {
+				temp.ToPortfolioID = ids[in.PortfolioName]
+			}
+			if err = s.insertTransfer(ctx, dbTx, &temp); err != nil {
+				return 0, 0, 0, err
+			}
+			inserted += legs
+			continue
+		}
+
+		ok, err := s.insertTransaction(ctx, dbTx, dbUserID, ids[tx.PortfolioName], &temp)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		inserted++
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return 0, 0, 0, fmt.Errorf("commit restore transactions: %w", err)
+	}
+
+	return created, inserted, skipped, nil
+}
+
+// creates portfolios referenced by the export which the user doesn't have, returns ids of all of them
+// by their names in the export and the number of created ones. an export name matches an existing
+// portfolio as is or after normalizing, names left empty by normalizing fail the restore
+func (s *Store) restorePortfolios(
+	ctx context.Context,
+	dbTx *sql.Tx,
+	dbUserID int64,
+	portfolios []t.Portfolio,
+	txs []t.Transaction,
+) (map[string]int64, int, error) {
+	query, args, err := s.sqlBuilder.
+		Select("id", "name").
+		From("portfolios").
+		Where(sq.Eq{"user_id": dbUserID}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("build existing portfolios query: %w", err)
+	}
+
+	rows, err := dbTx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("exec existing portfolios query: %w", err)
+	}
+	existing := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("scan existing portfolio: %w", err)
+		}
+		existing[name] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	// portfolios only mentioned by transactions are created too
+	known := make(map[string]bool)
+	for _, p := range portfolios {
+		known[p.Name] = true
+	}
+	for _, tx := range txs {
+		if !known[tx.PortfolioName] {
+			known[tx.PortfolioName] = true
+			portfolios = append(portfolios, t.Portfolio{Name: tx.PortfolioName})
+		}
+	}
+
+	ids := make(map[string]int64, len(portfolios))
+	// names of the export which end up in the same missing portfolio
+	aliases := make(map[string][]string)
+	var missing []t.Portfolio
+	for _, p := range portfolios {
+		if id, ok := existing[p.Name]; ok {
+			ids[p.Name] = id
+			continue
+		}
+
+		name := t.PrettyPortfolioName(p.Name)
+		if name == "" {
+			return nil, 0, fmt.Errorf("%w: %q", ErrPortfolioNameInvalid, p.Name)
+		}
+		if id, ok := existing[name]; ok {
+			ids[p.Name] = id
+			continue
+		}
+
+		if _, ok := aliases[name]; !ok {
+			exportName := p.Name
+			p.Name = name
+			missing = append(missing, p)
+			aliases[name] = []string{exportName}
+			continue
+		}
+		aliases[name] = append(aliases[name], p.Name)
+	}
+	if len(existing)+len(missing) > maxPortfolios {
+		return nil, 0, ErrPortfolioLimitReached
+	}
+
+	// a user without portfolios gets the default one from the export
+	needDefault := len(existing) == 0
+	if needDefault {
+		hasDefault := false
+		for _, p := range missing {
+			hasDefault = hasDefault || p.IsDefault
+		}
+		if !hasDefault && len(missing) > 0 {
+			missing[0].IsDefault = true
+		}
+	}
+
+	for _, p := range missing {
+		query, args, err := s.sqlBuilder.
+			Insert("portfolios").
+			Columns("user_id", "name", "description", "is_default", "created_at").
+			Values(dbUserID, p.Name, p.Description, needDefault && p.IsDefault, time.Now()).
+			Suffix("RETURNING id").
+			ToSql()
+		if err != nil {
+			return nil, 0, fmt.Errorf("build restore portfolio query: %w", err)
+		}
+
+		var id int64
+		if err := dbTx.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+			return nil, 0, fmt.Errorf("exec restore portfolio query: %w", err)
+		}
+		for _, exportName := range aliases[p.Name] {
+			ids[exportName] = id
+		}
+	}
+
+	return ids, len(missing), nil
+}
+
+// adds hashes of the user's rows without an import hash, computed the way exporter.Read does it for them:
+// in export order, with identical rows counted in the same seen map
+func (s *Store) addContentHashes(ctx context.Context, dbTx *sql.Tx, dbUserID int64, known map[string]struct{}) error {
+	query, args, err := s.sqlBuilder.
+		Select(transactionColumns...).
+		From("transactions t").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
+		Where(sq.Eq{
+			"p.user_id":     dbUserID,
+			"t.import_hash": nil,
+		}).
+		OrderBy("t.transaction_date", "t.id").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build content hashes query: %w", err)
+	}
+
+	rows, err := dbTx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec content hashes query: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]int)
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return fmt.Errorf("scan content hash transaction: %w", err)
+		}
+		known[importer.Hash(t.TempTransactionData{
+			Asset:           tx.Asset,
+			Type:            tx.Type,
+			AssetAmount:     tx.AssetAmount,
+			QuoteCurrency:   tx.QuoteCurrency,
+			QuotePrice:      tx.QuotePrice,
+			FeeAmount:       tx.FeeAmount,
+			FeeCurrency:     tx.FeeCurrency,
+			TransactionDate: tx.TransactionDate,
+		}, seen)] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return nil
+}
+
+// returns those of hashes which are already in any of the user's portfolios
+func (s *Store) knownImportHashes(
+	ctx context.Context,
+	dbTx *sql.Tx,
+	dbUserID int64,
+	hashes []string,
+) (map[string]struct{}, error) {
+	known := make(map[string]struct{})
+	if len(hashes) == 0 {
+		return known, nil
+	}
+
+	query, args, err := s.sqlBuilder.
+		Select("import_hash").
+		From("transactions").
+		Where(sq.Eq{"import_hash": hashes}).
+		Where(userPortfoliosCond(dbUserID)).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build imported hashes query: %w", err)
+	}
+
+	rows, err := dbTx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec imported hashes query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("scan import hash: %w", err)
+		}
+		known[hash] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return known, nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/internal/exporter"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// rows added by hand have no import hash, restoring their own export must not duplicate them
+func TestRestoreSkipsRowsWithoutImportHash(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	user, portfolio := createTestUser(t, s, 1001)
+	addTestTransaction(t, s, user, portfolio, types.TempTransactionData{})
+	// identical rows, e.g. two equal sells on the same day
+	addTestTransaction(t, s, user, portfolio, types.TempTransactionData{Type: "sell"})
+	addTestTransaction(t, s, user, portfolio, types.TempTransactionData{Type: "sell"})
+
+	doc := exportDocument(t, s, user)
+
+	before := dataDigest(t, s)
+	created, inserted, skipped, err := s.RestoreTransactions(ctx, user, doc.PortfolioList(), doc.TransactionList())
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if created != 0 || inserted != 0 || skipped != 3 {
+		t.Errorf("got created %d, inserted %d, skipped %d, want 0, 0, 3", created, inserted, skipped)
+	}
+	if after := dataDigest(t, s); after != before {
+		t.Errorf("rows changed:\nbefore %s\nafter  %s", before, after)
+	}
+
+	// another user gets the rows once, however many times the file is restored
+	other, _ := createTestUser(t, s, 1002)
+	for i, want := range []int{3, 0} {
+		_, inserted, _, err := s.RestoreTransactions(ctx, other, doc.PortfolioList(), doc.TransactionList())
+		if err != nil {
+			t.Fatalf("restore %d: %v", i+1, err)
+		}
+		if inserted != want {
+			t.Errorf("restore %d inserted %d, want %d", i+1, inserted, want)
+		}
+	}
+}
+
+// writes the user's JSON export and reads it back like the bot does
+func exportDocument(tb testing.TB, s *Store, dbUserID int64) *exporter.Document {
+	tb.Helper()
+	ctx := context.Background()
+
+	portfolios, err := s.GetPortfolios(ctx, dbUserID)
+	if err != nil {
+		tb.Fatalf("get portfolios: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := exporter.NewWriter(exporter.JSON, &buf, portfolios, time.Now())
+	if err != nil {
+		tb.Fatalf("new writer: %v", err)
+	}
+	if err := s.StreamTransactionsForUser(ctx, dbUserID, w.Write); err != nil {
+		tb.Fatalf("export: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatalf("close export: %v", err)
+	}
+
+	doc, err := exporter.Read(&buf)
+	if err != nil {
+		tb.Fatalf("read export: %v", err)
+	}
+	return doc
+}
+
+// portfolio names of an export get the same rules as names typed in the bot
+func TestRestoreNormalizesPortfolioNames(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	user, _ := createTestUser(t, s, 1001)
+	long := strings.Repeat("x", types.MaxPortfolioNameLength+10)
+
+	portfolios := []types.Portfolio{{Name: "Main"}, {Name: "Cold Wallet!"}, {Name: long}}
+	txs := []types.Transaction{
+		restoreTestTransaction("Cold Wallet!", 1),
+		restoreTestTransaction("cold wallet", 2),
+		restoreTestTransaction(long, 3),
+	}
+
+	created, inserted, _, err := s.RestoreTransactions(ctx, user, portfolios, txs)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if created != 2 || inserted != 3 {
+		t.Errorf("got created %d, inserted %d, want 2, 3", created, inserted)
+	}
+
+	refs, err := s.GetPortfolioRefs(ctx, user)
+	if err != nil {
+		t.Fatalf("get portfolios: %v", err)
+	}
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	sort.Strings(names)
+	want := []string{"cold_wallet", "main", strings.Repeat("x", types.MaxPortfolioNameLength)}
+	if !slices.Equal(names, want) {
+		t.Errorf("got portfolios %v, want %v", names, want)
+	}
+
+	before := dataDigest(t, s)
+	_, _, _, err = s.RestoreTransactions(ctx, user, []types.Portfolio{{Name: "Кошелёк"}}, nil)
+	if !errors.Is(err, ErrPortfolioNameInvalid) {
+		t.Errorf("got error %v, want %v", err, ErrPortfolioNameInvalid)
+	}
+	if after := dataDigest(t, s); after != before {
+		t.Errorf("rows changed:\nbefore %s\nafter  %s", before, after)
+	}
+}
+
+func restoreTestTransaction(portfolio string, day int) types.Transaction {
+	return types.Transaction{
+		PortfolioName:   portfolio,
+		Type:            "buy",
+		Asset:           "BTC",
+		AssetAmount:     decimal.NewFromInt(1),
+		AssetPrice:      decimal.NewFromInt(100),
+		QuoteCurrency:   types.FiatUSD,
+		QuotePrice:      decimal.NewFromInt(100),
+		USDAmount:       decimal.NewFromInt(100),
+		TransactionDate: time.Date(2025, 1, day, 0, 0, 0, 0, time.UTC),
+		ImportHash:      fmt.Sprintf("restore-test-%d", day),
+	}
+}
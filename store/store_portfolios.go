@@ -9,6 +9,7 @@ import (
 
 	sq "github.com/Masterminds/squirrel"
 	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
 func (s *Store) CreatePortfolio(ctx context.Context, dbUserID int64, portfolioName string, description string) error {
@@ -73,6 +74,8 @@ func (s *Store) PortfolioExists(ctx context.Context, dbUserID int64) (bool, erro
 	return true, nil
 }
 
+const maxPortfolios = 2
+
 func (s *Store) ReachedPortfolioLimit(ctx context.Context, dbUserID int64) (bool, error) {
 	var count int
 	query, args, err := s.sqlBuilder.
@@ -88,7 +91,7 @@ func (s *Store) ReachedPortfolioLimit(ctx context.Context, dbUserID int64) (bool
 	if err := s.DB.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
 		return false, fmt.Errorf("exec ReachedPortfolioLimit query: %w", err)
 	}
-	return count >= 2, nil
+	return count >= maxPortfolios, nil
 }
 
 func (s *Store) PortfolioNameExists(ctx context.Context, dbUserID int64, portfolioName string) (bool, error) {
@@ -136,9 +139,12 @@ func (s *Store) DeletePortfolio(ctx context.Context, dbUserID int64, portfolioNa
 		return fmt.Errorf("exec delete query: %w", err)
 	}
 
-	rows, _ := result.RowsAffected()
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check affected rows: %w", err)
+	}
 	if rows == 0 {
-		log.Warnf("no portfolio deleted for user_id=%d, portfolio_name=%s", dbUserID, portfolioName)
+		return ErrPortfolioNotFound
 	}
 
 	return nil
@@ -161,7 +167,7 @@ func (s *Store) GetDefaultPortfolio(ctx context.Context, dbUserID int64) (string
 	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&dpName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", err
+			return "", ErrPortfolioNotFound
 		}
 		return "", fmt.Errorf("exec GetDefaultPortfolio query: %w", err)
 	}
@@ -186,22 +192,24 @@ func (s *Store) GetDefaultPortfolioID(ctx context.Context, dbUserID int64) (int,
 	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&dpID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return 0, err
+			return 0, ErrPortfolioNotFound
 		}
 		return 0, fmt.Errorf("exec GetDefaultPortfolioID query: %w", err)
 	}
 	return dpID, nil
 }
 
+// returns ids and names of user's portfolios by name, onlyNonDefault leaves the default one out
 func (s *Store) GetPortfoliosFiltered(
 	ctx context.Context,
 	dbUserID int64,
 	onlyNonDefault bool,
-) ([]string, error) {
+) ([]t.PortfolioRef, error) {
 	builder := s.sqlBuilder.
-		Select("name").
+		Select("id", "name").
 		From("portfolios").
-		Where(sq.Eq{"user_id": dbUserID})
+		Where(sq.Eq{"user_id": dbUserID}).
+		OrderBy("name")
 
 	if onlyNonDefault {
 		builder = builder.Where(sq.Eq{"is_default": false})
@@ -218,13 +226,13 @@ func (s *Store) GetPortfoliosFiltered(
 	}
 	defer rows.Close()
 
-	var portfolios []string
+	var portfolios []t.PortfolioRef
 	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
+		var ref t.PortfolioRef
+		if err := rows.Scan(&ref.ID, &ref.Name); err != nil {
 			return nil, fmt.Errorf("scan GetPortfoliosFiltered result: %w", err)
 		}
-		portfolios = append(portfolios, name)
+		portfolios = append(portfolios, ref)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -234,6 +242,40 @@ func (s *Store) GetPortfoliosFiltered(
 	return portfolios, nil
 }
 
+// returns ids and names of user's portfolios, default one first
+func (s *Store) GetPortfolioRefs(ctx context.Context, dbUserID int64) ([]t.PortfolioRef, error) {
+	query, args, err := s.sqlBuilder.
+		Select("id", "name").
+		From("portfolios").
+		Where(sq.Eq{"user_id": dbUserID}).
+		OrderBy("is_default DESC", "name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetPortfolioRefs query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec GetPortfolioRefs query: %w", err)
+	}
+	defer rows.Close()
+
+	var refs []t.PortfolioRef
+	for rows.Next() {
+		var ref t.PortfolioRef
+		if err := rows.Scan(&ref.ID, &ref.Name); err != nil {
+			return nil, fmt.Errorf("scan GetPortfolioRefs result: %w", err)
+		}
+		refs = append(refs, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return refs, nil
+}
+
 // TODO: add check for name existance
 func (s *Store) RenamePortfolio(
 	ctx context.Context,
@@ -263,7 +305,7 @@ func (s *Store) RenamePortfolio(
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("rename failed: no portfolio found with name '%s'", oldName)
+		return fmt.Errorf("rename portfolio '%s': %w", oldName, ErrPortfolioNotFound)
 	}
 
 	log.Infof("portfolio renamed: user_id=%d, from=%s to=%s", dbUserID, oldName, newName)
@@ -274,7 +316,18 @@ func (s *Store) ChangeDefaultPortfolio(
 	ctx context.Context,
 	dbUserID int64,
 	portfolioName string,
-) error {
+) (err error) {
+	// reset and set must happen together, otherwise user may end up without default portfolio
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin change default transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
 	resetQuery, resetArgs, err := s.sqlBuilder.
 		Update("portfolios").
 		Set("is_default", false).
@@ -286,7 +339,7 @@ func (s *Store) ChangeDefaultPortfolio(
 		return fmt.Errorf("build reset default query: %w", err)
 	}
 
-	_, err = s.DB.ExecContext(ctx, resetQuery, resetArgs...)
+	_, err = dbTx.ExecContext(ctx, resetQuery, resetArgs...)
 	if err != nil {
 		return fmt.Errorf("exec reset default query: %w", err)
 	}
@@ -303,7 +356,7 @@ func (s *Store) ChangeDefaultPortfolio(
 		return fmt.Errorf("build set default query: %w", err)
 	}
 
-	result, err := s.DB.ExecContext(ctx, setQuery, setArgs...)
+	result, err := dbTx.ExecContext(ctx, setQuery, setArgs...)
 	if err != nil {
 		return fmt.Errorf("exec set default query: %w", err)
 	}
@@ -314,7 +367,11 @@ func (s *Store) ChangeDefaultPortfolio(
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("set default failed: no portfolio found with name '%s'", portfolioName)
+		return fmt.Errorf("set default portfolio '%s': %w", portfolioName, ErrPortfolioNotFound)
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return fmt.Errorf("commit change default transaction: %w", err)
 	}
 
 	log.Infof("default portfolio changed: user_id=%d, new_default='%s'", dbUserID, portfolioName)
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// inserts price snapshots, snapshot for the same asset, quote and time is overwritten
+func (s *Store) SavePrices(ctx context.Context, records []t.PriceRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	builder := s.sqlBuilder.
+		Insert("prices").
+		Columns("asset", "quote", "price_time", "price", "source")
+
+	for _, r := range records {
+		builder = builder.Values(r.Asset, r.Quote, r.Time, r.Price, r.Source)
+	}
+
+	query, args, err := builder.
+		Suffix("ON CONFLICT (asset, quote, price_time) DO UPDATE SET price = EXCLUDED.price, source = EXCLUDED.source").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build SavePrices query: %w", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec SavePrices query: %w", err)
+	}
+
+	return nil
+}
+
+// returns every asset that appears in transactions of any user
+func (s *Store) GetTrackedAssets(ctx context.Context) ([]string, error) {
+	query, args, err := s.sqlBuilder.
+		Select("DISTINCT asset").
+		From("transactions").
+		Where(sq.NotEq{"type": fiatFlowTypes}).
+		OrderBy("asset").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetTrackedAssets query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec GetTrackedAssets query: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []string
+	for rows.Next() {
+		var asset string
+		if err := rows.Scan(&asset); err != nil {
+			return nil, fmt.Errorf("scan tracked asset: %w", err)
+		}
+		assets = append(assets, asset)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return assets, nil
+}
+
+// returns quote currencies of transactions and reporting currencies of users,
+// their rates are collected like asset prices
+func (s *Store) GetTrackedCurrencies(ctx context.Context) ([]string, error) {
+	query, args, err := s.sqlBuilder.
+		Select("quote_currency").
+		From("transactions").
+		Suffix("UNION SELECT reporting_currency FROM user_settings").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetTrackedCurrencies query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec GetTrackedCurrencies query: %w", err)
+	}
+	defer rows.Close()
+
+	var currencies []string
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			return nil, fmt.Errorf("scan tracked currency: %w", err)
+		}
+		if !t.IsUSD(currency) {
+			currencies = append(currencies, currency)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return currencies, nil
+}
+
+// returns the latest stored price at or before the given time
+func (s *Store) GetPriceAt(ctx context.Context, asset, quote string, at time.Time) (t.PriceRecord, error) {
+	query, args, err := s.sqlBuilder.
+		Select("asset", "quote", "price_time", "price", "source").
+		From("prices").
+		Where(sq.Eq{
+			"asset": asset,
+			"quote": quote,
+		}).
+		Where(sq.LtOrEq{"price_time": at}).
+		OrderBy("price_time DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return t.PriceRecord{}, fmt.Errorf("build GetPriceAt query: %w", err)
+	}
+
+	var r t.PriceRecord
+	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&r.Asset, &r.Quote, &r.Time, &r.Price, &r.Source)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return t.PriceRecord{}, ErrPriceNotFound
+		}
+		return t.PriceRecord{}, fmt.Errorf("exec GetPriceAt query: %w", err)
+	}
+
+	return r, nil
+}
+
+// returns the most recent stored price for each of the assets, assets without prices are skipped
+func (s *Store) GetLatestPrices(ctx context.Context, assets []string, quote string) ([]t.PriceRecord, error) {
+	if len(assets) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := s.sqlBuilder.
+		Select("DISTINCT ON (asset) asset", "quote", "price_time", "price", "source").
+		From("prices").
+		Where(sq.Eq{
+			"asset": assets,
+			"quote": quote,
+		}).
+		OrderBy("asset", "price_time DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetLatestPrices query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec GetLatestPrices query: %w", err)
+	}
+	defer rows.Close()
+
+	var records []t.PriceRecord
+	for rows.Next() {
+		var r t.PriceRecord
+		if err := rows.Scan(&r.Asset, &r.Quote, &r.Time, &r.Price, &r.Source); err != nil {
+			return nil, fmt.Errorf("scan latest price: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return records, nil
+}
+
+// returns stored prices of the assets within [from, to] ordered by time
+func (s *Store) GetPriceHistory(ctx context.Context, assets []string, quote string, from, to time.Time) ([]t.PriceRecord, error) {
+	if len(assets) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := s.sqlBuilder.
+		Select("asset", "quote", "price_time", "price", "source").
+		From("prices").
+		Where(sq.Eq{
+			"asset": assets,
+			"quote": quote,
+		}).
+		Where(sq.GtOrEq{"price_time": from}).
+		Where(sq.LtOrEq{"price_time": to}).
+		OrderBy("price_time", "asset").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetPriceHistory query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec GetPriceHistory query: %w", err)
+	}
+	defer rows.Close()
+
+	var records []t.PriceRecord
+	for rows.Next() {
+		var r t.PriceRecord
+		if err := rows.Scan(&r.Asset, &r.Quote, &r.Time, &r.Price, &r.Source); err != nil {
+			return nil, fmt.Errorf("scan price history: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return records, nil
+}
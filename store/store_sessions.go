@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// returns serialized session of the user, ErrSessionNotFound if there is none
+func (s *Store) GetSession(ctx context.Context, telegramID int64) ([]byte, error) {
+	query, args, err := s.sqlBuilder.
+		Select("data").
+		From("bot_sessions").
+		Where(sq.Eq{
+			"telegram_id": telegramID,
+		}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build GetSession query: %w", err)
+	}
+
+	var data []byte
+	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("exec GetSession query: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *Store) SaveSession(ctx context.Context, telegramID int64, data []byte, updatedAt time.Time) error {
+	query, args, err := s.sqlBuilder.
+		Insert("bot_sessions").
+		Columns("telegram_id", "data", "updated_at").
+		Values(telegramID, string(data), updatedAt).
+		Suffix("ON CONFLICT (telegram_id) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build SaveSession query: %w", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec SaveSession query: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteSession(ctx context.Context, telegramID int64) error {
+	query, args, err := s.sqlBuilder.
+		Delete("bot_sessions").
+		Where(sq.Eq{
+			"telegram_id": telegramID,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build DeleteSession query: %w", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec DeleteSession query: %w", err)
+	}
+
+	return nil
+}
+
+// deletes sessions not updated since the given time, returns how many were deleted
+func (s *Store) DeleteSessionsBefore(ctx context.Context, before time.Time) (int64, error) {
+	query, args, err := s.sqlBuilder.
+		Delete("bot_sessions").
+		Where(sq.Lt{
+			"updated_at": before,
+		}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("build DeleteSessionsBefore query: %w", err)
+	}
+
+	res, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("exec DeleteSessionsBefore query: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get DeleteSessionsBefore rows affected: %w", err)
+	}
+
+	return deleted, nil
+}
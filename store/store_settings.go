@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"gitlab.com/avolkov/wood_post/pkg/log"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+const DefaultCostBasisMethod = "fifo"
+
+// returns user's cost basis method, default one if user never changed it
+func (s *Store) GetCostBasisMethod(ctx context.Context, dbUserID int64) (string, error) {
+	query, args, err := s.sqlBuilder.
+		Select("cost_basis_method").
+		From("user_settings").
+		Where(sq.Eq{
+			"user_id": dbUserID,
+		}).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("build GetCostBasisMethod query: %w", err)
+	}
+
+	var method string
+	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&method)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultCostBasisMethod, nil
+		}
+		return "", fmt.Errorf("exec GetCostBasisMethod query: %w", err)
+	}
+
+	return method, nil
+}
+
+func (s *Store) SetCostBasisMethod(ctx context.Context, dbUserID int64, method string) error {
+	return s.setUserSetting(ctx, dbUserID, "cost_basis_method", method)
+}
+
+const DefaultReportingCurrency = "USD"
+
+// returns the currency user's reports are shown in
+func (s *Store) GetReportingCurrency(ctx context.Context, dbUserID int64) (string, error) {
+	query, args, err := s.sqlBuilder.
+		Select("reporting_currency").
+		From("user_settings").
+		Where(sq.Eq{
+			"user_id": dbUserID,
+		}).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("build GetReportingCurrency query: %w", err)
+	}
+
+	var currency string
+	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&currency)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultReportingCurrency, nil
+		}
+		return "", fmt.Errorf("exec GetReportingCurrency query: %w", err)
+	}
+
+	return currency, nil
+}
+
+func (s *Store) SetReportingCurrency(ctx context.Context, dbUserID int64, currency string) error {
+	return s.setUserSetting(ctx, dbUserID, "reporting_currency", currency)
+}
+
+func (s *Store) SetTimezone(ctx context.Context, dbUserID int64, timezone string) error {
+	return s.setUserSetting(ctx, dbUserID, "timezone", timezone)
+}
+
+func (s *Store) SetDateFormat(ctx context.Context, dbUserID int64, format string) error {
+	return s.setUserSetting(ctx, dbUserID, "date_format", format)
+}
+
+func (s *Store) SetLanguage(ctx context.Context, dbUserID int64, language string) error {
+	return s.setUserSetting(ctx, dbUserID, "language", language)
+}
+
+func (s *Store) SetMessageTTL(ctx context.Context, dbUserID int64, ttl time.Duration) error {
+	return s.setUserSetting(ctx, dbUserID, "message_ttl", int(ttl.Seconds()))
+}
+
+// settings of users who never changed anything
+var DefaultUserSettings = t.UserSettings{
+	CostBasisMethod:   DefaultCostBasisMethod,
+	ReportingCurrency: DefaultReportingCurrency,
+	Timezone:          "UTC",
+	DateFormat:        t.DateFormats[0].Name,
+	Language:          t.Languages[0].Code,
+	MessageTTL:        20 * time.Second,
+}
+
+// returns all settings of the user, defaults for users without a settings row
+func (s *Store) GetUserSettingsByTelegramID(ctx context.Context, telegramID int64) (t.UserSettings, error) {
+	query, args, err := s.sqlBuilder.
+		Select(
+			"us.cost_basis_method",
+			"us.reporting_currency",
+			"us.timezone",
+			"us.date_format",
+			"us.language",
+			"us.message_ttl",
+		).
+		From("user_settings us").
+		Join("users u ON u.id = us.user_id").
+		Where(sq.Eq{
+			"u.telegram_id": telegramID,
+		}).
+		ToSql()
+	if err != nil {
+		return t.UserSettings{}, fmt.Errorf("build GetUserSettingsByTelegramID query: %w", err)
+	}
+
+	var (
+		us         t.UserSettings
+		ttlSeconds int
+	)
+	err = s.DB.QueryRowContext(ctx, query, args...).Scan(
+		&us.CostBasisMethod,
+		&us.ReportingCurrency,
+		&us.Timezone,
+		&us.DateFormat,
+		&us.Language,
+		&ttlSeconds,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultUserSettings, nil
+		}
+		return t.UserSettings{}, fmt.Errorf("exec GetUserSettingsByTelegramID query: %w", err)
+	}
+	us.MessageTTL = time.Duration(ttlSeconds) * time.Second
+
+	return us, nil
+}
+
+// upserts one column of user_settings, column names come only from the setters above
+func (s *Store) setUserSetting(ctx context.Context, dbUserID int64, column string, value any) error {
+	query, args, err := s.sqlBuilder.
+		Insert("user_settings").
+		Columns("user_id", column, "updated_at").
+		Values(dbUserID, value, time.Now()).
+		Suffix(fmt.Sprintf("ON CONFLICT (user_id) DO UPDATE SET %s = EXCLUDED.%s, updated_at = EXCLUDED.updated_at", column, column)).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build setUserSetting query: %w", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec setUserSetting query: %w", err)
+	}
+
+	log.Infof("user setting changed: user_id=%d, %s=%v", dbUserID, column, value)
+	return nil
+}
@@ -0,0 +1,271 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// comma separated tag names of a transaction t, tag names can't contain commas
+const transactionTagsSQL = "COALESCE((SELECT string_agg(tg.name, ',' ORDER BY lower(tg.name)) " +
+	"FROM transaction_tags tt JOIN tags tg ON tg.id = tt.tag_id " +
+	"WHERE tt.transaction_id = t.id), '')"
+
+// limits transactions t to the ones having the tag, the name is matched case insensitive
+func tagCond(tag string) sq.Sqlizer {
+	return sq.Expr("EXISTS (SELECT 1 FROM transaction_tags tt JOIN tags tg ON tg.id = tt.tag_id "+
+		"WHERE tt.transaction_id = t.id AND lower(tg.name) = lower(?))", tag)
+}
+
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// retrieves user's tags with the number of transactions using them
+func (s *Store) GetTags(ctx context.Context, dbUserID int64) ([]t.Tag, error) {
+	query, args, err := s.sqlBuilder.
+		Select("tg.id", "tg.name", "COUNT(tt.transaction_id)").
+		From("tags tg").
+		LeftJoin("transaction_tags tt ON tt.tag_id = tg.id").
+		Where(sq.Eq{"tg.user_id": dbUserID}).
+		GroupBy("tg.id", "tg.name").
+		OrderBy("lower(tg.name)").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build get tags query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec get tags query: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []t.Tag
+	for rows.Next() {
+		var tag t.Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Count); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (s *Store) RenameTag(ctx context.Context, dbUserID, tagID int64, newName string) error {
+	query, args, err := s.sqlBuilder.
+		Select("COUNT(*)").
+		From("tags").
+		Where(sq.Eq{
+			"user_id":     dbUserID,
+			"lower(name)": strings.ToLower(newName),
+		}).
+		Where(sq.NotEq{"id": tagID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build tag name check query: %w", err)
+	}
+
+	var taken int
+	if err := s.DB.QueryRowContext(ctx, query, args...).Scan(&taken); err != nil {
+		return fmt.Errorf("exec tag name check query: %w", err)
+	}
+	if taken > 0 {
+		return ErrTagExists
+	}
+
+	query, args, err = s.sqlBuilder.
+		Update("tags").
+		Set("name", newName).
+		Where(sq.Eq{
+			"id":      tagID,
+			"user_id": dbUserID,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build rename tag query: %w", err)
+	}
+
+	result, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec rename tag query: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrTagNotFound
+	}
+
+	return nil
+}
+
+// deletes the tag, transactions lose it but stay as they are
+func (s *Store) DeleteTag(ctx context.Context, dbUserID, tagID int64) error {
+	query, args, err := s.sqlBuilder.
+		Delete("tags").
+		Where(sq.Eq{
+			"id":      tagID,
+			"user_id": dbUserID,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build delete tag query: %w", err)
+	}
+
+	result, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("exec delete tag query: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrTagNotFound
+	}
+
+	return nil
+}
+
+// SetTransactionTags replaces tags of a user's transaction and records the change,
+// unknown tag names are created
+func (s *Store) SetTransactionTags(ctx context.Context, dbUserID, txID int64, names []string) (err error) {
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin set transaction tags: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
+	query, args, err := s.sqlBuilder.
+		Select(transactionTagsSQL).
+		From("transactions t").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
+		Where(sq.Eq{
+			"t.id":      txID,
+			"p.user_id": dbUserID,
+		}).
+		Suffix("FOR UPDATE OF t").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build select old tags query: %w", err)
+	}
+
+	var oldTags string
+	if err = dbTx.QueryRowContext(ctx, query, args...).Scan(&oldTags); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTransactionNotFound
+		}
+		return fmt.Errorf("exec select old tags query: %w", err)
+	}
+
+	query, args, err = s.sqlBuilder.
+		Delete("transaction_tags").
+		Where(sq.Eq{"transaction_id": txID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build clear transaction tags query: %w", err)
+	}
+
+	if _, err = dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec clear transaction tags query: %w", err)
+	}
+
+	if err = s.attachTags(ctx, dbTx, dbUserID, txID, names); err != nil {
+		return err
+	}
+
+	query, args, err = s.sqlBuilder.
+		Insert("transaction_changes").
+		Columns("transaction_id", "user_id", "field", "old_value", "new_value", "changed_at").
+		Values(txID, dbUserID, "tags", oldTags, strings.Join(names, ","), time.Now()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build transaction change query: %w", err)
+	}
+
+	if _, err = dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec transaction change query: %w", err)
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return fmt.Errorf("commit set transaction tags: %w", err)
+	}
+
+	return nil
+}
+
+// creates missing tags of the user and links all of them to the transaction
+func (s *Store) attachTags(ctx context.Context, dbTx *sql.Tx, dbUserID, txID int64, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	insert := s.sqlBuilder.
+		Insert("tags").
+		Columns("user_id", "name", "created_at")
+
+	lowered := make([]string, 0, len(names))
+	for _, name := range names {
+		insert = insert.Values(dbUserID, name, time.Now())
+		lowered = append(lowered, strings.ToLower(name))
+	}
+
+	query, args, err := insert.
+		Suffix("ON CONFLICT (user_id, lower(name)) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build add tags query: %w", err)
+	}
+
+	if _, err := dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec add tags query: %w", err)
+	}
+
+	selectQuery := sq.
+		Select().
+		Column("?::bigint", txID).
+		Column("id").
+		From("tags").
+		Where(sq.Eq{
+			"user_id":     dbUserID,
+			"lower(name)": lowered,
+		})
+
+	query, args, err = s.sqlBuilder.
+		Insert("transaction_tags").
+		Columns("transaction_id", "tag_id").
+		Select(selectQuery).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build link tags query: %w", err)
+	}
+
+	if _, err := dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec link tags query: %w", err)
+	}
+
+	return nil
+}
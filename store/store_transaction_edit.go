@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// fields that can be edited and their columns, field names are the ones used in the bot
+var editableColumns = map[string]string{
+	"asset":     "asset",
+	"amount":    "asset_amount",
+	"price":     "quote_price",
+	"date":      "transaction_date",
+	"type":      "type",
+	"portfolio": "portfolio_id",
+	"note":      "note",
+}
+
+// retrieves a single transaction if it belongs to the user
+func (s *Store) GetTransactionByID(ctx context.Context, dbUserID, txID int64) (t.Transaction, error) {
+	query, args, err := s.sqlBuilder.
+		Select(transactionColumns...).
+		From("transactions t").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
+		Where(sq.Eq{
+			"t.id":      txID,
+			"p.user_id": dbUserID,
+		}).
+		ToSql()
+	if err != nil {
+		return t.Transaction{}, fmt.Errorf("build get transaction by id query: %w", err)
+	}
+
+	tx, err := scanTransaction(s.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return t.Transaction{}, ErrTransactionNotFound
+		}
+		return t.Transaction{}, fmt.Errorf("exec get transaction by id query: %w", err)
+	}
+
+	return tx, nil
+}
+
+// UpdateTransaction changes one field of a user's transaction and records the change,
+// USD amounts follow amount, price and date changes. a price is given as types.QuotedPrice,
+// a date of a transaction priced in another currency than USD as types.RepricedDate.
+// a fee paid in the asset is restated in USD when the asset changes
+func (s *Store) UpdateTransaction(ctx context.Context, dbUserID, txID int64, field string, value any) (err error) {
+	column, ok := editableColumns[field]
+	if !ok {
+		return fmt.Errorf("unknown transaction field: %s", field)
+	}
+
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin update transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
+	oldValueSQL := "COALESCE(t." + column + "::text, '')"
+	if field == "price" {
+		oldValueSQL = "t.quote_price::text || ' ' || t.quote_currency"
+	}
+
+	// ownership check and old value in one go, row is locked until commit
+	query, args, err := s.sqlBuilder.
+		Select(oldValueSQL, "t.type", "COALESCE(t.quote_currency, '')").
+		From("transactions t").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
+		Where(sq.Eq{
+			"t.id":      txID,
+			"p.user_id": dbUserID,
+		}).
+		Suffix("FOR UPDATE OF t").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build select old value query: %w", err)
+	}
+
+	var oldValue, txType, quoteCurrency string
+	if err = dbTx.QueryRowContext(ctx, query, args...).Scan(&oldValue, &txType, &quoteCurrency); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTransactionNotFound
+		}
+		return fmt.Errorf("exec select old value query: %w", err)
+	}
+
+	// a transfer leg on its own can't be changed without breaking the pair
+	if txType == "transfer" {
+		return ErrTransferNotEditable
+	}
+
+	if field == "portfolio" {
+		query, args, err = s.sqlBuilder.
+			Select("COUNT(*)").
+			From("portfolios").
+			Where(sq.Eq{
+				"id":      value,
+				"user_id": dbUserID,
+			}).
+			ToSql()
+		if err != nil {
+			return fmt.Errorf("build portfolio ownership query: %w", err)
+		}
+
+		var owned int
+		if err = dbTx.QueryRowContext(ctx, query, args...).Scan(&owned); err != nil {
+			return fmt.Errorf("exec portfolio ownership query: %w", err)
+		}
+		if owned == 0 {
+			return ErrPortfolioNotFound
+		}
+	}
+
+	update := s.sqlBuilder.
+		Update("transactions").
+		Where(sq.Eq{"id": txID})
+
+	switch field {
+	case "asset":
+		// the fee amount means nothing in the new asset, its USD value at the transaction price stays
+		feeInOldAsset := "fee_currency = asset AND asset <> ?"
+		update = update.
+			Set(column, value).
+			Set("fee_amount", sq.Expr("CASE WHEN "+feeInOldAsset+" THEN fee_amount * asset_price ELSE fee_amount END", value)).
+			Set("fee_usd", sq.Expr("CASE WHEN "+feeInOldAsset+" THEN fee_amount * asset_price ELSE fee_usd END", value)).
+			Set("fee_currency", sq.Expr("CASE WHEN "+feeInOldAsset+" THEN 'USD' ELSE fee_currency END", value))
+	case "amount":
+		update = update.
+			Set(column, value).
+			Set("amount_usd", sq.Expr("? * asset_price", value)).
+			Set("fee_usd", sq.Expr("CASE WHEN fee_currency = asset THEN fee_amount * asset_price ELSE fee_usd END"))
+	case "date":
+		switch date := value.(type) {
+		case time.Time:
+			// the USD value of a trade in another currency depends on the date
+			if !t.IsUSD(quoteCurrency) {
+				return fmt.Errorf("date of a transaction priced in %s must come with its USD price", quoteCurrency)
+			}
+			update = update.Set(column, date)
+		case t.RepricedDate:
+			update = update.
+				Set(column, date.Date).
+				Set("asset_price", date.Price.USD).
+				Set("amount_usd", sq.Expr("asset_amount * ?", date.Price.USD)).
+				Set("fee_usd", sq.Expr("CASE "+
+					"WHEN fee_currency = asset THEN fee_amount * ? "+
+					"WHEN fee_currency = quote_currency AND quote_price > 0 THEN fee_amount * ? / quote_price "+
+					"ELSE fee_usd END", date.Price.USD, date.Price.USD))
+			value = date.Date
+		default:
+			return fmt.Errorf("date must be a time or a repriced date, got %T", value)
+		}
+	case "price":
+		price, ok := value.(t.QuotedPrice)
+		if !ok {
+			return fmt.Errorf("price must be a quoted price, got %T", value)
+		}
+		update = update.
+			Set("quote_price", price.Price).
+			Set("quote_currency", price.Currency).
+			Set("asset_price", price.USD).
+			Set("amount_usd", sq.Expr("asset_amount * ?", price.USD)).
+			Set("fee_usd", sq.Expr("CASE WHEN fee_currency = asset THEN fee_amount * ? ELSE fee_usd END", price.USD))
+	default:
+		update = update.Set(column, value)
+	}
+
+	query, args, err = update.ToSql()
+	if err != nil {
+		return fmt.Errorf("build update transaction query: %w", err)
+	}
+
+	if _, err = dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec update transaction query: %w", err)
+	}
+
+	newValue := fmt.Sprint(value)
+	if date, ok := value.(time.Time); ok {
+		newValue = date.Format("2006-01-02")
+	}
+
+	query, args, err = s.sqlBuilder.
+		Insert("transaction_changes").
+		Columns("transaction_id", "user_id", "field", "old_value", "new_value", "changed_at").
+		Values(txID, dbUserID, field, oldValue, newValue, time.Now()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build transaction change query: %w", err)
+	}
+
+	if _, err = dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec transaction change query: %w", err)
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return fmt.Errorf("commit update transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
+	types "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+func TestUpdateTransactionRecomputesUSD(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	user, portfolio := createTestUser(t, s, 1001)
+	newDate := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	// fee in the asset, its USD value left stale on purpose
+	assetFeeTx := addTestTransaction(t, s, user, portfolio, types.TempTransactionData{
+		FeeAmount:   decimal.RequireFromString("0.01"),
+		FeeCurrency: "BTC",
+	})
+
+	// fee of 0.01 BTC at 100 USD, its USD value isn't stored
+	renamedTx := addTestTransaction(t, s, user, portfolio, types.TempTransactionData{
+		FeeAmount:   decimal.RequireFromString("0.01"),
+		FeeCurrency: "BTC",
+	})
+
+	// 100 EUR at 1.1 USD, fee of 2 EUR
+	eurTx := addTestTransaction(t, s, user, portfolio, types.TempTransactionData{
+		QuoteCurrency: "EUR",
+		QuotePrice:    decimal.NewFromInt(100),
+		AssetPrice:    decimal.NewFromInt(110),
+		FeeAmount:     decimal.NewFromInt(2),
+		FeeCurrency:   "EUR",
+		FeeUSD:        decimal.RequireFromString("2.2"),
+	})
+
+	tests := []struct {
+		name          string
+		txID          int64
+		field         string
+		value         any
+		wantErr       bool
+		wantAmountUSD string
+		wantFeeUSD    string
+		wantPrice     string
+		wantFee       string // amount and currency, not checked when empty
+	}{
+		{
+			name:          "amount edit revalues fee in the asset",
+			txID:          assetFeeTx,
+			field:         "amount",
+			value:         decimal.NewFromInt(2),
+			wantAmountUSD: "200",
+			wantFeeUSD:    "1",
+			wantPrice:     "100",
+		},
+		{
+			name:    "date of a EUR trade without its USD price",
+			txID:    eurTx,
+			field:   "date",
+			value:   newDate,
+			wantErr: true,
+		},
+		{
+			name:  "date edit reprices a EUR trade",
+			txID:  eurTx,
+			field: "date",
+			value: types.RepricedDate{
+				Date:  newDate,
+				Price: types.QuotedPrice{Currency: "EUR", Price: decimal.NewFromInt(100), USD: decimal.NewFromInt(120)},
+			},
+			wantAmountUSD: "120",
+			wantFeeUSD:    "2.4",
+			wantPrice:     "120",
+		},
+		{
+			name:          "asset edit restates fee in the old asset in USD",
+			txID:          renamedTx,
+			field:         "asset",
+			value:         "ETH",
+			wantAmountUSD: "100",
+			wantFeeUSD:    "1",
+			wantPrice:     "100",
+			wantFee:       "1 USD",
+		},
+		{
+			name:          "asset edit keeps fee in the quote currency",
+			txID:          eurTx,
+			field:         "asset",
+			value:         "ETH",
+			wantAmountUSD: "120",
+			wantFeeUSD:    "2.4",
+			wantPrice:     "120",
+			wantFee:       "2 EUR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.UpdateTransaction(ctx, user, tt.txID, tt.field, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("update: %v", err)
+			}
+
+			tx, err := s.GetTransactionByID(ctx, user, tt.txID)
+			if err != nil {
+				t.Fatalf("get transaction: %v", err)
+			}
+			if tx.USDAmount.String() != tt.wantAmountUSD || tx.FeeUSD.String() != tt.wantFeeUSD || tx.AssetPrice.String() != tt.wantPrice {
+				t.Errorf("got amount %s, fee %s, price %s USD, want %s, %s, %s",
+					tx.USDAmount, tx.FeeUSD, tx.AssetPrice, tt.wantAmountUSD, tt.wantFeeUSD, tt.wantPrice)
+			}
+			if fee := tx.FeeAmount.String() + " " + tx.FeeCurrency; tt.wantFee != "" && fee != tt.wantFee {
+				t.Errorf("got fee %s, want %s", fee, tt.wantFee)
+			}
+		})
+	}
+
+	tx, err := s.GetTransactionByID(ctx, user, eurTx)
+	if err != nil {
+		t.Fatalf("get transaction: %v", err)
+	}
+	if !tx.TransactionDate.Equal(newDate) {
+		t.Errorf("got date %s, want %s", tx.TransactionDate, newDate)
+	}
+
+	var change string
+	err = s.DB.QueryRowContext(ctx,
+		"SELECT new_value FROM transaction_changes WHERE transaction_id = $1 AND field = 'date'", eurTx).Scan(&change)
+	if err != nil || change != "2025-02-01" {
+		t.Errorf("got recorded date %q, %v, want 2025-02-01", change, err)
+	}
+}
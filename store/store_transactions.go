@@ -2,19 +2,82 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
+	"gitlab.com/avolkov/wood_post/pkg/decimal"
 	t "gitlab.com/avolkov/wood_post/pkg/types"
 )
 
+// AddNewTransaction inserts the transaction with its tags, unknown tags are created for the user
 func (s *Store) AddNewTransaction(
 	ctx context.Context,
 	dbUserID int64,
 	defID int,
 	tx *t.TempTransactionData,
-) error {
+) (err error) {
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin add new transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
+	inserted, err := s.insertTransaction(ctx, dbTx, dbUserID, int64(defID), tx)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		return ErrPortfolioNotFound
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return fmt.Errorf("commit add new transaction: %w", err)
+	}
+
+	return nil
+}
+
+// inserts one row with its tags, inserted is false when the portfolio doesn't belong to the user
+// or a row with the same import hash is already in the portfolio
+func (s *Store) insertTransaction(
+	ctx context.Context,
+	dbTx *sql.Tx,
+	dbUserID, portfolioID int64,
+	tx *t.TempTransactionData,
+) (inserted bool, err error) {
+	// the row is inserted only if the portfolio belongs to the user,
+	// parameters in a select list have no type so they are cast explicitly
+	selectQuery := sq.
+		Select().
+		Column("id").
+		Column("?::text", tx.Asset).
+		Column("?::numeric", tx.AssetAmount).
+		Column("?::numeric", tx.AssetPrice).
+		Column("?::text", tx.Quote()).
+		Column("?::numeric", quotePrice(tx)).
+		Column("?::numeric", tx.USDAmount).
+		Column("?::numeric", tx.FeeAmount).
+		Column("?::text", feeCurrency(tx.FeeCurrency)).
+		Column("?::numeric", tx.FeeUSD).
+		Column("?::timestamp", tx.TransactionDate).
+		Column("?::text", tx.Type).
+		Column("?::timestamp", time.Now()).
+		Column("NULLIF(?::text, '')", tx.Note).
+		Column("NULLIF(?::text, '')", tx.ImportHash).
+		From("portfolios").
+		Where(sq.Eq{
+			"id":      portfolioID,
+			"user_id": dbUserID,
+		})
+
 	query, args, err := s.sqlBuilder.
 		Insert("transactions").
 		Columns(
@@ -22,43 +85,80 @@ func (s *Store) AddNewTransaction(
 			"asset",
 			"asset_amount",
 			"asset_price",
+			"quote_currency",
+			"quote_price",
 			"amount_usd",
+			"fee_amount",
+			"fee_currency",
+			"fee_usd",
 			"transaction_date",
 			"type",
 			"created_at",
-			// "note",
-		).
-		Values(
-			defID,
-			tx.Asset,
-			tx.AssetAmount,
-			tx.AssetPrice,
-			tx.USDAmount,
-			tx.TransactionDate,
-			tx.Type,
-			time.Now(),
+			"note",
+			"import_hash",
 		).
+		Select(selectQuery).
+		Suffix("ON CONFLICT (portfolio_id, import_hash) WHERE import_hash IS NOT NULL DO NOTHING RETURNING id").
 		ToSql()
 	if err != nil {
-		return fmt.Errorf("build add new transaction query: %w", err)
+		return false, fmt.Errorf("build add new transaction query: %w", err)
 	}
 
-	_, err = s.DB.ExecContext(ctx, query, args...)
-	if err != nil {
-		return fmt.Errorf("exec add new transaction query: %w", err)
+	var txID int64
+	if err = dbTx.QueryRowContext(ctx, query, args...).Scan(&txID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("exec add new transaction query: %w", err)
+	}
+
+	if err = s.attachTags(ctx, dbTx, dbUserID, txID, tx.Tags); err != nil {
+		return false, err
 	}
 
-	return nil // will be fixed
+	return true, nil
+}
+
+// sql version of types.AmountSign, keep them in sync
+const amountSignSQL = "(CASE " +
+	"WHEN t.type IN ('buy', 'income', 'airdrop', 'gift') OR (t.type = 'transfer' AND t.direction = 'in') THEN 1 " +
+	"WHEN t.type IN ('deposit', 'withdrawal') THEN 0 " +
+	"ELSE -1 END)"
+
+// cost basis change of a row: fees add to the cost of acquisitions and reduce what disposals bring back,
+// fiat flows don't change it
+const costUSDSQL = "(CASE " +
+	"WHEN " + amountSignSQL + " > 0 THEN t.amount_usd + t.fee_usd " +
+	"WHEN " + amountSignSQL + " < 0 THEN -(t.amount_usd - t.fee_usd) " +
+	"ELSE 0 END)"
+
+// fiat flows are kept out of asset lists
+var fiatFlowTypes = []string{"deposit", "withdrawal"}
+
+func feeCurrency(currency string) string {
+	if currency == "" {
+		return t.FeeCurrencyUSD
+	}
+	return currency
+}
+
+// rows without a quote price are priced in USD
+func quotePrice(tx *t.TempTransactionData) decimal.Decimal {
+	if tx.QuotePrice.IsZero() && t.IsUSD(tx.QuoteCurrency) {
+		return tx.AssetPrice
+	}
+	return tx.QuotePrice
 }
 
 func (s *Store) GetTopAssetsForUser(ctx context.Context, dbUserID int64) ([]string, error) {
 	query, args, err := s.sqlBuilder.
 		Select("t.asset").
 		From("transactions t").
-		LeftJoin("portfolios p ON p.id = t.portfolio_id").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
 		Where(sq.Eq{
 			"p.user_id": dbUserID,
 		}).
+		Where(sq.NotEq{"t.type": fiatFlowTypes}).
 		GroupBy("p.user_id, t.asset").
 		OrderBy("COUNT(t.asset) DESC").
 		Limit(5).
@@ -90,56 +190,96 @@ func (s *Store) GetTopAssetsForUser(ctx context.Context, dbUserID int64) ([]stri
 	return assets, nil
 }
 
-// FIXME
-// retrieves the last 5 transactions for a user with portfolio information
-func (s *Store) GetLast5TransactionsForUser(ctx context.Context, dbUserID int64) ([]t.Transaction, error) {
-	query, args, err := s.sqlBuilder.
-		Select(
-			"t.id",
-			"p.name as portfolio_name",
-			"t.type",
-			"t.asset",
-			"t.asset_amount",
-			"t.asset_price",
-			"t.amount_usd",
-			"t.transaction_date",
-			// "COALESCE(t.note, '') as note",
-			// "t.created_at",
-		).
+// full set of columns scanned by scanTransaction, expects transactions t joined with portfolios p
+var transactionColumns = []string{
+	"t.id",
+	"t.portfolio_id",
+	"p.name as portfolio_name",
+	"t.type",
+	"t.asset",
+	"t.asset_amount",
+	"t.asset_price",
+	"t.quote_currency",
+	"t.quote_price",
+	"t.amount_usd",
+	"t.fee_amount",
+	"t.fee_currency",
+	"t.fee_usd",
+	"t.transaction_date",
+	"COALESCE(t.note, '') as note",
+	"t.created_at",
+	"COALESCE(t.transfer_id, 0) as transfer_id",
+	"COALESCE(t.direction, '') as direction",
+	transactionTagsSQL + " as tags",
+	"COALESCE(t.import_hash, '') as import_hash",
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransaction(row rowScanner) (t.Transaction, error) {
+	var tx t.Transaction
+	var tags string
+	err := row.Scan(
+		&tx.ID,
+		&tx.PortfolioID,
+		&tx.PortfolioName,
+		&tx.Type,
+		&tx.Asset,
+		&tx.AssetAmount,
+		&tx.AssetPrice,
+		&tx.QuoteCurrency,
+		&tx.QuotePrice,
+		&tx.USDAmount,
+		&tx.FeeAmount,
+		&tx.FeeCurrency,
+		&tx.FeeUSD,
+		&tx.TransactionDate,
+		&tx.Note,
+		&tx.CreatedAt,
+		&tx.TransferID,
+		&tx.Direction,
+		&tags,
+		&tx.ImportHash,
+	)
+	tx.Tags = splitTags(tags)
+	return tx, err
+}
+
+// retrieves all transactions of a user in transaction date order, used to replay history in reports.
+// non empty tag keeps only transactions with that tag
+func (s *Store) GetTransactionsForUser(ctx context.Context, dbUserID int64, tag string) ([]t.Transaction, error) {
+	builder := s.sqlBuilder.
+		Select(transactionColumns...).
 		From("transactions t").
-		LeftJoin("portfolios p ON p.id = t.portfolio_id").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
 		Where(sq.Eq{
 			"p.user_id": dbUserID,
-		}).
-		OrderBy("t.created_at DESC").
-		Limit(5).
+		})
+
+	if tag != "" {
+		builder = builder.Where(tagCond(tag))
+	}
+
+	query, args, err := builder.
+		OrderBy("t.transaction_date", "t.id").
 		ToSql()
 
 	if err != nil {
-		return nil, fmt.Errorf("build get last 5 transactions query: %w", err)
+		return nil, fmt.Errorf("build get transactions for user query: %w", err)
 	}
 
 	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("exec get last 5 transactions query: %w", err)
+		return nil, fmt.Errorf("exec get transactions for user query: %w", err)
 	}
 	defer rows.Close()
 
 	var transactions []t.Transaction
 	for rows.Next() {
-		var tx t.Transaction
-		if err := rows.Scan(
-			&tx.ID,
-			&tx.PortfolioName,
-			&tx.Type,
-			&tx.Asset,
-			&tx.AssetAmount,
-			&tx.AssetPrice,
-			&tx.USDAmount,
-			&tx.TransactionDate,
-			// &tx.Note,
-			// &tx.CreatedAt,
-		); err != nil {
+		tx, err := scanTransaction(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan transaction: %w", err)
 		}
 		transactions = append(transactions, tx)
@@ -152,41 +292,157 @@ func (s *Store) GetLast5TransactionsForUser(ctx context.Context, dbUserID int64)
 	return transactions, nil
 }
 
+// GetTransactionsPage returns up to limit transactions after cursor (before it when backward is set)
+// in transaction date order, more reports whether there are rows beyond the page in that direction.
+// nil cursor means the first page
+func (s *Store) GetTransactionsPage(
+	ctx context.Context,
+	dbUserID int64,
+	filter t.TransactionFilter,
+	cursor *t.TransactionCursor,
+	backward bool,
+	limit int,
+) (page []t.Transaction, more bool, err error) {
+	builder := s.sqlBuilder.
+		Select(transactionColumns...).
+		From("transactions t").
+		InnerJoin("portfolios p ON p.id = t.portfolio_id").
+		Where(sq.Eq{
+			"p.user_id": dbUserID,
+		})
+
+	if filter.PortfolioID != 0 {
+		builder = builder.Where(sq.Eq{"t.portfolio_id": filter.PortfolioID})
+	}
+	if filter.Asset != "" {
+		builder = builder.Where(sq.Eq{"t.asset": filter.Asset})
+	}
+	if filter.Type != "" {
+		builder = builder.Where(sq.Eq{"t.type": filter.Type})
+	}
+	if filter.Tag != "" {
+		builder = builder.Where(tagCond(filter.Tag))
+	}
+	if !filter.From.IsZero() {
+		builder = builder.Where(sq.GtOrEq{"t.transaction_date": filter.From})
+	}
+	if !filter.To.IsZero() {
+		builder = builder.Where(sq.Lt{"t.transaction_date": filter.To.AddDate(0, 0, 1)})
+	}
+
+	// going backward walks the same order in reverse and flips the page afterwards
+	desc := !filter.OldestFirst
+	if backward {
+		desc = !desc
+	}
+
+	order, op := "ASC", ">"
+	if desc {
+		order, op = "DESC", "<"
+	}
+
+	if cursor != nil {
+		builder = builder.Where(
+			sq.Expr("(t.transaction_date, t.id) "+op+" (?, ?)", cursor.Date, cursor.ID))
+	}
+
+	query, args, err := builder.
+		OrderBy("t.transaction_date "+order, "t.id "+order).
+		Limit(uint64(limit + 1)).
+		ToSql()
+	if err != nil {
+		return nil, false, fmt.Errorf("build transactions page query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("exec transactions page query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, false, fmt.Errorf("scan transaction: %w", err)
+		}
+		page = append(page, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if len(page) > limit {
+		more = true
+		page = page[:limit]
+	}
+
+	if backward {
+		slices.Reverse(page)
+	}
+
+	return page, more, nil
+}
+
+// deletes a transaction of the user, both legs of a transfer go together
 func (s *Store) DeleteTransaction(ctx context.Context, dbUserID, txID int64) error {
 	query, args, err := s.sqlBuilder.
 		Delete("transactions").
-		Where(sq.Eq{
-			"id": txID}).
+		Where(sq.Or{
+			sq.Eq{"id": txID},
+			sq.Expr("transfer_id = (SELECT transfer_id FROM transactions WHERE id = ?)", txID),
+		}).
+		Where(userPortfoliosCond(dbUserID)).
 		ToSql()
 
 	if err != nil {
 		return fmt.Errorf("build delete transaction query: %w", err)
 	}
 
-	_, err = s.DB.ExecContext(ctx, query, args...)
+	result, err := s.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("exec delete transaction query: %w", err)
 	}
 
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrTransactionNotFound
+	}
+
 	return nil
 }
 
-// retrieves portfolio summaries with asset totals for a user
-func (s *Store) GetPortfolioSummariesForUser(ctx context.Context, dbUserID int64) ([]t.PortfolioSummary, error) {
-	query, args, err := s.sqlBuilder.
+// limits statements without a join to rows in the user's portfolios
+func userPortfoliosCond(dbUserID int64) sq.Sqlizer {
+	return sq.Expr("portfolio_id IN (SELECT id FROM portfolios WHERE user_id = ?)", dbUserID)
+}
+
+// retrieves portfolio summaries with asset totals for a user, non empty tag limits them to tagged transactions
+func (s *Store) GetPortfolioSummariesForUser(ctx context.Context, dbUserID int64, tag string) ([]t.PortfolioSummary, error) {
+	builder := s.sqlBuilder.
 		Select(
 			"p.name as portfolio_name",
 			"t.asset",
-			"SUM(CASE WHEN t.type = 'buy' THEN t.asset_amount ELSE -t.asset_amount END) as total_amount",
-			"SUM(CASE WHEN t.type = 'buy' THEN t.amount_usd ELSE -t.amount_usd END) as total_usd",
+			"SUM("+amountSignSQL+" * t.asset_amount) as total_amount",
+			"SUM("+costUSDSQL+") as total_usd",
 		).
 		From("transactions t").
 		InnerJoin("portfolios p ON p.id = t.portfolio_id").
 		Where(sq.Eq{
 			"p.user_id": dbUserID,
 		}).
+		Where(sq.NotEq{"t.type": fiatFlowTypes})
+
+	if tag != "" {
+		builder = builder.Where(tagCond(tag))
+	}
+
+	query, args, err := builder.
 		GroupBy("p.name", "t.asset").
-		Having("SUM(CASE WHEN t.type = 'buy' THEN t.asset_amount ELSE -t.asset_amount END) > 0").
+		Having("SUM("+amountSignSQL+" * t.asset_amount) > 0").
 		OrderBy("p.name", "t.asset").
 		ToSql()
 
@@ -205,7 +461,7 @@ func (s *Store) GetPortfolioSummariesForUser(ctx context.Context, dbUserID int64
 
 	for rows.Next() {
 		var portfolioName, asset string
-		var totalAmount, totalUSD float64
+		var totalAmount, totalUSD decimal.Decimal
 
 		if err := rows.Scan(&portfolioName, &asset, &totalAmount, &totalUSD); err != nil {
 			return nil, fmt.Errorf("scan portfolio summary: %w", err)
@@ -236,56 +492,48 @@ func (s *Store) GetPortfolioSummariesForUser(ctx context.Context, dbUserID int64
 	return summaries, nil
 }
 
-// retrieves aggregated transaction data across all portfolios for PnL calculations
-func (s *Store) GetReportData(ctx context.Context, dbUserID int64) ([]t.CurrencyPnLData, error) {
-	query, args, err := s.sqlBuilder.
-		Select(
-			"t.asset",
-			"SUM(CASE WHEN t.type = 'buy' THEN t.asset_amount ELSE -t.asset_amount END) as total_asset_amount",
-			"SUM(CASE WHEN t.type = 'buy' THEN t.amount_usd ELSE 0 END) as total_invested_usd",
-		).
+// returns USD totals of the given transaction types for a user, types without rows are missing in the map.
+// non empty tag keeps only transactions with that tag
+func (s *Store) GetUSDTotalsByType(ctx context.Context, dbUserID int64, types []string, tag string) (map[string]decimal.Decimal, error) {
+	builder := s.sqlBuilder.
+		Select("t.type", "SUM(t.amount_usd)").
 		From("transactions t").
 		InnerJoin("portfolios p ON p.id = t.portfolio_id").
 		Where(sq.Eq{
 			"p.user_id": dbUserID,
-		}).
-		GroupBy("t.asset").
-		Having("SUM(CASE WHEN t.type = 'buy' THEN t.asset_amount ELSE -t.asset_amount END) > 0").
-		OrderBy("t.asset").
-		ToSql()
+			"t.type":    types,
+		})
+
+	if tag != "" {
+		builder = builder.Where(tagCond(tag))
+	}
 
+	query, args, err := builder.
+		GroupBy("t.type").
+		ToSql()
 	if err != nil {
-		return nil, fmt.Errorf("build report data query: %w", err)
+		return nil, fmt.Errorf("build usd totals by type query: %w", err)
 	}
 
 	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("exec report data query: %w", err)
+		return nil, fmt.Errorf("exec usd totals by type query: %w", err)
 	}
 	defer rows.Close()
 
-	var reportData []t.CurrencyPnLData
+	totals := make(map[string]decimal.Decimal)
 	for rows.Next() {
-		var data t.CurrencyPnLData
-		if err := rows.Scan(
-			&data.Asset,
-			&data.TotalAssetAmount,
-			&data.TotalInvestedUSD,
-		); err != nil {
-			return nil, fmt.Errorf("scan report data: %w", err)
+		var txType string
+		var total decimal.Decimal
+		if err := rows.Scan(&txType, &total); err != nil {
+			return nil, fmt.Errorf("scan usd total: %w", err)
 		}
-
-		// Calculate average purchase price
-		if data.TotalAssetAmount > 0 {
-			data.AveragePurchasePrice = data.TotalInvestedUSD / data.TotalAssetAmount
-		}
-
-		reportData = append(reportData, data)
+		totals[txType] = total
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
 
-	return reportData, nil
+	return totals, nil
 }
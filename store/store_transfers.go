@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	t "gitlab.com/avolkov/wood_post/pkg/types"
+)
+
+// returns amount and cost basis of the asset in every portfolio of the user,
+// portfolios that never had the asset are returned with zero amount
+func (s *Store) GetPortfolioHoldings(ctx context.Context, dbUserID int64, asset string) ([]t.PortfolioHolding, error) {
+	query, args, err := s.sqlBuilder.
+		Select(
+			"p.id",
+			"p.name",
+			"COALESCE(SUM("+amountSignSQL+" * t.asset_amount), 0) as amount",
+			"COALESCE(SUM("+costUSDSQL+"), 0) as cost_usd",
+		).
+		From("portfolios p").
+		LeftJoin("transactions t ON t.portfolio_id = p.id AND t.asset = ?", asset).
+		Where(sq.Eq{
+			"p.user_id": dbUserID,
+		}).
+		GroupBy("p.id", "p.name").
+		OrderBy("p.name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build portfolio holdings query: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec portfolio holdings query: %w", err)
+	}
+	defer rows.Close()
+
+	var holdings []t.PortfolioHolding
+	for rows.Next() {
+		var h t.PortfolioHolding
+		if err := rows.Scan(&h.PortfolioID, &h.Name, &h.Amount, &h.CostUSD); err != nil {
+			return nil, fmt.Errorf("scan portfolio holding: %w", err)
+		}
+		holdings = append(holdings, h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return holdings, nil
+}
+
+// AddTransfer records both legs of a transfer in one db transaction,
+// ToPortfolioID 0 means the asset left to an external wallet and only the outgoing leg is stored
+func (s *Store) AddTransfer(ctx context.Context, dbUserID int64, tx *t.TempTransactionData) (err error) {
+	dbTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transfer transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbTx.Rollback()
+		}
+	}()
+
+	portfolioIDs := []int64{tx.FromPortfolioID}
+	if tx.ToPortfolioID != 0 {
+		portfolioIDs = append(portfolioIDs, tx.ToPortfolioID)
+	}
+
+	// both portfolios must belong to the user
+	query, args, err := s.sqlBuilder.
+		Select("COUNT(*)").
+		From("portfolios").
+		Where(sq.Eq{
+			"user_id": dbUserID,
+			"id":      portfolioIDs,
+		}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build transfer portfolios query: %w", err)
+	}
+
+	var owned int
+	if err = dbTx.QueryRowContext(ctx, query, args...).Scan(&owned); err != nil {
+		return fmt.Errorf("exec transfer portfolios query: %w", err)
+	}
+	if owned != len(portfolioIDs) {
+		return ErrPortfolioNotFound
+	}
+
+	if err = s.insertTransfer(ctx, dbTx, tx); err != nil {
+		return err
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return fmt.Errorf("commit transfer transaction: %w", err)
+	}
+
+	return nil
+}
+
+// inserts both legs, portfolios must be checked by the caller
+func (s *Store) insertTransfer(ctx context.Context, dbTx *sql.Tx, tx *t.TempTransactionData) error {
+	// id of the outgoing leg is reserved up front, it's also the transfer id of both legs
+	var transferID int64
+	err := dbTx.QueryRowContext(ctx, "SELECT nextval(pg_get_serial_sequence('transactions', 'id'))").Scan(&transferID)
+	if err != nil {
+		return fmt.Errorf("reserve transfer id: %w", err)
+	}
+
+	now := time.Now()
+
+	if err := insertTransferLeg(ctx, s.sqlBuilder, dbTx, tx, transferID, tx.FromPortfolioID, t.TransferOut, transferID, now); err != nil {
+		return err
+	}
+
+	if tx.ToPortfolioID != 0 {
+		if err := insertTransferLeg(ctx, s.sqlBuilder, dbTx, tx, 0, tx.ToPortfolioID, t.TransferIn, transferID, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// id 0 lets the database assign it
+func insertTransferLeg(
+	ctx context.Context,
+	builder sq.StatementBuilderType,
+	dbTx *sql.Tx,
+	tx *t.TempTransactionData,
+	id, portfolioID int64,
+	direction string,
+	transferID int64,
+	now time.Time,
+) error {
+	columns := []string{
+		"portfolio_id",
+		"asset",
+		"asset_amount",
+		"asset_price",
+		"quote_currency",
+		"quote_price",
+		"amount_usd",
+		"transaction_date",
+		"type",
+		"transfer_id",
+		"direction",
+		"created_at",
+	}
+	values := []any{
+		portfolioID,
+		tx.Asset,
+		tx.AssetAmount,
+		tx.AssetPrice,
+		tx.Quote(),
+		quotePrice(tx),
+		tx.USDAmount,
+		tx.TransactionDate,
+		"transfer",
+		transferID,
+		direction,
+		now,
+	}
+	if id != 0 {
+		columns = append(columns, "id")
+		values = append(values, id)
+	}
+	// both legs share the hash, they are always in different portfolios
+	if tx.ImportHash != "" {
+		columns = append(columns, "import_hash")
+		values = append(values, tx.ImportHash)
+	}
+
+	query, args, err := builder.
+		Insert("transactions").
+		Columns(columns...).
+		Values(values...).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build transfer leg query: %w", err)
+	}
+
+	if _, err := dbTx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("exec transfer leg query: %w", err)
+	}
+
+	return nil
+}